@@ -0,0 +1,33 @@
+package secstorage
+
+import (
+	"crypto/hmac"
+	"errors"
+
+	"github.com/awnumar/memguard"
+)
+
+// ErrAADMismatch 表示解密时提供的关联数据（Syncer.AAD）与加密时绑定的 EncryptionOptions.AAD 不一致，
+// 包括文件绑定了关联数据而解密时没有提供，或者反过来。
+var ErrAADMismatch = errors.New("associated data does not match the encrypted file")
+
+// aadHashPrefix 是计算关联数据哈希时的域分隔前缀。
+var aadHashPrefix = []byte("secstorage-aad\x00")
+
+// aadHash 返回以文件密钥计算的关联数据 HMAC，aad 为空时返回 nil。
+// 使用带密钥的哈希，存储方无法通过枚举租户 ID 等可猜测的取值确认文件绑定的上下文。
+func aadHash(key *memguard.LockedBuffer, aad []byte) []byte {
+	if len(aad) == 0 {
+		return nil
+	}
+	return sign(append(append([]byte{}, aadHashPrefix...), aad...), key.Bytes())
+}
+
+// bindAAD 检查 aad 与清单记录的关联数据哈希一致，并把它记在清单上，供解密文件名和数据密钥时使用。
+func bindAAD(manifest *Manifest, key *memguard.LockedBuffer, aad []byte) error {
+	if !hmac.Equal(manifest.AADHash, aadHash(key, aad)) {
+		return ErrAADMismatch
+	}
+	manifest.aad = aad
+	return nil
+}
@@ -0,0 +1,115 @@
+package secstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestAADRoundTrip(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.AAD = []byte("tenant-42/doc-7")
+	manifestID, data := encryptTestFile(t, s, opts, 5000)
+
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.AADHash) == 0 {
+		t.Fatal("expected the manifest to record a hash of the associated data")
+	}
+	raw, err := os.ReadFile(s.getManifestPath(manifestID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, opts.AAD) {
+		t.Fatal("manifest stores the associated data itself")
+	}
+
+	s.AAD = []byte("tenant-42/doc-7")
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
+
+func TestAADMismatch(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.AAD = []byte("tenant-42")
+	manifestID, _ := encryptTestFile(t, s, opts, 3000)
+
+	for _, aad := range [][]byte{nil, []byte("tenant-43")} {
+		s.AAD = aad
+		if err := s.DecryptFile(manifestID, t.TempDir(), testPassword); !errors.Is(err, ErrAADMismatch) {
+			t.Fatalf("AAD %q: got %v, want ErrAADMismatch", aad, err)
+		}
+	}
+
+	// A file without associated data cannot be opened with some
+	plainID, _ := encryptTestFile(t, s, testOptions(), 3000)
+	s.AAD = []byte("tenant-42")
+	if err := s.DecryptFile(plainID, t.TempDir(), testPassword); !errors.Is(err, ErrAADMismatch) {
+		t.Fatalf("got %v, want ErrAADMismatch", err)
+	}
+}
+
+func TestAADBindsDataKeys(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.AAD = []byte("tenant-42")
+	manifestID, _ := encryptTestFile(t, s, opts, 3000)
+
+	// Clearing the recorded hash gets past the check, but the data keys still fail authentication
+	if _, _, err := s.OpenManifest(manifestID, testPassword); !errors.Is(err, ErrAADMismatch) {
+		t.Fatalf("got %v, want ErrAADMismatch", err)
+	}
+	s.AAD = opts.AAD
+	manifest, key, err := s.OpenManifest(manifestID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+	manifest.AADHash = nil
+	if err := s.WriteManifest(manifestID, manifest, key); err != nil {
+		t.Fatal(err)
+	}
+	s.AAD = nil
+	if _, err := s.DecryptToWriter(context.Background(), manifestID, testPassword, &bytes.Buffer{}); err == nil || errors.Is(err, ErrAADMismatch) {
+		t.Fatalf("got %v, want an authentication failure", err)
+	}
+}
+
+func TestAADResume(t *testing.T) {
+	s := newTestSyncer(t)
+	path, data := writeTestFile(t, t.TempDir(), "input.bin", 8000)
+	backend := &failingPutBackend{Backend: NewLocalBackend(t.TempDir()), failKey: "/chunk_2_"}
+	s.Backend = backend
+	opts := testOptions()
+	opts.AAD = []byte("tenant-42")
+	manifestID, err := s.EncryptFile(path, opts)
+	if err == nil {
+		t.Fatal("EncryptFile ignored the injected failure")
+	}
+
+	backend.failKey = ""
+	opts.ResumeManifestID = manifestID
+	opts.AAD = []byte("tenant-43")
+	if _, err := s.EncryptFile(path, opts); err == nil {
+		t.Fatal("expected resuming with different associated data to fail")
+	}
+	opts.AAD = []byte("tenant-42")
+	if _, err := s.EncryptFile(path, opts); err != nil {
+		t.Fatal(err)
+	}
+	s.AAD = opts.AAD
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
+
+func TestAADNotSupportedInShards(t *testing.T) {
+	opts := testOptions()
+	opts.AAD = []byte("tenant-42")
+	if _, _, err := EncryptToShards(bytes.NewReader([]byte("hello")), "input.bin", opts); err == nil {
+		t.Fatal("expected EncryptToShards to reject AAD")
+	}
+}
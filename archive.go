@@ -0,0 +1,178 @@
+package secstorage
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// maxArchiveManifestSize 是 ImportArchive 接受的 manifest.json 的最大字节数。
+const maxArchiveManifestSize = 16 << 20
+
+// archiveManifestName 返回归档中清单条目的名称。
+func archiveManifestName(manifestID string) string {
+	return path.Join(manifestID, "manifest.json")
+}
+
+// ExportArchive 将 manifestID 对应的清单及其所有分片写成一个 tar 流，便于把加密对象作为单个文件传输。
+// 第一个条目是 "<manifestID>/manifest.json"，之后是以 Backend key 命名的各个分片（被拆分的分片按部分存放）。
+// 已丢失的分片会被跳过，因此降级但仍可恢复的对象同样可以导出。
+func (s *Syncer) ExportArchive(manifestID string, w io.Writer) error {
+	manifest, err := s.loadManifest(manifestID)
+	if err != nil {
+		return err
+	}
+	if len(manifest.ShardLocations) > 0 {
+		return fmt.Errorf("manifest %s stores its shards at external locations and cannot be exported", manifestID)
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	tw := tar.NewWriter(w)
+	modTime := manifest.CreatedAt
+	if modTime.IsZero() {
+		modTime = time.Now().UTC()
+	}
+	writeEntry := func(name string, data []byte) error {
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     int64(len(data)),
+			Mode:     defaultFilePerm,
+			ModTime:  modTime,
+			Format:   tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write archive header for %s: %w", name, err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("failed to write %s to archive: %w", name, err)
+		}
+		return nil
+	}
+
+	if err := writeEntry(archiveManifestName(manifestID), manifestData); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	for i := range manifest.ChunkPaths {
+		for _, name := range manifest.storageNames(i) {
+			key := shardKey(manifestID, name)
+			data, err := s.backend().Get(ctx, key)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read shard %s: %w", key, err)
+			}
+			if err := writeEntry(key, data); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return nil
+}
+
+// ImportArchive 读取 ExportArchive 生成的 tar 流，将分片写入 Backend、清单写入存储目录，并返回 manifestID。
+// 清单在所有分片写入后才会出现，因此中途失败不会留下可见的半导入对象，已写入的分片会被删除。
+// 归档中的清单会经过结构校验，每个条目都必须是清单中列出的分片；同名清单已存在时返回错误。
+// 与 ImportManifestToken 一样，导入不需要密码，签名在之后用密码打开清单时验证。
+func (s *Syncer) ImportArchive(r io.Reader) (manifestID string, err error) {
+	tr := tar.NewReader(r)
+
+	// 1. The manifest comes first so every following entry can be checked against it
+	header, err := tr.Next()
+	if err != nil {
+		return "", fmt.Errorf("failed to read archive: %w", err)
+	}
+	manifestID = path.Dir(header.Name)
+	if header.Typeflag != tar.TypeReg || header.Name != archiveManifestName(manifestID) {
+		return "", fmt.Errorf("archive does not start with a manifest: %q", header.Name)
+	}
+	if err := s.validateManifestID(manifestID); err != nil {
+		return "", err
+	}
+	if header.Size > maxArchiveManifestSize {
+		return "", fmt.Errorf("archived manifest is %d bytes, the limit is %d", header.Size, maxArchiveManifestSize)
+	}
+	manifestData, err := io.ReadAll(tr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read archived manifest: %w", err)
+	}
+	manifest, err := decodeManifest(manifestData)
+	if err != nil {
+		return "", err
+	}
+	if len(manifest.Signature) == 0 {
+		return "", fmt.Errorf("invalid manifest %s: missing signature", manifestID)
+	}
+	if err := validateManifest(manifest); err != nil {
+		return "", fmt.Errorf("invalid manifest %s: %w", manifestID, err)
+	}
+
+	// Map each shard key to its chunk, whose encrypted size bounds the shard size
+	shardChunks := make(map[string]int)
+	for i := range manifest.ChunkPaths {
+		for _, name := range manifest.storageNames(i) {
+			shardChunks[shardKey(manifestID, name)] = i
+		}
+	}
+
+	defer s.lockManifest(manifestID)()
+	if err := s.checkImportTarget(manifestID); err != nil {
+		return "", err
+	}
+
+	// 2. Store the shards, removing them again if the import fails
+	ctx := context.Background()
+	var written []string
+	defer func() {
+		if err != nil {
+			for _, key := range written {
+				s.backend().Delete(ctx, key)
+			}
+		}
+	}()
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read archive: %w", err)
+		}
+		i, ok := shardChunks[header.Name]
+		if !ok || header.Typeflag != tar.TypeReg {
+			return "", fmt.Errorf("archive entry %q is not a shard of manifest %s", header.Name, manifestID)
+		}
+		if header.Size > int64(manifest.EncryptedChunkSizes[i]) {
+			return "", fmt.Errorf("archive entry %q is %d bytes, larger than its chunk", header.Name, header.Size)
+		}
+		delete(shardChunks, header.Name)
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read archive entry %q: %w", header.Name, err)
+		}
+		if err := s.backend().Put(ctx, header.Name, data); err != nil {
+			return "", fmt.Errorf("failed to write shard %s: %w", header.Name, err)
+		}
+		written = append(written, header.Name)
+	}
+
+	// 3. Publish the manifest last
+	if err := s.importManifest(manifestID, manifest); err != nil {
+		return "", err
+	}
+	return manifestID, nil
+}
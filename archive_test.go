@@ -0,0 +1,77 @@
+package secstorage
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, src, testOptions(), 3000)
+	// A missing shard is skipped on export and repaired by the erasure code on decryption
+	if err := os.Remove(shardPath(src, manifestID, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	if err := src.ExportArchive(manifestID, &archive); err != nil {
+		t.Fatal(err)
+	}
+	dst := newTestSyncer(t)
+	imported, err := dst.ImportArchive(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != manifestID {
+		t.Fatalf("imported %s, want %s", imported, manifestID)
+	}
+	assertDecrypts(t, dst, manifestID, testPassword, data)
+
+	if _, err := dst.ImportArchive(bytes.NewReader(archive.Bytes())); err == nil {
+		t.Fatal("import overwrote an existing manifest")
+	}
+}
+
+func TestImportArchiveRejectsForeignEntries(t *testing.T) {
+	src := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, src, testOptions(), 3000)
+	var archive bytes.Buffer
+	if err := src.ExportArchive(manifestID, &archive); err != nil {
+		t.Fatal(err)
+	}
+
+	// Append an entry that escapes the manifest directory
+	var tampered bytes.Buffer
+	tw := tar.NewWriter(&tampered)
+	tr := tar.NewReader(&archive)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw.WriteHeader(header)
+		tw.Write(data)
+	}
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "../evil", Size: 1, Mode: 0o600})
+	tw.Write([]byte{1})
+	tw.Close()
+
+	dst := newTestSyncer(t)
+	if _, err := dst.ImportArchive(&tampered); err == nil {
+		t.Fatal("archive with a foreign entry was imported")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dst.StorageDir, manifestID)); len(entries) > 0 {
+		t.Fatalf("failed import left %d files behind", len(entries))
+	}
+	if _, err := os.Stat(filepath.Join(dst.StorageDir, "..", "evil")); err == nil {
+		t.Fatal("entry was written outside the storage directory")
+	}
+}
@@ -0,0 +1,244 @@
+package secstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Backend 定义了分片数据的存储后端。清单始终保存在 Syncer.StorageDir 中，而分片通过 Backend 读写，
+// 因此可以把分片放到对象存储等远程位置。
+// key 是以 "/" 分隔的相对路径，形如 "<manifestID>/chunk_0_shard_1.dat"。
+// 当 key 不存在时，Get 返回的错误必须满足 errors.Is(err, os.ErrNotExist)。
+// 调用方可能在 Put 返回后复用 data，实现不能保留对它的引用。实现必须可以被多个 goroutine 并发调用。
+type Backend interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// shardKey 返回清单中某个分片文件在 Backend 中的 key。
+func shardKey(manifestID, name string) string {
+	return path.Join(manifestID, name)
+}
+
+// LocalBackend 是将分片保存在本地目录中的 Backend 实现，也是 Syncer 的默认后端。
+type LocalBackend struct {
+	Root string
+	// Durable 为 true 时，Put 在返回前对分片文件及其所在目录执行 fsync。
+	Durable bool
+	// DirDepth 与 Syncer.ManifestDirDepth 含义相同：key 的第一个元素（即 manifestID）按其前缀分散到 DirDepth 层子目录中。
+	DirDepth int
+	// Overwrite 为 true 时 Put 截断并覆盖已存在的文件，默认返回错误。
+	Overwrite bool
+}
+
+// NewLocalBackend 创建一个以 root 为根目录的 LocalBackend。
+func NewLocalBackend(root string) *LocalBackend {
+	return &LocalBackend{Root: root}
+}
+
+// path 将 key 转换为 Root 下的文件路径。key 必须是不含 ".." 的相对路径，
+// 否则返回错误，以免被篡改的清单读写或删除 Root 之外的文件。
+func (b *LocalBackend) path(key string) (string, error) {
+	localKey := filepath.FromSlash(key)
+	if !filepath.IsLocal(localKey) {
+		return "", fmt.Errorf("invalid key %q: resolves outside the backend root", key)
+	}
+	first, _, _ := strings.Cut(key, "/")
+	prefix := filepath.Join(manifestPrefixDirs(first, b.DirDepth)...)
+	return filepath.Join(b.Root, prefix, localKey), nil
+}
+
+// Put 实现了 Backend 接口。除非 Overwrite 为 true，key 已存在时返回满足 errors.Is(err, os.ErrExist) 的错误，
+// 以免清单 ID 冲突或有缺陷的重试悄悄覆盖其他对象的分片。
+func (b *LocalBackend) Put(ctx context.Context, key string, data []byte) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if b.Overwrite {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	dir := filepath.Dir(p)
+	if !b.Durable {
+		if err := os.MkdirAll(dir, defaultDirPerm); err != nil {
+			return err
+		}
+		return writeShardFile(p, data, flag, false)
+	}
+
+	// A freshly created directory is only durable once its parent has been synced too
+	_, statErr := os.Stat(dir)
+	if err := os.MkdirAll(dir, defaultDirPerm); err != nil {
+		return err
+	}
+	if errors.Is(statErr, os.ErrNotExist) {
+		if err := syncDir(filepath.Dir(dir)); err != nil {
+			return err
+		}
+	}
+	if err := writeShardFile(p, data, flag, true); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// writeShardFile 以 flag 打开 p 并写入 data，durable 为 true 时在关闭前执行 fsync。
+// 写入失败时删除不完整的文件，使重试不会因文件已存在而失败。
+func writeShardFile(p string, data []byte, flag int, durable bool) (err error) {
+	f, err := os.OpenFile(p, flag, defaultFilePerm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(p)
+		}
+	}()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if durable {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("failed to sync %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// Get 实现了 Backend 接口。
+func (b *LocalBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(p)
+}
+
+// Delete 实现了 Backend 接口，key 不存在时不返回错误。
+func (b *LocalBackend) Delete(ctx context.Context, key string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// backend 返回 Syncer 配置的分片后端；未配置时返回以 StorageDir 为根的 LocalBackend。
+func (s *Syncer) backend() Backend {
+	if s.Backend == nil {
+		return &LocalBackend{Root: s.StorageDir, Durable: s.Durable, DirDepth: s.ManifestDirDepth}
+	}
+	return s.Backend
+}
+
+// RetryBackend 是为任意 Backend 增加超时和重试能力的装饰器，适用于存在瞬时故障的网络后端。
+// 失败的操作按指数退避重试，直到达到 MaxRetries 或 ctx 的截止时间。
+// key 不存在或已存在属于永久性错误，不会被重试。
+// 被包装的后端实现了 Wiper 时，RetryBackend 同样以重试的方式转发 Wipe。
+type RetryBackend struct {
+	Backend Backend
+	// MaxRetries 是首次尝试失败后的最大重试次数。
+	MaxRetries int
+	// InitialBackoff 是第一次重试前的等待时间，之后每次翻倍。
+	InitialBackoff time.Duration
+	// MaxBackoff 是两次重试之间等待时间的上限，为 0 时不设上限。
+	MaxBackoff time.Duration
+	// Timeout 是单次尝试的超时时间，为 0 时仅受 ctx 约束。
+	Timeout time.Duration
+}
+
+// NewRetryBackend 用默认的退避参数包装 backend。
+func NewRetryBackend(backend Backend, maxRetries int) *RetryBackend {
+	return &RetryBackend{
+		Backend:        backend,
+		MaxRetries:     maxRetries,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+	}
+}
+
+// Put 实现了 Backend 接口。
+func (b *RetryBackend) Put(ctx context.Context, key string, data []byte) error {
+	return b.retry(ctx, "put", key, func(ctx context.Context) error {
+		return b.Backend.Put(ctx, key, data)
+	})
+}
+
+// Get 实现了 Backend 接口。
+func (b *RetryBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := b.retry(ctx, "get", key, func(ctx context.Context) error {
+		var err error
+		data, err = b.Backend.Get(ctx, key)
+		return err
+	})
+	return data, err
+}
+
+// Delete 实现了 Backend 接口。
+func (b *RetryBackend) Delete(ctx context.Context, key string) error {
+	return b.retry(ctx, "delete", key, func(ctx context.Context) error {
+		return b.Backend.Delete(ctx, key)
+	})
+}
+
+// Wipe 实现了 Wiper 接口。被包装的后端实现了 Wiper 时带重试地转发给它，
+// 否则通过 RetryBackend 自身的 Get、Put 和 Delete 覆写并删除分片。
+func (b *RetryBackend) Wipe(ctx context.Context, key string) error {
+	w, ok := b.Backend.(Wiper)
+	if !ok {
+		return overwriteShard(ctx, b, key)
+	}
+	return b.retry(ctx, "wipe", key, func(ctx context.Context) error {
+		return w.Wipe(ctx, key)
+	})
+}
+
+func (b *RetryBackend) retry(ctx context.Context, op, key string, fn func(ctx context.Context) error) error {
+	backoff := b.InitialBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = b.attempt(ctx, fn)
+		// A missing key or a refused overwrite will fail the same way on every attempt
+		if err == nil || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrExist) {
+			return err
+		}
+		if attempt >= b.MaxRetries {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s %s: %w (last error: %v)", op, key, ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if b.MaxBackoff > 0 && backoff > b.MaxBackoff {
+			backoff = b.MaxBackoff
+		}
+	}
+	return fmt.Errorf("%s %s failed after %d attempts: %w", op, key, b.MaxRetries+1, err)
+}
+
+func (b *RetryBackend) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	if b.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Timeout)
+		defer cancel()
+	}
+	return fn(ctx)
+}
@@ -0,0 +1,136 @@
+package secstorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// faultyBackend 包装 Backend，并让 key 以 failSuffix 结尾的 Get 调用失败。
+// block 为 true 时，失败的调用会一直阻塞到 ctx 结束。
+type faultyBackend struct {
+	Backend
+	failSuffix string
+	block      bool
+	gets       atomic.Int32
+}
+
+func (b *faultyBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	if !strings.HasSuffix(key, b.failSuffix) {
+		return b.Backend.Get(ctx, key)
+	}
+	b.gets.Add(1)
+	if b.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return nil, errors.New("connection reset")
+}
+
+func TestLocalBackendRejectsEscapingKeys(t *testing.T) {
+	b := NewLocalBackend(t.TempDir())
+	ctx := context.Background()
+	for _, key := range []string{"../victim", "id/../../victim", "/etc/passwd", ""} {
+		if err := b.Put(ctx, key, []byte("x")); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+		if _, err := b.Get(ctx, key); err == nil {
+			t.Errorf("Get(%q) succeeded", key)
+		}
+		if err := b.Delete(ctx, key); err == nil {
+			t.Errorf("Delete(%q) succeeded", key)
+		}
+	}
+	if err := b.Put(ctx, "id/chunk_0.dat", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalBackendRefusesOverwrite(t *testing.T) {
+	ctx := context.Background()
+	for _, durable := range []bool{false, true} {
+		b := &LocalBackend{Root: t.TempDir(), Durable: durable}
+		if err := b.Put(ctx, "id/chunk_0.dat", []byte("first")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put(ctx, "id/chunk_0.dat", []byte("second")); !errors.Is(err, os.ErrExist) {
+			t.Fatalf("durable=%v: second Put returned %v, want os.ErrExist", durable, err)
+		}
+		if got, err := b.Get(ctx, "id/chunk_0.dat"); err != nil || string(got) != "first" {
+			t.Fatalf("durable=%v: got %q, %v after the refused Put", durable, got, err)
+		}
+
+		b.Overwrite = true
+		if err := b.Put(ctx, "id/chunk_0.dat", []byte("2nd")); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := b.Get(ctx, "id/chunk_0.dat"); err != nil || string(got) != "2nd" {
+			t.Fatalf("durable=%v: got %q, %v after overwriting", durable, got, err)
+		}
+	}
+}
+
+func TestRetryBackendDoesNotRetryPermanentErrors(t *testing.T) {
+	// With an hour of backoff any retry would stall the test
+	retry := NewRetryBackend(NewLocalBackend(t.TempDir()), 3)
+	retry.InitialBackoff = time.Hour
+	ctx := context.Background()
+	if err := retry.Put(ctx, "id/chunk_0.dat", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := retry.Put(ctx, "id/chunk_0.dat", []byte("second")); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("second Put returned %v, want os.ErrExist", err)
+	}
+	if _, err := retry.Get(ctx, "id/missing.dat"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Get of a missing key returned %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestDecryptTreatsUnreadableShardAsMissing(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 5000)
+
+	faulty := &faultyBackend{Backend: NewLocalBackend(s.StorageDir), failSuffix: "_shard_1.dat"}
+	retry := NewRetryBackend(faulty, 2)
+	retry.InitialBackoff = time.Millisecond
+	s.Backend = retry
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	if faulty.gets.Load() == 0 {
+		t.Fatal("the faulty shard was never read")
+	}
+
+	s.StrictIntegrity = true
+	if err := s.DecryptFile(manifestID, t.TempDir(), testPassword); !errors.Is(err, ErrShardIntegrity) {
+		t.Fatalf("expected ErrShardIntegrity, got %v", err)
+	}
+}
+
+func TestDecryptFileContextHonoursDeadline(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, s, testOptions(), 3000)
+	s.Backend = NewRetryBackend(&faultyBackend{Backend: NewLocalBackend(s.StorageDir), failSuffix: "_shard_0.dat", block: true}, 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.DecryptFileContext(ctx, manifestID, t.TempDir(), testPassword); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestEncryptFileContextCancelled(t *testing.T) {
+	s := newTestSyncer(t)
+	path, _ := writeTestFile(t, t.TempDir(), "input.bin", 3000)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	manifestID, err := s.EncryptFileContext(ctx, path, testOptions())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if manifestID == "" {
+		t.Fatal("cancelled upload did not return a manifest ID to resume")
+	}
+}
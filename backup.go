@@ -0,0 +1,193 @@
+package secstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// backupFormat 标识备份清单的内容格式。
+const backupFormat = "secstorage-backup-v1"
+
+// backupListing 是备份清单的内容：每个文件相对路径到其 manifestID 的映射。
+// 它本身作为一个普通的加密对象保存，因此文件列表与文件内容受到同样的保护。
+type backupListing struct {
+	Format string            `json:"format"`
+	Files  map[string]string `json:"files"`
+}
+
+// backupRelPaths 将 paths 转换为相对于它们最近的共同父目录的路径，返回以 "/" 分隔的相对路径到原路径的映射。
+func backupRelPaths(paths []string) (map[string]string, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no files to back up")
+	}
+	absPaths := make([]string, len(paths))
+	for i, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", path, err)
+		}
+		absPaths[i] = absPath
+	}
+
+	// Walk up from the first file's directory until it contains every file
+	root := filepath.Dir(absPaths[0])
+	for _, absPath := range absPaths[1:] {
+		for {
+			if rel, err := filepath.Rel(root, absPath); err == nil && filepath.IsLocal(rel) {
+				break
+			}
+			parent := filepath.Dir(root)
+			if parent == root {
+				return nil, fmt.Errorf("%s and %s have no common directory", absPaths[0], absPath)
+			}
+			root = parent
+		}
+	}
+
+	relPaths := make(map[string]string, len(absPaths))
+	for i, absPath := range absPaths {
+		rel, err := filepath.Rel(root, absPath)
+		if err != nil {
+			return nil, err
+		}
+		rel = filepath.ToSlash(rel)
+		if _, ok := relPaths[rel]; ok {
+			return nil, fmt.Errorf("%s is listed more than once", paths[i])
+		}
+		relPaths[rel] = paths[i]
+	}
+	return relPaths, nil
+}
+
+// Backup 用 opts 分别加密 paths 中的每个文件，再把它们的相对路径和 manifestID 记录在一个加密的备份清单中，
+// 返回备份清单的 ID。相对路径以所有文件最近的共同父目录为根，Restore 据此还原目录结构。
+// 每个文件和备份清单都是普通的对象，可以单独解密，也会各自出现在 ListManifests 中。
+// 某个文件加密失败时，其余文件仍会逐个尝试，以便一次报告所有问题：成功加密的文件会被保留，
+// 备份清单只记录这些文件，Backup 同时返回该备份清单的 ID 和通过 errors.Join 汇总了每一个失败文件的错误。
+// 失败文件已上传的部分会被删除。只有所有文件都失败或备份清单无法保存时才返回空 ID，此时不会留下任何对象。
+// opts 不能设置 ManifestID 或 ResumeManifestID。
+func (s *Syncer) Backup(paths []string, opts EncryptionOptions) (backupID string, err error) {
+	if opts.ManifestID != "" || opts.ResumeManifestID != "" {
+		return "", errors.New("ManifestID and ResumeManifestID are not supported for backups")
+	}
+	relPaths, err := backupRelPaths(paths)
+	if err != nil {
+		return "", err
+	}
+	sorted := make([]string, 0, len(relPaths))
+	for relPath := range relPaths {
+		sorted = append(sorted, relPath)
+	}
+	sort.Strings(sorted)
+
+	// 1. Encrypt every file, keeping the ones that succeed unless no backup can be returned at all
+	listing := backupListing{Format: backupFormat, Files: make(map[string]string, len(sorted))}
+	defer func() {
+		if backupID == "" {
+			for _, manifestID := range listing.Files {
+				s.DeleteManifest(manifestID)
+			}
+		}
+	}()
+	ctx := context.Background()
+	var errs []error
+	for _, relPath := range sorted {
+		manifestID, err := s.EncryptFileContext(ctx, relPaths[relPath], opts)
+		if err != nil {
+			// The listing will not reference a partial upload, so nothing could resume it
+			if manifestID != "" {
+				s.DeleteManifest(manifestID)
+			}
+			errs = append(errs, fmt.Errorf("failed to back up %s: %w", relPaths[relPath], err))
+			continue
+		}
+		listing.Files[relPath] = manifestID
+	}
+	fileErr := errors.Join(errs...)
+	if len(listing.Files) == 0 {
+		return "", fileErr
+	}
+
+	// 2. Store the listing itself as an encrypted object
+	data, err := json.Marshal(listing)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal backup listing: %w", err)
+	}
+	backupID, err = s.encryptReader(ctx, bytes.NewReader(data), int64(len(data)), "backup.json", nil, nil, opts)
+	if err != nil {
+		if backupID != "" {
+			s.DeleteManifest(backupID)
+		}
+		return "", fmt.Errorf("failed to store backup listing: %w", err)
+	}
+
+	// 3. Make every new manifest directory durable before reporting the backup as complete
+	if err = s.syncManifestDirs(append(slices.Collect(maps.Values(listing.Files)), backupID)); err != nil {
+		s.DeleteManifest(backupID)
+		return "", err
+	}
+	return backupID, fileErr
+}
+
+// readBackupListing 解密并解析 backupID 对应的备份清单。
+func (s *Syncer) readBackupListing(backupID, password string) (*backupListing, error) {
+	r, err := s.OpenDecrypted(backupID, password)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup listing: %w", err)
+	}
+
+	var listing backupListing
+	if err := json.Unmarshal(data, &listing); err != nil || listing.Format != backupFormat {
+		return nil, fmt.Errorf("manifest %s is not a backup", backupID)
+	}
+	return &listing, nil
+}
+
+// Restore 将 Backup 创建的备份中的所有文件解密到 outputRoot 下，保留备份时的相对目录结构。
+// 遇到第一个错误时立即停止，已还原的文件会被保留。
+func (s *Syncer) Restore(backupID, outputRoot, password string) error {
+	listing, err := s.readBackupListing(backupID, password)
+	if err != nil {
+		return err
+	}
+	return s.DecryptDir(listing.Files, outputRoot, password)
+}
+
+// RestoreFile 只解密备份 backupID 中相对路径为 relPath 的一个文件，写入完整的目标文件路径 outputPath，
+// 不会读取或还原备份中的其他文件。relPath 使用备份时的相对路径（以 "/" 分隔）；
+// 备份中没有该文件时返回满足 errors.Is(err, os.ErrNotExist) 的错误。
+func (s *Syncer) RestoreFile(backupID, relPath, outputPath, password string) error {
+	if outputPath == "" {
+		return errors.New("an output file path is required")
+	}
+	listing, err := s.readBackupListing(backupID, password)
+	if err != nil {
+		return err
+	}
+	manifestID, ok := listing.Files[path.Clean(filepath.ToSlash(relPath))]
+	if !ok {
+		return fmt.Errorf("%s is not in backup %s: %w", relPath, backupID, os.ErrNotExist)
+	}
+	_, err = s.decryptFile(context.Background(), s.keyDeriver(), manifestID, password, func(string) (string, error) {
+		return outputPath, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %w", relPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package secstorage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	root := t.TempDir()
+	a, aData := writeTestFile(t, root, filepath.Join("x", "a.txt"), 3000)
+	b, bData := writeTestFile(t, root, filepath.Join("y", "z", "b.txt"), 100)
+
+	s := newTestSyncer(t)
+	backupID, err := s.Backup([]string{a, b}, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputRoot := t.TempDir()
+	if err := s.Restore(backupID, outputRoot, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]byte{"x/a.txt": aData, "y/z/b.txt": bData} {
+		got, err := os.ReadFile(filepath.Join(outputRoot, filepath.FromSlash(name)))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("%s not restored: %v", name, err)
+		}
+	}
+
+	ids, err := s.ListManifests()
+	if err != nil || len(ids) != 3 {
+		t.Fatalf("ListManifests = %v, %v; want two files and the backup listing", ids, err)
+	}
+	if err := s.Restore(backupID, t.TempDir(), "wrong password"); err == nil {
+		t.Fatal("restore with a wrong password succeeded")
+	}
+}
+
+func TestRestoreFile(t *testing.T) {
+	root := t.TempDir()
+	a, aData := writeTestFile(t, root, filepath.Join("x", "a.txt"), 3000)
+	b, _ := writeTestFile(t, root, filepath.Join("y", "b.txt"), 100)
+
+	s := newTestSyncer(t)
+	backupID, err := s.Backup([]string{a, b}, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the requested file is read, so losing another one does not matter
+	listing, err := s.readBackupListing(backupID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(s.StorageDir, listing.Files["y/b.txt"])); err != nil {
+		t.Fatal(err)
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "restored.txt")
+	if err := s.RestoreFile(backupID, "x/a.txt", outputPath, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(outputPath); err != nil || !bytes.Equal(got, aData) {
+		t.Fatalf("x/a.txt not restored: %v", err)
+	}
+	if err := s.RestoreFile(backupID, "x/missing.txt", outputPath, testPassword); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v for a file that is not in the backup", err)
+	}
+}
+
+func TestRestoreRejectsPlainObject(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, s, testOptions(), 100)
+	if err := s.Restore(manifestID, t.TempDir(), testPassword); err == nil {
+		t.Fatal("restored an object that is not a backup")
+	}
+}
+
+func TestBackupKeepsSuccessfulFilesOnFailure(t *testing.T) {
+	root := t.TempDir()
+	a, aData := writeTestFile(t, root, "a.txt", 100)
+
+	s := newTestSyncer(t)
+	backupID, err := s.Backup([]string{filepath.Join(root, "missing1.txt"), a, filepath.Join(root, "missing2.txt")}, testOptions())
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v, want os.ErrNotExist", err)
+	}
+	// Every failing file is reported, not just the first
+	if joined, ok := err.(interface{ Unwrap() []error }); !ok || len(joined.Unwrap()) != 2 {
+		t.Fatalf("expected one error per missing file, got %v", err)
+	}
+	if backupID == "" {
+		t.Fatal("partial backup returned no ID")
+	}
+
+	// The listing covers only the file that was stored
+	listing, err := s.readBackupListing(backupID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listing.Files) != 1 || listing.Files["a.txt"] == "" {
+		t.Fatalf("listing = %v, want only a.txt", listing.Files)
+	}
+	outputRoot := t.TempDir()
+	if err := s.Restore(backupID, outputRoot, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(outputRoot, "a.txt")); err != nil || !bytes.Equal(got, aData) {
+		t.Fatalf("a.txt not restored: %v", err)
+	}
+	if entries, _ := os.ReadDir(s.StorageDir); len(entries) != 2 {
+		t.Fatalf("partial backup left %d objects, want the file and the listing", len(entries))
+	}
+}
+
+func TestBackupCleansUpOnTotalFailure(t *testing.T) {
+	root := t.TempDir()
+	a, _ := writeTestFile(t, root, "a.txt", 100)
+
+	s := newTestSyncer(t)
+	backupID, err := s.Backup([]string{filepath.Join(root, "missing1.txt"), filepath.Join(root, "missing2.txt")}, testOptions())
+	if !errors.Is(err, os.ErrNotExist) || backupID != "" {
+		t.Fatalf("Backup = %q, %v; want no ID and os.ErrNotExist", backupID, err)
+	}
+	if entries, _ := os.ReadDir(s.StorageDir); len(entries) > 0 {
+		t.Fatalf("failed backup left %d objects behind", len(entries))
+	}
+	if _, err := s.Backup([]string{a, a}, testOptions()); err == nil {
+		t.Fatal("backup listing a file twice succeeded")
+	}
+}
+
+func TestBackupRelPaths(t *testing.T) {
+	root := t.TempDir()
+	got, err := backupRelPaths([]string{filepath.Join(root, "a", "x"), filepath.Join(root, "b", "c", "y")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["a/x"] == "" || got["b/c/y"] == "" {
+		t.Fatalf("backupRelPaths = %v", got)
+	}
+	got, err = backupRelPaths([]string{filepath.Join(root, "only")})
+	if err != nil || got["only"] == "" {
+		t.Fatalf("backupRelPaths of a single file = %v, %v", got, err)
+	}
+}
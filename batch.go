@@ -0,0 +1,140 @@
+package secstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/awnumar/memguard"
+)
+
+// validateSharedSalt 检查 SharedSalt 的长度，并拒绝与确定性模式同时使用。
+func (opts EncryptionOptions) validateSharedSalt() error {
+	if len(opts.SharedSalt) == 0 {
+		return nil
+	}
+	if opts.Deterministic {
+		return errors.New("SharedSalt cannot be combined with deterministic encryption")
+	}
+	if len(opts.SharedSalt) < saltLength {
+		return fmt.Errorf("shared salt must be at least %d bytes, got %d", saltLength, len(opts.SharedSalt))
+	}
+	return nil
+}
+
+// keyCache 是在一次批量解密中缓存派生结果的 KeyDeriver。批内所有文件使用同一个密码，
+// 因此盐值和参数相同的接收者只需派生一次。缓存的密钥保存在锁定内存中，用完后必须调用 destroy 销毁。
+type keyCache struct {
+	kd      KeyDeriver
+	mu      sync.Mutex
+	entries map[string]*keyCacheEntry
+}
+
+// keyCacheEntry 是 keyCache 中的一项；once 保证并发请求同一盐值时只派生一次。
+type keyCacheEntry struct {
+	once sync.Once
+	key  *memguard.LockedBuffer
+}
+
+// newKeyCache 创建一个通过 kd 派生密钥的 keyCache。
+func newKeyCache(kd KeyDeriver) *keyCache {
+	return &keyCache{kd: kd, entries: make(map[string]*keyCacheEntry)}
+}
+
+// Derive 实现了 KeyDeriver 接口，返回缓存密钥的副本，调用方可以照常销毁它。
+func (c *keyCache) Derive(password, salt []byte, params Argon2Config) *memguard.LockedBuffer {
+	id := fmt.Sprintf("%x/%d/%d/%d", salt, params.Time, params.MemoryKB, params.Threads)
+	c.mu.Lock()
+	entry, ok := c.entries[id]
+	if !ok {
+		entry = &keyCacheEntry{}
+		c.entries[id] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() { entry.key = c.kd.Derive(password, salt, params) })
+	key := memguard.NewBuffer(entry.key.Size())
+	key.Copy(entry.key.Bytes())
+	return key
+}
+
+// destroy 销毁所有缓存的密钥。
+func (c *keyCache) destroy() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.entries {
+		if entry.key != nil {
+			entry.key.Destroy()
+		}
+	}
+	c.entries = nil
+}
+
+// DecryptBatch 把 manifestIDs 中的每个文件解密到 outputDir，输出文件名取自各清单保存的原始文件名。
+// 返回的结果与 manifestIDs 一一对应，FileResult.Path 是输出文件名。文件由最多 BatchConcurrency 个
+// goroutine 并行解密，单个文件的失败不影响其他文件；有文件失败时，返回的错误通过 errors.Join 汇总了每一个失败。
+//
+// 整批文件使用同一个密码，派生出的密钥在批内按盐值和参数缓存，因此以同一 EncryptionOptions.SharedSalt
+// 加密的文件只需一次 Argon2id 计算。各自使用随机盐值的文件仍需各自派生，此时只能从并行中获益。
+// 未保存原始文件名的清单，以及与批内另一个清单的原始文件名相同的清单（其中之一）会被报告为失败。
+func (s *Syncer) DecryptBatch(manifestIDs []string, outputDir, password string) ([]FileResult, error) {
+	ctx := context.Background()
+	kd := newKeyCache(s.keyDeriver())
+	defer kd.destroy()
+
+	concurrency := s.BatchConcurrency
+	if concurrency < 1 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	var mu sync.Mutex
+	claimed := make(map[string]string)
+	results := make([]FileResult, len(manifestIDs))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				manifestID := manifestIDs[i]
+				result := &results[i]
+				result.ManifestID = manifestID
+				var report DecryptReport
+				report, result.Err = s.decryptFile(ctx, kd, manifestID, password, func(name string) (string, error) {
+					if name == "" {
+						return "", fmt.Errorf("manifest %s does not store the original filename", manifestID)
+					}
+					if !filepath.IsLocal(name) || filepath.Base(name) != name {
+						return "", fmt.Errorf("refusing to restore %q outside of the output directory", name)
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					if other, ok := claimed[name]; ok {
+						return "", fmt.Errorf("manifest %s restores to %s, as does manifest %s in the same batch", manifestID, name, other)
+					}
+					claimed[name] = manifestID
+					result.Path = name
+					return filepath.Join(outputDir, name), nil
+				})
+				result.Metadata = report.Metadata
+			}
+		}()
+	}
+	for i := range manifestIDs {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("failed to decrypt %s: %w", result.ManifestID, result.Err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
@@ -0,0 +1,95 @@
+package secstorage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// encryptBatchFiles 以 opts 加密 names 中的每个文件，返回 manifestID 列表和各文件的内容。
+func encryptBatchFiles(t *testing.T, s *Syncer, opts EncryptionOptions, names ...string) ([]string, [][]byte) {
+	t.Helper()
+	dir := t.TempDir()
+	var ids []string
+	var contents [][]byte
+	for i, name := range names {
+		path, data := writeTestFile(t, dir, name, 1000+i*500)
+		manifestID, err := s.EncryptFile(path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, manifestID)
+		contents = append(contents, data)
+	}
+	return ids, contents
+}
+
+func TestDecryptBatch(t *testing.T) {
+	s := newTestSyncer(t)
+	s.BatchConcurrency = 2
+	ids, contents := encryptBatchFiles(t, s, testOptions(), "a.bin", "b.bin", "c.bin")
+
+	// A missing manifest fails on its own without stopping the rest
+	ids = append(ids, strings.Repeat("0", len(ids[0])))
+	outputDir := t.TempDir()
+	results, err := s.DecryptBatch(ids, outputDir, testPassword)
+	if err == nil || !errors.Is(err, results[3].Err) {
+		t.Fatalf("got %v, want the missing manifest's error", err)
+	}
+	for i, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		if results[i].Err != nil || results[i].Path != name || results[i].ManifestID != ids[i] {
+			t.Fatalf("result %d = %+v", i, results[i])
+		}
+		if got, err := os.ReadFile(filepath.Join(outputDir, name)); err != nil || !bytes.Equal(got, contents[i]) {
+			t.Fatalf("%s differs: %v", name, err)
+		}
+	}
+}
+
+func TestDecryptBatchDuplicateName(t *testing.T) {
+	s := newTestSyncer(t)
+	first, _ := encryptBatchFiles(t, s, testOptions(), "same.bin")
+	second, _ := encryptBatchFiles(t, s, testOptions(), "same.bin")
+	results, err := s.DecryptBatch(append(first, second...), t.TempDir(), testPassword)
+	if err == nil {
+		t.Fatal("expected an error for two files with the same name")
+	}
+	if (results[0].Err == nil) == (results[1].Err == nil) {
+		t.Fatalf("exactly one of the files should fail: %v, %v", results[0].Err, results[1].Err)
+	}
+}
+
+func TestDecryptBatchSharedSalt(t *testing.T) {
+	s := newTestSyncer(t)
+	deriver := &countingDeriver{}
+	s.KeyDeriver = deriver
+
+	opts := testOptions()
+	opts.SharedSalt = bytes.Repeat([]byte{7}, saltLength)
+	shared, _ := encryptBatchFiles(t, s, opts, "a.bin", "b.bin", "c.bin")
+	separate, _ := encryptBatchFiles(t, s, testOptions(), "d.bin", "e.bin")
+
+	deriver.calls.Store(0)
+	if _, err := s.DecryptBatch(shared, t.TempDir(), testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if calls := deriver.calls.Load(); calls != 1 {
+		t.Fatalf("files sharing a salt derived %d keys, want 1", calls)
+	}
+
+	deriver.calls.Store(0)
+	if _, err := s.DecryptBatch(separate, t.TempDir(), testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if calls := deriver.calls.Load(); calls != 2 {
+		t.Fatalf("files with their own salts derived %d keys, want 2", calls)
+	}
+
+	opts.SharedSalt = opts.SharedSalt[:8]
+	if _, err := s.EncryptFile(writeTestFileOnly(t), opts); err == nil {
+		t.Fatal("expected an error for a short shared salt")
+	}
+}
@@ -0,0 +1,63 @@
+package secstorage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMemoryBudget 表示即使只处理一个块，估计的内存占用也超过了 Syncer.MemoryBudget。
+var ErrMemoryBudget = errors.New("estimated memory use exceeds the memory budget")
+
+// chunkDecryptMemory 估计读取、重建并解密第 i 个块时同时占用的最大内存：
+// 所有分片、纠删码校验和重建时分配的奇偶校验大小的缓冲区、拼接后的密文以及解密出的明文。
+func chunkDecryptMemory(m *Manifest, i int) int64 {
+	encrypted := int64(m.EncryptedChunkSizes[i])
+	if m.ParityShards == 0 {
+		return 2 * encrypted
+	}
+	shard := int64(m.shardSize(i))
+	total := int64(m.DataShards + m.ParityShards)
+	// Split shards are read into a buffer before being handed over, one at a time
+	var parts int64
+	if shardPartCount(int(shard), m.MaxShardBytes) > 1 {
+		parts = shard
+	}
+	return total*shard + 2*int64(m.ParityShards)*shard + parts + 2*encrypted
+}
+
+// decryptMemory 返回清单中所有块的 chunkDecryptMemory 的最大值，即同时只处理一个块时的峰值内存估计。
+func decryptMemory(m *Manifest) int64 {
+	var peak int64
+	for i := range m.ChunkPaths {
+		peak = max(peak, chunkDecryptMemory(m, i))
+	}
+	return peak
+}
+
+// EstimateDecryptMemory 估计解密 manifestID 对应的文件时在途数据占用的峰值内存（字节），
+// 由最大块的大小和纠删码的分片开销决定，不包括 Argon2 派生密钥所需的内存。
+// DecryptFile 逐块处理，峰值即该估计值；VerifyManifest 以 concurrency 个块并行时约为其 concurrency 倍。
+// 估计只读取清单而不验证签名，因此不需要密码。
+func (s *Syncer) EstimateDecryptMemory(manifestID string) (int64, error) {
+	manifest, err := s.loadManifest(manifestID)
+	if err != nil {
+		return 0, err
+	}
+	return decryptMemory(manifest), nil
+}
+
+// limitConcurrency 根据 Syncer.MemoryBudget 限制处理清单 m 时并行的块数：返回不超过 concurrency、
+// 且估计内存不超过预算的最大并发数。未设置预算时原样返回；连一个块都超出预算时返回 ErrMemoryBudget。
+func (s *Syncer) limitConcurrency(m *Manifest, concurrency int) (int, error) {
+	if s.MemoryBudget <= 0 {
+		return concurrency, nil
+	}
+	perChunk := decryptMemory(m)
+	if perChunk > s.MemoryBudget {
+		return 0, fmt.Errorf("%w: a single chunk needs about %d bytes, the budget is %d", ErrMemoryBudget, perChunk, s.MemoryBudget)
+	}
+	if perChunk == 0 {
+		return concurrency, nil
+	}
+	return int(min(int64(concurrency), s.MemoryBudget/perChunk)), nil
+}
@@ -0,0 +1,79 @@
+package secstorage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestEstimateDecryptMemory(t *testing.T) {
+	s := newTestSyncer(t)
+	sealedOpts := testOptions()
+	sealedOpts.DataShards, sealedOpts.ParityShards = 1, 0
+	sealedID, data := encryptTestFile(t, s, sealedOpts, 5000)
+
+	manifest, err := s.ReadManifest(sealedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	estimate, err := s.EstimateDecryptMemory(sealedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2 * int64(slices.Max(manifest.EncryptedChunkSizes)); estimate != want {
+		t.Fatalf("sealed estimate = %d, want %d", estimate, want)
+	}
+
+	// Parity shards add to the footprint of the same chunks
+	path := filepath.Join(t.TempDir(), "input.bin")
+	if err := os.WriteFile(path, data, defaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	codedID, err := s.EncryptFile(path, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	coded, err := s.EstimateDecryptMemory(codedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coded <= estimate {
+		t.Fatalf("erasure coded estimate %d is not above the sealed estimate %d", coded, estimate)
+	}
+
+	if _, err := s.EstimateDecryptMemory("0123456789abcdef0123456789abcdef"); err == nil {
+		t.Fatal("expected an error for a missing manifest")
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 5000)
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	perChunk := decryptMemory(manifest)
+
+	s.MemoryBudget = perChunk*3 + perChunk/2
+	if got, err := s.limitConcurrency(manifest, 8); err != nil || got != 3 {
+		t.Fatalf("limitConcurrency = %d, %v; want 3", got, err)
+	}
+	if got, _ := s.limitConcurrency(manifest, 2); got != 2 {
+		t.Fatalf("limitConcurrency lowered concurrency within the budget to %d", got)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	if report, err := s.VerifyManifest(manifestID, testPassword, 8); err != nil || !report.OK() {
+		t.Fatalf("VerifyManifest = %+v, %v", report, err)
+	}
+
+	s.MemoryBudget = perChunk - 1
+	if err := s.DecryptFile(manifestID, t.TempDir(), testPassword); !errors.Is(err, ErrMemoryBudget) {
+		t.Fatalf("DecryptFile: got %v, want ErrMemoryBudget", err)
+	}
+	if _, err := s.VerifyManifest(manifestID, testPassword, 1); !errors.Is(err, ErrMemoryBudget) {
+		t.Fatalf("VerifyManifest: got %v, want ErrMemoryBudget", err)
+	}
+}
@@ -0,0 +1,50 @@
+package secstorage
+
+import (
+	"sync"
+
+	"github.com/awnumar/memguard"
+)
+
+// BufferPool 是块和分片缓冲区的复用池，通过 Syncer.BufferPool 启用，可以被多个 Syncer 共享。
+// 缓冲区放回池中之前会被清零，因此池中不会残留明文或密钥材料。
+type BufferPool struct {
+	pool sync.Pool
+}
+
+// NewBufferPool 创建一个空的 BufferPool。
+func NewBufferPool() *BufferPool {
+	return &BufferPool{}
+}
+
+// get 返回一个长度为 0、容量至少为 size 的缓冲区。池中的缓冲区太小时将其丢弃并重新分配。
+// p 为 nil 时总是分配新的缓冲区。
+func (p *BufferPool) get(size int) []byte {
+	if p != nil {
+		if buf, ok := p.pool.Get().(*[]byte); ok && cap(*buf) >= size {
+			return (*buf)[:0]
+		}
+	}
+	return make([]byte, 0, size)
+}
+
+// put 清零 buf 并在 p 不为 nil 时将其放回池中。调用方之后不能再使用 buf。
+func (p *BufferPool) put(buf []byte) {
+	buf = buf[:cap(buf)]
+	memguard.WipeBytes(buf)
+	if p != nil && cap(buf) > 0 {
+		p.pool.Put(&buf)
+	}
+}
+
+// chunkBuffers 从 p 中取出加密一个块所需的明文和密文缓冲区，容量足以容纳 opts 下最大的块。
+// 密文缓冲区还预留了奇偶校验分片的空间，使 reedsolomon 的 Split 无需另行分配。
+func chunkBuffers(p *BufferPool, opts EncryptionOptions) (plain, encoded []byte) {
+	// The chunker never produces chunks larger than twice the average size
+	maxChunk := opts.ChunkSizeKB * 2048
+	encodedSize := maxChunk + maxCipherOverhead
+	if opts.ParityShards > 0 {
+		encodedSize = shardSizeFor(encodedSize, opts.DataShards, opts.ParityShards) * (opts.DataShards + opts.ParityShards)
+	}
+	return p.get(maxChunk), p.get(encodedSize)
+}
@@ -1,17 +1,40 @@
 package secstorage
 
 import (
-	"crypto/rand"
 	"fmt"
 	"io"
+	"slices"
 
+	"github.com/awnumar/memguard"
 	"github.com/restic/chunker"
 )
 
+// defaultChunkerPolynomial 是新文件分块时使用的 Rabin 多项式。
+// 块边界只由内容和多项式决定，因此它会被记录在清单中，使续传等操作能够得到完全相同的块。
+const defaultChunkerPolynomial = chunker.Pol(0x3DA3358B4DC173) // Corresponds to 1MiB average
+
+// minChunkSizeKB 和 maxChunkSizeKB 是 ChunkSizeKB 的取值范围。块最大可达平均大小的两倍，
+// 并且需要整块放在内存中加密，因此平均大小限制在 16 MiB 以内。
+// 下限有意取 1 KB，而不是其他分块器常用的 64 KB：最小块（512 字节）仍远大于 Rabin 指纹的 64 字节窗口，
+// 分块器可以正常工作；小块导致的分片数量膨胀由 EncryptionOptions.MaxChunks 控制。
+// 测试依赖 1 KB 的块，以便几 KB 的文件就能覆盖多块的情形。
+const (
+	minChunkSizeKB = 1
+	maxChunkSizeKB = 16 * 1024
+)
+
+// validateChunkSizeKB 检查 chunkSizeKB 在 newCDCChunker 支持的范围内。
+func validateChunkSizeKB(chunkSizeKB int) error {
+	if chunkSizeKB < minChunkSizeKB || chunkSizeKB > maxChunkSizeKB {
+		return fmt.Errorf("chunk size must be between %d and %d KB, got %d KB", minChunkSizeKB, maxChunkSizeKB, chunkSizeKB)
+	}
+	return nil
+}
+
 // newCDCChunker 创建一个新的内容定义分块器 (Content-Defined Chunker)。
 // CDC 是一种智能的分块算法，它根据文件内容本身来决定如何分块。
 // 这意味着即使文件内容有小的改动，大部分分块的哈希值仍然保持不变，非常适合增量备份和去重场景。
-func newCDCChunker(r io.Reader, chunkSizeKB int) *chunker.Chunker {
+func newCDCChunker(r io.Reader, chunkSizeKB int, poly chunker.Pol) *chunker.Chunker {
 	// The polynomial is chosen based on the desired average chunk size.
 	// See https://github.com/restic/chunker/blob/master/chunker_test.go#L24 for details.
 	// We use NewWithBoundaries to enforce our own size limits based on the config.
@@ -20,22 +43,31 @@ func newCDCChunker(r io.Reader, chunkSizeKB int) *chunker.Chunker {
 	minSize := avgSize / 2
 	maxSize := avgSize * 2
 
-	// 注意：下面的多项式是为 1MiB 平均块大小优化的。
+	// 注意：默认的多项式是为 1MiB 平均块大小优化的。
 	// 如果在配置中设置了显著不同于 1MiB 的 chunk_size_kb，
 	// 分块效率可能会降低。为了获得最佳性能，
 	// 应根据平均块大小动态生成或选择多项式。
-	// 为简单起见，我们在这里使用一个固定的值。
-	poly := chunker.Pol(0x3DA3358B4DC173) // Corresponds to 1MiB average
-
+	// 为简单起见，新文件统一使用 defaultChunkerPolynomial。
 	return chunker.NewWithBoundaries(r, poly, minSize, maxSize)
 }
 
-// generateManifestID 为清单 (manifest) 生成一个唯一的16字节（32个十六进制字符）ID。
-// 这个 ID 用于唯一标识一次加密操作产生的所有文件和元数据。
-func generateManifestID() (string, error) {
-	bytes := make([]byte, 16) // 16 bytes = 32 hex characters
-	if _, err := rand.Read(bytes); err != nil {
-		return "", err
+// wipingReader 记录 Read 写入过的缓冲区，使分块器内部保存着尚未到达块边界的明文的缓冲区可以在加密结束后被擦除。
+type wipingReader struct {
+	r    io.Reader
+	bufs [][]byte
+}
+
+func (w *wipingReader) Read(p []byte) (int, error) {
+	// The chunker refills the same buffer, so it is recorded once
+	if full := p[:cap(p)]; len(full) > 0 && !slices.ContainsFunc(w.bufs, func(buf []byte) bool { return &buf[len(buf)-1] == &full[len(full)-1] }) {
+		w.bufs = append(w.bufs, full)
+	}
+	return w.r.Read(p)
+}
+
+// wipe 清零所有记录过的缓冲区。
+func (w *wipingReader) wipe() {
+	for _, buf := range w.bufs {
+		memguard.WipeBytes(buf)
 	}
-	return fmt.Sprintf("%x", bytes), nil
 }
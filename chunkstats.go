@@ -0,0 +1,142 @@
+package secstorage
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/awnumar/memguard"
+)
+
+// ChunkStats 描述内容定义分块实际产生的块大小分布，用于判断配置的平均块大小是否被多项式较好地遵循。
+type ChunkStats struct {
+	// Count 是块的数量，TotalBytes 是所有块的明文字节数之和。
+	Count      int   `json:"count"`
+	TotalBytes int64 `json:"total_bytes"`
+	// Min、Max、Mean 和 StdDev 是块明文大小的最小值、最大值、平均值和（总体）标准差，Count 为 0 时均为 0。
+	Min    int     `json:"min"`
+	Max    int     `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+}
+
+// newChunkStats 计算 sizes 的分布。
+func newChunkStats(sizes []int) ChunkStats {
+	stats := ChunkStats{Count: len(sizes)}
+	if len(sizes) == 0 {
+		return stats
+	}
+	stats.Min, stats.Max = sizes[0], sizes[0]
+	for _, size := range sizes {
+		stats.TotalBytes += int64(size)
+		stats.Min = min(stats.Min, size)
+		stats.Max = max(stats.Max, size)
+	}
+	stats.Mean = float64(stats.TotalBytes) / float64(len(sizes))
+	var squares float64
+	for _, size := range sizes {
+		d := float64(size) - stats.Mean
+		squares += d * d
+	}
+	stats.StdDev = math.Sqrt(squares / float64(len(sizes)))
+	return stats
+}
+
+// ErrTooManyChunks 表示文件分块后的块数超过了 EncryptionOptions.MaxChunks。
+var ErrTooManyChunks = errors.New("file has more chunks than MaxChunks allows")
+
+// tooManyChunks 返回 chunks 个块超过 opts.MaxChunks 时的错误，并给出保证大小为 size 的文件满足上限的最小 ChunkSizeKB：
+// 块不会小于平均大小的一半，因此平均大小至少为 2*size/MaxChunks 时块数一定不超过上限。
+func tooManyChunks(chunks, size int64, opts EncryptionOptions) error {
+	limit := int64(opts.MaxChunks) * 1024
+	suggested := (2*size + limit - 1) / limit
+	return fmt.Errorf("%w: %d chunks of about %d KB exceed the limit of %d, use a ChunkSizeKB of at least %d",
+		ErrTooManyChunks, chunks, opts.ChunkSizeKB, opts.MaxChunks, suggested)
+}
+
+// checkProjectedChunks 在大小为 size 的文件的块数必然超过 opts.MaxChunks 时返回错误。
+// 块不会大于平均大小的两倍，由此得到块数的下限；实际块数取决于内容，加密过程中仍会严格检查。
+func checkProjectedChunks(size int64, opts EncryptionOptions) error {
+	if opts.MaxChunks <= 0 || opts.ChunkSizeKB <= 0 {
+		return nil
+	}
+	maxChunkBytes := int64(opts.ChunkSizeKB) * 2048
+	if atLeast := (size + maxChunkBytes - 1) / maxChunkBytes; atLeast > int64(opts.MaxChunks) {
+		return tooManyChunks(atLeast, size, opts)
+	}
+	return nil
+}
+
+// PlanEncryption 用 opts.ChunkSizeKB 对 localPath 进行与 EncryptFile 完全相同的内容定义分块，但不加密也不写入任何数据，
+// 返回块大小的分布，其中 Count 就是加密将产生的块数。新文件使用为 1MiB 平均块大小调优的默认多项式，
+// 因此在配置明显不同的 ChunkSizeKB 之前，可以先用它确认实际的块大小是否符合预期。
+// 块数超过 opts.MaxChunks 时同时返回完整的分布和满足 errors.Is(err, ErrTooManyChunks) 的错误。
+func (s *Syncer) PlanEncryption(localPath string, opts EncryptionOptions) (ChunkStats, error) {
+	if err := validateChunkSizeKB(opts.ChunkSizeKB); err != nil {
+		return ChunkStats{}, err
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		return ChunkStats{}, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return ChunkStats{}, err
+	}
+	size := int64(-1)
+	if info.Mode().IsRegular() {
+		size = info.Size()
+	}
+	source, release := s.sourceReader(file, size, opts)
+	defer release()
+
+	var sizes []int
+	chunker := newCDCChunker(source, opts.ChunkSizeKB, defaultChunkerPolynomial)
+	buf := make([]byte, 0, opts.ChunkSizeKB*2048)
+	for {
+		chunk, err := chunker.Next(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ChunkStats{}, fmt.Errorf("failed to read chunk: %w", err)
+		}
+		sizes = append(sizes, len(chunk.Data))
+	}
+	stats := newChunkStats(sizes)
+	if opts.MaxChunks > 0 && stats.Count > opts.MaxChunks {
+		return stats, tooManyChunks(int64(stats.Count), stats.TotalBytes, opts)
+	}
+	return stats, nil
+}
+
+// PlanChunkHashes 用 opts.ChunkSizeKB 对 r 的全部内容进行与 EncryptFile 完全相同的内容定义分块，
+// 按顺序返回每个块明文的 SHA-256，不加密也不写入任何数据，便于与支持服务端去重的备份服务器协商哪些块需要上传。
+// 注意这些哈希是明文的无密钥摘要，能让接收方确认某个块的内容，只应发送给可信的服务器。读取过的明文缓冲区在返回前被擦除。
+func PlanChunkHashes(r io.Reader, opts EncryptionOptions) ([][32]byte, error) {
+	if err := validateChunkSizeKB(opts.ChunkSizeKB); err != nil {
+		return nil, err
+	}
+	source := &wipingReader{r: r}
+	defer source.wipe()
+	buf := make([]byte, 0, opts.ChunkSizeKB*2048)
+	defer func() { memguard.WipeBytes(buf[:cap(buf)]) }()
+
+	var hashes [][32]byte
+	chunker := newCDCChunker(source, opts.ChunkSizeKB, defaultChunkerPolynomial)
+	for {
+		chunk, err := chunker.Next(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunk: %w", err)
+		}
+		hashes = append(hashes, sha256.Sum256(chunk.Data))
+	}
+	return hashes, nil
+}
@@ -0,0 +1,136 @@
+package secstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestNewChunkStats(t *testing.T) {
+	stats := newChunkStats([]int{2, 4, 4, 4, 5, 5, 7, 9})
+	want := ChunkStats{Count: 8, TotalBytes: 40, Min: 2, Max: 9, Mean: 5, StdDev: 2}
+	if stats != want {
+		t.Fatalf("newChunkStats = %+v, want %+v", stats, want)
+	}
+	if empty := newChunkStats(nil); empty != (ChunkStats{}) {
+		t.Fatalf("stats of no chunks = %+v", empty)
+	}
+}
+
+func TestPlanEncryptionMatchesEncryptFile(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	path, _ := writeTestFile(t, t.TempDir(), "input.bin", 20000)
+
+	plan, err := s.PlanEncryption(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Count < 2 || plan.TotalBytes != 20000 || plan.Min > plan.Max || math.IsNaN(plan.StdDev) {
+		t.Fatalf("implausible plan %+v", plan)
+	}
+	// The chunker never exceeds twice the configured average
+	if plan.Max > 2048 {
+		t.Fatalf("largest chunk %d exceeds twice the average", plan.Max)
+	}
+
+	manifestID, err := s.EncryptFile(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.InspectManifest(manifestID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ChunkStats != plan {
+		t.Fatalf("encrypted chunks %+v differ from the plan %+v", info.ChunkStats, plan)
+	}
+}
+
+func TestPlanChunkHashesMatchesEncryptFile(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	path, data := writeTestFile(t, t.TempDir(), "input.bin", 20000)
+
+	hashes, err := PlanChunkHashes(bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestID, err := s.EncryptFile(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != len(manifest.PlaintextChunkSizes) {
+		t.Fatalf("got %d hashes for %d chunks", len(hashes), len(manifest.PlaintextChunkSizes))
+	}
+	rest := data
+	for i, size := range manifest.PlaintextChunkSizes {
+		if hashes[i] != sha256.Sum256(rest[:size]) {
+			t.Fatalf("hash of chunk %d differs", i)
+		}
+		rest = rest[size:]
+	}
+
+	if empty, err := PlanChunkHashes(bytes.NewReader(nil), opts); err != nil || len(empty) != 0 {
+		t.Fatalf("got %d hashes, %v for empty input", len(empty), err)
+	}
+	opts.ChunkSizeKB = 0
+	if _, err := PlanChunkHashes(bytes.NewReader(data), opts); err == nil {
+		t.Fatal("expected an error for a zero chunk size")
+	}
+}
+
+func TestMaxChunks(t *testing.T) {
+	s := newTestSyncer(t)
+	path, data := writeTestFile(t, t.TempDir(), "input.bin", 20000)
+
+	// The projection rejects the file before anything is written
+	opts := testOptions()
+	opts.MaxChunks = 5
+	_, err := s.EncryptFile(path, opts)
+	if !errors.Is(err, ErrTooManyChunks) {
+		t.Fatalf("got %v, want ErrTooManyChunks", err)
+	}
+	if !strings.Contains(err.Error(), "ChunkSizeKB of at least 8") {
+		t.Fatalf("error does not suggest a chunk size: %v", err)
+	}
+	if ids, err := s.ListManifests(); err != nil || len(ids) != 0 {
+		t.Fatalf("manifests after a rejected file: %v, %v", ids, err)
+	}
+
+	// PlanEncryption reports the exact count along with the error
+	plan, err := s.PlanEncryption(path, opts)
+	if !errors.Is(err, ErrTooManyChunks) || plan.Count <= opts.MaxChunks {
+		t.Fatalf("plan %+v, %v", plan, err)
+	}
+	opts.MaxChunks = plan.Count
+	if _, err := s.PlanEncryption(path, opts); err != nil {
+		t.Fatal(err)
+	}
+	manifestID, err := s.EncryptFile(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
+
+func TestMaxChunksEnforcedWhileEncrypting(t *testing.T) {
+	s := newTestSyncer(t)
+	data := make([]byte, 20000)
+	rand.Read(data)
+	opts := testOptions()
+	opts.MaxChunks = 3
+	// A reader has no size to project from, so the limit is hit during the upload
+	if _, err := s.EncryptReader(context.Background(), bytes.NewReader(data), "input.bin", opts); !errors.Is(err, ErrTooManyChunks) {
+		t.Fatalf("got %v, want ErrTooManyChunks", err)
+	}
+}
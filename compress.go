@@ -0,0 +1,65 @@
+package secstorage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// maxDecompressedManifestSize 是压缩清单解压后允许的最大字节数，防止精心构造的压缩数据耗尽内存。
+const maxDecompressedManifestSize = 64 << 20
+
+// gzipMagic 是 gzip 数据的开头两个字节。JSON 文本不可能以它们开头，因此可以据此识别压缩的清单。
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// encodeManifest 将已签名的清单编码为写入 manifest.json 的字节，Syncer.CompressManifest 为 true 时使用 gzip 压缩。
+// 签名只覆盖清单内容的 JSON 编码，与磁盘上的格式无关。
+func (s *Syncer) encodeManifest(manifest *Manifest) ([]byte, error) {
+	if !s.CompressManifest {
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+		}
+		return data, nil
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress manifest: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress manifest: %w", err)
+	}
+	return compressed.Bytes(), nil
+}
+
+// decodeManifest 解析 manifest.json 的内容，无论它是否经过压缩。它只解码，不做结构校验。
+func decodeManifest(data []byte) (*Manifest, error) {
+	if bytes.HasPrefix(data, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress manifest: %w", err)
+		}
+		defer zr.Close()
+		data, err = io.ReadAll(io.LimitReader(zr, maxDecompressedManifestSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress manifest: %w", err)
+		}
+		if len(data) > maxDecompressedManifestSize {
+			return nil, fmt.Errorf("decompressed manifest exceeds %d bytes", maxDecompressedManifestSize)
+		}
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal manifest: %w", err)
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,106 @@
+package secstorage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestCompressedManifest(t *testing.T) {
+	s := newTestSyncer(t)
+	s.CompressManifest = true
+	manifestID, data := encryptTestFile(t, s, testOptions(), 3000)
+
+	raw, err := os.ReadFile(s.getManifestPath(manifestID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(raw, gzipMagic) {
+		t.Fatal("manifest was not compressed")
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	// Reading does not depend on the option, and rewriting switches the format
+	s.CompressManifest = false
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	if err := s.AddRecipient(manifestID, testPassword, "another password"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err = os.ReadFile(s.getManifestPath(manifestID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(raw, gzipMagic) {
+		t.Fatal("rewritten manifest is still compressed")
+	}
+	assertDecrypts(t, s, manifestID, "another password", data)
+}
+
+// largeManifest 返回一个包含 chunks 个块、4+2 分片、内容随机的 version 版本清单，用于比较清单大小。
+func largeManifest(version, chunks int) *Manifest {
+	manifest := &Manifest{Version: version, DataShards: 4, ParityShards: 2}
+	suffixes := standardShardSuffixes(manifest.DataShards, manifest.ParityShards)
+	if version >= manifestVersionShardSuffixes {
+		manifest.ShardSuffixes = suffixes
+	}
+	for i := range chunks {
+		wrapped := make([]byte, keyLength+28)
+		rand.Read(wrapped)
+		manifest.ChunkPaths = append(manifest.ChunkPaths, fmt.Sprintf("chunk_%d", i))
+		manifest.EncryptedDataKeys = append(manifest.EncryptedDataKeys, wrapped)
+		manifest.EncryptedChunkSizes = append(manifest.EncryptedChunkSizes, 1<<20+28)
+		manifest.PlaintextChunkSizes = append(manifest.PlaintextChunkSizes, 1<<20)
+		if version < manifestVersionShardSuffixes {
+			manifest.ErasureCodeChunkSuffixes = append(manifest.ErasureCodeChunkSuffixes, suffixes)
+		}
+	}
+	return manifest
+}
+
+func TestCompressedManifestSize(t *testing.T) {
+	const chunks = 10000
+	manifest := largeManifest(manifestVersionPaddedFilename, chunks)
+
+	s := &Syncer{}
+	plain, err := s.encodeManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.CompressManifest = true
+	compressed, err := s.encodeManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%d-chunk manifest: %d bytes, %d bytes compressed (%.0f%%)", chunks, len(plain), len(compressed), 100*float64(len(compressed))/float64(len(plain)))
+	if len(compressed) >= len(plain)/2 {
+		t.Fatalf("compressed manifest is %d bytes, uncompressed %d", len(compressed), len(plain))
+	}
+
+	decoded, err := decodeManifest(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateManifest(decoded); err != nil || len(decoded.ChunkPaths) != chunks {
+		t.Fatalf("decoded manifest has %d chunks: %v", len(decoded.ChunkPaths), err)
+	}
+}
+
+func TestSharedShardSuffixesShrinkManifest(t *testing.T) {
+	const chunks = 10000
+	s := &Syncer{}
+	perChunk, err := s.encodeManifest(largeManifest(manifestVersionPaddedFilename, chunks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := s.encodeManifest(largeManifest(manifestVersionShardSuffixes, chunks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%d-chunk manifest: %d bytes with per-chunk suffixes, %d bytes with shared suffixes (%.0f%%)",
+		chunks, len(perChunk), len(shared), 100*float64(len(shared))/float64(len(perChunk)))
+	if len(shared) >= len(perChunk)*3/4 {
+		t.Fatalf("shared suffixes saved too little: %d bytes, was %d", len(shared), len(perChunk))
+	}
+}
@@ -0,0 +1,137 @@
+package secstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/awnumar/memguard"
+)
+
+// EncryptConcat 把 paths 中的文件按顺序首尾相接，作为一个连续的数据流加密为一个清单，例如合并多个日志分段。
+// 块边界只由内容决定，可以跨越文件；清单中加密保存每个文件的字节数，DecryptConcat 解密时一并返回，
+// 调用方据此可以重新切分出各个文件。与 Backup 不同，解密得到的是一个拼接后的流，而不是多个文件。
+// 第一个文件的文件名作为原始文件名保存在清单中，因此 DecryptFile 会把拼接的内容还原为该文件名。
+// 与 EncryptFile 一样，上传中断时返回 manifestID，可以用完全相同的 paths 续传。
+func (s *Syncer) EncryptConcat(paths []string, opts EncryptionOptions) (manifestID string, err error) {
+	if len(paths) == 0 {
+		return "", errors.New("no files to concatenate")
+	}
+	sources := &concatReader{sizes: make([]int64, len(paths))}
+	defer func() {
+		for _, file := range sources.files {
+			file.Close()
+		}
+	}()
+	var size int64
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		sources.files = append(sources.files, file)
+		info, err := file.Stat()
+		if err != nil {
+			return "", err
+		}
+		if !info.Mode().IsRegular() {
+			return "", fmt.Errorf("'%s' is not a regular file", path)
+		}
+		size += info.Size()
+	}
+	if err := checkProjectedChunks(size, opts); err != nil {
+		return "", err
+	}
+
+	upload, err := s.startUpload(opts)
+	if err != nil {
+		return "", err
+	}
+	upload.sources = sources
+	return s.encryptUpload(context.Background(), upload, sources, size, paths[0], nil, nil, opts)
+}
+
+// DecryptConcat 解密 EncryptConcat 创建的清单，把拼接后的内容按顺序写入 w，并返回每个源文件的字节数。
+// 与 DecryptToWriter 一样，内容是边解密边写出的，出错时 w 中可能已经写入了部分内容，调用方应丢弃它。
+// 清单不是由 EncryptConcat 创建时，在写出任何内容之前返回错误。
+func (s *Syncer) DecryptConcat(ctx context.Context, manifestID, password string, w io.Writer) ([]int64, error) {
+	defer func(start time.Time) { s.metrics().ObserveDecryptDuration(time.Since(start)) }(time.Now())
+
+	manifest, key, err := s.openManifest(manifestID, password)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Destroy()
+	sizes, err := decryptSourceSizes(manifestID, manifest, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.limitConcurrency(manifest, 1); err != nil {
+		return nil, err
+	}
+	var report DecryptReport
+	if err := s.decryptChunks(ctx, manifestID, manifest, key, w, &report); err != nil {
+		return nil, err
+	}
+	return sizes, nil
+}
+
+// decryptSourceSizes 解密清单记录的各个源文件的字节数，并检查它们的总和与块的明文大小一致。
+func decryptSourceSizes(manifestID string, manifest *Manifest, key *memguard.LockedBuffer) ([]int64, error) {
+	if len(manifest.EncryptedSourceSizes) == 0 {
+		return nil, fmt.Errorf("manifest %s was not created by EncryptConcat", manifestID)
+	}
+	data, err := manifest.open(manifest.KeyWrapCipher, manifest.EncryptedSourceSizes, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt source sizes: %w", err)
+	}
+	var sizes []int64
+	if err := json.Unmarshal(data, &sizes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal source sizes: %w", err)
+	}
+
+	chunkSizes, err := plaintextChunkSizes(manifest)
+	if err != nil {
+		return nil, err
+	}
+	var total, sourceTotal int64
+	for _, size := range chunkSizes {
+		total += int64(size)
+	}
+	for _, size := range sizes {
+		if size < 0 {
+			return nil, fmt.Errorf("manifest %s records a negative source size", manifestID)
+		}
+		sourceTotal += size
+	}
+	if sourceTotal != total {
+		return nil, fmt.Errorf("manifest %s records %d bytes of sources for %d bytes of content", manifestID, sourceTotal, total)
+	}
+	return sizes, nil
+}
+
+// concatReader 依次读取 files，并在 sizes 中记录从每个文件读取的字节数。
+type concatReader struct {
+	files   []*os.File
+	sizes   []int64
+	current int
+}
+
+func (r *concatReader) Read(p []byte) (int, error) {
+	for r.current < len(r.files) {
+		n, err := r.files[r.current].Read(p)
+		r.sizes[r.current] += int64(n)
+		if err == io.EOF {
+			r.current++
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+	return 0, io.EOF
+}
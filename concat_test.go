@@ -0,0 +1,82 @@
+package secstorage
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestEncryptConcat(t *testing.T) {
+	s := newTestSyncer(t)
+	dir := t.TempDir()
+	var paths []string
+	var want []byte
+	for _, segment := range []struct {
+		name string
+		size int
+	}{{"input.bin", 3000}, {"empty.log", 0}, {"tail.log", 1500}} {
+		path, data := writeTestFile(t, dir, segment.name, segment.size)
+		paths = append(paths, path)
+		want = append(want, data...)
+	}
+
+	manifestID, err := s.EncryptConcat(paths, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	sizes, err := s.DecryptConcat(context.Background(), manifestID, testPassword, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Fatalf("got %d bytes, want %d", got.Len(), len(want))
+	}
+	if !slices.Equal(sizes, []int64{3000, 0, 1500}) {
+		t.Fatalf("got source sizes %v", sizes)
+	}
+	// The joined stream is restored under the first file's name
+	assertDecrypts(t, s, manifestID, testPassword, want)
+
+	if _, err := s.EncryptConcat(nil, testOptions()); err == nil {
+		t.Fatal("concatenated no files")
+	}
+	if _, err := s.EncryptConcat([]string{paths[0], dir}, testOptions()); err == nil {
+		t.Fatal("concatenated a directory")
+	}
+}
+
+func TestRebuildManifestKeepsSourceSizes(t *testing.T) {
+	s := newTestSyncer(t)
+	dir := t.TempDir()
+	first, _ := writeTestFile(t, dir, "first.log", 2000)
+	second, _ := writeTestFile(t, dir, "second.log", 700)
+	opts := testOptions()
+	opts.RecoveryRecords = true
+	manifestID, err := s.EncryptConcat([]string{first, second}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRebuildsIdentically(t, s, manifestID)
+
+	sizes, err := s.DecryptConcat(context.Background(), manifestID, testPassword, &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(sizes, []int64{2000, 700}) {
+		t.Fatalf("got source sizes %v after rebuild", sizes)
+	}
+}
+
+func TestDecryptConcatRejectsPlainManifest(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, s, testOptions(), 3000)
+	var got bytes.Buffer
+	if _, err := s.DecryptConcat(context.Background(), manifestID, testPassword, &got); err == nil {
+		t.Fatal("DecryptConcat accepted a manifest without source sizes")
+	}
+	if got.Len() > 0 {
+		t.Fatalf("wrote %d bytes before rejecting the manifest", got.Len())
+	}
+}
@@ -1,59 +1,287 @@
 package secstorage
 
 import (
-	"os"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config 定义了应用程序的所有配置选项。
-// 这些选项可以从一个 YAML 文件中加载。
+// 这些选项可以从一个 YAML 或 JSON 文件中加载。
 
 type Config struct {
 	// ChunkSizeKB 定义了内容分块的平均大小（以 KB 为单位）。
-	ChunkSizeKB int `yaml:"chunk_size_kb"`
+	ChunkSizeKB int `yaml:"chunk_size_kb" json:"chunk_size_kb"`
 	// DataShards 定义了纠删码所需的数据分片数。
-	DataShards int `yaml:"data_shards"`
+	DataShards int `yaml:"data_shards" json:"data_shards"`
 	// ParityShards 定义了纠删码所需的奇偶校验分片数。
-	ParityShards int `yaml:"parity_shards"`
+	ParityShards int `yaml:"parity_shards" json:"parity_shards"`
 	// Argon2 包含了用于密钥派生的 Argon2 算法的配置。
-	Argon2 Argon2Config `yaml:"argon2"`
+	Argon2 Argon2Config `yaml:"argon2" json:"argon2"`
 	// StoragePath 定义了加密文件存储的根目录。
-	StoragePath string `yaml:"storage_path"`
+	StoragePath string `yaml:"storage_path" json:"storage_path"`
 }
 
 // Argon2Config 定义了 Argon2 密钥派生函数的参数。
 
 type Argon2Config struct {
 	// Time 是 Argon2 算法的迭代次数。
-	Time uint32 `yaml:"time"`
+	Time uint32 `yaml:"time" json:"time"`
 	// MemoryKB 是 Argon2 算法应使用的内存量（以 KB 为单位）。
-	MemoryKB uint32 `yaml:"memory_kb"`
+	// 配置文件中也可以用 memory 键写成带单位的字符串，如 "64MiB"，见 UnmarshalYAML。
+	MemoryKB uint32 `yaml:"memory_kb" json:"memory_kb"`
 	// Threads 是 Argon2 算法可以使用的 CPU 线程数。
-	Threads uint8 `yaml:"threads"`
+	Threads uint8 `yaml:"threads" json:"threads"`
+}
+
+// argon2ConfigFields 是 Argon2Config 的字段，不带自定义的解析方法，以免 UnmarshalYAML 递归。
+type argon2ConfigFields Argon2Config
+
+// argon2ConfigDocument 是配置文件中 argon2 一节的内容，比 Argon2Config 多一个带单位的 memory 键。
+type argon2ConfigDocument struct {
+	argon2ConfigFields `yaml:",inline"`
+	Memory             string `yaml:"memory" json:"memory"`
+}
+
+// UnmarshalYAML 解析 argon2 一节。内存量既可以用 memory_kb 写成以 KB 为单位的整数，
+// 也可以用 memory 写成带 KiB、MiB 或 GiB 单位的字符串，如 "64MiB"，两者不能同时出现。
+// 解析后的内存量必须在 Argon2 允许的范围内：至少为每个线程 8 KiB，且不超过 2^32-1 KiB。
+func (c *Argon2Config) UnmarshalYAML(value *yaml.Node) error {
+	var doc argon2ConfigDocument
+	if err := value.Decode(&doc); err != nil {
+		return err
+	}
+	return c.set(doc)
 }
 
-// LoadConfig 从指定的路径加载 YAML 配置文件并解析它。
+// UnmarshalJSON 与 UnmarshalYAML 的规则相同。
+func (c *Argon2Config) UnmarshalJSON(data []byte) error {
+	var doc argon2ConfigDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	return c.set(doc)
+}
+
+// set 把解析出的 argon2 一节换算并校验后保存到 c。
+func (c *Argon2Config) set(doc argon2ConfigDocument) error {
+	config := Argon2Config(doc.argon2ConfigFields)
+	if doc.Memory != "" {
+		if config.MemoryKB != 0 {
+			return errors.New("argon2: memory and memory_kb must not both be set")
+		}
+		kb, err := parseMemorySize(doc.Memory)
+		if err != nil {
+			return fmt.Errorf("argon2: %w", err)
+		}
+		config.MemoryKB = kb
+	}
+	if config.MemoryKB != 0 && uint64(config.MemoryKB) < 8*uint64(max(config.Threads, 1)) {
+		return fmt.Errorf("argon2: memory of %d KiB is below the minimum of 8 KiB per thread", config.MemoryKB)
+	}
+	*c = config
+	return nil
+}
+
+// memorySizeUnits 是 parseMemorySize 接受的单位及其对应的 KiB 数。
+var memorySizeUnits = []struct {
+	suffix string
+	kb     uint64
+}{
+	{"GiB", 1 << 20},
+	{"MiB", 1 << 10},
+	{"KiB", 1},
+}
+
+// parseMemorySize 把 "64MiB" 这样的内存量换算为 KiB。必须带单位，以免把字节数或 KB 数误当成另一种单位。
+func parseMemorySize(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	for _, unit := range memorySizeUnits {
+		number, ok := strings.CutSuffix(s, unit.suffix)
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(number), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid memory size %q", s)
+		}
+		if n == 0 || n > math.MaxUint32/unit.kb {
+			return 0, fmt.Errorf("memory size %q is outside the range Argon2 allows", s)
+		}
+		return uint32(n * unit.kb), nil
+	}
+	return 0, fmt.Errorf("memory size %q must end in KiB, MiB or GiB", s)
+}
+
+// formatMemorySize 把以 KiB 为单位的内存量写成 parseMemorySize 接受的形式，使用能整除的最大单位。
+func formatMemorySize(kb uint32) string {
+	unit := memorySizeUnits[len(memorySizeUnits)-1]
+	for _, u := range memorySizeUnits {
+		if uint64(kb)%u.kb == 0 {
+			unit = u
+			break
+		}
+	}
+	return fmt.Sprintf("%d%s", uint64(kb)/unit.kb, unit.suffix)
+}
+
+// ErrConfigNotFound 表示配置文件不存在，调用方通常可以改用 DefaultConfig。
+var ErrConfigNotFound = errors.New("config file not found")
+
+// ErrConfigInvalid 表示配置无法解析或未通过校验。
+var ErrConfigInvalid = errors.New("invalid config")
+
+// LoadConfig 从指定的路径加载配置文件并解析它。
+// 扩展名为 .json 的文件按 JSON 解析，其他文件（通常为 .yaml 或 .yml）按 YAML 解析。
 // 它返回一个包含配置的 Config 结构体指针，或者在出错时返回一个错误。
+// 文件不存在时错误满足 errors.Is(err, ErrConfigNotFound)，内容无效时满足 errors.Is(err, ErrConfigInvalid)。
 func LoadConfig(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w at %s: %w", ErrConfigNotFound, path, err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file at %s: %w", path, err)
 	}
+	defer file.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return LoadConfigJSON(file)
+	}
+	return LoadConfigYAML(file)
+}
+
+// LoadConfigFromReader 等同于 LoadConfigYAML，为兼容已有调用方而保留。
+func LoadConfigFromReader(r io.Reader) (*Config, error) {
+	return LoadConfigYAML(r)
+}
+
+// LoadConfigYAML 从 r 读取 YAML 配置并解析和校验，规则与 LoadConfig 相同，解析或校验失败时的错误满足 errors.Is(err, ErrConfigInvalid)。
+// 适用于配置嵌在其他文档中或来自密钥管理服务等不便写入文件的场景。
+func LoadConfigYAML(r io.Reader) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
 
 	var config Config
 	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal config YAML: %w", err)
+		return nil, fmt.Errorf("%w: failed to unmarshal config YAML: %w", ErrConfigInvalid, err)
 	}
-
-	// Here you could add validation for the config values, e.g.:
-	if config.ChunkSizeKB <= 0 {
-		return nil, fmt.Errorf("chunk_size_kb must be positive")
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfigInvalid, err)
 	}
-	if config.DataShards <= 0 || config.ParityShards <= 0 {
-		return nil, fmt.Errorf("data_shards and parity_shards must be positive")
+	return &config, nil
+}
+
+// LoadConfigJSON 从 r 读取 JSON 配置并解析和校验。JSON 的键名和返回的错误都与 LoadConfigYAML 相同。
+func LoadConfigJSON(r io.Reader) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
 	}
 
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%w: failed to unmarshal config JSON: %w", ErrConfigInvalid, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfigInvalid, err)
+	}
 	return &config, nil
 }
+
+// validate 检查配置值是否有效，YAML 和 JSON 两种格式共用同一套规则。
+func (c *Config) validate() error {
+	if c.ChunkSizeKB < minChunkSizeKB || c.ChunkSizeKB > maxChunkSizeKB {
+		return fmt.Errorf("chunk_size_kb must be between %d and %d, got %d", minChunkSizeKB, maxChunkSizeKB, c.ChunkSizeKB)
+	}
+	if c.DataShards <= 0 {
+		return fmt.Errorf("data_shards must be positive")
+	}
+	// A parity_shards of 0 disables erasure coding entirely.
+	if c.ParityShards < 0 {
+		return fmt.Errorf("parity_shards must not be negative")
+	}
+	return nil
+}
+
+// DefaultConfig 返回推荐的默认配置，其取值与仓库中的 config.yaml 示例一致。
+func DefaultConfig() *Config {
+	return &Config{
+		ChunkSizeKB:  1024,
+		DataShards:   10,
+		ParityShards: 3,
+		Argon2: Argon2Config{
+			Time:     3,
+			MemoryKB: 64 * 1024,
+			Threads:  4,
+		},
+		StoragePath: "/var/secstorage/data",
+	}
+}
+
+// DefaultEncryptionOptions 返回以 password 加密、其余参数取 DefaultConfig 推荐值的加密选项：
+// 1 MB 的平均块大小、10+3 的纠删码，以及 3 次迭代、64 MiB 内存、4 个线程的 Argon2id。
+// 手工构造 EncryptionOptions 时容易遗漏分片数或选用过弱的 Argon2 参数，建议从它开始再修改需要调整的字段。
+func DefaultEncryptionOptions(password string) EncryptionOptions {
+	c := DefaultConfig()
+	return EncryptionOptions{
+		Password:      password,
+		DataShards:    c.DataShards,
+		ParityShards:  c.ParityShards,
+		ChunkSizeKB:   c.ChunkSizeKB,
+		Argon2Time:    c.Argon2.Time,
+		Argon2Memory:  c.Argon2.MemoryKB,
+		Argon2Threads: c.Argon2.Threads,
+	}
+}
+
+// defaultConfigTemplate 是 WriteDefaultConfig 写出的带注释的 YAML 模板。
+const defaultConfigTemplate = `# SecStorage 配置文件
+
+# 内容分块的平均大小（以 KB 为单位）。
+chunk_size_kb: %d
+# 纠删码所需的数据分片数。
+data_shards: %d
+# 纠删码所需的奇偶校验分片数，为 0 时不使用纠删码。
+parity_shards: %d
+# 用于密钥派生的 Argon2 算法的配置。
+argon2:
+  # Argon2 算法的迭代次数。
+  time: %d
+  # Argon2 算法应使用的内存量，单位可以是 KiB、MiB 或 GiB；也可以改用 memory_kb 写成以 KB 为单位的整数。
+  memory: %q
+  # Argon2 算法可以使用的 CPU 线程数。
+  threads: %d
+# 加密文件存储的根目录。
+storage_path: %q
+`
+
+// WriteDefaultConfig 在 path 写入一个带注释的 YAML 配置模板，其中填入了 DefaultConfig 的取值，
+// 方便用户在可用的配置基础上修改。为避免覆盖已有配置，path 已存在时返回错误。
+func WriteDefaultConfig(path string) error {
+	c := DefaultConfig()
+	content := fmt.Sprintf(defaultConfigTemplate,
+		c.ChunkSizeKB, c.DataShards, c.ParityShards,
+		c.Argon2.Time, formatMemorySize(c.Argon2.MemoryKB), c.Argon2.Threads,
+		c.StoragePath)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, defaultFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create config file at %s: %w", path, err)
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write config file at %s: %w", path, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,135 @@
+package secstorage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigFormats(t *testing.T) {
+	want := &Config{
+		ChunkSizeKB:  512,
+		DataShards:   6,
+		ParityShards: 2,
+		Argon2:       Argon2Config{Time: 2, MemoryKB: 1024, Threads: 1},
+		StoragePath:  "/data",
+	}
+	yamlConfig := "chunk_size_kb: 512\ndata_shards: 6\nparity_shards: 2\nargon2:\n  time: 2\n  memory_kb: 1024\n  threads: 1\nstorage_path: /data\n"
+	jsonConfig := `{"chunk_size_kb": 512, "data_shards": 6, "parity_shards": 2,
+		"argon2": {"time": 2, "memory_kb": 1024, "threads": 1}, "storage_path": "/data"}`
+
+	for name, load := range map[string]func() (*Config, error){
+		"yaml reader":   func() (*Config, error) { return LoadConfigYAML(strings.NewReader(yamlConfig)) },
+		"legacy reader": func() (*Config, error) { return LoadConfigFromReader(strings.NewReader(yamlConfig)) },
+		"json reader":   func() (*Config, error) { return LoadConfigJSON(strings.NewReader(jsonConfig)) },
+		"json file": func() (*Config, error) {
+			path := filepath.Join(t.TempDir(), "config.JSON")
+			if err := os.WriteFile(path, []byte(jsonConfig), defaultFilePerm); err != nil {
+				t.Fatal(err)
+			}
+			return LoadConfig(path)
+		},
+	} {
+		got, err := load()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %+v, want %+v", name, got, want)
+		}
+	}
+
+	if _, err := LoadConfigYAML(strings.NewReader("chunk_size_kb: 0\ndata_shards: 1\n")); !errors.Is(err, ErrConfigInvalid) {
+		t.Fatalf("got %v for an invalid config, want ErrConfigInvalid", err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadConfig(filepath.Join(dir, "missing.yaml")); !errors.Is(err, ErrConfigNotFound) || errors.Is(err, ErrConfigInvalid) {
+		t.Fatalf("got %v for a missing file, want ErrConfigNotFound", err)
+	}
+	for name, content := range map[string]string{
+		"malformed.yaml": "chunk_size_kb: [\n",
+		"malformed.json": "{",
+		"invalid.json":   `{"chunk_size_kb": 0, "data_shards": 1}`,
+		"huge.yaml":      "chunk_size_kb: 5242880\ndata_shards: 1\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), defaultFilePerm); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfig(path); !errors.Is(err, ErrConfigInvalid) || errors.Is(err, ErrConfigNotFound) {
+			t.Fatalf("%s: got %v, want ErrConfigInvalid", name, err)
+		}
+	}
+}
+
+func TestLoadConfigMemoryUnits(t *testing.T) {
+	load := func(argon2 string) (*Config, error) {
+		return LoadConfigYAML(strings.NewReader("chunk_size_kb: 512\ndata_shards: 6\nargon2:\n" + argon2))
+	}
+	for memory, want := range map[string]uint32{"64MiB": 64 * 1024, "512 KiB": 512, "2GiB": 2 << 20} {
+		config, err := load("  memory: " + memory + "\n  threads: 4\n")
+		if err != nil {
+			t.Fatalf("%s: %v", memory, err)
+		}
+		if config.Argon2.MemoryKB != want {
+			t.Fatalf("%s: got %d KiB, want %d", memory, config.Argon2.MemoryKB, want)
+		}
+	}
+	config, err := LoadConfigJSON(strings.NewReader(`{"chunk_size_kb": 512, "data_shards": 6, "argon2": {"memory": "1MiB"}}`))
+	if err != nil || config.Argon2.MemoryKB != 1024 {
+		t.Fatalf("JSON: got %+v, %v", config, err)
+	}
+
+	for _, argon2 := range []string{
+		"  memory: 65536\n",
+		"  memory: 64MB\n",
+		"  memory: 0MiB\n",
+		"  memory: 4096GiB\n",
+		"  memory: 16KiB\n  threads: 4\n",
+		"  memory_kb: 16\n  threads: 4\n",
+		"  memory: 64MiB\n  memory_kb: 65536\n",
+	} {
+		if _, err := load(argon2); err == nil {
+			t.Errorf("accepted %q", argon2)
+		}
+	}
+}
+
+func TestWriteDefaultConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := WriteDefaultConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, DefaultConfig()) {
+		t.Fatalf("template parses to %+v, want %+v", got, DefaultConfig())
+	}
+	if data, err := os.ReadFile(path); err != nil || !strings.Contains(string(data), `memory: "64MiB"`) {
+		t.Fatalf("template does not use memory units: %v", err)
+	}
+	if err := WriteDefaultConfig(path); err == nil {
+		t.Fatal("WriteDefaultConfig overwrote an existing file")
+	}
+}
+
+func TestDefaultEncryptionOptions(t *testing.T) {
+	opts := DefaultEncryptionOptions(testPassword)
+	if err := opts.validate(); err != nil {
+		t.Fatal(err)
+	}
+	c := DefaultConfig()
+	if opts.Password != testPassword || opts.DataShards != c.DataShards || opts.ParityShards != c.ParityShards ||
+		opts.ChunkSizeKB != c.ChunkSizeKB || opts.Argon2Time != c.Argon2.Time ||
+		opts.Argon2Memory != c.Argon2.MemoryKB || opts.Argon2Threads != c.Argon2.Threads {
+		t.Fatalf("got %+v, want the values of DefaultConfig", opts)
+	}
+}
@@ -0,0 +1,58 @@
+package secstorage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/awnumar/memguard"
+	"golang.org/x/crypto/blake2b"
+	"lukechampine.com/blake3"
+)
+
+// HashAlgorithm 定义了计算完整内容哈希所用的算法。
+type HashAlgorithm string
+
+const (
+	// HashSHA256 是默认的 SHA-256。
+	HashSHA256 HashAlgorithm = "sha256"
+	// HashBLAKE2b 是输出 256 位的 BLAKE2b，在没有 SHA 指令扩展的 CPU 上明显快于 SHA-256，适合数 GB 的大文件。
+	HashBLAKE2b HashAlgorithm = "blake2b-256"
+	// HashBLAKE3 是输出 256 位的 BLAKE3，内部按树形结构并行处理数据，在支持 SIMD 的 CPU 上通常比 BLAKE2b 更快。
+	HashBLAKE3 HashAlgorithm = "blake3-256"
+)
+
+// newContentHash 根据算法创建哈希实例。空算法表示 HashSHA256，以兼容没有记录算法的清单。
+func newContentHash(algorithm HashAlgorithm) (hash.Hash, error) {
+	switch algorithm {
+	case "", HashSHA256:
+		return sha256.New(), nil
+	case HashBLAKE2b:
+		return blake2b.New256(nil)
+	case HashBLAKE3:
+		return blake3.New(32, nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q", algorithm)
+	}
+}
+
+// ErrContentHash 表示解密出的完整内容与加密时记录的哈希不一致。
+// 每个块都经过了认证，因此这通常意味着块被整体调换、截断或实现存在缺陷。
+var ErrContentHash = errors.New("decrypted content does not match the recorded hash")
+
+// verifyContentHash 检查 sum 与清单中加密保存的完整明文哈希是否一致；旧清单没有记录时直接通过。
+func verifyContentHash(manifest *Manifest, key *memguard.LockedBuffer, sum []byte) error {
+	if len(manifest.EncryptedContentHash) == 0 {
+		return nil
+	}
+	want, err := manifest.open(manifest.KeyWrapCipher, manifest.EncryptedContentHash, key, nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt content hash: %w", err)
+	}
+	if !hmac.Equal(want, sum) {
+		return ErrContentHash
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package secstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"testing"
+)
+
+// rewriteContentHash 把清单中记录的内容哈希替换为 sum 的加密结果（sum 为 nil 时删除该字段），并重新签名保存。
+func rewriteContentHash(t *testing.T, s *Syncer, manifestID string, sum []byte) {
+	t.Helper()
+	manifest, key, err := s.openManifest(manifestID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+	manifest.EncryptedContentHash = nil
+	if sum != nil {
+		if manifest.EncryptedContentHash, err = encryptWith(manifest.KeyWrapCipher, sum, key, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.saveManifest(manifestID, manifest, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContentHashMismatch(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 5000)
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.EncryptedContentHash) == 0 {
+		t.Fatal("new manifests should record a content hash")
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	wrong := sha256.Sum256([]byte("something else"))
+	rewriteContentHash(t, s, manifestID, wrong[:])
+
+	// The stream has already received everything when the mismatch is detected
+	var out bytes.Buffer
+	_, err = s.DecryptToWriter(context.Background(), manifestID, testPassword, &out)
+	if !errors.Is(err, ErrContentHash) {
+		t.Fatalf("got %v, want ErrContentHash", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("expected the full content to have been written before the check")
+	}
+
+	// DecryptFile checks before moving the file into place
+	outputDir := t.TempDir()
+	if err := s.DecryptFile(manifestID, outputDir, testPassword); !errors.Is(err, ErrContentHash) {
+		t.Fatalf("got %v, want ErrContentHash", err)
+	}
+	if entries, _ := os.ReadDir(outputDir); len(entries) != 0 {
+		t.Fatalf("output directory has %d entries after a failed decryption", len(entries))
+	}
+}
+
+func TestContentHashMissingInOldManifest(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 3000)
+	rewriteContentHash(t, s, manifestID, nil)
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
+
+func TestContentHashInShards(t *testing.T) {
+	manifest, shards, err := EncryptToShards(bytes.NewReader([]byte("hello, shards")), "input.bin", testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := unlockManifest(argon2Deriver{}, manifest, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+	wrong := sha256.Sum256(nil)
+	if manifest.EncryptedContentHash, err = encryptWith(manifest.KeyWrapCipher, wrong[:], key, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := signManifest(manifest, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := DecryptFromShards(manifest, shards, testPassword, &bytes.Buffer{}); !errors.Is(err, ErrContentHash) {
+		t.Fatalf("got %v, want ErrContentHash", err)
+	}
+}
+
+func TestContentHashAlgorithm(t *testing.T) {
+	for _, algorithm := range []HashAlgorithm{HashBLAKE2b, HashBLAKE3} {
+		s := newTestSyncer(t)
+		opts := testOptions()
+		opts.ContentHash = algorithm
+		manifestID, data := encryptTestFile(t, s, opts, 5000)
+		manifest, err := s.ReadManifest(manifestID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if manifest.ContentHashAlgorithm != algorithm {
+			t.Fatalf("got algorithm %q, want %q", manifest.ContentHashAlgorithm, algorithm)
+		}
+		assertDecrypts(t, s, manifestID, testPassword, data)
+
+		// The digest is checked with the recorded algorithm, not SHA-256
+		sum := sha256.Sum256(data)
+		rewriteContentHash(t, s, manifestID, sum[:])
+		if _, err := s.DecryptToWriter(context.Background(), manifestID, testPassword, &bytes.Buffer{}); !errors.Is(err, ErrContentHash) {
+			t.Fatalf("%s: got %v, want ErrContentHash", algorithm, err)
+		}
+	}
+
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.ContentHash = "md5"
+	if _, err := s.EncryptFile(writeTestFileOnly(t), opts); err == nil {
+		t.Fatal("expected an error for an unsupported hash algorithm")
+	}
+}
+
+func TestBLAKE3KnownAnswer(t *testing.T) {
+	h, err := newContentHash(HashBLAKE3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// BLAKE3 of the empty input from the reference test vectors
+	if got := hex.EncodeToString(h.Sum(nil)); got != "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestContentHashAlgorithmInShards(t *testing.T) {
+	opts := testOptions()
+	opts.ContentHash = HashBLAKE2b
+	data := []byte("hello, shards")
+	manifest, shards, err := EncryptToShards(bytes.NewReader(data), "input.bin", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ContentHashAlgorithm != HashBLAKE2b {
+		t.Fatalf("got algorithm %q", manifest.ContentHashAlgorithm)
+	}
+	var out bytes.Buffer
+	if err := DecryptFromShards(manifest, shards, testPassword, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("round trip failed: %v", err)
+	}
+}
@@ -6,11 +6,16 @@ import (
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"slices"
 
 	"github.com/awnumar/memguard"
 	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/sys/cpu"
 )
 
 const (
@@ -18,8 +23,78 @@ const (
 	keyLength = 32
 	// saltLength 定义了生成盐值的长度（16字节）。
 	saltLength = 16
+	// filenameBucketSize 定义了文件名加密前填充到的块大小（64字节的整数倍）。
+	filenameBucketSize = 64
+	// minGCMNonceSize 和 maxGCMNonceSize 限定了清单可以声明的 AES-GCM nonce 长度（Manifest.NonceSize）。
+	// 更短的随机 nonce 碰撞概率过高，更长的 nonce 会被 GHASH 压缩，不再带来任何好处。
+	minGCMNonceSize = 8
+	maxGCMNonceSize = 64
 )
 
+// KeyDeriver 定义了从密码派生密钥的方式，使部署方可以替换为硬件加速、协处理器或经 FIPS 验证的 Argon2id 实现。
+// 实现必须与 golang.org/x/crypto/argon2 的 IDKey 产生完全相同的输出（长度为 32 字节），
+// 否则已有文件将无法解密。实现必须可以被多个 goroutine 并发调用。
+type KeyDeriver interface {
+	Derive(password, salt []byte, params Argon2Config) *memguard.LockedBuffer
+}
+
+// argon2Deriver 是使用 golang.org/x/crypto/argon2 的默认 KeyDeriver。
+type argon2Deriver struct{}
+
+// Derive 实现了 KeyDeriver 接口。
+func (argon2Deriver) Derive(password, salt []byte, params Argon2Config) *memguard.LockedBuffer {
+	return deriveKey(password, salt, params.Time, params.MemoryKB, params.Threads)
+}
+
+// KDFAlgorithm 定义了从密码派生密钥的算法。
+type KDFAlgorithm string
+
+const (
+	// KDFArgon2id 是默认的 Argon2id。
+	KDFArgon2id KDFAlgorithm = "argon2id"
+	// KDFScrypt 是 scrypt，供要求使用 scrypt 的合规场景选用。
+	KDFScrypt KDFAlgorithm = "scrypt"
+)
+
+const (
+	// defaultScryptN、defaultScryptR 和 defaultScryptP 是未指定时使用的 scrypt 参数，
+	// 与 golang.org/x/crypto/scrypt 文档推荐的交互式登录参数一致。
+	defaultScryptN = 1 << 15
+	defaultScryptR = 8
+	defaultScryptP = 1
+)
+
+// validateScryptParams 检查 scrypt 参数：N 必须是大于 1 的 2 的幂，r 和 p 必须为正且 r*p < 2^30。
+func validateScryptParams(n, r, p int) error {
+	if n <= 1 || n&(n-1) != 0 {
+		return fmt.Errorf("scrypt N must be a power of two greater than 1, got %d", n)
+	}
+	if r <= 0 || p <= 0 || uint64(r)*uint64(p) >= 1<<30 {
+		return fmt.Errorf("invalid scrypt parameters r=%d, p=%d", r, p)
+	}
+	return nil
+}
+
+// validateArgon2Params 检查 Argon2id 的迭代次数和线程数至少为 1，否则 argon2.IDKey 会 panic。
+func validateArgon2Params(params Argon2Config) error {
+	if params.Time < 1 || params.Threads < 1 {
+		return fmt.Errorf("argon2 time and threads must be at least 1, got %d and %d", params.Time, params.Threads)
+	}
+	return nil
+}
+
+// deriveScryptKey 使用 scrypt 从密码和盐值派生出加密密钥。
+func deriveScryptKey(password, salt []byte, n, r, p int) (*memguard.LockedBuffer, error) {
+	if err := validateScryptParams(n, r, p); err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key(password, salt, n, r, p, keyLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive scrypt key: %w", err)
+	}
+	return memguard.NewBufferFromBytes(key), nil
+}
+
 // deriveKey 使用 Argon2id 从密码和盐值派生出加密密钥。
 // 为了增强安全性，返回的密钥存储在 memguard 的 LockedBuffer 中，以防止内存泄漏。
 func deriveKey(password []byte, salt []byte, time, memory uint32, threads uint8) *memguard.LockedBuffer {
@@ -44,52 +119,220 @@ func generateSalt() ([]byte, error) {
 	return salt, nil
 }
 
+// chunkAAD 生成数据块加密时使用的关联数据：manifestID || chunkIndex（8字节大端序）。
+// 它把每个块的密文绑定到所属清单及其位置上，被调换位置的块会直接认证失败。
+func chunkAAD(manifestID string, chunkIndex int) []byte {
+	aad := make([]byte, len(manifestID)+8)
+	copy(aad, manifestID)
+	binary.BigEndian.PutUint64(aad[len(manifestID):], uint64(chunkIndex))
+	return aad
+}
 
+// padFilename 将文件名编码为 [长度(2字节大端序) || 文件名 || 零填充]，总长度为 filenameBucketSize 的整数倍。
+// 这样同一长度区间内的文件名加密后长度完全相同，密文不再泄露文件名的确切长度。
+func padFilename(name string) ([]byte, error) {
+	if len(name) > 0xFFFF {
+		return nil, fmt.Errorf("filename too long: %d bytes", len(name))
+	}
+	size := (2 + len(name) + filenameBucketSize - 1) / filenameBucketSize * filenameBucketSize
+	padded := make([]byte, size)
+	binary.BigEndian.PutUint16(padded, uint16(len(name)))
+	copy(padded[2:], name)
+	return padded, nil
+}
 
-// encrypt 使用 AES-256-GCM 算法加密数据。
-// GCM 提供认证加密，无需额外的填充（如 PKCS#7）。
-// 输出格式为：[nonce || ciphertext || tag]。
-func encrypt(plaintext []byte, key *memguard.LockedBuffer) ([]byte, error) {
-	block, err := aes.NewCipher(key.Bytes())
-	if err != nil {
-		return nil, err
+// unpadFilename 是 padFilename 的逆操作。它拒绝长度不是 filenameBucketSize 整数倍或填充部分不全为零的输入，
+// 使每个文件名只有唯一一种合法编码。
+func unpadFilename(padded []byte) (string, error) {
+	if len(padded) < 2 || len(padded)%filenameBucketSize != 0 {
+		return "", fmt.Errorf("invalid padded filename size %d", len(padded))
+	}
+	length := int(binary.BigEndian.Uint16(padded))
+	if 2+length > len(padded) {
+		return "", fmt.Errorf("invalid padded filename length %d", length)
 	}
+	for _, b := range padded[2+length:] {
+		if b != 0 {
+			return "", fmt.Errorf("padded filename has non-zero padding")
+		}
+	}
+	return string(padded[2 : 2+length]), nil
+}
 
-	gcm, err := cipher.NewGCM(block)
+// CipherAlgorithm 定义了可选的 AEAD 加密算法。
+type CipherAlgorithm string
+
+const (
+	// CipherAESGCM 是 AES-256-GCM，使用 96 位随机 nonce，这是默认算法。
+	CipherAESGCM CipherAlgorithm = "aes-256-gcm"
+	// CipherXChaCha20Poly1305 是 XChaCha20-Poly1305，使用 192 位随机 nonce。
+	// 在同一密钥下加密大量消息时，随机 nonce 发生碰撞的概率可以忽略不计。
+	CipherXChaCha20Poly1305 CipherAlgorithm = "xchacha20-poly1305"
+)
+
+// 本库的所有密文（块、数据密钥、文件名、元数据等）都采用同一种布局，不带任何头部或版本字段：
+//
+//	[nonce || ciphertext || tag]
+//
+// nonce 是随机生成的，AES-256-GCM 为 NonceSize 字节（清单记录了 Manifest.NonceSize 时以它为准），
+// XChaCha20-Poly1305 为 XNonceSize 字节；ciphertext 与明文等长；tag 是 TagOverhead 字节的认证标签。
+// 外部工具可以用 SplitCiphertext 拆分后交给任何标准的 AEAD 实现解密（通常把 ciphertext 和 tag 连在一起传入 Open）。
+const (
+	// NonceSize 是 AES-256-GCM 密文开头的 nonce 字节数。
+	NonceSize = 12
+	// XNonceSize 是 XChaCha20-Poly1305 密文开头的 nonce 字节数。
+	XNonceSize = chacha20poly1305.NonceSizeX
+	// TagOverhead 是两种算法的密文末尾认证标签的字节数。
+	TagOverhead = 16
+)
+
+// SplitCiphertext 按 AES-256-GCM 的布局把 blob 拆分为 nonce、密文和认证标签，返回的切片都引用 blob。
+// blob 短于 nonce 与标签之和时返回错误。它只拆分，不做任何认证。
+func SplitCiphertext(blob []byte) (nonce, ct, tag []byte, err error) {
+	return SplitCiphertextWith(CipherAESGCM, blob)
+}
+
+// SplitCiphertextWith 与 SplitCiphertext 相同，但按 algorithm 的 nonce 长度拆分。
+func SplitCiphertextWith(algorithm CipherAlgorithm, blob []byte) (nonce, ct, tag []byte, err error) {
+	overhead, err := cipherOverhead(algorithm)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
+	}
+	if len(blob) < overhead {
+		return nil, nil, nil, fmt.Errorf("ciphertext is %d bytes, shorter than the %d bytes of nonce and tag", len(blob), overhead)
 	}
+	nonceSize := overhead - TagOverhead
+	return blob[:nonceSize], blob[nonceSize : len(blob)-TagOverhead], blob[len(blob)-TagOverhead:], nil
+}
 
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+// hasAESHardware 报告当前 CPU 是否支持 AES-GCM 硬件加速，判断条件与 crypto/tls 选择默认密码套件时相同。
+// 它在进程启动时确定一次，之后不再重复检测。
+var hasAESHardware = (cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ) ||
+	(cpu.ARM64.HasAES && cpu.ARM64.HasPMULL) ||
+	(cpu.S390X.HasAES && cpu.S390X.HasAESGCM)
+
+// preferredCipher 返回在当前机器上最快的算法：有 AES 硬件加速时为 AES-256-GCM，
+// 否则为在纯软件实现下明显更快的 XChaCha20-Poly1305。
+func preferredCipher() CipherAlgorithm {
+	if hasAESHardware {
+		return CipherAESGCM
+	}
+	return CipherXChaCha20Poly1305
+}
+
+// validateCipher 检查 algorithm 是否为受支持的算法，空值表示 CipherAESGCM。
+func validateCipher(algorithm CipherAlgorithm) error {
+	switch algorithm {
+	case "", CipherAESGCM, CipherXChaCha20Poly1305:
+		return nil
+	default:
+		return fmt.Errorf("unsupported cipher algorithm %q", algorithm)
+	}
+}
+
+// cipherOverhead 返回 encryptWith 的输出比明文多出的字节数，即 nonce 与认证标签的长度之和。
+func cipherOverhead(algorithm CipherAlgorithm) (int, error) {
+	switch algorithm {
+	case "", CipherAESGCM:
+		return NonceSize + TagOverhead, nil
+	case CipherXChaCha20Poly1305:
+		return XNonceSize + TagOverhead, nil
+	default:
+		return 0, fmt.Errorf("unsupported cipher algorithm %q", algorithm)
+	}
+}
+
+// newAEAD 根据算法创建 AEAD 实例。空算法表示 CipherAESGCM，以兼容旧清单。
+func newAEAD(algorithm CipherAlgorithm, key []byte) (cipher.AEAD, error) {
+	switch algorithm {
+	case "", CipherAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("unsupported cipher algorithm %q", algorithm)
+	}
+}
+
+// newAEADWithNonceSize 与 newAEAD 相同，但 AES-GCM 使用 nonceSize 字节的 nonce；nonceSize 为 0 时使用标准的 12 字节。
+// XChaCha20-Poly1305 的 nonce 长度是固定的，nonceSize 对它没有影响。
+func newAEADWithNonceSize(algorithm CipherAlgorithm, key []byte, nonceSize int) (cipher.AEAD, error) {
+	if nonceSize == 0 || algorithm == CipherXChaCha20Poly1305 {
+		return newAEAD(algorithm, key)
+	}
+	if nonceSize < minGCMNonceSize || nonceSize > maxGCMNonceSize {
+		return nil, fmt.Errorf("unsupported AES-GCM nonce size %d", nonceSize)
+	}
+	if err := validateCipher(algorithm); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
 		return nil, err
 	}
+	return cipher.NewGCMWithNonceSize(block, nonceSize)
+}
 
-	encrypted := gcm.Seal(nil, nonce, plaintext, nil)
-	return append(nonce, encrypted...), nil
+// encrypt 使用 AES-256-GCM 算法加密数据。
+// GCM 提供认证加密，无需额外的填充（如 PKCS#7）。
+// aad 为可选的关联数据，它参与认证但不会被加密或写入输出。
+// 输出格式为：[nonce || ciphertext || tag]。
+func encrypt(plaintext []byte, key *memguard.LockedBuffer, aad []byte) ([]byte, error) {
+	return encryptWith(CipherAESGCM, plaintext, key, aad)
 }
 
 // decrypt 使用 AES-256-GCM 算法解密数据。
 // GCM 会自动处理认证和解密，无需手动移除填充。
+// aad 必须与加密时提供的关联数据一致，否则认证失败。
 // 输入格式必须为：[nonce || ciphertext || tag]。
-func decrypt(ciphertext []byte, key *memguard.LockedBuffer) ([]byte, error) {
-	block, err := aes.NewCipher(key.Bytes())
+func decrypt(ciphertext []byte, key *memguard.LockedBuffer, aad []byte) ([]byte, error) {
+	return decryptWith(CipherAESGCM, ciphertext, key, aad)
+}
+
+// encryptWith 使用指定的 AEAD 算法加密数据，输出格式与 encrypt 相同：[nonce || ciphertext || tag]。
+func encryptWith(algorithm CipherAlgorithm, plaintext []byte, key *memguard.LockedBuffer, aad []byte) ([]byte, error) {
+	return encryptAppend(algorithm, nil, plaintext, key, aad)
+}
+
+// encryptAppend 与 encryptWith 相同，但把输出追加到 dst 之后；dst 容量足够时不会分配内存。
+func encryptAppend(algorithm CipherAlgorithm, dst, plaintext []byte, key *memguard.LockedBuffer, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(algorithm, key.Bytes())
 	if err != nil {
 		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	dst = slices.Grow(dst, aead.NonceSize()+len(plaintext)+aead.Overhead())
+	nonce := dst[len(dst) : len(dst)+aead.NonceSize()]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(dst[:len(dst)+len(nonce)], nonce, plaintext, aad), nil
+}
+
+// decryptWith 使用指定的 AEAD 算法解密 encryptWith 的输出。
+func decryptWith(algorithm CipherAlgorithm, ciphertext []byte, key *memguard.LockedBuffer, aad []byte) ([]byte, error) {
+	return decryptAppend(algorithm, 0, nil, ciphertext, key, aad)
+}
+
+// decryptAppend 与 decryptWith 相同，但把明文追加到 dst 之后；dst 不能与 ciphertext 重叠。
+// nonceSize 是 AES-GCM 密文的 nonce 长度，为 0 时使用标准长度，详见 newAEADWithNonceSize。
+func decryptAppend(algorithm CipherAlgorithm, nonceSize int, dst, ciphertext []byte, key *memguard.LockedBuffer, aad []byte) ([]byte, error) {
+	aead, err := newAEADWithNonceSize(algorithm, key.Bytes(), nonceSize)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(ciphertext) < gcm.NonceSize() {
+	if len(ciphertext) < aead.NonceSize() {
 		return nil, fmt.Errorf("ciphertext too short")
 	}
 
-	nonce, actualCiphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	nonce, actualCiphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
 
-	return gcm.Open(nil, nonce, actualCiphertext, nil)
+	return aead.Open(dst, nonce, actualCiphertext, aad)
 }
 
 // sign 使用 HMAC-SHA256 算法为数据生成签名。
@@ -0,0 +1,286 @@
+package secstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/awnumar/memguard"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func TestFilenamePaddingHidesLength(t *testing.T) {
+	key := memguard.NewBufferRandom(32)
+	defer key.Destroy()
+
+	// Names of different lengths within one bucket encrypt to the same length
+	manifest := &Manifest{Version: currentManifestVersion}
+	var ciphertextLen int
+	for _, name := range []string{"a", "report.pdf", strings.Repeat("x", filenameBucketSize-2)} {
+		ciphertext, err := encryptFilename("", name, key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ciphertextLen == 0 {
+			ciphertextLen = len(ciphertext)
+		} else if len(ciphertext) != ciphertextLen {
+			t.Fatalf("%q encrypted to %d bytes, want %d", name, len(ciphertext), ciphertextLen)
+		}
+
+		manifest.EncryptedOrigFilename = ciphertext
+		got, err := decryptFilename(manifest, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != name {
+			t.Fatalf("round trip gave %q, want %q", got, name)
+		}
+	}
+
+	// The next bucket starts once the length prefix no longer fits
+	ciphertext, err := encryptFilename("", strings.Repeat("x", filenameBucketSize-1), key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphertext) != ciphertextLen+filenameBucketSize {
+		t.Fatalf("bucket overflow encrypted to %d bytes, want %d", len(ciphertext), ciphertextLen+filenameBucketSize)
+	}
+}
+
+func TestUnpadFilenameRejectsMalformedInput(t *testing.T) {
+	padded, err := padFilename("name.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unpadFilename(padded); err != nil {
+		t.Fatal(err)
+	}
+
+	nonZero := append([]byte(nil), padded...)
+	nonZero[len(nonZero)-1] = 1
+	for name, input := range map[string][]byte{
+		"non-zero padding": nonZero,
+		"short bucket":     padded[:len(padded)-1],
+		"length overflow":  append([]byte{0xFF, 0xFF}, padded[2:]...),
+		"empty":            nil,
+	} {
+		if _, err := unpadFilename(input); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+// countingDeriver 包装默认的 KeyDeriver 并统计调用次数。
+type countingDeriver struct {
+	calls atomic.Int32
+}
+
+func (d *countingDeriver) Derive(password, salt []byte, params Argon2Config) *memguard.LockedBuffer {
+	d.calls.Add(1)
+	return argon2Deriver{}.Derive(password, salt, params)
+}
+
+func TestCustomKeyDeriver(t *testing.T) {
+	s := newTestSyncer(t)
+	deriver := &countingDeriver{}
+	s.KeyDeriver = deriver
+	manifestID, data := encryptTestFile(t, s, testOptions(), 100)
+	if deriver.calls.Load() == 0 {
+		t.Fatal("EncryptFile did not use the configured KeyDeriver")
+	}
+
+	calls := deriver.calls.Load()
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	if deriver.calls.Load() == calls {
+		t.Fatal("DecryptFile did not use the configured KeyDeriver")
+	}
+
+	// A compatible implementation is interchangeable with the default
+	s.KeyDeriver = nil
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
+
+// sealWithNonceSize 模拟使用非标准 nonce 长度的其他实现，输出格式为 [nonce || ciphertext || tag]。
+func sealWithNonceSize(t *testing.T, nonceSize int, plaintext []byte, key *memguard.LockedBuffer, aad []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCMWithNonceSize(block, nonceSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, nonceSize)
+	rand.Read(nonce)
+	return aead.Seal(nonce, nonce, plaintext, aad)
+}
+
+func TestDecryptAppendNonceSize(t *testing.T) {
+	key, err := generateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+	ciphertext := sealWithNonceSize(t, 16, []byte("migrated"), key, nil)
+
+	got, err := decryptAppend(CipherAESGCM, 16, nil, ciphertext, key, nil)
+	if err != nil || string(got) != "migrated" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if _, err := decryptWith(CipherAESGCM, ciphertext, key, nil); err == nil {
+		t.Fatal("expected the standard nonce size to fail")
+	}
+	if _, err := decryptAppend(CipherAESGCM, 4, nil, ciphertext, key, nil); err == nil {
+		t.Fatal("expected an error for a nonce size below the minimum")
+	}
+}
+
+// TestDecryptFileNonStandardNonceSize 把一个已加密文件的所有密文改写为 16 字节 nonce 的 AES-GCM，
+// 就像从其他实现迁移而来，然后检查 DecryptFile 按清单中的 NonceSize 解密。
+func TestDecryptFileNonStandardNonceSize(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.KeyWrapCipher = CipherAESGCM
+	opts.DataShards, opts.ParityShards = 1, 0
+	manifestID, data := encryptTestFile(t, s, opts, 3000)
+
+	manifest, key, err := s.openManifest(manifestID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+	const nonceSize = 16
+	ctx := context.Background()
+	for i, chunkPath := range manifest.ChunkPaths {
+		shardKey := shardKey(manifestID, chunkPath+plainChunkSuffix)
+		encrypted, err := s.backend().Get(ctx, shardKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dataKeyBytes, err := decryptWith(manifest.KeyWrapCipher, manifest.EncryptedDataKeys[i], key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dataKey := memguard.NewBufferFromBytes(dataKeyBytes)
+		plaintext, err := decryptWith(manifest.ChunkCipher, encrypted, dataKey, chunkAAD(manifestID, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resealed := sealWithNonceSize(t, nonceSize, plaintext, dataKey, chunkAAD(manifestID, i))
+		manifest.EncryptedDataKeys[i] = sealWithNonceSize(t, nonceSize, dataKey.Bytes(), key, nil)
+		manifest.EncryptedChunkSizes[i] = len(resealed)
+		dataKey.Destroy()
+		if err := s.backend().Delete(ctx, shardKey); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.backend().Put(ctx, shardKey, resealed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	padded, err := padFilename("input.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.EncryptedOrigFilename = sealWithNonceSize(t, nonceSize, padded, key, nil)
+	contentHash, err := decryptWith(manifest.KeyWrapCipher, manifest.EncryptedContentHash, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.EncryptedContentHash = sealWithNonceSize(t, nonceSize, contentHash, key, nil)
+	manifest.NonceSize = nonceSize
+	if err := s.saveManifest(manifestID, manifest, key); err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	// Without the recorded nonce size the same ciphertexts no longer authenticate
+	manifest.NonceSize = 0
+	if err := s.saveManifest(manifestID, manifest, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DecryptFile(manifestID, t.TempDir(), testPassword); err == nil {
+		t.Fatal("expected decryption to fail with the standard nonce size")
+	}
+}
+
+func TestSplitCiphertext(t *testing.T) {
+	key := memguard.NewBufferRandom(keyLength)
+	defer key.Destroy()
+	plaintext, aad := []byte("interoperable"), []byte("context")
+
+	for _, algorithm := range []CipherAlgorithm{CipherAESGCM, CipherXChaCha20Poly1305} {
+		blob, err := encryptWith(algorithm, plaintext, key, aad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce, ct, tag, err := SplitCiphertextWith(algorithm, blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantNonce := NonceSize
+		if algorithm == CipherXChaCha20Poly1305 {
+			wantNonce = XNonceSize
+		}
+		if len(nonce) != wantNonce || len(ct) != len(plaintext) || len(tag) != TagOverhead {
+			t.Fatalf("%s: got %d+%d+%d bytes", algorithm, len(nonce), len(ct), len(tag))
+		}
+
+		// A standard AEAD opens the parts without any knowledge of this package
+		var aead cipher.AEAD
+		if algorithm == CipherAESGCM {
+			block, err := aes.NewCipher(key.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			aead, err = cipher.NewGCM(block)
+			if err != nil {
+				t.Fatal(err)
+			}
+		} else if aead, err = chacha20poly1305.NewX(key.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		got, err := aead.Open(nil, nonce, append(slices.Clone(ct), tag...), aad)
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Fatalf("%s: external open returned %q, %v", algorithm, got, err)
+		}
+	}
+
+	if _, _, _, err := SplitCiphertext(make([]byte, NonceSize+TagOverhead-1)); err == nil {
+		t.Fatal("split a blob shorter than the nonce and tag")
+	}
+	if _, _, _, err := SplitCiphertextWith("rot13", make([]byte, 64)); err == nil {
+		t.Fatal("split with an unknown algorithm")
+	}
+}
+
+func TestAutoCipher(t *testing.T) {
+	defer func(saved bool) { hasAESHardware = saved }(hasAESHardware)
+	for _, tc := range []struct {
+		aesHardware bool
+		want        CipherAlgorithm
+	}{{true, CipherAESGCM}, {false, CipherXChaCha20Poly1305}} {
+		hasAESHardware = tc.aesHardware
+		s := newTestSyncer(t)
+		s.AutoCipher = true
+		manifestID, data := encryptTestFile(t, s, testOptions(), 3000)
+		manifest, err := s.ReadManifest(manifestID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if manifest.ChunkCipher != tc.want {
+			t.Fatalf("AES hardware %v: chunk cipher %q, want %q", tc.aesHardware, manifest.ChunkCipher, tc.want)
+		}
+
+		// The recorded algorithm is used for decryption whatever this machine would pick
+		s.AutoCipher = false
+		hasAESHardware = !tc.aesHardware
+		assertDecrypts(t, s, manifestID, testPassword, data)
+	}
+}
@@ -0,0 +1,121 @@
+package secstorage
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/awnumar/memguard"
+)
+
+// sealFunc 是 AEAD 加密函数，把 [nonce || ciphertext || tag] 追加到 dst 之后。
+// 默认使用随机 nonce 的 encryptAppend，确定性模式下使用 encryptSynthetic。
+type sealFunc func(algorithm CipherAlgorithm, dst, plaintext []byte, key *memguard.LockedBuffer, aad []byte) ([]byte, error)
+
+// HKDF 的 info 标签，区分确定性模式下派生出的不同用途的值。
+const (
+	hkdfInfoNonce         = "secstorage deterministic nonce"
+	hkdfInfoDataKey       = "secstorage deterministic data key"
+	hkdfInfoFileKey       = "secstorage deterministic file key"
+	hkdfInfoRecipientSalt = "secstorage deterministic recipient salt"
+)
+
+// validateDeterministic 检查确定性模式所需的参数：对象必须使用固定的 manifestID（或继续中断的上传），
+// 并提供至少 saltLength 字节的盐值。
+func (opts EncryptionOptions) validateDeterministic() error {
+	if !opts.Deterministic {
+		return nil
+	}
+	if opts.ManifestID == "" && opts.ResumeManifestID == "" {
+		return errors.New("deterministic encryption requires ManifestID")
+	}
+	if len(opts.DeterministicSalt) < saltLength {
+		return fmt.Errorf("deterministic encryption requires a salt of at least %d bytes, got %d", saltLength, len(opts.DeterministicSalt))
+	}
+	return nil
+}
+
+// syntheticNonce 通过 HKDF 从 key、aad 和 plaintext 派生 nonce。
+// 只有 key、aad 和明文完全相同时 nonce 才会相同，此时密文也完全相同，因此不会出现 nonce 重用。
+func syntheticNonce(key []byte, aad, plaintext []byte, size int) ([]byte, error) {
+	h := sha256.New()
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(aad)))
+	h.Write(length[:])
+	h.Write(aad)
+	h.Write(plaintext)
+	return hkdf.Key(sha256.New, key, h.Sum(nil), hkdfInfoNonce, size)
+}
+
+// encryptSynthetic 与 encryptAppend 相同，但 nonce 由 syntheticNonce 派生而不是随机生成，
+// 因此相同的输入总是得到相同的密文。
+func encryptSynthetic(algorithm CipherAlgorithm, dst, plaintext []byte, key *memguard.LockedBuffer, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(algorithm, key.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := syntheticNonce(key.Bytes(), aad, plaintext, aead.NonceSize())
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive nonce: %w", err)
+	}
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plaintext, aad), nil
+}
+
+// syntheticDataKey 通过 HKDF 从文件密钥、块的关联数据（manifestID 与块序号）和块明文派生数据密钥。
+// 派生输入包含明文的摘要，所以同一位置的块内容变化时数据密钥也随之变化。
+func syntheticDataKey(fileKey *memguard.LockedBuffer, aad, plaintext []byte) (*memguard.LockedBuffer, error) {
+	digest := sha256.Sum256(plaintext)
+	key, err := hkdf.Key(sha256.New, fileKey.Bytes(), digest[:], hkdfInfoDataKey+string(aad), keyLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive data key: %w", err)
+	}
+	return memguard.NewBufferFromBytes(key), nil
+}
+
+// deterministicRecipients 为确定性模式生成文件密钥和接收者列表。第 i 个接收者的盐值由 salt 和 i 派生；
+// 文件密钥由第一个密码派生出的包装密钥和 manifestID 派生，因此只取决于 manifestID、第一个密码和 salt。
+// 返回的密钥必须由调用方销毁。
+func deterministicRecipients(kd KeyDeriver, manifestID string, passwords []string, params Recipient, salt []byte) (*memguard.LockedBuffer, []Recipient, error) {
+	var fileKey *memguard.LockedBuffer
+	recipients := make([]Recipient, 0, len(passwords))
+	fail := func(err error) (*memguard.LockedBuffer, []Recipient, error) {
+		if fileKey != nil {
+			fileKey.Destroy()
+		}
+		return nil, nil, err
+	}
+
+	for i, password := range passwords {
+		recipient := params
+		var err error
+		recipient.Salt, err = hkdf.Key(sha256.New, salt, nil, fmt.Sprintf("%s %d", hkdfInfoRecipientSalt, i), saltLength)
+		if err != nil {
+			return fail(fmt.Errorf("failed to derive salt: %w", err))
+		}
+		pass := memguard.NewBufferFromBytes([]byte(password))
+		wrapKey, err := recipient.deriveKey(kd, pass.Bytes())
+		pass.Destroy()
+		if err != nil {
+			return fail(err)
+		}
+
+		if fileKey == nil {
+			key, err := hkdf.Key(sha256.New, wrapKey.Bytes(), []byte(manifestID), hkdfInfoFileKey, keyLength)
+			if err != nil {
+				wrapKey.Destroy()
+				return fail(fmt.Errorf("failed to derive file key: %w", err))
+			}
+			fileKey = memguard.NewBufferFromBytes(key)
+		}
+		recipient.WrappedKey, err = encryptSynthetic(CipherAESGCM, nil, fileKey.Bytes(), wrapKey, nil)
+		wrapKey.Destroy()
+		if err != nil {
+			return fail(fmt.Errorf("failed to wrap file key: %w", err))
+		}
+		recipients = append(recipients, recipient)
+	}
+	return fileKey, recipients, nil
+}
@@ -0,0 +1,110 @@
+package secstorage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// deterministicOptions 返回以固定 ManifestID 和盐值启用确定性加密的测试选项。
+func deterministicOptions() EncryptionOptions {
+	opts := testOptions()
+	opts.AdditionalPasswords = []string{"second password"}
+	opts.Metadata = map[string]string{"k": "v"}
+	opts.ManifestID = strings.Repeat("cd", defaultManifestIDBytes)
+	opts.Deterministic = true
+	opts.DeterministicSalt = bytes.Repeat([]byte{7}, saltLength)
+	return opts
+}
+
+// readTree 返回 dir 下所有文件相对路径到内容的映射。
+func readTree(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	files := make(map[string][]byte)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		rel, _ := filepath.Rel(dir, path)
+		files[rel] = data
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestDeterministicEncryptionIsReproducible(t *testing.T) {
+	path, data := writeTestFile(t, t.TempDir(), "input.bin", 3000)
+	opts := deterministicOptions()
+
+	var trees []map[string][]byte
+	for range 2 {
+		s := newTestSyncer(t)
+		if _, err := s.EncryptFile(path, opts); err != nil {
+			t.Fatal(err)
+		}
+		assertDecrypts(t, s, opts.ManifestID, "second password", data)
+		trees = append(trees, readTree(t, s.StorageDir))
+	}
+	if len(trees[0]) == 0 || len(trees[0]) != len(trees[1]) {
+		t.Fatalf("encryptions produced %d and %d files", len(trees[0]), len(trees[1]))
+	}
+	for name, content := range trees[0] {
+		if !bytes.Equal(content, trees[1][name]) {
+			t.Errorf("%s differs between encryptions", name)
+		}
+	}
+}
+
+func TestDeterministicEncryptionChangesWithContent(t *testing.T) {
+	dir := t.TempDir()
+	path, _ := writeTestFile(t, dir, "input.bin", 500)
+	opts := deterministicOptions()
+	opts.ParityShards = 0
+
+	s := newTestSyncer(t)
+	if _, err := s.EncryptFile(path, opts); err != nil {
+		t.Fatal(err)
+	}
+	shard := filepath.Join(s.StorageDir, opts.ManifestID, "chunk_0"+plainChunkSuffix)
+	first, err := os.ReadFile(shard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same position, same length, different content: neither the key nor the nonce may repeat
+	_, data := writeTestFile(t, dir, "input.bin", 500)
+	opts.Overwrite = true
+	if _, err := s.EncryptFile(path, opts); err != nil {
+		t.Fatal(err)
+	}
+	second, err := os.ReadFile(shard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first[:12], second[:12]) {
+		t.Fatal("different plaintexts were encrypted with the same nonce")
+	}
+	assertDecrypts(t, s, opts.ManifestID, testPassword, data)
+}
+
+func TestDeterministicOptionsValidation(t *testing.T) {
+	s := newTestSyncer(t)
+	path, _ := writeTestFile(t, t.TempDir(), "input.bin", 100)
+
+	opts := deterministicOptions()
+	opts.ManifestID = ""
+	if _, err := s.EncryptFile(path, opts); err == nil {
+		t.Error("deterministic encryption without ManifestID accepted")
+	}
+	opts = deterministicOptions()
+	opts.DeterministicSalt = []byte("short")
+	if _, err := s.EncryptFile(path, opts); err == nil {
+		t.Error("deterministic encryption with a short salt accepted")
+	}
+}
@@ -0,0 +1,277 @@
+package secstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// DirOptions 封装了目录加密操作的参数。目录中的每个文件都使用 EncryptionOptions 单独加密。
+type DirOptions struct {
+	EncryptionOptions
+	// Include 是 glob 模式列表（语法同 path.Match）。不为空时只加密至少匹配其中一个模式的文件。
+	// 不含 "/" 的模式匹配文件或目录名本身（例如 "*.log"），含 "/" 的模式匹配相对于根目录的完整路径（例如 "docs/*.md"）。
+	Include []string
+	// Exclude 的语法与 Include 相同。匹配的文件会被跳过，匹配的目录不会被进入（例如 "node_modules"、".git"）。
+	// Exclude 优先于 Include。
+	Exclude []string
+}
+
+// matchPattern 报告以 "/" 分隔的相对路径 relPath 是否匹配 pattern，匹配规则见 DirOptions.Include。
+func matchPattern(pattern, relPath string) bool {
+	if !strings.Contains(pattern, "/") {
+		relPath = path.Base(relPath)
+	}
+	ok, _ := path.Match(pattern, relPath)
+	return ok
+}
+
+// matchAny 报告 relPath 是否匹配 patterns 中的任意一个模式。
+func matchAny(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePatterns 检查 glob 模式的语法，避免错误的模式在遍历时被静默地当作不匹配。
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// FileResult 报告目录操作中单个文件的处理结果。
+type FileResult struct {
+	// Path 是文件相对于目录根的路径，使用 "/" 分隔。
+	Path string
+	// ManifestID 是加密后得到的清单 ID；解密时为被解密的清单 ID。
+	ManifestID string
+	// Metadata 是解密时从清单中读取的自定义元数据，加密时为 nil。
+	Metadata map[string]string
+	// LinkTo 不为空时，该文件是目录中另一个文件的硬链接：LinkTo 是该文件的相对路径，
+	// ManifestID 与它相同，内容不会被重复加密或解密。
+	LinkTo string
+	// Err 是处理该文件时发生的错误，成功时为 nil。
+	Err error
+}
+
+// EncryptDirStream 递归加密 root 下的所有普通文件（按 opts.Include 和 opts.Exclude 过滤），并通过返回的 channel 逐个报告结果。
+// 符号链接不会被跟随，而是保存为记录了（加密的）链接目标的链接清单，解密时重新创建为符号链接。
+// 同一文件的多个硬链接只加密一次，之后出现的路径引用第一个路径的清单，见 FileResult.LinkTo。
+// 单个文件失败不会中止整个操作，调用方可以根据每个 FileResult 自行决定是否继续；
+// 取消 ctx 会中止正在处理的文件并停止遍历。所有文件处理完毕后，新建的清单目录项会被同步到磁盘（见 Syncer.Durable），
+// 然后 channel 才会被关闭；同步失败时最后报告一个 Path 为空的错误。
+func (s *Syncer) EncryptDirStream(ctx context.Context, root string, opts DirOptions) <-chan FileResult {
+	results := make(chan FileResult)
+	go func() {
+		defer close(results)
+		send := func(result FileResult) bool {
+			select {
+			case results <- result:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if err := validatePatterns(slices.Concat(opts.Include, opts.Exclude)); err != nil {
+			send(FileResult{Err: err})
+			return
+		}
+		// Every file needs its own manifest, so a single caller-chosen ID cannot apply
+		if opts.ManifestID != "" {
+			send(FileResult{Err: errors.New("ManifestID is not supported for directories")})
+			return
+		}
+
+		// Encrypted regular files by size, so hard links to them can be recognised
+		encrypted := make(map[int64][]encryptedFile)
+		var stored []string
+		filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+			if ctx.Err() != nil {
+				return filepath.SkipAll
+			}
+			relPath, relErr := filepath.Rel(root, filePath)
+			if relErr != nil {
+				relPath = filePath
+			}
+			relPath = filepath.ToSlash(relPath)
+
+			// Excluded directories are pruned without being read
+			if relPath != "." && matchAny(opts.Exclude, relPath) {
+				if d != nil && d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if err != nil {
+				if !send(FileResult{Path: relPath, Err: fmt.Errorf("failed to walk %s: %w", filePath, err)}) {
+					return filepath.SkipAll
+				}
+				return nil
+			}
+			isSymlink := d.Type()&fs.ModeSymlink != 0
+			if !d.Type().IsRegular() && !isSymlink {
+				return nil
+			}
+			if len(opts.Include) > 0 && !matchAny(opts.Include, relPath) {
+				return nil
+			}
+
+			result := FileResult{Path: relPath}
+			if isSymlink {
+				result.ManifestID, result.Err = s.encryptSymlink(filePath, opts.EncryptionOptions)
+			} else if info, err := d.Info(); err != nil {
+				result.Err = fmt.Errorf("failed to stat %s: %w", filePath, err)
+			} else if file, ok := sameFile(encrypted[info.Size()], info); ok {
+				result.ManifestID, result.LinkTo = file.manifestID, file.relPath
+			} else {
+				result.ManifestID, result.Err = s.EncryptFileContext(ctx, filePath, opts.EncryptionOptions)
+				if result.Err == nil {
+					encrypted[info.Size()] = append(encrypted[info.Size()], encryptedFile{info: info, relPath: relPath, manifestID: result.ManifestID})
+				}
+			}
+			if result.Err == nil && result.LinkTo == "" {
+				stored = append(stored, result.ManifestID)
+			}
+			if !send(result) {
+				return filepath.SkipAll
+			}
+			return nil
+		})
+
+		// Make the new manifest directories durable before reporting the operation as complete
+		if err := s.syncManifestDirs(stored); err != nil {
+			send(FileResult{Err: err})
+		}
+	}()
+	return results
+}
+
+// EncryptDir 递归加密 root 下的所有普通文件和符号链接，返回相对路径到 manifestID 的映射。
+// 互为硬链接的路径映射到同一个 manifestID。
+// 单个文件失败不会中止操作：其余文件照常加密，返回的映射只包含成功的文件，
+// 返回的错误通过 errors.Join 汇总了每一个失败，可以用 errors.Is 查找特定的错误，或通过 Unwrap() []error 逐个检查。
+// 需要在处理过程中逐个获得结果时请使用 EncryptDirStream。
+func (s *Syncer) EncryptDir(root string, opts DirOptions) (map[string]string, error) {
+	manifests := make(map[string]string)
+	var errs []error
+	for result := range s.EncryptDirStream(context.Background(), root, opts) {
+		if result.Err != nil {
+			errs = append(errs, fileError("encrypt", result))
+			continue
+		}
+		manifests[result.Path] = result.ManifestID
+	}
+	return manifests, errors.Join(errs...)
+}
+
+// fileError 为 result 的错误加上操作名称和文件路径；与单个文件无关的错误（Path 为空）原样返回。
+func fileError(op string, result FileResult) error {
+	if result.Path == "" {
+		return result.Err
+	}
+	return fmt.Errorf("failed to %s %s: %w", op, result.Path, result.Err)
+}
+
+// DecryptDirStream 将 files（相对路径到 manifestID 的映射）中的每个文件解密到 outputRoot 下对应的相对路径，
+// 并通过返回的 channel 逐个报告结果。其语义与 EncryptDirStream 相同。
+// 输出文件名始终取自映射中的相对路径，因此以 OmitFilename 加密的文件同样可以还原。
+// 指向 outputRoot 之外的相对路径会被拒绝。
+//
+// 映射到同一个 manifestID 的多个路径只解密一次，其余路径还原为指向它的硬链接（无法创建硬链接时单独解密）。
+// 位于已还原的符号链接之下的路径会被拒绝，因此文件不会经由符号链接写到 outputRoot 之外。
+// 与 DecryptBatch 一样，派生出的密钥在整个操作期间按盐值缓存，见 EncryptionOptions.SharedSalt。
+func (s *Syncer) DecryptDirStream(ctx context.Context, files map[string]string, outputRoot, password string) <-chan FileResult {
+	relPaths := make([]string, 0, len(files))
+	for relPath := range files {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+
+	results := make(chan FileResult)
+	go func() {
+		defer close(results)
+		kd := newKeyCache(s.keyDeriver())
+		defer kd.destroy()
+		restored := make(map[string]FileResult)
+		symlinks := make(map[string]bool)
+		for _, relPath := range relPaths {
+			if ctx.Err() != nil {
+				return
+			}
+			manifestID := files[relPath]
+			result := FileResult{Path: relPath, ManifestID: manifestID}
+			localPath := filepath.FromSlash(relPath)
+			if !filepath.IsLocal(localPath) {
+				result.Err = fmt.Errorf("refusing to restore %s outside of the output directory", relPath)
+			} else if underSymlink(symlinks, relPath) {
+				result.Err = fmt.Errorf("refusing to restore %s through a symbolic link", relPath)
+			} else {
+				// The relative path decides the output file, whether or not the manifest stores a filename
+				outputPath := filepath.Join(outputRoot, localPath)
+				first, linked := restored[manifestID]
+				if linked && restoreHardlink(filepath.Join(outputRoot, filepath.FromSlash(first.Path)), outputPath) == nil {
+					result.Metadata, result.LinkTo = first.Metadata, first.Path
+				} else {
+					var report DecryptReport
+					report, result.Err = s.decryptFile(ctx, kd, manifestID, password, func(string) (string, error) {
+						return outputPath, nil
+					})
+					result.Metadata = report.Metadata
+					if result.Err == nil && !linked {
+						restored[manifestID] = result
+					}
+					if info, err := os.Lstat(outputPath); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+						symlinks[path.Clean(relPath)] = true
+					}
+				}
+			}
+
+			select {
+			case results <- result:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return results
+}
+
+// DecryptDir 将 EncryptDir 返回的映射中的所有文件解密到 outputRoot，保留相对目录结构。
+// 遇到第一个错误时立即停止；需要跳过失败文件继续处理时请使用 DecryptDirStream。
+func (s *Syncer) DecryptDir(files map[string]string, outputRoot, password string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for result := range s.DecryptDirStream(ctx, files, outputRoot, password) {
+		if result.Err != nil {
+			return fileError("decrypt", result)
+		}
+	}
+	return nil
+}
+
+// underSymlink 报告以 "/" 分隔的相对路径 relPath 的某个上级目录是否在 symlinks 中。
+func underSymlink(symlinks map[string]bool, relPath string) bool {
+	for dir := path.Dir(path.Clean(relPath)); dir != "."; dir = path.Dir(dir) {
+		if symlinks[dir] {
+			return true
+		}
+	}
+	return false
+}
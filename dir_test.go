@@ -0,0 +1,263 @@
+package secstorage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, relPath string
+		want             bool
+	}{
+		{"*.tmp", "a.tmp", true},
+		{"*.tmp", "deep/nested/a.tmp", true},
+		{"node_modules", "src/node_modules", true},
+		{"node_modules", "src/node_modules_old", false},
+		{"docs/*.md", "docs/a.md", true},
+		{"docs/*.md", "docs/sub/a.md", false},
+		{"docs/*.md", "other/docs/a.md", false},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.relPath); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.relPath, got, tt.want)
+		}
+	}
+}
+
+func TestEncryptDirIncludeExclude(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"a.txt",
+		"b.tmp",
+		"node_modules/x.txt",
+		"src/c.txt",
+		"src/node_modules/d.txt",
+		"src/e.go",
+		"docs/f.md",
+		"docs/sub/g.md",
+		"docs/keep.tmp.txt",
+	} {
+		writeTestFile(t, root, filepath.FromSlash(name), 16)
+	}
+
+	s := newTestSyncer(t)
+	opts := DirOptions{
+		EncryptionOptions: testOptions(),
+		Include:           []string{"*.txt", "docs/*.md", "e.go"},
+		// Exclude wins over Include: docs/keep.tmp.txt matches "*.txt" but is excluded by name.
+		Exclude: []string{"node_modules", "*.tmp*", "docs/sub"},
+	}
+	manifests, err := s.EncryptDir(root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for relPath := range manifests {
+		got = append(got, relPath)
+	}
+	sort.Strings(got)
+	want := []string{"a.txt", "docs/f.md", "src/c.txt", "src/e.go"}
+	if len(got) != len(want) {
+		t.Fatalf("encrypted %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("encrypted %v, want %v", got, want)
+		}
+	}
+}
+
+func TestEncryptDirExcludePrunesDirectories(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read directories regardless of their permissions")
+	}
+	root := t.TempDir()
+	writeTestFile(t, root, "a.txt", 16)
+	unreadable := filepath.Join(root, "private")
+	writeTestFile(t, unreadable, "secret.txt", 16)
+	if err := os.Chmod(unreadable, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(unreadable, defaultDirPerm)
+
+	s := newTestSyncer(t)
+	manifests, err := s.EncryptDir(root, DirOptions{EncryptionOptions: testOptions(), Exclude: []string{"private"}})
+	if err != nil {
+		t.Fatalf("excluded directory was read: %v", err)
+	}
+	if len(manifests) != 1 {
+		t.Fatalf("encrypted %d files, want 1", len(manifests))
+	}
+}
+
+func TestEncryptDirAggregatesFailures(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, root, "small.txt", 100)
+	writeTestFile(t, root, "big1.bin", 64*1024)
+	writeTestFile(t, root, filepath.Join("sub", "big2.bin"), 64*1024)
+
+	// Files too large for MaxChunks fail, the small one is still encrypted
+	opts := testOptions()
+	opts.MaxChunks = 2
+	s := newTestSyncer(t)
+	manifests, err := s.EncryptDir(root, DirOptions{EncryptionOptions: opts})
+	if len(manifests) != 1 || manifests["small.txt"] == "" {
+		t.Fatalf("got manifests %v, want only small.txt", manifests)
+	}
+	if !errors.Is(err, ErrTooManyChunks) {
+		t.Fatalf("got %v, want ErrTooManyChunks", err)
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok || len(joined.Unwrap()) != 2 {
+		t.Fatalf("expected one error per failed file, got %v", err)
+	}
+}
+
+func TestEncryptDirRejectsBadPattern(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, root, "a.txt", 16)
+	include := make([]string, 1, 4)
+	include[0] = "*.txt"
+
+	s := newTestSyncer(t)
+	if _, err := s.EncryptDir(root, DirOptions{EncryptionOptions: testOptions(), Include: include, Exclude: []string{"["}}); err == nil {
+		t.Fatal("expected an error for a malformed pattern")
+	}
+	if spare := include[:2]; spare[1] != "" {
+		t.Fatalf("caller's Include backing array was modified: %q", spare[1])
+	}
+}
+
+func TestDecryptDirRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	_, a := writeTestFile(t, root, "a.txt", 3000)
+	_, b := writeTestFile(t, root, filepath.Join("sub", "b.txt"), 100)
+
+	s := newTestSyncer(t)
+	manifests, err := s.EncryptDir(root, DirOptions{EncryptionOptions: testOptions()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputRoot := t.TempDir()
+	if err := s.DecryptDir(manifests, outputRoot, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]byte{"a.txt": a, "sub/b.txt": b} {
+		got, err := os.ReadFile(filepath.Join(outputRoot, filepath.FromSlash(name)))
+		if err != nil || string(got) != string(want) {
+			t.Fatalf("%s not restored: %v", name, err)
+		}
+	}
+}
+
+func TestDecryptDirOmitFilename(t *testing.T) {
+	root := t.TempDir()
+	_, a := writeTestFile(t, root, filepath.Join("sub", "a.txt"), 100)
+
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.OmitFilename = true
+	manifests, err := s.EncryptDir(root, DirOptions{EncryptionOptions: opts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputRoot := t.TempDir()
+	if err := s.DecryptDir(manifests, outputRoot, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(outputRoot, "sub", "a.txt"))
+	if err != nil || string(got) != string(a) {
+		t.Fatalf("sub/a.txt not restored: %v", err)
+	}
+}
+
+func TestEncryptDirLinks(t *testing.T) {
+	root := t.TempDir()
+	_, a := writeTestFile(t, root, "a.txt", 3000)
+	_, c := writeTestFile(t, root, filepath.Join("sub", "c.txt"), 100)
+	for _, link := range [][2]string{{"a.txt", "b.txt"}, {"a.txt", "sub/a-link.txt"}} {
+		if err := os.Link(filepath.Join(root, link[0]), filepath.Join(root, filepath.FromSlash(link[1]))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, link := range [][2]string{{"a.txt", "symlink"}, {"missing", "dangling"}, {"sub", "subdir"}} {
+		if err := os.Symlink(link[0], filepath.Join(root, link[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := newTestSyncer(t)
+	manifests, err := s.EncryptDir(root, DirOptions{EncryptionOptions: testOptions()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 7 {
+		t.Fatalf("encrypted %v, want 7 paths", manifests)
+	}
+	if manifests["b.txt"] != manifests["a.txt"] || manifests["sub/a-link.txt"] != manifests["a.txt"] {
+		t.Fatal("hard links were encrypted separately")
+	}
+	if _, ok := manifests["subdir/c.txt"]; ok {
+		t.Fatal("symbolic link to a directory was followed")
+	}
+	entries, err := os.ReadDir(s.StorageDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("storage has %d manifests, want 5", len(entries))
+	}
+
+	outputRoot := t.TempDir()
+	if err := s.DecryptDir(manifests, outputRoot, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]byte{"a.txt": a, "b.txt": a, "sub/a-link.txt": a, "sub/c.txt": c, "symlink": a} {
+		got, err := os.ReadFile(filepath.Join(outputRoot, filepath.FromSlash(name)))
+		if err != nil || string(got) != string(want) {
+			t.Fatalf("%s not restored: %v", name, err)
+		}
+	}
+	aInfo, _ := os.Stat(filepath.Join(outputRoot, "a.txt"))
+	for _, name := range []string{"b.txt", "sub/a-link.txt"} {
+		info, err := os.Stat(filepath.Join(outputRoot, filepath.FromSlash(name)))
+		if err != nil || !os.SameFile(aInfo, info) {
+			t.Fatalf("%s was not restored as a hard link: %v", name, err)
+		}
+	}
+	for name, want := range map[string]string{"symlink": "a.txt", "dangling": "missing", "subdir": "sub"} {
+		target, err := os.Readlink(filepath.Join(outputRoot, name))
+		if err != nil || target != want {
+			t.Fatalf("%s restored as %q (%v), want a link to %q", name, target, err, want)
+		}
+	}
+}
+
+func TestDecryptDirRefusesPathsThroughSymlinks(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, root, "a.txt", 16)
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestSyncer(t)
+	manifests, err := s.EncryptDir(root, DirOptions{EncryptionOptions: testOptions()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A crafted listing that places a file beneath the restored link
+	manifests["escape/a.txt"] = manifests["a.txt"]
+	outputRoot := t.TempDir()
+	if err := s.DecryptDir(manifests, outputRoot, testPassword); err == nil {
+		t.Fatal("expected an error for a path beneath a symbolic link")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "a.txt")); !os.IsNotExist(err) {
+		t.Fatalf("file was written through the symbolic link: %v", err)
+	}
+}
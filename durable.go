@@ -0,0 +1,92 @@
+package secstorage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// writeFile 将 data 写入临时文件后重命名为 path，保证 path 不会处于半写入状态：
+// 崩溃后它要么是旧内容，要么是新内容。Syncer.Durable 为 true 时，文件内容会在重命名前通过 fsync 落盘；
+// 重命名后的目录项还需要对其所在目录调用 syncDir 才能保证持久。
+func (s *Syncer) writeFile(path string, data []byte) error {
+	tempPath := path + ".tmp"
+	var err error
+	if s.Durable {
+		err = writeFileSync(tempPath, data, defaultFilePerm)
+	} else {
+		err = os.WriteFile(tempPath, data, defaultFilePerm)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// writeFileSync 与 os.WriteFile 相同，但在关闭文件前执行 fsync。
+func writeFileSync(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// syncDir 对目录执行 fsync，使其中新建、重命名的目录项在断电后依然存在。
+// Windows 不支持对目录执行 fsync，在该平台上直接返回。
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// syncManifestDirs 在批量操作结束时对 manifestIDs 的清单目录及其各级父目录（直到 StorageDir）执行 fsync，
+// 共享的父目录只同步一次，使新建的清单目录在断电后依然存在。它只保证目录项的持久，清单和分片的内容本身仍需 Durable。
+// Durable 为 true 时每个对象保存时已经同步过这些目录，直接返回。
+func (s *Syncer) syncManifestDirs(manifestIDs []string) error {
+	if s.Durable || len(manifestIDs) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, manifestID := range manifestIDs {
+		dir := s.manifestDir(manifestID)
+		for range s.ManifestDirDepth + 2 {
+			if seen[dir] {
+				break
+			}
+			seen[dir] = true
+			dirs = append(dirs, dir)
+			dir = filepath.Dir(dir)
+		}
+	}
+	for _, dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package secstorage
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSyncManifestDirs(t *testing.T) {
+	s := newTestSyncer(t)
+	s.ManifestDirDepth = 2
+	first, _ := encryptTestFile(t, s, testOptions(), 100)
+	second, _ := encryptTestFile(t, s, testOptions(), 100)
+	if err := s.syncManifestDirs([]string{first, second, first}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory that disappeared before the final sync is reported
+	if err := os.RemoveAll(s.manifestDir(second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.syncManifestDirs([]string{first, second}); err == nil {
+		t.Fatal("expected an error for a missing manifest directory")
+	}
+
+	// Durable mode has already synced every object as it was saved
+	s.Durable = true
+	if err := s.syncManifestDirs([]string{second}); err != nil {
+		t.Fatal(err)
+	}
+}
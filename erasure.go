@@ -0,0 +1,41 @@
+package secstorage
+
+import "fmt"
+
+// ErasureOp 表示出错的纠删码操作。
+type ErasureOp string
+
+const (
+	// ErasureNew 是创建编码器，通常表示分片数无效。
+	ErasureNew ErasureOp = "create encoder"
+	// ErasureSplit 是把加密块拆分为数据分片。
+	ErasureSplit ErasureOp = "split"
+	// ErasureEncode 是计算奇偶校验分片。
+	ErasureEncode ErasureOp = "encode"
+	// ErasureReconstruct 是根据剩余分片重建缺失的分片。
+	ErasureReconstruct ErasureOp = "reconstruct"
+	// ErasureJoin 是把数据分片拼接为加密块。
+	ErasureJoin ErasureOp = "join"
+)
+
+// ErasureError 包装 Reed-Solomon 库返回的错误，并记录出错的操作和块序号，
+// 调用方可以用 errors.As 取出，再用 errors.Is 与 reedsolomon 包的错误（如 ErrTooFewShards）比较。
+type ErasureError struct {
+	// Op 是出错的操作。
+	Op ErasureOp
+	// Chunk 是块序号，与具体的块无关时（例如创建编码器）为 -1。
+	Chunk int
+	// Err 是库返回的原始错误。
+	Err error
+}
+
+func (e *ErasureError) Error() string {
+	if e.Chunk < 0 {
+		return fmt.Sprintf("erasure code %s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("erasure code %s of chunk %d: %v", e.Op, e.Chunk, e.Err)
+}
+
+func (e *ErasureError) Unwrap() error {
+	return e.Err
+}
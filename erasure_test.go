@@ -0,0 +1,36 @@
+package secstorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+func TestErasureErrorRecordsOperationAndChunk(t *testing.T) {
+	s := newTestSyncer(t)
+	_, err := s.newErasureCoder(0, 2)
+	var erasureErr *ErasureError
+	if !errors.As(err, &erasureErr) || erasureErr.Op != ErasureNew || erasureErr.Chunk != -1 {
+		t.Fatalf("got %v, want an ErasureError for creating the encoder", err)
+	}
+	if !errors.Is(err, reedsolomon.ErrInvShardNum) {
+		t.Fatalf("got %v, want it to wrap ErrInvShardNum", err)
+	}
+
+	enc, err := s.newErasureCoder(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.putChunkShards(context.Background(), "m", 3, "chunk_3", standardShardSuffixes(4, 2), nil, enc, 0, nil)
+	if !errors.As(err, &erasureErr) || erasureErr.Op != ErasureSplit || erasureErr.Chunk != 3 {
+		t.Fatalf("got %v, want an ErasureError for splitting chunk 3", err)
+	}
+	if !errors.Is(err, reedsolomon.ErrShortData) {
+		t.Fatalf("got %v, want it to wrap ErrShortData", err)
+	}
+	if want := "erasure code split of chunk 3: " + reedsolomon.ErrShortData.Error(); err.Error() != want {
+		t.Fatalf("got %q, want %q", err.Error(), want)
+	}
+}
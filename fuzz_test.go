@@ -0,0 +1,91 @@
+package secstorage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/awnumar/memguard"
+)
+
+// boundedDeriver 把 Argon2 的迭代次数、内存和线程数限制在很小的值，使模糊测试不会被清单中的参数耗尽时间或内存。
+// 为 0 的参数原样传给 argon2。
+type boundedDeriver struct{}
+
+// Derive 实现了 KeyDeriver 接口。
+func (boundedDeriver) Derive(password, salt []byte, params Argon2Config) *memguard.LockedBuffer {
+	return deriveKey(password, salt, min(params.Time, 1), min(params.MemoryKB, 64), min(params.Threads, 4))
+}
+
+// FuzzDecryptManifest 把任意字节当作清单解析，通过结构检查后重新签名，使解密能够越过签名验证，
+// 在真实的分片上运行完整的解密流程。无论清单内容如何，都只能返回错误，不能 panic。
+func FuzzDecryptManifest(f *testing.F) {
+	s := NewSyncer(f.TempDir())
+	s.KeyWrapper = newTestKeyWrapper(f)
+	path, _ := writeTestFile(f, f.TempDir(), "input.bin", 3000)
+	seeds := []func(opts *EncryptionOptions){
+		func(opts *EncryptionOptions) {},
+		func(opts *EncryptionOptions) { opts.ParityShards = 0 },
+		func(opts *EncryptionOptions) { opts.MaxShardBytes = minShardPartBytes },
+	}
+
+	// The manifest ID is not part of the manifest, so every input is tried against the shards and file key of each seed
+	type target struct {
+		manifestID string
+		wrappedKey []byte
+		key        *memguard.LockedBuffer
+	}
+	var targets []target
+	for _, edit := range seeds {
+		opts := testOptions()
+		opts.KeyWrapper = s.KeyWrapper
+		edit(&opts)
+		id, err := s.EncryptFile(path, opts)
+		if err != nil {
+			f.Fatal(err)
+		}
+		data, err := os.ReadFile(s.getManifestPath(id))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+		manifest, key, err := s.openManifest(id, "")
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Cleanup(key.Destroy)
+		targets = append(targets, target{id, manifest.KMSWrappedKey, key})
+	}
+	f.Add([]byte(`{"chunk_paths":["chunk_0"],"data_shards":4,"parity_shards":2}`))
+	f.Add([]byte(`{"salt":"c2FsdHNhbHRzYWx0c2FsdA==","argon2_memory":64}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		manifest, err := decodeManifest(data)
+		if err != nil {
+			return
+		}
+		if err := validateManifest(manifest); err != nil {
+			return
+		}
+		// The password is tried before the signature can be checked, so the parameters are untrusted here.
+		// scrypt does not go through a KeyDeriver and its cost cannot be capped, so those manifests are skipped
+		if !slices.ContainsFunc(manifest.Recipients, func(r Recipient) bool { return r.KDF == KDFScrypt }) {
+			if key, err := unlockManifest(boundedDeriver{}, manifest, testPassword); err == nil {
+				key.Destroy()
+			}
+		}
+
+		output := filepath.Join(t.TempDir(), "output.bin")
+		for _, target := range targets {
+			manifest.KMSWrappedKey = target.wrappedKey
+			if err := signManifest(manifest, target.key); err != nil {
+				return
+			}
+			s.decryptManifest(context.Background(), s.keyDeriver(), target.manifestID, manifest, "", func(string) (string, error) {
+				return output, nil
+			})
+		}
+	})
+}
@@ -2,17 +2,21 @@ module github.com/liulcode/secstorage
 
 go 1.24.3
 
+require (
+	github.com/awnumar/memguard v0.22.5
+	github.com/klauspost/reedsolomon v1.12.5
+	github.com/restic/chunker v0.4.0
+	golang.org/x/crypto v0.40.0
+	golang.org/x/sys v0.34.0
+	gopkg.in/yaml.v3 v3.0.1
+	lukechampine.com/blake3 v1.4.1
+)
+
 require (
 	github.com/awnumar/memcall v0.2.0 // indirect
-	github.com/awnumar/memguard v0.22.5 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.10 // indirect
-	github.com/klauspost/reedsolomon v1.12.5 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/restic/chunker v0.4.0 // indirect
 	github.com/stretchr/testify v1.10.0 // indirect
-	golang.org/x/crypto v0.40.0 // indirect
-	golang.org/x/sys v0.34.0 // indirect
 	golang.org/x/term v0.33.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
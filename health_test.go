@@ -0,0 +1,71 @@
+package secstorage
+
+import (
+	"os"
+	"testing"
+)
+
+func TestChunkHealth(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, s, testOptions(), 5000)
+
+	health, err := s.ChunkHealth(manifestID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !health.Verifiable || len(health.Shards) != 6 {
+		t.Fatalf("fresh chunk reported as %+v", health)
+	}
+	for j, shard := range health.Shards {
+		if !shard.Exists || !shard.Verified || shard.Size != health.ShardSize {
+			t.Fatalf("shard %d of a fresh chunk reported as %+v", j, shard)
+		}
+	}
+
+	// A corrupted shard is located while there is redundancy to spare
+	flipBit(t, shardPath(s, manifestID, 1, 4))
+	health, err = s.ChunkHealth(manifestID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j, shard := range health.Shards {
+		if !shard.Exists || shard.Verified != (j != 4) {
+			t.Fatalf("shard %d reported as %+v", j, shard)
+		}
+	}
+
+	// A missing shard leaves the rest verifiable, but not a second fault on top of it
+	if err := os.Remove(shardPath(s, manifestID, 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+	health, err = s.ChunkHealth(manifestID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j, shard := range health.Shards {
+		if shard.Exists != (j != 2) || shard.Verified {
+			t.Fatalf("shard %d reported as %+v", j, shard)
+		}
+	}
+
+	// A truncated shard exists but has the wrong size
+	if err := os.Truncate(shardPath(s, manifestID, 2, 0), 1); err != nil {
+		t.Fatal(err)
+	}
+	health, err = s.ChunkHealth(manifestID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !health.Verifiable {
+		t.Fatal("expected the chunk to be verifiable")
+	}
+	for j, shard := range health.Shards {
+		if j == 0 && (!shard.Exists || shard.Size != 1 || shard.Verified) || j > 0 && !shard.Verified {
+			t.Fatalf("shard %d reported as %+v", j, shard)
+		}
+	}
+
+	if _, err := s.ChunkHealth(manifestID, 100); err == nil {
+		t.Fatal("expected an error for an out-of-range chunk")
+	}
+}
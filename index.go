@@ -0,0 +1,255 @@
+package secstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// IndexEntry 是清单索引中的一条记录，只包含无需密码即可获得的非机密信息。
+type IndexEntry struct {
+	ManifestID string    `json:"manifest_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	// Size 是所有加密块的总字节数（不含纠删码冗余）。
+	Size int64 `json:"size"`
+	// NameTag 是文件名的盲索引标签，仅在加密时配置了 Syncer.SearchKey 才存在。
+	NameTag []byte `json:"name_tag,omitempty"`
+}
+
+// Index 定义了清单索引的接口。
+// 索引只是磁盘内容的缓存，可以随时通过 Syncer.Reindex 从存储目录重建。
+// 实现必须可以被多个 goroutine 并发调用。
+type Index interface {
+	// Put 新增或更新一条记录。
+	Put(entry IndexEntry) error
+	// Delete 删除 manifestID 对应的记录，记录不存在时不返回错误。
+	Delete(manifestID string) error
+	// List 返回所有记录。
+	List() ([]IndexEntry, error)
+	// Replace 用 entries 替换索引的全部内容。
+	Replace(entries []IndexEntry) error
+}
+
+// FileIndex 是一个基于单个 JSON 文件的 Index 实现。
+// 所有记录常驻内存，每次修改后整体原子地写回文件。
+type FileIndex struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]IndexEntry
+}
+
+// NewFileIndex 打开或创建位于 path 的索引文件。
+func NewFileIndex(path string) (*FileIndex, error) {
+	idx := &FileIndex{path: path, entries: make(map[string]IndexEntry)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index file %s: %w", path, err)
+	}
+
+	var entries []IndexEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal index file %s: %w", path, err)
+	}
+	for _, entry := range entries {
+		idx.entries[entry.ManifestID] = entry
+	}
+	return idx, nil
+}
+
+// Put 实现了 Index 接口。
+func (idx *FileIndex) Put(entry IndexEntry) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.entries[entry.ManifestID] = entry
+	return idx.save()
+}
+
+// Delete 实现了 Index 接口。
+func (idx *FileIndex) Delete(manifestID string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if _, ok := idx.entries[manifestID]; !ok {
+		return nil
+	}
+	delete(idx.entries, manifestID)
+	return idx.save()
+}
+
+// List 实现了 Index 接口，返回按 ManifestID 排序的记录。
+func (idx *FileIndex) List() ([]IndexEntry, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.sortedEntries(), nil
+}
+
+// Replace 实现了 Index 接口。
+func (idx *FileIndex) Replace(entries []IndexEntry) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.entries = make(map[string]IndexEntry, len(entries))
+	for _, entry := range entries {
+		idx.entries[entry.ManifestID] = entry
+	}
+	return idx.save()
+}
+
+func (idx *FileIndex) sortedEntries() []IndexEntry {
+	entries := make([]IndexEntry, 0, len(idx.entries))
+	for _, entry := range idx.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ManifestID < entries[j].ManifestID })
+	return entries
+}
+
+// save 将索引写入临时文件后重命名，保证索引文件不会处于半写入状态。调用方必须持有锁。
+func (idx *FileIndex) save() error {
+	data, err := json.MarshalIndent(idx.sortedEntries(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal index: %w", err)
+	}
+
+	tempPath := idx.path + ".tmp"
+	if err := os.WriteFile(tempPath, data, defaultFilePerm); err != nil {
+		return fmt.Errorf("failed to write index file: %w", err)
+	}
+	if err := os.Rename(tempPath, idx.path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to replace index file: %w", err)
+	}
+	return nil
+}
+
+// newIndexEntry 根据清单生成索引记录。
+func newIndexEntry(manifestID string, manifest *Manifest, createdAt time.Time) IndexEntry {
+	var size int64
+	for _, chunkSize := range manifest.EncryptedChunkSizes {
+		size += int64(chunkSize)
+	}
+	return IndexEntry{ManifestID: manifestID, CreatedAt: createdAt, Size: size, NameTag: manifest.NameTag}
+}
+
+// scanManifests 遍历存储目录，为每个包含 manifest.json 的子目录生成索引记录。
+// 创建时间取自清单记录的 CreatedAt，旧清单没有该字段时使用 manifest.json 的修改时间。
+// 与 scrub 一样，无法读取或解析的清单不会中断遍历：它们被跳过，各自的错误收集在 skipped 中。
+// 只有存储目录本身无法读取时才返回 err。
+func (s *Syncer) scanManifests() (entries []IndexEntry, skipped []error, err error) {
+	ids, err := s.storedManifestIDs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, manifestID := range ids {
+		manifest, err := s.loadManifest(manifestID)
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("skipped manifest %s: %w", manifestID, err))
+			continue
+		}
+		createdAt := manifest.CreatedAt
+		if createdAt.IsZero() {
+			info, err := os.Stat(s.getManifestPath(manifestID))
+			if err != nil {
+				skipped = append(skipped, fmt.Errorf("failed to stat manifest %s: %w", manifestID, err))
+				continue
+			}
+			createdAt = info.ModTime()
+		}
+		entries = append(entries, newIndexEntry(manifestID, manifest, createdAt))
+	}
+	return entries, skipped, nil
+}
+
+// ListManifests 返回存储目录中所有清单的 ID。
+// 配置了 Index 时直接读取索引，否则遍历存储目录并解析每个 manifest.json。
+// 遍历时无法解析的清单会被跳过：返回的 ID 仍包含其余清单，同时返回通过 errors.Join 汇总的错误。
+func (s *Syncer) ListManifests() ([]string, error) {
+	var entries []IndexEntry
+	var skipped []error
+	var err error
+	if s.Index != nil {
+		entries, err = s.Index.List()
+	} else {
+		entries, skipped, err = s.scanManifests()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.ManifestID)
+	}
+	sort.Strings(ids)
+	return ids, errors.Join(skipped...)
+}
+
+// DeleteManifest 删除 manifestID 对应的清单及其所有分片，并从索引中移除该记录。
+// 它只是解除文件链接，不会覆写文件内容；需要覆写时使用 WipeManifest。
+// 对于中断后未续传、因此还没有 manifest.json 的上传，它根据进度文件删除已写入的分片和整个目录，
+// 用于放弃不再需要续传的上传。
+func (s *Syncer) DeleteManifest(manifestID string) error {
+	defer s.lockManifest(manifestID)()
+
+	names, err := s.manifestShardNames(manifestID)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	for _, name := range names {
+		if err := s.backend().Delete(ctx, shardKey(manifestID, name)); err != nil {
+			return fmt.Errorf("failed to delete shard %s: %w", name, err)
+		}
+	}
+	if err := os.RemoveAll(s.manifestDir(manifestID)); err != nil {
+		return fmt.Errorf("failed to delete manifest %s: %w", manifestID, err)
+	}
+	if s.Index != nil {
+		if err := s.Index.Delete(manifestID); err != nil {
+			return fmt.Errorf("failed to remove manifest %s from index: %w", manifestID, err)
+		}
+	}
+	return nil
+}
+
+// manifestShardNames 返回 manifestID 在后端中的所有分片文件名。清单不存在时改为根据上传进度文件列出，
+// 以便清理中断的上传。
+func (s *Syncer) manifestShardNames(manifestID string) ([]string, error) {
+	manifest, err := s.loadManifest(manifestID)
+	switch {
+	case err == nil:
+		var names []string
+		for i := range manifest.ChunkPaths {
+			names = append(names, manifest.storageNames(i)...)
+		}
+		return names, nil
+	case errors.Is(err, os.ErrNotExist):
+		return s.uploadShardNames(manifestID)
+	default:
+		return nil, err
+	}
+}
+
+// Reindex 通过遍历存储目录重建 Syncer 的索引。未配置 Index 时返回错误。
+// 无法解析的清单不会进入新索引，但其余清单照常写入；此时返回通过 errors.Join 汇总的错误。
+func (s *Syncer) Reindex() error {
+	if s.Index == nil {
+		return fmt.Errorf("no index configured")
+	}
+	entries, skipped, err := s.scanManifests()
+	if err != nil {
+		return err
+	}
+	if err := s.Index.Replace(entries); err != nil {
+		return err
+	}
+	return errors.Join(skipped...)
+}
@@ -0,0 +1,272 @@
+package secstorage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tamperManifest 在不重新签名的情况下修改 manifestID 的清单。
+func tamperManifest(t *testing.T, s *Syncer, manifestID string, edit func(m *Manifest)) {
+	t.Helper()
+	data, err := os.ReadFile(s.getManifestPath(manifestID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	edit(&manifest)
+	data, err = json.Marshal(&manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.getManifestPath(manifestID), data, defaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteManifestRejectsPathTraversal(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, s, testOptions(), 3000)
+	victim, _ := writeTestFile(t, t.TempDir(), "victim", 16)
+
+	rel, err := filepath.Rel(filepath.Join(s.StorageDir, manifestID), victim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamperManifest(t, s, manifestID, func(m *Manifest) {
+		m.ShardSuffixes[0] = "/" + filepath.ToSlash(rel)
+		m.ChunkPaths[0] = "."
+	})
+	if err := s.DeleteManifest(manifestID); err == nil {
+		t.Fatal("DeleteManifest accepted a manifest with a traversing shard name")
+	}
+	if _, err := os.Stat(victim); err != nil {
+		t.Fatalf("file outside the storage directory was touched: %v", err)
+	}
+}
+
+func TestLoadManifestRejectsInconsistentLengths(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, s, testOptions(), 3000)
+	tamperManifest(t, s, manifestID, func(m *Manifest) {
+		m.EncryptedChunkSizes = m.EncryptedChunkSizes[:1]
+	})
+	if err := s.DeleteManifest(manifestID); !errors.Is(err, ErrManifestTampered) {
+		t.Fatalf("DeleteManifest returned %v, want ErrManifestTampered", err)
+	}
+	if err := s.DecryptFile(manifestID, t.TempDir(), testPassword); !errors.Is(err, ErrManifestTampered) {
+		t.Fatalf("DecryptFile returned %v, want ErrManifestTampered", err)
+	}
+}
+
+func TestValidateManifest(t *testing.T) {
+	valid := func() *Manifest {
+		return &Manifest{
+			DataShards:               2,
+			ParityShards:             1,
+			ChunkPaths:               []string{"chunk_0"},
+			EncryptedDataKeys:        [][]byte{{1}},
+			EncryptedChunkSizes:      []int{10},
+			ErasureCodeChunkSuffixes: [][]string{{"_shard_0.dat", "_shard_1.dat", "_shard_2.dat"}},
+		}
+	}
+	if err := validateManifest(valid()); err != nil {
+		t.Fatal(err)
+	}
+	for name, edit := range map[string]func(m *Manifest){
+		"separator in suffix": func(m *Manifest) { m.ErasureCodeChunkSuffixes[0][1] = "/../x" },
+		"dot-dot chunk path":  func(m *Manifest) { m.ChunkPaths[0] = ".." },
+		"backslash":           func(m *Manifest) { m.ChunkPaths[0] = `a\b` },
+		"empty chunk path":    func(m *Manifest) { m.ChunkPaths[0] = "" },
+		"missing shard":       func(m *Manifest) { m.ErasureCodeChunkSuffixes[0] = m.ErasureCodeChunkSuffixes[0][:2] },
+		"missing size":        func(m *Manifest) { m.EncryptedChunkSizes = nil },
+		"missing data key":    func(m *Manifest) { m.EncryptedDataKeys = nil },
+		"negative size":       func(m *Manifest) { m.EncryptedChunkSizes[0] = -1 },
+		"negative shards":     func(m *Manifest) { m.ParityShards = -1 },
+		"short nonce":         func(m *Manifest) { m.NonceSize = 4 },
+		"zero argon2 time":    func(m *Manifest) { m.Recipients = []Recipient{{Argon2Memory: 64, Argon2Threads: 1}} },
+		"bad scrypt N":        func(m *Manifest) { m.Recipients = []Recipient{{KDF: KDFScrypt, ScryptN: 3, ScryptR: 8, ScryptP: 1}} },
+	} {
+		m := valid()
+		edit(m)
+		if err := validateManifest(m); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	for name, edit := range map[string]func(m *Manifest){
+		"missing shard":      func(m *Manifest) { m.ErasureCodeChunkSuffixes[0] = m.ErasureCodeChunkSuffixes[0][:2] },
+		"extra suffix list":  func(m *Manifest) { m.ErasureCodeChunkSuffixes = append(m.ErasureCodeChunkSuffixes, nil) },
+		"missing size":       func(m *Manifest) { m.EncryptedChunkSizes = nil },
+		"missing data key":   func(m *Manifest) { m.EncryptedDataKeys = nil },
+		"extra chunk path":   func(m *Manifest) { m.ChunkPaths = append(m.ChunkPaths, "chunk_1") },
+		"plaintext sizes":    func(m *Manifest) { m.PlaintextChunkSizes = []int{1, 2} },
+		"more parity shards": func(m *Manifest) { m.ParityShards = 2 },
+	} {
+		m := valid()
+		edit(m)
+		if err := validateManifest(m); !errors.Is(err, ErrManifestTampered) {
+			t.Errorf("%s: got %v, want ErrManifestTampered", name, err)
+		}
+	}
+
+	// From version 4 on the suffixes are shared by all chunks
+	shared := func() *Manifest {
+		m := valid()
+		m.Version = manifestVersionShardSuffixes
+		m.ShardSuffixes, m.ErasureCodeChunkSuffixes = m.ErasureCodeChunkSuffixes[0], nil
+		return m
+	}
+	if err := validateManifest(shared()); err != nil {
+		t.Fatal(err)
+	}
+	for name, edit := range map[string]func(m *Manifest){
+		"per-chunk suffixes":  func(m *Manifest) { m.ErasureCodeChunkSuffixes = [][]string{m.ShardSuffixes} },
+		"separator in suffix": func(m *Manifest) { m.ShardSuffixes[1] = "/../x" },
+		"missing shard":       func(m *Manifest) { m.ShardSuffixes = m.ShardSuffixes[:2] },
+	} {
+		m := shared()
+		edit(m)
+		if err := validateManifest(m); err == nil {
+			t.Errorf("version 4, %s: accepted", name)
+		}
+	}
+}
+
+func TestFileIndexPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	index, err := NewFileIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, entry := range []IndexEntry{
+		{ManifestID: "b", CreatedAt: createdAt, Size: 20},
+		{ManifestID: "a", CreatedAt: createdAt, Size: 10, NameTag: []byte("tag")},
+		{ManifestID: "c", CreatedAt: createdAt, Size: 30},
+	} {
+		if err := index.Put(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := index.Delete("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Delete("missing"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh FileIndex sees exactly what was written
+	reopened, err := NewFileIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := reopened.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ManifestID != "a" || entries[1].ManifestID != "b" {
+		t.Fatalf("reopened index has %+v", entries)
+	}
+	if entries[0].Size != 10 || !entries[0].CreatedAt.Equal(createdAt) || string(entries[0].NameTag) != "tag" {
+		t.Fatalf("entry a came back as %+v", entries[0])
+	}
+
+	if err := reopened.Replace([]IndexEntry{{ManifestID: "d"}}); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err = NewFileIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := reopened.List(); len(entries) != 1 || entries[0].ManifestID != "d" {
+		t.Fatalf("replaced index has %+v", entries)
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), defaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileIndex(path); err == nil {
+		t.Fatal("opened a corrupted index file")
+	}
+}
+
+func TestListManifestsSkipsUnreadableManifests(t *testing.T) {
+	s := newTestSyncer(t)
+	first, _ := encryptTestFile(t, s, testOptions(), 100)
+	second, _ := encryptTestFile(t, s, testOptions(), 100)
+	bad, _ := encryptTestFile(t, s, testOptions(), 100)
+	if err := os.WriteFile(s.getManifestPath(bad), []byte("{"), defaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := s.ListManifests()
+	if err == nil || !strings.Contains(err.Error(), bad) {
+		t.Fatalf("expected an error naming %s, got %v", bad, err)
+	}
+	want := []string{first, second}
+	slices.Sort(want)
+	if !slices.Equal(ids, want) {
+		t.Fatalf("ListManifests = %v, want %v", ids, want)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	s := newTestSyncer(t)
+	if err := s.Reindex(); err == nil {
+		t.Fatal("Reindex without an index succeeded")
+	}
+	index, err := NewFileIndex(filepath.Join(t.TempDir(), "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Index = index
+
+	kept, _ := encryptTestFile(t, s, testOptions(), 100)
+	deleted, _ := encryptTestFile(t, s, testOptions(), 100)
+	if ids, err := s.ListManifests(); err != nil || len(ids) != 2 {
+		t.Fatalf("ListManifests = %v, %v; want both new manifests", ids, err)
+	}
+	if err := s.DeleteManifest(deleted); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := s.ListManifests(); err != nil || !slices.Equal(ids, []string{kept}) {
+		t.Fatalf("ListManifests after DeleteManifest = %v, %v; want [%s]", ids, err, kept)
+	}
+
+	// Objects written without the index, and a stale entry, are fixed by Reindex
+	s.Index = nil
+	unindexed, _ := encryptTestFile(t, s, testOptions(), 100)
+	bad, _ := encryptTestFile(t, s, testOptions(), 100)
+	if err := os.WriteFile(s.getManifestPath(bad), []byte("{"), defaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	s.Index = index
+	if err := index.Put(IndexEntry{ManifestID: "stale"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reindex(); err == nil || !strings.Contains(err.Error(), bad) {
+		t.Fatalf("expected Reindex to report %s, got %v", bad, err)
+	}
+	want := []string{kept, unindexed}
+	slices.Sort(want)
+	if ids, err := s.ListManifests(); err != nil || !slices.Equal(ids, want) {
+		t.Fatalf("ListManifests after Reindex = %v, %v; want %v", ids, err, want)
+	}
+	entries, err := index.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Size <= 0 || entry.CreatedAt.IsZero() {
+			t.Fatalf("rebuilt entry %+v is missing its size or creation time", entry)
+		}
+	}
+}
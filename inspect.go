@@ -0,0 +1,89 @@
+package secstorage
+
+import (
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// modulePath 是本库的模块路径，用于从构建信息中查找库版本。
+const modulePath = "github.com/liulcode/secstorage"
+
+// creatorVersion 返回写入清单 CreatorVersion 字段的库版本，形如 "github.com/liulcode/secstorage@v1.2.3"。
+// 版本号取自二进制的构建信息，无法确定时为 "(devel)"。结果只计算一次。
+var creatorVersion = sync.OnceValue(func() string {
+	version := "(devel)"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path == modulePath && info.Main.Version != "" {
+			version = info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath && dep.Version != "" {
+				version = dep.Version
+			}
+		}
+	}
+	return modulePath + "@" + version
+})
+
+// ManifestInfo 汇总了清单中的非机密元数据，由 InspectManifest 和 VerifyManifestToken 返回。
+type ManifestInfo struct {
+	ManifestID string `json:"manifest_id"`
+	// Version 是清单的格式版本。
+	Version int `json:"version"`
+	// CreatedAt 和 CreatorVersion 是加密时记录的创建时间和库版本，旧清单中为空。
+	CreatedAt      time.Time `json:"created_at,omitzero"`
+	CreatorVersion string    `json:"creator_version,omitempty"`
+	// ChunkCount 是块的数量，Size 是所有加密块的总字节数（不含纠删码冗余）。
+	ChunkCount int   `json:"chunk_count"`
+	Size       int64 `json:"size"`
+	// DataShards 和 ParityShards 是纠删码参数，ParityShards 为 0 表示未使用纠删码。
+	DataShards   int `json:"data_shards"`
+	ParityShards int `json:"parity_shards"`
+	// Recipients 是可以解密该文件的密码数量，旧清单为 1。
+	Recipients    int             `json:"recipients"`
+	KeyWrapCipher CipherAlgorithm `json:"key_wrap_cipher,omitempty"`
+	ChunkCipher   CipherAlgorithm `json:"chunk_cipher,omitempty"`
+	// HasFilename 报告清单是否保存了（加密的）原始文件名。
+	HasFilename bool `json:"has_filename"`
+	// ChunkStats 是块明文大小的分布。旧清单没有记录明文大小，此时由加密块大小推算。
+	ChunkStats ChunkStats `json:"chunk_stats"`
+}
+
+// InspectManifest 返回 manifestID 对应清单的元数据。
+// 它用 password 验证清单签名，因此返回的信息（包括创建时间和版本）未被篡改，但不会解密文件名或任何数据。
+func (s *Syncer) InspectManifest(manifestID, password string) (ManifestInfo, error) {
+	manifest, key, err := s.openManifest(manifestID, password)
+	if err != nil {
+		return ManifestInfo{}, err
+	}
+	key.Destroy()
+	return newManifestInfo(manifestID, manifest)
+}
+
+// newManifestInfo 从已验证签名的清单中提取 ManifestInfo。
+func newManifestInfo(manifestID string, manifest *Manifest) (ManifestInfo, error) {
+	sizes, err := plaintextChunkSizes(manifest)
+	if err != nil {
+		return ManifestInfo{}, err
+	}
+	recipients := len(manifest.Recipients)
+	if manifest.Version < manifestVersionRecipients {
+		recipients = 1
+	}
+	return ManifestInfo{
+		ManifestID:     manifestID,
+		Version:        manifest.Version,
+		CreatedAt:      manifest.CreatedAt,
+		CreatorVersion: manifest.CreatorVersion,
+		ChunkCount:     len(manifest.ChunkPaths),
+		Size:           newIndexEntry(manifestID, manifest, manifest.CreatedAt).Size,
+		DataShards:     manifest.DataShards,
+		ParityShards:   manifest.ParityShards,
+		Recipients:     recipients,
+		KeyWrapCipher:  manifest.KeyWrapCipher,
+		ChunkCipher:    manifest.ChunkCipher,
+		HasFilename:    len(manifest.EncryptedOrigFilename) > 0,
+		ChunkStats:     newChunkStats(sizes),
+	}, nil
+}
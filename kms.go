@@ -0,0 +1,96 @@
+package secstorage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/awnumar/memguard"
+)
+
+// KeyWrapper 用外部密钥管理服务（KMS、HSM 等）包装和解开文件密钥。
+// 设置 EncryptionOptions.KeyWrapper 后，文件密钥会额外由它包装并保存在清单中；
+// 解密时把 Syncer.KeyWrapper 设为能够解开它的实现并传入空密码，即可绕过密码直接解密。
+// 实现可以被多个 goroutine 并发调用。
+type KeyWrapper interface {
+	// Wrap 包装 plaintextKey 并返回可以公开保存的密文，不能保留 plaintextKey。
+	Wrap(plaintextKey []byte) ([]byte, error)
+	// Unwrap 解开 Wrap 的输出，返回原来的密钥。
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+// kmsWrapAAD 是 LocalKeyWrapper 包装密钥时使用的关联数据，使它的密文不能被当作其他用途的密文。
+var kmsWrapAAD = []byte("secstorage key wrapper")
+
+// LocalKeyWrapper 是用本地主密钥以 AES-256-GCM 包装文件密钥的 KeyWrapper，
+// 适用于测试，或主密钥由其他机制（如挂载的密钥文件）保护的部署。生产环境中通常应实现对接真实 KMS 的 KeyWrapper。
+type LocalKeyWrapper struct {
+	key *memguard.LockedBuffer
+}
+
+// NewLocalKeyWrapper 用 32 字节的 masterKey 创建 LocalKeyWrapper。masterKey 会被复制到锁定内存中并从原处清零。
+func NewLocalKeyWrapper(masterKey []byte) (*LocalKeyWrapper, error) {
+	if len(masterKey) != keyLength {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", keyLength, len(masterKey))
+	}
+	return &LocalKeyWrapper{key: memguard.NewBufferFromBytes(masterKey)}, nil
+}
+
+// Wrap 实现了 KeyWrapper 接口。
+func (w *LocalKeyWrapper) Wrap(plaintextKey []byte) ([]byte, error) {
+	return encrypt(plaintextKey, w.key, kmsWrapAAD)
+}
+
+// Unwrap 实现了 KeyWrapper 接口。
+func (w *LocalKeyWrapper) Unwrap(wrapped []byte) ([]byte, error) {
+	return decrypt(wrapped, w.key, kmsWrapAAD)
+}
+
+// Destroy 销毁主密钥，之后 w 不能再使用。
+func (w *LocalKeyWrapper) Destroy() {
+	w.key.Destroy()
+}
+
+// validateKeyWrapper 拒绝与确定性模式和 EncryptToShards 不兼容的 KeyWrapper 配置。
+func (opts EncryptionOptions) validateKeyWrapper() error {
+	if opts.KeyWrapper != nil && opts.Deterministic {
+		return errors.New("KeyWrapper cannot be combined with deterministic encryption")
+	}
+	return nil
+}
+
+// kmsWrapFileKey 在配置了 opts.KeyWrapper 时用它包装文件密钥，否则返回 nil。
+func kmsWrapFileKey(opts EncryptionOptions, key *memguard.LockedBuffer) ([]byte, error) {
+	if opts.KeyWrapper == nil {
+		return nil, nil
+	}
+	wrapped, err := opts.KeyWrapper.Wrap(key.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap file key with KeyWrapper: %w", err)
+	}
+	return wrapped, nil
+}
+
+// kmsUnlock 在 password 为空、配置了 kw 且 wrapped 不为空时通过 kw 解开文件密钥，
+// 返回的布尔值报告是否走了这条路径；为 false 时调用方应改用密码解锁。
+func kmsUnlock(kw KeyWrapper, wrapped []byte, password string) (*memguard.LockedBuffer, bool, error) {
+	if password != "" || kw == nil || len(wrapped) == 0 {
+		return nil, false, nil
+	}
+	key, err := kw.Unwrap(wrapped)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to unwrap file key with KeyWrapper: %w", err)
+	}
+	if len(key) != keyLength {
+		memguard.WipeBytes(key)
+		return nil, true, fmt.Errorf("KeyWrapper returned a %d-byte file key, want %d", len(key), keyLength)
+	}
+	return memguard.NewBufferFromBytes(key), true, nil
+}
+
+// unlockFileKey 获取清单的文件密钥：password 为空且清单由 Syncer.KeyWrapper 包装过时通过它解开，否则使用密码。
+func (s *Syncer) unlockFileKey(kd KeyDeriver, manifest *Manifest, password string) (*memguard.LockedBuffer, error) {
+	if key, ok, err := kmsUnlock(s.KeyWrapper, manifest.KMSWrappedKey, password); ok {
+		return key, err
+	}
+	return unlockManifest(kd, manifest, password)
+}
@@ -0,0 +1,113 @@
+package secstorage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestKeyWrapper 创建一个使用随机主密钥的 LocalKeyWrapper。
+func newTestKeyWrapper(t testing.TB) *LocalKeyWrapper {
+	t.Helper()
+	masterKey := make([]byte, keyLength)
+	rand.Read(masterKey)
+	w, err := NewLocalKeyWrapper(masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.Destroy)
+	return w
+}
+
+func TestKeyWrapperPasswordless(t *testing.T) {
+	s := newTestSyncer(t)
+	wrapper := newTestKeyWrapper(t)
+	opts := testOptions()
+	opts.Password = ""
+	opts.KeyWrapper = wrapper
+	manifestID, data := encryptTestFile(t, s, opts, 3000)
+
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Recipients) != 0 || len(manifest.KMSWrappedKey) == 0 {
+		t.Fatalf("got %d recipients and a %d-byte wrapped key", len(manifest.Recipients), len(manifest.KMSWrappedKey))
+	}
+
+	// Without a KeyWrapper on the Syncer there is no way in
+	if err := s.DecryptFile(manifestID, t.TempDir(), ""); err == nil {
+		t.Fatal("decrypted without a KeyWrapper")
+	}
+	s.KeyWrapper = wrapper
+	assertDecrypts(t, s, manifestID, "", data)
+
+	// A different master key cannot unwrap the file key
+	s.KeyWrapper = newTestKeyWrapper(t)
+	if err := s.DecryptFile(manifestID, t.TempDir(), ""); err == nil {
+		t.Fatal("decrypted with the wrong master key")
+	}
+}
+
+func TestKeyWrapperWithPassword(t *testing.T) {
+	s := newTestSyncer(t)
+	wrapper := newTestKeyWrapper(t)
+	opts := testOptions()
+	opts.KeyWrapper = wrapper
+	manifestID, data := encryptTestFile(t, s, opts, 3000)
+
+	// Either the password or the KeyWrapper unlocks the file
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	s.KeyWrapper = wrapper
+	assertDecrypts(t, s, manifestID, "", data)
+	if ok, err := s.CheckPassword(manifestID, "wrong"); ok || err != nil {
+		t.Fatalf("CheckPassword(wrong) = %v, %v", ok, err)
+	}
+}
+
+func TestKeyWrapperResume(t *testing.T) {
+	s := newTestSyncer(t)
+	wrapper := newTestKeyWrapper(t)
+	path, data := writeTestFile(t, t.TempDir(), "input.bin", 8000)
+	opts := testOptions()
+	opts.Password = ""
+	opts.KeyWrapper = wrapper
+
+	// Interrupt the upload at the third chunk
+	backend := &failingPutBackend{Backend: NewLocalBackend(t.TempDir()), failKey: "/chunk_2_"}
+	s.Backend = backend
+	manifestID, err := s.EncryptFile(path, opts)
+	if err == nil || manifestID == "" {
+		t.Fatalf("expected an interrupted upload, got %q, %v", manifestID, err)
+	}
+	backend.failKey = ""
+	opts.ResumeManifestID = manifestID
+	if _, err := s.EncryptFile(path, opts); err != nil {
+		t.Fatal(err)
+	}
+	s.KeyWrapper = wrapper
+	outputDir := t.TempDir()
+	if err := s.DecryptFile(manifestID, outputDir, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(outputDir, "input.bin")); !bytes.Equal(got, data) {
+		t.Fatal("resumed file differs")
+	}
+}
+
+func TestKeyWrapperOptions(t *testing.T) {
+	if _, err := NewLocalKeyWrapper(make([]byte, 16)); err == nil {
+		t.Fatal("accepted a short master key")
+	}
+	opts := testOptions()
+	opts.KeyWrapper = newTestKeyWrapper(t)
+	if _, _, err := EncryptToShards(bytes.NewReader([]byte("x")), "x", opts); err == nil {
+		t.Fatal("EncryptToShards accepted a KeyWrapper")
+	}
+	opts.Deterministic, opts.ManifestID, opts.DeterministicSalt = true, "00112233445566778899aabbccddeeff", make([]byte, saltLength)
+	if err := opts.validate(); err == nil {
+		t.Fatal("accepted a KeyWrapper in deterministic mode")
+	}
+}
@@ -0,0 +1,186 @@
+package secstorage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/awnumar/memguard"
+)
+
+// encryptSymlink 把符号链接 localPath 保存为一个链接清单，而不是跟随它加密所指向的内容。
+// 链接目标与文件名一样经过填充后用文件密钥加密，清单没有任何块。失败时不会留下清单目录。
+func (s *Syncer) encryptSymlink(localPath string, opts EncryptionOptions) (string, error) {
+	if err := opts.validate(); err != nil {
+		return "", fmt.Errorf("invalid encryption options: %w", err)
+	}
+	if opts.ManifestID != "" || opts.ResumeManifestID != "" {
+		return "", errors.New("ManifestID and ResumeManifestID are not supported for symbolic links")
+	}
+	target, err := os.Readlink(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read symbolic link: %w", err)
+	}
+	metadata, err := marshalMetadata(opts.Metadata)
+	if err != nil {
+		return "", err
+	}
+	defer memguard.WipeBytes(metadata)
+
+	// 1. Claim a manifest directory and generate the file key
+	manifestID, err := s.createManifestDir()
+	if err != nil {
+		return "", err
+	}
+	manifest, key, err := s.newLinkManifest(manifestID, localPath, target, metadata, opts)
+	if err != nil {
+		os.RemoveAll(s.manifestDir(manifestID))
+		return "", err
+	}
+	defer key.Destroy()
+
+	// 2. Sign and save the manifest, then record it in the index
+	if err := s.saveManifest(manifestID, manifest, key); err != nil {
+		os.RemoveAll(s.manifestDir(manifestID))
+		return "", err
+	}
+	if s.Index != nil {
+		if err := s.Index.Put(newIndexEntry(manifestID, manifest, manifest.CreatedAt)); err != nil {
+			return manifestID, fmt.Errorf("failed to update manifest index: %w", err)
+		}
+	}
+	return manifestID, nil
+}
+
+// newLinkManifest 为指向 target 的符号链接 localPath 生成文件密钥，并返回尚未签名的链接清单。
+// 返回的密钥必须由调用方销毁。
+func (s *Syncer) newLinkManifest(manifestID, localPath, target string, metadata []byte, opts EncryptionOptions) (*Manifest, *memguard.LockedBuffer, error) {
+	key, recipients, err := newFileKey(s.keyDeriver(), manifestID, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	kmsWrappedKey, err := kmsWrapFileKey(opts, key)
+	if err != nil {
+		key.Destroy()
+		return nil, nil, err
+	}
+	dataShards := opts.DataShards
+	if opts.ParityShards == 0 {
+		dataShards = 1
+	}
+	manifest := &Manifest{
+		Version:           currentManifestVersion,
+		Recipients:        recipients,
+		KMSWrappedKey:     kmsWrappedKey,
+		KeyWrapCipher:     opts.KeyWrapCipher,
+		ChunkCipher:       s.chunkCipher(),
+		DataShards:        dataShards,
+		ParityShards:      opts.ParityShards,
+		ShardSuffixes:     standardShardSuffixes(dataShards, opts.ParityShards),
+		ChunkerPolynomial: uint64(defaultChunkerPolynomial),
+		CreatedAt:         time.Now().UTC(),
+		CreatorVersion:    creatorVersion(),
+		AADHash:           aadHash(key, opts.AAD),
+	}
+
+	origFilename := filepath.Base(localPath)
+	if !opts.OmitFilename {
+		manifest.EncryptedOrigFilename, err = encryptFilename(opts.KeyWrapCipher, origFilename, key, opts.AAD)
+		if err != nil {
+			key.Destroy()
+			return nil, nil, fmt.Errorf("failed to encrypt original filename for file '%s': %w", localPath, err)
+		}
+		if len(s.SearchKey) > 0 {
+			manifest.NameTag = nameTag(s.SearchKey, origFilename)
+		}
+	}
+	manifest.EncryptedLinkTarget, err = encryptFilename(opts.KeyWrapCipher, target, key, opts.AAD)
+	if err != nil {
+		key.Destroy()
+		return nil, nil, fmt.Errorf("failed to encrypt link target: %w", err)
+	}
+	if metadata != nil {
+		manifest.EncryptedMetadata, err = encryptWith(opts.KeyWrapCipher, metadata, key, nil)
+		if err != nil {
+			key.Destroy()
+			return nil, nil, fmt.Errorf("failed to encrypt metadata: %w", err)
+		}
+	}
+	return manifest, key, nil
+}
+
+// restoreSymlink 解密链接清单中的目标，并在 path 处创建符号链接，替换已存在的文件。
+// 目标按加密时的原样还原，可以是绝对路径或指向输出目录之外。
+func restoreSymlink(manifest *Manifest, key *memguard.LockedBuffer, path string) error {
+	plaintext, err := manifest.open(manifest.KeyWrapCipher, manifest.EncryptedLinkTarget, key, manifest.aad)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt link target: %w", err)
+	}
+	target, err := unpadFilename(plaintext)
+	if err != nil {
+		return fmt.Errorf("failed to decode link target: %w", err)
+	}
+
+	// Create the link under a temporary name and rename it into place, like a decrypted file
+	tempPath, err := tempLinkPath(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(target, tempPath); err != nil {
+		return fmt.Errorf("failed to create symbolic link: %w", err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to move symbolic link into place: %w", err)
+	}
+	return nil
+}
+
+// restoreHardlink 在 path 处创建指向 existing 的硬链接，替换已存在的文件。
+func restoreHardlink(existing, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), defaultDirPerm); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	tempPath, err := tempLinkPath(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Link(existing, tempPath); err != nil {
+		return fmt.Errorf("failed to create hard link: %w", err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to move hard link into place: %w", err)
+	}
+	return nil
+}
+
+// tempLinkPath 返回 path 所在目录中一个未被占用的临时文件名。
+func tempLinkPath(path string) (string, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp link: %w", err)
+	}
+	file.Close()
+	return file.Name(), os.Remove(file.Name())
+}
+
+// sameFile 在 files 中查找与 info 是同一个文件（例如互为硬链接）的记录。
+func sameFile(files []encryptedFile, info fs.FileInfo) (encryptedFile, bool) {
+	for _, file := range files {
+		if os.SameFile(file.info, info) {
+			return file, true
+		}
+	}
+	return encryptedFile{}, false
+}
+
+// encryptedFile 记录目录加密中已加密的普通文件，用于识别指向它的硬链接。
+type encryptedFile struct {
+	info       fs.FileInfo
+	relPath    string
+	manifestID string
+}
@@ -0,0 +1,56 @@
+package secstorage
+
+import (
+	"context"
+	"fmt"
+)
+
+// shardLocation 返回第 i 个块的第 j 个分片在 Backend 中的 key：清单记录了 ShardLocations 时取记录的位置，
+// 否则按约定由 manifestID、块名和分片后缀组成。
+func (m *Manifest) shardLocation(manifestID string, i, j int) string {
+	if len(m.ShardLocations) > 0 {
+		return m.ShardLocations[i][j]
+	}
+	return shardKey(manifestID, m.ChunkPaths[i]+m.chunkSuffixes(i)[j])
+}
+
+// readShard 读取第 i 个块的第 j 个分片。清单记录了 ShardLocations 时从 Backend 读取记录的位置，
+// 否则读取约定位置上的分片（被拆分时依次读取各个部分）。
+func (s *Syncer) readShard(ctx context.Context, manifestID string, manifest *Manifest, i, j int) ([]byte, error) {
+	if err := s.checkChunkSize(manifest, i); err != nil {
+		return nil, err
+	}
+	transform, err := s.shardTransform(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.ShardLocations) > 0 {
+		return s.getTransformed(ctx, manifest.ShardLocations[i][j], transform)
+	}
+	return s.getShard(ctx, manifestID, manifest.ChunkPaths[i]+manifest.chunkSuffixes(i)[j], manifest.shardSize(i), manifest.MaxShardBytes, transform)
+}
+
+// validateShardLocations 检查 ShardLocations 与块和分片一一对应且没有空位置。
+// 外部位置上的分片不会被拆分，因此不能与 MaxShardBytes 同时使用。
+func validateShardLocations(m *Manifest, shards int) error {
+	if len(m.ShardLocations) == 0 {
+		return nil
+	}
+	if len(m.ShardLocations) != len(m.ChunkPaths) {
+		return fmt.Errorf("%w: shard locations for %d chunks, expected %d", ErrManifestTampered, len(m.ShardLocations), len(m.ChunkPaths))
+	}
+	if m.MaxShardBytes != 0 {
+		return fmt.Errorf("shard locations cannot be combined with split shards")
+	}
+	for i, locations := range m.ShardLocations {
+		if len(locations) != shards {
+			return fmt.Errorf("%w: chunk %d has %d shard locations, expected %d", ErrManifestTampered, i, len(locations), shards)
+		}
+		for j, location := range locations {
+			if location == "" {
+				return fmt.Errorf("chunk %d has an empty location for shard %d", i, j)
+			}
+		}
+	}
+	return nil
+}
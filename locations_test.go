@@ -0,0 +1,102 @@
+package secstorage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// mapBackend 是只读的内存 Backend，模拟以 URL 为 key 的外部存储。
+type mapBackend struct {
+	Backend
+	objects map[string][]byte
+}
+
+func (b *mapBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	data, ok := b.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	}
+	return data, nil
+}
+
+func TestShardLocations(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 5000)
+
+	// Move every shard to a "CDN" and record where it went
+	manifest, key, err := s.OpenManifest(manifestID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+	cdn := &mapBackend{Backend: NewLocalBackend(s.StorageDir), objects: make(map[string][]byte)}
+	for i := range manifest.ChunkPaths {
+		var locations []string
+		for j := range manifest.chunkSuffixes(i) {
+			path := shardPath(s, manifestID, i, j)
+			shard, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			location := fmt.Sprintf("https://cdn.example.com/objects/%d/%d", i, j)
+			cdn.objects[location] = shard
+			locations = append(locations, location)
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+		}
+		manifest.ShardLocations = append(manifest.ShardLocations, locations)
+	}
+	if err := s.WriteManifest(manifestID, manifest, key); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Backend = cdn
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	// A missing external shard is rebuilt from parity and reported by its location
+	delete(cdn.objects, manifest.ShardLocations[0][1])
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	health, err := s.ChunkHealth(manifestID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shard := health.Shards[1]; shard.Exists || shard.Key != manifest.ShardLocations[0][1] {
+		t.Fatalf("missing shard reported as %+v", shard)
+	}
+
+	if err := s.ExportArchive(manifestID, &bytes.Buffer{}); err == nil {
+		t.Fatal("exported a manifest with external shard locations")
+	}
+}
+
+func TestShardLocationsValidation(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, s, testOptions(), 3000)
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := len(manifest.chunkSuffixes(0))
+	for name, locations := range map[string][][]string{
+		"too few chunks": {make([]string, shards)},
+		"empty location": func() [][]string {
+			l := make([][]string, len(manifest.ChunkPaths))
+			for i := range l {
+				l[i] = strings.Split(strings.Repeat("x,", shards-1)+"x", ",")
+			}
+			l[0][0] = ""
+			return l
+		}(),
+	} {
+		m := *manifest
+		m.ShardLocations = locations
+		if err := validateManifest(&m); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
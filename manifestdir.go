@@ -0,0 +1,133 @@
+package secstorage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// maxManifestDirDepth 是 Syncer.ManifestDirDepth 允许的最大层数。
+	maxManifestDirDepth = 3
+	// manifestPrefixLen 是每一层前缀目录取自 manifestID 的字符数。
+	manifestPrefixLen = 2
+)
+
+// manifestPrefixDirs 返回 manifestID 在 depth 层分层布局下的前缀目录名，例如 depth 为 2 时 "abcdef…" 得到 ["ab", "cd"]。
+func manifestPrefixDirs(manifestID string, depth int) []string {
+	var dirs []string
+	for i := 0; i < depth && (i+1)*manifestPrefixLen <= len(manifestID); i++ {
+		dirs = append(dirs, manifestID[i*manifestPrefixLen:(i+1)*manifestPrefixLen])
+	}
+	return dirs
+}
+
+// manifestDir 返回 manifestID 的清单目录，按 ManifestDirDepth 分层。
+func (s *Syncer) manifestDir(manifestID string) string {
+	elems := append([]string{s.StorageDir}, manifestPrefixDirs(manifestID, s.ManifestDirDepth)...)
+	return filepath.Join(append(elems, manifestID)...)
+}
+
+// validateManifestDirDepth 检查 ManifestDirDepth 的取值。
+func (s *Syncer) validateManifestDirDepth() error {
+	if s.ManifestDirDepth < 0 || s.ManifestDirDepth > maxManifestDirDepth {
+		return fmt.Errorf("manifest directory depth must be between 0 and %d, got %d", maxManifestDirDepth, s.ManifestDirDepth)
+	}
+	return nil
+}
+
+// migrateManifestDir 在启用分层布局时，把仍位于 StorageDir 下扁平布局中的 manifestID 目录移动到分层后的位置。
+// 目录已经在新位置或根本不存在时什么也不做。manifestID 必须已经通过 validateManifestID 的检查。
+func (s *Syncer) migrateManifestDir(manifestID string) error {
+	if err := s.validateManifestDirDepth(); err != nil {
+		return err
+	}
+	if s.ManifestDirDepth == 0 {
+		return nil
+	}
+	flat := filepath.Join(s.StorageDir, manifestID)
+	info, err := os.Lstat(flat)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && !info.IsDir()) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat manifest directory %s: %w", manifestID, err)
+	}
+
+	dir := s.manifestDir(manifestID)
+	if err := os.MkdirAll(filepath.Dir(dir), defaultDirPerm); err != nil {
+		return storageWriteErr(fmt.Errorf("failed to create manifest prefix directory: %w", err))
+	}
+	if err := os.Rename(flat, dir); err != nil {
+		// Another migration of the same manifest may have won the race
+		if _, statErr := os.Lstat(flat); errors.Is(statErr, os.ErrNotExist) {
+			return nil
+		}
+		return storageWriteErr(fmt.Errorf("failed to migrate manifest directory %s: %w", manifestID, err))
+	}
+	if s.Durable {
+		return s.syncManifestParents(manifestID)
+	}
+	return nil
+}
+
+// syncManifestParents 对 manifestID 清单目录的各级父目录（直到 StorageDir）执行 fsync，
+// 使新建或移动的清单目录及前缀目录在断电后依然存在。
+func (s *Syncer) syncManifestParents(manifestID string) error {
+	dir := filepath.Dir(s.manifestDir(manifestID))
+	for range s.ManifestDirDepth {
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+	return syncDir(s.StorageDir)
+}
+
+// storedManifestIDs 遍历存储目录，返回每个包含 manifest.json 的清单目录的 manifestID，按 ID 排序。
+// 它同时列出分层布局和尚未迁移的扁平布局中的清单，不解析清单，因此损坏的清单同样会被列出。
+func (s *Syncer) storedManifestIDs() ([]string, error) {
+	if err := s.validateManifestDirDepth(); err != nil {
+		return nil, err
+	}
+	var ids []string
+	if err := s.collectManifestIDs(s.StorageDir, 0, &ids); err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// collectManifestIDs 把 dir（位于第 level 层）中的清单目录追加到 ids，并递归进入下一层前缀目录。
+func (s *Syncer) collectManifestIDs(dir string, level int, ids *[]string) error {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read storage directory: %w", err)
+	}
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		name := dirEntry.Name()
+		if level < s.ManifestDirDepth && len(name) == manifestPrefixLen {
+			if err := s.collectManifestIDs(filepath.Join(dir, name), level+1, ids); err != nil {
+				return err
+			}
+			continue
+		}
+		// Manifests live at the configured depth; flat ones are left over from before sharding
+		if (level != s.ManifestDirDepth && level != 0) || s.validateManifestID(name) != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, name, manifestFileName)); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return fmt.Errorf("failed to stat manifest %s: %w", name, err)
+		}
+		*ids = append(*ids, name)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package secstorage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestManifestPrefixDirs(t *testing.T) {
+	for _, tc := range []struct {
+		depth int
+		want  []string
+	}{
+		{0, nil},
+		{1, []string{"ab"}},
+		{3, []string{"ab", "cd", "ef"}},
+	} {
+		if got := manifestPrefixDirs("abcdef0123", tc.depth); !slices.Equal(got, tc.want) {
+			t.Errorf("depth %d: got %v, want %v", tc.depth, got, tc.want)
+		}
+	}
+}
+
+func TestManifestDirDepth(t *testing.T) {
+	s := newTestSyncer(t)
+	s.ManifestDirDepth = 2
+	manifestID, data := encryptTestFile(t, s, testOptions(), 3000)
+
+	dir := filepath.Join(s.StorageDir, manifestID[:2], manifestID[2:4], manifestID)
+	if _, err := os.Stat(filepath.Join(dir, "manifest.json")); err != nil {
+		t.Fatalf("manifest is not in the sharded directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "chunk_0_shard_0.dat")); err != nil {
+		t.Fatalf("shards are not next to the manifest: %v", err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	if ids, err := s.ListManifests(); err != nil || !slices.Equal(ids, []string{manifestID}) {
+		t.Fatalf("ListManifests = %v, %v", ids, err)
+	}
+
+	if err := s.DeleteManifest(manifestID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("manifest directory still exists: %v", err)
+	}
+
+	s.ManifestDirDepth = maxManifestDirDepth + 1
+	if _, err := s.EncryptFile(writeTestFileOnly(t), testOptions()); err == nil {
+		t.Fatal("expected an error for an unsupported depth")
+	}
+}
+
+func TestManifestDirMigratesFlatLayout(t *testing.T) {
+	s := newTestSyncer(t)
+	first, firstData := encryptTestFile(t, s, testOptions(), 3000)
+	second, _ := encryptTestFile(t, s, testOptions(), 2000)
+
+	// Enabling sharding leaves the existing directories where they are until they are used
+	s.ManifestDirDepth = 1
+	want := []string{first, second}
+	slices.Sort(want)
+	if ids, err := s.ListManifests(); err != nil || !slices.Equal(ids, want) {
+		t.Fatalf("ListManifests before migration = %v, %v", ids, err)
+	}
+
+	assertDecrypts(t, s, first, testPassword, firstData)
+	if _, err := os.Stat(filepath.Join(s.StorageDir, first)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("flat directory was not migrated: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.StorageDir, first[:2], first, "manifest.json")); err != nil {
+		t.Fatalf("migrated manifest not found: %v", err)
+	}
+	if ids, err := s.ListManifests(); err != nil || !slices.Equal(ids, want) {
+		t.Fatalf("ListManifests after migration = %v, %v", ids, err)
+	}
+
+	// A custom ID held by a flat directory is still taken
+	opts := testOptions()
+	opts.ManifestID = second
+	if _, err := s.EncryptFile(writeTestFileOnly(t), opts); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("got %v, want os.ErrExist", err)
+	}
+	if err := s.DeleteManifest(second); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := s.ListManifests(); err != nil || !slices.Equal(ids, []string{first}) {
+		t.Fatalf("ListManifests after delete = %v, %v", ids, err)
+	}
+}
+
+// writeTestFileOnly 写入一个小的随机测试文件并只返回其路径。
+func writeTestFileOnly(t *testing.T) string {
+	t.Helper()
+	path, _ := writeTestFile(t, t.TempDir(), "input.bin", 100)
+	return path
+}
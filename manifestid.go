@@ -0,0 +1,155 @@
+package secstorage
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+)
+
+// ManifestIDEncoding 定义了 manifestID 的文本编码方式。
+type ManifestIDEncoding string
+
+const (
+	// ManifestIDHex 使用小写十六进制编码，这是默认编码。
+	ManifestIDHex ManifestIDEncoding = "hex"
+	// ManifestIDBase32 使用无填充的小写 base32 编码，比十六进制更短。它只含小写字母和数字，
+	// 因此可以安全地存放在不区分大小写的文件系统上；但与十六进制一样，只接受小写形式的 ID。
+	ManifestIDBase32 ManifestIDEncoding = "base32"
+	// ManifestIDBase58 使用 Bitcoin 字母表的 base58 编码，最短但区分大小写，
+	// 不适合存放在不区分大小写的文件系统上。
+	ManifestIDBase58 ManifestIDEncoding = "base58"
+)
+
+const (
+	// defaultManifestIDBytes 是 manifestID 默认的随机字节数（16字节 = 32个十六进制字符）。
+	defaultManifestIDBytes = 16
+	// minManifestIDBytes 是为保证抗碰撞性所允许的最小随机字节数。
+	minManifestIDBytes = 16
+	// base58Alphabet 是 Bitcoin 使用的 base58 字母表。
+	base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+)
+
+// manifestIDRand 是生成 manifestID 的随机数来源，测试中可替换为固定输入以模拟 ID 冲突。
+var manifestIDRand io.Reader = rand.Reader
+
+// base32Encoding 是 manifestID 使用的小写、无填充 base32 编码。
+var base32Encoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// manifestIDFormat 返回 Syncer 配置的 manifestID 字节数和编码，未配置时使用默认值。
+func (s *Syncer) manifestIDFormat() (int, ManifestIDEncoding) {
+	size := s.ManifestIDBytes
+	if size == 0 {
+		size = defaultManifestIDBytes
+	}
+	encoding := s.ManifestIDEncoding
+	if encoding == "" {
+		encoding = ManifestIDHex
+	}
+	return size, encoding
+}
+
+// generateManifestID 为清单 (manifest) 生成一个唯一的随机 ID。
+// 这个 ID 用于唯一标识一次加密操作产生的所有文件和元数据，其长度和编码由 Syncer 的配置决定。
+func (s *Syncer) generateManifestID() (string, error) {
+	size, encoding := s.manifestIDFormat()
+	if size < minManifestIDBytes {
+		return "", fmt.Errorf("manifest ID length must be at least %d bytes, got %d", minManifestIDBytes, size)
+	}
+
+	bytes := make([]byte, size)
+	if _, err := io.ReadFull(manifestIDRand, bytes); err != nil {
+		return "", err
+	}
+	return encodeManifestID(bytes, encoding)
+}
+
+// validateManifestID 检查 manifestID 是否符合 Syncer 配置的编码和长度。
+// 这同时保证了 manifestID 不会包含路径分隔符等字符，可以安全地拼接到存储路径中。
+func (s *Syncer) validateManifestID(manifestID string) error {
+	size, encoding := s.manifestIDFormat()
+
+	var decoded []byte
+	var err error
+	switch encoding {
+	case ManifestIDHex:
+		if strings.ToLower(manifestID) != manifestID {
+			return fmt.Errorf("invalid manifest ID %q: must be lowercase hex", manifestID)
+		}
+		decoded, err = hex.DecodeString(manifestID)
+	case ManifestIDBase32:
+		decoded, err = base32Encoding.DecodeString(manifestID)
+	case ManifestIDBase58:
+		decoded, err = decodeBase58(manifestID)
+	default:
+		return fmt.Errorf("unsupported manifest ID encoding %q", encoding)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid manifest ID %q: %w", manifestID, err)
+	}
+	if len(decoded) != size {
+		return fmt.Errorf("invalid manifest ID %q: expected %d bytes, got %d", manifestID, size, len(decoded))
+	}
+	return nil
+}
+
+// encodeManifestID 使用指定的编码将随机字节编码为 manifestID。
+func encodeManifestID(bytes []byte, encoding ManifestIDEncoding) (string, error) {
+	switch encoding {
+	case ManifestIDHex:
+		return hex.EncodeToString(bytes), nil
+	case ManifestIDBase32:
+		return base32Encoding.EncodeToString(bytes), nil
+	case ManifestIDBase58:
+		return encodeBase58(bytes), nil
+	default:
+		return "", fmt.Errorf("unsupported manifest ID encoding %q", encoding)
+	}
+}
+
+// encodeBase58 将字节编码为 base58 字符串，前导零字节编码为字符 '1'。
+func encodeBase58(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	base := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, base, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+// decodeBase58 是 encodeBase58 的逆操作。
+func decodeBase58(s string) ([]byte, error) {
+	n := new(big.Int)
+	base := big.NewInt(58)
+	for _, c := range []byte(s) {
+		digit := strings.IndexByte(base58Alphabet, c)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		n.Mul(n, base)
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+
+	var leadingZeros int
+	for leadingZeros < len(s) && s[leadingZeros] == base58Alphabet[0] {
+		leadingZeros++
+	}
+	return append(make([]byte, leadingZeros), n.Bytes()...), nil
+}
@@ -0,0 +1,79 @@
+package secstorage
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// setManifestIDRand 在测试期间用 data 替换 manifestID 的随机数来源。
+func setManifestIDRand(t *testing.T, data []byte) {
+	t.Helper()
+	old := manifestIDRand
+	manifestIDRand = bytes.NewReader(data)
+	t.Cleanup(func() { manifestIDRand = old })
+}
+
+func TestCreateManifestDirRetriesOnCollision(t *testing.T) {
+	s := newTestSyncer(t)
+	first := bytes.Repeat([]byte{1}, defaultManifestIDBytes)
+	second := bytes.Repeat([]byte{2}, defaultManifestIDBytes)
+	setManifestIDRand(t, bytes.Join([][]byte{first, first, second}, nil))
+
+	id1, err := s.createManifestDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := s.createManifestDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id1 == id2 || id2 != strings.Repeat("02", defaultManifestIDBytes) {
+		t.Fatalf("collision was not retried: %s, %s", id1, id2)
+	}
+}
+
+func TestCreateManifestDirGivesUpAfterRepeatedCollisions(t *testing.T) {
+	s := newTestSyncer(t)
+	id := bytes.Repeat([]byte{7}, defaultManifestIDBytes)
+	setManifestIDRand(t, bytes.Repeat(id, maxManifestIDAttempts+1))
+
+	existing, err := s.createManifestDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.createManifestDir(); err == nil {
+		t.Fatal("createManifestDir reused an existing directory")
+	}
+	entries, err := os.ReadDir(s.StorageDir)
+	if err != nil || len(entries) != 1 || entries[0].Name() != existing {
+		t.Fatalf("unexpected storage directory contents: %v, %v", entries, err)
+	}
+}
+
+func TestManifestIDEncodings(t *testing.T) {
+	for _, encoding := range []ManifestIDEncoding{ManifestIDHex, ManifestIDBase32, ManifestIDBase58} {
+		s := &Syncer{StorageDir: t.TempDir(), ManifestIDEncoding: encoding, ManifestIDBytes: 20}
+		id, err := s.generateManifestID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.validateManifestID(id); err != nil {
+			t.Errorf("%s: generated ID %q does not validate: %v", encoding, id, err)
+		}
+	}
+}
+
+func TestValidateManifestIDRejectsUppercase(t *testing.T) {
+	for _, encoding := range []ManifestIDEncoding{ManifestIDHex, ManifestIDBase32} {
+		s := &Syncer{StorageDir: t.TempDir(), ManifestIDEncoding: encoding}
+		id, err := s.generateManifestID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.validateManifestID(strings.ToUpper(id)); err == nil {
+			t.Errorf("%s: uppercase ID %q accepted", encoding, strings.ToUpper(id))
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package secstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/awnumar/memguard"
+	"github.com/klauspost/reedsolomon"
+	"github.com/restic/chunker"
+)
+
+// memoryBackend 是把分片保存在内存 map 中的 Backend，供 EncryptToShards 和 DecryptFromShards 使用。
+type memoryBackend struct {
+	mu     sync.Mutex
+	shards map[string][]byte
+}
+
+// Put 实现了 Backend 接口。data 会被复制，调用方之后可以复用它。
+func (b *memoryBackend) Put(ctx context.Context, key string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.shards[key] = bytes.Clone(data)
+	return nil
+}
+
+// Get 实现了 Backend 接口。返回的切片直接引用 map 中的数据，调用方不能修改。
+func (b *memoryBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.shards[key]
+	if !ok {
+		return nil, fmt.Errorf("shard %s: %w", key, os.ErrNotExist)
+	}
+	return data, nil
+}
+
+// Delete 实现了 Backend 接口。
+func (b *memoryBackend) Delete(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.shards, key)
+	return nil
+}
+
+// EncryptToShards 在内存中加密 r 的全部内容，返回签名的清单和分片文件名到内容的映射，不读写任何文件，
+// 便于把 secstorage 嵌入到自行管理存储布局的系统中。origName 作为原始文件名保存在清单中（除非设置了 OmitFilename）。
+//
+// 分片文件名与 Syncer 在清单目录下使用的相同（例如 chunk_0_shard_1.dat），调用方可以任意保存，
+// 只要解密时以同样的文件名交给 DecryptFromShards。由于没有 manifestID，块的关联数据只绑定块序号，
+// 因此这样得到的清单不能导入 Syncer。与存储位置相关的选项 ManifestID、ResumeManifestID 和 RecoveryRecords 以及 KeyWrapper、AAD 不受支持，
+// 确定性模式因需要 ManifestID 同样不可用；其余选项（包括 MaxShardBytes 和 MaxBytesPerSec）与 EncryptFile 相同。
+func EncryptToShards(r io.Reader, origName string, opts EncryptionOptions) (*Manifest, map[string][]byte, error) {
+	if opts.ManifestID != "" || opts.ResumeManifestID != "" || opts.RecoveryRecords || opts.KeyWrapper != nil || len(opts.AAD) > 0 {
+		return nil, nil, errors.New("ManifestID, ResumeManifestID, RecoveryRecords, KeyWrapper and AAD are not supported by EncryptToShards")
+	}
+	if err := opts.validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid encryption options: %w", err)
+	}
+	metadata, err := marshalMetadata(opts.Metadata)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer memguard.WipeBytes(metadata)
+
+	// 1. Generate the file key and wrap it for every password
+	key, recipients, err := newFileKey(argon2Deriver{}, "", opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer key.Destroy()
+
+	// 2. Chunk, encrypt and erasure code into an in-memory backend; an empty manifest ID
+	// makes the backend keys plain shard names.
+	backend := &memoryBackend{shards: make(map[string][]byte)}
+	s := &Syncer{Backend: backend}
+	ctx := context.Background()
+	limiter := newRateLimiter(opts.MaxBytesPerSec)
+
+	dataShards := opts.DataShards
+	var enc reedsolomon.Encoder
+	if opts.ParityShards == 0 {
+		dataShards = 1
+	} else {
+		enc, err = reedsolomon.New(opts.DataShards, opts.ParityShards)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create erasure code encoder: %w", &ErasureError{Op: ErasureNew, Chunk: -1, Err: err})
+		}
+	}
+
+	manifest := &Manifest{
+		Version:           currentManifestVersion,
+		Recipients:        recipients,
+		KeyWrapCipher:     opts.KeyWrapCipher,
+		DataShards:        dataShards,
+		ParityShards:      opts.ParityShards,
+		ShardSuffixes:     standardShardSuffixes(dataShards, opts.ParityShards),
+		MaxShardBytes:     opts.MaxShardBytes,
+		ChunkerPolynomial: uint64(defaultChunkerPolynomial),
+		CreatorVersion:    creatorVersion(),
+		CreatedAt:         time.Now().UTC(),
+	}
+	var pool *BufferPool
+	plainBuf, encodedBuf := chunkBuffers(pool, opts)
+	defer pool.put(plainBuf)
+	cdc := newCDCChunker(r, opts.ChunkSizeKB, chunker.Pol(manifest.ChunkerPolynomial))
+	contentHash, err := newContentHash(opts.ContentHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest.ContentHashAlgorithm = opts.ContentHash
+	for chunkNumber := 0; ; chunkNumber++ {
+		chunk, err := cdc.Next(plainBuf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read chunk: %w", err)
+		}
+		contentHash.Write(chunk.Data)
+
+		encryptedData, encryptedKey, err := sealChunk(encryptAppend, manifest.ChunkCipher, opts, key, chunkAAD("", chunkNumber), encodedBuf, chunk.Data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encrypt chunk %d: %w", chunkNumber, err)
+		}
+		if _, err := s.writeChunkShards(ctx, "", chunkNumber, encryptedData, enc, opts.MaxShardBytes, nil, limiter); err != nil {
+			return nil, nil, err
+		}
+
+		manifest.ChunkPaths = append(manifest.ChunkPaths, fmt.Sprintf("chunk_%d", chunkNumber))
+		manifest.EncryptedDataKeys = append(manifest.EncryptedDataKeys, encryptedKey)
+		manifest.EncryptedChunkSizes = append(manifest.EncryptedChunkSizes, len(encryptedData))
+		manifest.PlaintextChunkSizes = append(manifest.PlaintextChunkSizes, len(chunk.Data))
+	}
+
+	// 3. Encrypt the content hash, original filename and metadata, then sign the manifest
+	manifest.EncryptedContentHash, err = encryptWith(opts.KeyWrapCipher, contentHash.Sum(nil), key, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encrypt content hash: %w", err)
+	}
+	if !opts.OmitFilename {
+		manifest.EncryptedOrigFilename, err = encryptFilename(opts.KeyWrapCipher, origName, key, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encrypt original filename: %w", err)
+		}
+	}
+	if metadata != nil {
+		manifest.EncryptedMetadata, err = encryptWith(opts.KeyWrapCipher, metadata, key, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encrypt metadata: %w", err)
+		}
+	}
+	if err := signManifest(manifest, key); err != nil {
+		return nil, nil, err
+	}
+	return manifest, backend.shards, nil
+}
+
+// DecryptFromShards 用 password 验证 EncryptToShards 返回的清单，并从 shards 中重建、解密文件内容写入 w。
+// 与 DecryptFile 相同，缺失或损坏的分片只要不超过纠删码的容量就会被透明地重建。
+// 结束时检查完整内容的 SHA-256，不一致时返回 ErrContentHash。出错时 w 中可能已经写入了部分甚至全部内容。
+func DecryptFromShards(m *Manifest, shards map[string][]byte, password string, w io.Writer) error {
+	if err := validateManifest(m); err != nil {
+		return err
+	}
+	key, err := unlockManifest(argon2Deriver{}, m, password)
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+	if err := verifyManifestSignature(m, key); err != nil {
+		return err
+	}
+	if err := bindAAD(m, key, nil); err != nil {
+		return err
+	}
+
+	var enc reedsolomon.Encoder
+	if m.ParityShards > 0 {
+		enc, err = reedsolomon.New(m.DataShards, m.ParityShards)
+		if err != nil {
+			return fmt.Errorf("failed to create erasure code decoder: %w", &ErasureError{Op: ErasureNew, Chunk: -1, Err: err})
+		}
+	}
+
+	s := &Syncer{Backend: &memoryBackend{shards: shards}}
+	contentHash, err := newContentHash(m.ContentHashAlgorithm)
+	if err != nil {
+		return err
+	}
+	for i := range m.ChunkPaths {
+		plaintext, _, err := s.readChunk(context.Background(), "", m, enc, key, i, false)
+		if err != nil {
+			return err
+		}
+		contentHash.Write(plaintext)
+		_, err = w.Write(plaintext)
+		memguard.WipeBytes(plaintext)
+		if err != nil {
+			return fmt.Errorf("failed to write decrypted chunk %d: %w", i, err)
+		}
+	}
+	return verifyContentHash(m, key, contentHash.Sum(nil))
+}
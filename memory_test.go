@@ -0,0 +1,95 @@
+package secstorage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+func TestEncryptToShardsRoundTrip(t *testing.T) {
+	data := make([]byte, 5000)
+	rand.Read(data)
+
+	for _, tc := range []struct {
+		name string
+		opts func(*EncryptionOptions)
+	}{
+		{"erasure coded", func(*EncryptionOptions) {}},
+		{"sealed", func(o *EncryptionOptions) { o.DataShards, o.ParityShards = 1, 0 }},
+		{"split shards", func(o *EncryptionOptions) { o.MaxShardBytes = 128 }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := testOptions()
+			tc.opts(&opts)
+			manifest, shards, err := EncryptToShards(bytes.NewReader(data), "input.bin", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := 0
+			for i := range manifest.ChunkPaths {
+				want += len(manifest.storageNames(i))
+			}
+			if len(shards) != want {
+				t.Fatalf("got %d shards, manifest names %d", len(shards), want)
+			}
+			for name := range shards {
+				if strings.Contains(name, "/") {
+					t.Fatalf("shard name %q is not a plain file name", name)
+				}
+			}
+
+			var out bytes.Buffer
+			if err := DecryptFromShards(manifest, shards, testPassword, &out); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), data) {
+				t.Fatal("decrypted content differs")
+			}
+		})
+	}
+}
+
+func TestDecryptFromShardsReconstructs(t *testing.T) {
+	data := make([]byte, 3000)
+	rand.Read(data)
+	manifest, shards, err := EncryptToShards(bytes.NewReader(data), "input.bin", testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Drop one shard and corrupt another of every chunk; two parity shards cover both
+	for i, chunkPath := range manifest.ChunkPaths {
+		delete(shards, chunkPath+manifest.chunkSuffixes(i)[0])
+		shards[chunkPath+manifest.chunkSuffixes(i)[1]][0] ^= 0xff
+	}
+
+	var out bytes.Buffer
+	if err := DecryptFromShards(manifest, shards, testPassword, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("decrypted content differs")
+	}
+}
+
+func TestDecryptFromShardsRejects(t *testing.T) {
+	manifest, shards, err := EncryptToShards(strings.NewReader("hello"), "input.bin", testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DecryptFromShards(manifest, shards, "wrong password", &bytes.Buffer{}); err == nil {
+		t.Fatal("expected an error for a wrong password")
+	}
+	manifest.PlaintextChunkSizes[0]++
+	if err := DecryptFromShards(manifest, shards, testPassword, &bytes.Buffer{}); err == nil {
+		t.Fatal("expected an error for a tampered manifest")
+	}
+}
+
+func TestEncryptToShardsRejectsStorageOptions(t *testing.T) {
+	opts := testOptions()
+	opts.RecoveryRecords = true
+	if _, _, err := EncryptToShards(strings.NewReader("hello"), "input.bin", opts); err == nil {
+		t.Fatal("expected an error for RecoveryRecords")
+	}
+}
@@ -0,0 +1,55 @@
+package secstorage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/awnumar/memguard"
+)
+
+// maxMetadataSize 是自定义元数据编码为 JSON 后允许的最大字节数，以免清单过度膨胀。
+const maxMetadataSize = 64 * 1024
+
+// marshalMetadata 将自定义元数据编码为 JSON 并检查大小；metadata 为空时返回 nil。
+func marshalMetadata(metadata map[string]string) ([]byte, error) {
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	if len(data) > maxMetadataSize {
+		return nil, fmt.Errorf("metadata is %d bytes when encoded, the limit is %d", len(data), maxMetadataSize)
+	}
+	return data, nil
+}
+
+// decryptMetadata 解密清单中的自定义元数据；清单未保存元数据时返回 nil。
+func decryptMetadata(manifest *Manifest, key *memguard.LockedBuffer) (map[string]string, error) {
+	if len(manifest.EncryptedMetadata) == 0 {
+		return nil, nil
+	}
+	data, err := manifest.open(manifest.KeyWrapCipher, manifest.EncryptedMetadata, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt metadata: %w", err)
+	}
+	defer memguard.WipeBytes(data)
+
+	var metadata map[string]string
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// GetMetadata 返回加密时通过 EncryptionOptions.Metadata 附加到文件上的自定义元数据，无需解密文件内容。
+// 文件没有元数据时返回 nil。
+func (s *Syncer) GetMetadata(manifestID, password string) (map[string]string, error) {
+	manifest, key, err := s.openManifest(manifestID, password)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Destroy()
+	return decryptMetadata(manifest, key)
+}
@@ -0,0 +1,62 @@
+package secstorage
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Metrics 定义了 Syncer 在加解密过程中上报运行指标的接口，可以方便地对接 Prometheus 等监控系统。
+// 其中分片重建次数是一个重要的运维信号：它反映了存储冗余被消耗的频率。
+// 实现必须可以被多个 goroutine 并发调用。
+type Metrics interface {
+	// IncChunksEncrypted 在每个块加密并写入存储后调用。
+	IncChunksEncrypted()
+	// IncChunksDecrypted 在每个块解密并写入输出后调用。
+	IncChunksDecrypted()
+	// IncShardsReconstructed 在通过纠删码重建丢失的分片后调用，n 为被重建的分片数。
+	IncShardsReconstructed(n int)
+	// AddBytesWritten 在写入分片文件或解密输出后调用，n 为写入的字节数。
+	AddBytesWritten(n int)
+	// ObserveEncryptDuration 记录一次 EncryptFile 调用的耗时。
+	ObserveEncryptDuration(d time.Duration)
+	// ObserveDecryptDuration 记录一次 DecryptFile 调用的耗时。
+	ObserveDecryptDuration(d time.Duration)
+}
+
+// noopMetrics 是未设置 Metrics 时使用的空实现。
+type noopMetrics struct{}
+
+func (noopMetrics) IncChunksEncrypted()                  {}
+func (noopMetrics) IncChunksDecrypted()                  {}
+func (noopMetrics) IncShardsReconstructed(int)           {}
+func (noopMetrics) AddBytesWritten(int)                  {}
+func (noopMetrics) ObserveEncryptDuration(time.Duration) {}
+func (noopMetrics) ObserveDecryptDuration(time.Duration) {}
+
+// metrics 返回 Syncer 配置的指标接收器；未配置时返回空实现。
+func (s *Syncer) metrics() Metrics {
+	if s.Metrics == nil {
+		return noopMetrics{}
+	}
+	return s.Metrics
+}
+
+// logger 返回 Syncer 配置的日志记录器；未配置时返回丢弃所有日志的记录器。
+func (s *Syncer) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return s.Logger
+}
+
+// reportProgress 以 done 和 total 调用 progress（为 nil 时什么也不做）。
+// total 为 -1 表示大小未知；声明的大小小于实际已读取的字节数时改报 done，使百分比不会超过 100%。
+func reportProgress(progress func(done, total int64), done, total int64) {
+	if progress == nil {
+		return
+	}
+	if total >= 0 {
+		total = max(total, done)
+	}
+	progress(done, total)
+}
@@ -0,0 +1,56 @@
+package secstorage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"runtime/debug"
+)
+
+// errMappedFileChanged 表示映射的文件在读取过程中被截短，访问已不存在的页面时发生了内存错误。
+var errMappedFileChanged = errors.New("memory-mapped source file was truncated while it was being read")
+
+// mappedFile 以 io.Reader 的形式依次读出映射到内存的文件内容。
+type mappedFile struct {
+	data []byte
+	// offset 是 data 中内容开始的位置；映射必须从页边界开始，因此文件的当前位置之前的部分也被映射。
+	offset int
+}
+
+// Read 实现了 io.Reader 接口。
+func (m *mappedFile) Read(p []byte) (n int, err error) {
+	if m.offset >= len(m.data) {
+		return 0, io.EOF
+	}
+	// Pages past the end of a file truncated by another process raise SIGBUS instead of returning an error
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if recover() != nil {
+			n, err = 0, errMappedFileChanged
+		}
+	}()
+	n = copy(p, m.data[m.offset:])
+	m.offset += n
+	return n, nil
+}
+
+// sourceReader 返回读取 file 从当前位置起 size 字节所用的 Reader。opts.MemoryMap 为 true 且 file 是非空的普通文件时，
+// 先尝试把它映射到内存并把 file 的位置移到映射的末尾；映射失败（平台或文件系统不支持等）时回退为直接读取 file。
+// 调用方必须在读取完毕（包括出错）后调用返回的 release 解除映射。
+func (s *Syncer) sourceReader(file *os.File, size int64, opts EncryptionOptions) (io.Reader, func()) {
+	if !opts.MemoryMap || size <= 0 {
+		return file, func() {}
+	}
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err == nil {
+		var data []byte
+		if data, err = mapFile(file, offset+size); err == nil {
+			if _, err = file.Seek(offset+size, io.SeekStart); err == nil {
+				return &mappedFile{data: data, offset: int(offset)}, func() { unmapFile(data) }
+			}
+			unmapFile(data)
+		}
+	}
+	s.logger().Debug("memory mapping failed, reading the file normally", "path", file.Name(), "error", err)
+	return file, func() {}
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package secstorage
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile 在没有 mmap 的平台上总是返回错误，调用方回退为直接读取。
+func mapFile(file *os.File, length int64) ([]byte, error) {
+	return nil, errors.New("memory mapping is not supported on this platform")
+}
+
+// unmapFile 在没有 mmap 的平台上什么也不做。
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,84 @@
+package secstorage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryMap(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.MemoryMap = true
+	path, data := writeTestFile(t, t.TempDir(), "input.bin", 20000)
+
+	plain, err := s.PlanEncryption(path, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped, err := s.PlanEncryption(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapped.Count != plain.Count || mapped.TotalBytes != plain.TotalBytes {
+		t.Fatalf("mapped plan %+v differs from %+v", mapped, plain)
+	}
+
+	manifestID, err := s.EncryptFile(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	// An open file is mapped from its current position, which ends up at the end as with normal reads
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(5000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	manifestID, err = s.EncryptOpenFile(f, "input.bin", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data[5000:])
+	if offset, _ := f.Seek(0, io.SeekCurrent); offset != int64(len(data)) {
+		t.Fatalf("file offset is %d, want %d", offset, len(data))
+	}
+}
+
+func TestMemoryMapFallsBack(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.MemoryMap = true
+
+	// Empty files and pipes cannot be mapped and are read normally
+	empty := filepath.Join(t.TempDir(), "input.bin")
+	if err := os.WriteFile(empty, nil, defaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	manifestID, err := s.EncryptFile(empty, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, nil)
+
+	_, data := writeTestFile(t, t.TempDir(), "data", 3000)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	go func() {
+		w.Write(data)
+		w.Close()
+	}()
+	manifestID, err = s.EncryptOpenFile(r, "input.bin", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
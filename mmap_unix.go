@@ -0,0 +1,27 @@
+//go:build unix
+
+package secstorage
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// mapFile 把 file 的前 length 字节以只读方式映射到内存。
+func mapFile(file *os.File, length int64) ([]byte, error) {
+	if int64(int(length)) != length {
+		return nil, fmt.Errorf("file of %d bytes is too large to map", length)
+	}
+	data, err := unix.Mmap(int(file.Fd()), 0, int(length), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map %s: %w", file.Name(), err)
+	}
+	return data, nil
+}
+
+// unmapFile 解除 mapFile 建立的映射。
+func unmapFile(data []byte) error {
+	return unix.Munmap(data)
+}
@@ -0,0 +1,55 @@
+package secstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/awnumar/memguard"
+)
+
+// fileOwner 是加密时以 PreserveOwner 保存的文件所有者。
+type fileOwner struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
+// marshalOwner 返回 info 所描述文件的所有者的 JSON 编码；当前平台没有 uid/gid 时返回 nil。
+func marshalOwner(info os.FileInfo) ([]byte, error) {
+	owner := ownerOf(info)
+	if owner == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal file owner: %w", err)
+	}
+	return data, nil
+}
+
+// decryptOwner 解密清单中的文件所有者；清单未保存所有者时返回 nil。
+func decryptOwner(manifest *Manifest, key *memguard.LockedBuffer) (*fileOwner, error) {
+	if len(manifest.EncryptedOwner) == 0 {
+		return nil, nil
+	}
+	data, err := manifest.open(manifest.KeyWrapCipher, manifest.EncryptedOwner, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt file owner: %w", err)
+	}
+	defer memguard.WipeBytes(data)
+
+	var owner fileOwner
+	if err := json.Unmarshal(data, &owner); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal file owner: %w", err)
+	}
+	return &owner, nil
+}
+
+// restoreOwner 把 path 的所有者设置为清单中保存的 uid/gid。没有权限或当前平台不支持时只记录警告，
+// 因为以普通用户身份恢复他人的文件是常见情形，不应使整个解密失败。
+func (s *Syncer) restoreOwner(manifestID, path string, owner *fileOwner) {
+	if err := os.Lchown(path, owner.UID, owner.GID); err != nil {
+		s.logger().Warn("failed to restore file owner",
+			"manifest_id", manifestID, "path", path, "uid", owner.UID, "gid", owner.GID, "error", err)
+	}
+}
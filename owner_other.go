@@ -0,0 +1,10 @@
+//go:build !unix
+
+package secstorage
+
+import "os"
+
+// ownerOf 在没有 uid/gid 的平台上总是返回 nil。
+func ownerOf(info os.FileInfo) *fileOwner {
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package secstorage
+
+import (
+	"os"
+	"syscall"
+)
+
+// ownerOf 返回 info 中的 uid 和 gid。
+func ownerOf(info os.FileInfo) *fileOwner {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &fileOwner{UID: int(st.Uid), GID: int(st.Gid)}
+}
@@ -0,0 +1,127 @@
+//go:build unix
+
+package secstorage
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// encryptOwnedFile 写入一个属于 uid:gid 的测试文件，以 PreserveOwner 加密并返回 manifestID。
+func encryptOwnedFile(t *testing.T, s *Syncer, uid, gid int) string {
+	t.Helper()
+	path, _ := writeTestFile(t, t.TempDir(), "input.bin", 2000)
+	if err := os.Chown(path, uid, gid); err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions()
+	opts.PreserveOwner = true
+	manifestID, err := s.EncryptFile(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifestID
+}
+
+// fileOwnerIDs 返回 path 的 uid 和 gid。
+func fileOwnerIDs(t *testing.T, path string) (int, int) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	return int(st.Uid), int(st.Gid)
+}
+
+func TestRestoreOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner of a file requires root")
+	}
+	s := newTestSyncer(t)
+	manifestID := encryptOwnedFile(t, s, 1234, 5678)
+
+	// Without RestoreMetadata the file belongs to the current user
+	outputDir := t.TempDir()
+	if err := s.DecryptFile(manifestID, outputDir, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if uid, gid := fileOwnerIDs(t, filepath.Join(outputDir, "input.bin")); uid != 0 || gid != os.Getgid() {
+		t.Fatalf("got owner %d:%d without RestoreMetadata", uid, gid)
+	}
+
+	s.RestoreMetadata = true
+	outputDir = t.TempDir()
+	if err := s.DecryptFile(manifestID, outputDir, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if uid, gid := fileOwnerIDs(t, filepath.Join(outputDir, "input.bin")); uid != 1234 || gid != 5678 {
+		t.Fatalf("got owner %d:%d, want 1234:5678", uid, gid)
+	}
+}
+
+func TestRestoreOwnerUnprivileged(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can always change the owner of a file")
+	}
+	s := newTestSyncer(t)
+	manifestID := encryptOwnedFile(t, s, os.Getuid(), os.Getgid())
+
+	// Claim a different owner, which an unprivileged user cannot restore
+	manifest, key, err := s.OpenManifest(manifestID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+	if manifest.EncryptedOwner, err = encryptWith(manifest.KeyWrapCipher, []byte(`{"uid":0,"gid":0}`), key, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteManifest(manifestID, manifest, key); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	s.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+	s.RestoreMetadata = true
+	outputDir := t.TempDir()
+	if err := s.DecryptFile(manifestID, outputDir, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if uid, _ := fileOwnerIDs(t, filepath.Join(outputDir, "input.bin")); uid != os.Getuid() {
+		t.Fatalf("got uid %d, want %d", uid, os.Getuid())
+	}
+	if !strings.Contains(logs.String(), "failed to restore file owner") {
+		t.Fatalf("expected a warning, got %q", logs.String())
+	}
+}
+
+func TestRebuildManifestKeepsOwner(t *testing.T) {
+	path, _ := writeTestFile(t, t.TempDir(), "input.bin", 2000)
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.PreserveOwner = true
+	opts.RecoveryRecords = true
+	manifestID, err := s.EncryptFile(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRebuildsIdentically(t, s, manifestID)
+
+	manifest, key, err := s.OpenManifest(manifestID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+	owner, err := decryptOwner(manifest, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid, gid := fileOwnerIDs(t, path); owner == nil || owner.UID != uid || owner.GID != gid {
+		t.Fatalf("rebuilt manifest records owner %+v, want %d:%d", owner, uid, gid)
+	}
+}
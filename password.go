@@ -0,0 +1,75 @@
+package secstorage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"unicode"
+)
+
+// ErrWeakPassword 表示密码未通过 MinPasswordEntropy 构造的强度检查。
+var ErrWeakPassword = errors.New("password is too weak")
+
+// EstimatePasswordEntropy 根据长度和用到的字符类别粗略估计 password 的熵（比特）：
+// 字符数乘以 log2(字符集大小)，字符集是出现过的各类别之和——小写字母 26、大写字母 26、数字 10、其他字符 33。
+// 它假设每个字符都是从字符集中独立随机选取的，因此是一个上界：字典单词、键盘序列或重复字符的真实强度要低得多。
+func EstimatePasswordEntropy(password string) float64 {
+	var lower, upper, digit, other bool
+	length := 0
+	for _, r := range password {
+		length++
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {other, 33}} {
+		if class.present {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(length) * math.Log2(float64(pool))
+}
+
+// MinPasswordEntropy 返回一个可用作 EncryptionOptions.PasswordPolicy 的策略：
+// EstimatePasswordEntropy 的估计值低于 bits 的密码被拒绝，错误满足 errors.Is(err, ErrWeakPassword)。
+func MinPasswordEntropy(bits float64) func(string) error {
+	return func(password string) error {
+		if entropy := EstimatePasswordEntropy(password); entropy < bits {
+			return fmt.Errorf("%w: estimated %.0f bits of entropy, at least %.0f required", ErrWeakPassword, entropy, bits)
+		}
+		return nil
+	}
+}
+
+// checkPasswordPolicy 用 PasswordPolicy 逐个检查 Password 和 AdditionalPasswords；未设置策略时直接通过。
+// 只通过 KeyWrapper 解密的文件没有 Password，不做检查。
+func (opts EncryptionOptions) checkPasswordPolicy() error {
+	if opts.PasswordPolicy == nil {
+		return nil
+	}
+	if opts.Password != "" || opts.KeyWrapper == nil {
+		if err := opts.PasswordPolicy(opts.Password); err != nil {
+			return fmt.Errorf("password rejected: %w", err)
+		}
+	}
+	for i, password := range opts.AdditionalPasswords {
+		if err := opts.PasswordPolicy(password); err != nil {
+			return fmt.Errorf("additional password %d rejected: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package secstorage
+
+import (
+	"errors"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestEstimatePasswordEntropy(t *testing.T) {
+	for _, tc := range []struct {
+		password string
+		want     float64
+	}{
+		{"", 0},
+		{"aaaaaaaa", 8 * math.Log2(26)},
+		{"Password1", 9 * math.Log2(62)},
+		{"Pass word1!", 11 * math.Log2(95)},
+		{"密码密码", 4 * math.Log2(33)},
+	} {
+		if got := EstimatePasswordEntropy(tc.password); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%q: got %.2f bits, want %.2f", tc.password, got, tc.want)
+		}
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.Password = "hunter2"
+	opts.PasswordPolicy = MinPasswordEntropy(60)
+	if _, err := s.EncryptFile(writeTestFileOnly(t), opts); !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("got %v, want ErrWeakPassword", err)
+	}
+	// Nothing is written for a rejected password
+	if entries, _ := os.ReadDir(s.StorageDir); len(entries) != 0 {
+		t.Fatalf("rejected encryption left %d entries behind", len(entries))
+	}
+
+	// Additional passwords are checked too
+	opts.Password = "correct-Horse-battery-staple"
+	opts.AdditionalPasswords = []string{"1234"}
+	if _, err := s.EncryptFile(writeTestFileOnly(t), opts); !errors.Is(err, ErrWeakPassword) {
+		t.Fatalf("got %v, want ErrWeakPassword", err)
+	}
+
+	opts.AdditionalPasswords = nil
+	if _, err := s.EncryptFile(writeTestFileOnly(t), opts); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,408 @@
+package secstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/awnumar/memguard"
+)
+
+// progressFileName 是记录上传进度的文件名。它与 manifest.json 位于同一目录，上传完成后即被删除。
+const progressFileName = "progress.jsonl"
+
+// progressSignaturePrefix 用于区分进度记录与清单的签名，二者使用同一个文件密钥。
+var progressSignaturePrefix = []byte("secstorage-progress\x00")
+
+// uploadProgressHeader 是进度文件的第一行，记录了文件密钥以及续传时必须保持不变的参数。
+type uploadProgressHeader struct {
+	Recipients        []Recipient     `json:"recipients"`
+	KMSWrappedKey     []byte          `json:"kms_wrapped_key,omitempty"`
+	KeyWrapCipher     CipherAlgorithm `json:"key_wrap_cipher,omitempty"`
+	ChunkCipher       CipherAlgorithm `json:"chunk_cipher,omitempty"`
+	DataShards        int             `json:"data_shards"`
+	ParityShards      int             `json:"parity_shards"`
+	ChunkSizeKB       int             `json:"chunk_size_kb"`
+	ChunkerPolynomial uint64          `json:"chunker_polynomial"`
+	MaxShardBytes     int             `json:"max_shard_bytes,omitempty"`
+	AADHash           []byte          `json:"aad_hash,omitempty"`
+	ShardTransform    string          `json:"shard_transform,omitempty"`
+	Signature         []byte          `json:"signature"`
+}
+
+// uploadProgressChunk 记录一个所有分片都已成功写入后端的块。
+// PlainTag 是明文的带密钥摘要，续传时用来确认源文件在该块处没有变化。
+type uploadProgressChunk struct {
+	Index              int      `json:"index"`
+	PlainSize          int      `json:"plain_size"`
+	PlainTag           []byte   `json:"plain_tag"`
+	EncryptedDataKey   []byte   `json:"encrypted_data_key"`
+	EncryptedChunkSize int      `json:"encrypted_chunk_size"`
+	ChunkSuffixes      []string `json:"chunk_suffixes"`
+	Signature          []byte   `json:"signature"`
+}
+
+// uploadProgress 是一个打开的进度文件。每完成一个块就追加一行签名的记录，
+// 因此中断时最多只会丢失最后一个块的记录，续传时该块会被重新上传。
+type uploadProgress struct {
+	path   string
+	file   *os.File
+	key    *memguard.LockedBuffer
+	header uploadProgressHeader
+	// chunks 是打开进度文件时已经完成的块，按序号排列。
+	chunks []uploadProgressChunk
+}
+
+// progressPath 返回 manifestID 对应的进度文件路径。
+func (s *Syncer) progressPath(manifestID string) string {
+	return filepath.Join(s.manifestDir(manifestID), progressFileName)
+}
+
+// beginUpload 为 EncryptFile 准备 manifestID、文件密钥和进度文件。
+// 未设置 opts.ResumeManifestID 时创建新的清单目录（使用 opts.ManifestID 或随机 ID）和文件密钥；
+// 否则打开中断上传的进度文件继续。
+// 返回的密钥必须由调用方销毁。
+func (s *Syncer) beginUpload(opts EncryptionOptions) (string, *uploadProgress, *memguard.LockedBuffer, error) {
+	if err := opts.validate(); err != nil {
+		return "", nil, nil, fmt.Errorf("invalid encryption options: %w", err)
+	}
+	transform, err := s.shardTransformName()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if opts.ResumeManifestID != "" {
+		return s.resumeUpload(opts, transform)
+	}
+
+	// 1. Claim the directory of the requested or a freshly generated manifest ID
+	manifestID := opts.ManifestID
+	if manifestID != "" {
+		err = s.claimManifestDir(manifestID, opts.Overwrite)
+	} else {
+		manifestID, err = s.createManifestDir()
+	}
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	// 2. Generate the file key and wrap it for every password
+	header := uploadProgressHeader{
+		KeyWrapCipher:     opts.KeyWrapCipher,
+		ChunkCipher:       s.chunkCipher(),
+		DataShards:        opts.DataShards,
+		ParityShards:      opts.ParityShards,
+		ChunkSizeKB:       opts.ChunkSizeKB,
+		ChunkerPolynomial: uint64(defaultChunkerPolynomial),
+		MaxShardBytes:     opts.MaxShardBytes,
+	}
+	key, recipients, err := newFileKey(s.keyDeriver(), manifestID, opts)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	header.Recipients = recipients
+	header.AADHash = aadHash(key, opts.AAD)
+	header.ShardTransform = transform
+	if header.KMSWrappedKey, err = kmsWrapFileKey(opts, key); err != nil {
+		key.Destroy()
+		return "", nil, nil, err
+	}
+
+	// 3. Record the parameters so an interrupted upload can be resumed
+	progress, err := createUploadProgress(s.progressPath(manifestID), header, key)
+	if err != nil {
+		key.Destroy()
+		return "", nil, nil, err
+	}
+	return manifestID, progress, key, nil
+}
+
+// resumeUpload 用 opts.Password（为空时用 opts.KeyWrapper）打开 opts.ResumeManifestID 的进度文件，
+// 并检查影响分片布局的参数与中断前一致。接收者沿用中断前的设置，opts 中的 Argon2 参数和 AdditionalPasswords 被忽略。
+func (s *Syncer) resumeUpload(opts EncryptionOptions, transform string) (string, *uploadProgress, *memguard.LockedBuffer, error) {
+	manifestID := opts.ResumeManifestID
+	if err := s.validateManifestID(manifestID); err != nil {
+		return "", nil, nil, err
+	}
+	if err := s.migrateManifestDir(manifestID); err != nil {
+		return "", nil, nil, err
+	}
+	if _, err := os.Stat(s.getManifestPath(manifestID)); err == nil {
+		return "", nil, nil, fmt.Errorf("upload of manifest %s has already completed", manifestID)
+	}
+	// Nothing is written before the password is checked, so probe for a read-only store first;
+	// any other failure is reported by openUploadProgress
+	if err := checkWritable(s.manifestDir(manifestID)); errors.Is(err, ErrStorageReadOnly) {
+		return "", nil, nil, err
+	}
+
+	progress, key, err := openUploadProgress(s.keyDeriver(), opts.KeyWrapper, s.progressPath(manifestID), opts.Password)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	header := progress.header
+	if header.DataShards != opts.DataShards || header.ParityShards != opts.ParityShards ||
+		header.ChunkSizeKB != opts.ChunkSizeKB || header.KeyWrapCipher != opts.KeyWrapCipher ||
+		header.MaxShardBytes != opts.MaxShardBytes || !hmac.Equal(header.AADHash, aadHash(key, opts.AAD)) ||
+		header.ShardTransform != transform {
+		progress.Close()
+		key.Destroy()
+		return "", nil, nil, fmt.Errorf("encryption options do not match the interrupted upload of manifest %s", manifestID)
+	}
+
+	// The chunk being uploaded at the interruption may have left partial shards, which would block rewriting it
+	leftovers, err := pendingShardNames(header, len(progress.chunks))
+	if err == nil {
+		for _, name := range leftovers {
+			if err = s.backend().Delete(context.Background(), shardKey(manifestID, name)); err != nil {
+				err = fmt.Errorf("failed to delete partial shard %s: %w", name, err)
+				break
+			}
+		}
+	}
+	if err != nil {
+		progress.Close()
+		key.Destroy()
+		return "", nil, nil, err
+	}
+	return manifestID, progress, key, nil
+}
+
+// createUploadProgress 创建新的进度文件并写入签名的头部。
+func createUploadProgress(path string, header uploadProgressHeader, key *memguard.LockedBuffer) (*uploadProgress, error) {
+	line, err := marshalProgressRecord(&header, &header.Signature, key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, defaultFilePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload progress file: %w", err)
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write upload progress: %w", err)
+	}
+	return &uploadProgress{path: path, file: file, key: key, header: header}, nil
+}
+
+// openUploadProgress 读取进度文件，用 password 解开文件密钥，并返回已完成的块。
+// 返回的密钥必须由调用方销毁。
+func openUploadProgress(kd KeyDeriver, kw KeyWrapper, path, password string) (*uploadProgress, *memguard.LockedBuffer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read upload progress: %w", err)
+	}
+
+	// 1. The header unlocks the file key and must be intact
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		return nil, nil, fmt.Errorf("upload progress file %s is truncated", path)
+	}
+	var header uploadProgressHeader
+	if err := json.Unmarshal(data[:end], &header); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal upload progress: %w", err)
+	}
+
+	key, ok, err := kmsUnlock(kw, header.KMSWrappedKey, password)
+	if !ok {
+		pass := memguard.NewBufferFromBytes([]byte(password))
+		defer pass.Destroy()
+		_, key, err = findRecipient(kd, header.Recipients, pass.Bytes())
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !verifyProgressRecord(&header, &header.Signature, key) {
+		key.Destroy()
+		return nil, nil, fmt.Errorf("upload progress signature verification failed")
+	}
+
+	// 2. Completed chunks follow in order. A torn or unverifiable line ends the usable
+	// prefix; everything after it is treated as not uploaded and will be sent again.
+	progress := &uploadProgress{path: path, key: key, header: header}
+	offset := end + 1
+	for {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end < 0 {
+			break
+		}
+		var chunk uploadProgressChunk
+		if json.Unmarshal(data[offset:offset+end], &chunk) != nil ||
+			chunk.Index != len(progress.chunks) || !verifyProgressRecord(&chunk, &chunk.Signature, key) {
+			break
+		}
+		progress.chunks = append(progress.chunks, chunk)
+		offset += end + 1
+	}
+
+	// 3. Drop the unusable tail so new records are appended after the last good line
+	if err := os.Truncate(path, int64(offset)); err != nil {
+		key.Destroy()
+		return nil, nil, fmt.Errorf("failed to truncate upload progress: %w", err)
+	}
+	progress.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, defaultFilePerm)
+	if err != nil {
+		key.Destroy()
+		return nil, nil, fmt.Errorf("failed to open upload progress: %w", err)
+	}
+	return progress, key, nil
+}
+
+// completed 返回第 i 个块在打开进度文件时是否已经上传完成。
+func (p *uploadProgress) completed(i int) (uploadProgressChunk, bool) {
+	if i < len(p.chunks) {
+		return p.chunks[i], true
+	}
+	return uploadProgressChunk{}, false
+}
+
+// record 追加一条块完成记录。
+func (p *uploadProgress) record(chunk uploadProgressChunk) error {
+	line, err := marshalProgressRecord(&chunk, &chunk.Signature, p.key)
+	if err != nil {
+		return err
+	}
+	if _, err := p.file.Write(line); err != nil {
+		return fmt.Errorf("failed to record upload progress of chunk %d: %w", chunk.Index, err)
+	}
+	return nil
+}
+
+// Close 关闭进度文件但保留其内容，可以重复调用。
+func (p *uploadProgress) Close() error {
+	if p.file == nil {
+		return nil
+	}
+	err := p.file.Close()
+	p.file = nil
+	return err
+}
+
+// remove 在上传完成后关闭并删除进度文件。
+func (p *uploadProgress) remove() error {
+	p.Close()
+	if err := os.Remove(p.path); err != nil {
+		return fmt.Errorf("failed to remove upload progress: %w", err)
+	}
+	return nil
+}
+
+// uploadShardNames 返回 manifestID 未完成的上传可能已写入后端的所有分片文件名，供 DeleteManifest 清理。
+// 它不需要密码，因此不验证进度记录的签名，只检查其中的名称不会指向清单目录之外。
+// 除了已记录完成的块，中断时正在上传的下一个块也可能留下部分分片，同样会被列出。
+func (s *Syncer) uploadShardNames(manifestID string) ([]string, error) {
+	data, err := os.ReadFile(s.progressPath(manifestID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload progress: %w", err)
+	}
+	lines := bytes.Split(data, []byte{'\n'})
+	var header uploadProgressHeader
+	if err := json.Unmarshal(lines[0], &header); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal upload progress: %w", err)
+	}
+	if header.ParityShards < 0 || header.DataShards < 0 || header.DataShards+header.ParityShards > maxTotalShards {
+		return nil, fmt.Errorf("upload progress has invalid shard counts %d+%d", header.DataShards, header.ParityShards)
+	}
+	if header.MaxShardBytes != 0 && header.MaxShardBytes < minShardPartBytes {
+		return nil, fmt.Errorf("upload progress has invalid max shard size %d", header.MaxShardBytes)
+	}
+	partNames := func(name string, encryptedSize int) ([]string, error) {
+		shardSize := shardSizeFor(encryptedSize, header.DataShards, header.ParityShards)
+		if err := validateShardParts(shardSize, header.MaxShardBytes); err != nil {
+			return nil, err
+		}
+		return shardPartNames(name, shardSize, header.MaxShardBytes), nil
+	}
+
+	var names []string
+	next := 0
+	for _, line := range lines[1:] {
+		var chunk uploadProgressChunk
+		if json.Unmarshal(line, &chunk) != nil || chunk.Index != next {
+			break
+		}
+		for _, suffix := range chunk.ChunkSuffixes {
+			name := fmt.Sprintf("chunk_%d%s", chunk.Index, suffix)
+			if err := validateStorageName(name); err != nil {
+				return nil, fmt.Errorf("upload progress of chunk %d: %w", chunk.Index, err)
+			}
+			parts, err := partNames(name, chunk.EncryptedChunkSize)
+			if err != nil {
+				return nil, fmt.Errorf("upload progress of chunk %d: %w", chunk.Index, err)
+			}
+			names = append(names, parts...)
+		}
+		next++
+	}
+
+	pending, err := pendingShardNames(header, next)
+	if err != nil {
+		return nil, err
+	}
+	return append(names, pending...), nil
+}
+
+// pendingShardNames 返回中断时正在上传的第 next 个块可能留下的分片文件名。该块的大小未知，
+// 因此按分块器的最大块大小列出所有可能的部分，拆分时还包括未拆分的名称。
+func pendingShardNames(header uploadProgressHeader, next int) ([]string, error) {
+	var pending []string
+	if header.ParityShards == 0 {
+		pending = append(pending, fmt.Sprintf("chunk_%d%s", next, plainChunkSuffix))
+	}
+	for i := 0; header.ParityShards > 0 && i < header.DataShards+header.ParityShards; i++ {
+		pending = append(pending, fmt.Sprintf("chunk_%d%s", next, shardSuffix(i)))
+	}
+	shardSize := shardSizeFor(header.ChunkSizeKB*2048+maxCipherOverhead, header.DataShards, header.ParityShards)
+	if err := validateShardParts(shardSize, header.MaxShardBytes); err != nil {
+		return nil, fmt.Errorf("upload progress of chunk %d: %w", next, err)
+	}
+	var names []string
+	for _, name := range pending {
+		parts := shardPartNames(name, shardSize, header.MaxShardBytes)
+		if len(parts) > 1 {
+			names = append(names, name)
+		}
+		names = append(names, parts...)
+	}
+	return names, nil
+}
+
+// progressChunkTag 计算第 i 个块明文的带密钥摘要，用于续传时识别源文件是否发生了变化。
+func progressChunkTag(key *memguard.LockedBuffer, manifestID string, i int, plaintext []byte) []byte {
+	digest := sha256.Sum256(plaintext)
+	data := append(append([]byte{}, progressSignaturePrefix...), chunkAAD(manifestID, i)...)
+	return sign(append(data, digest[:]...), key.Bytes())
+}
+
+// marshalProgressRecord 对 record 签名并将其编码为一行 JSON。signature 指向 record 的 Signature 字段。
+func marshalProgressRecord(record any, signature *[]byte, key *memguard.LockedBuffer) ([]byte, error) {
+	*signature = nil
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal upload progress for signing: %w", err)
+	}
+	*signature = sign(append(append([]byte{}, progressSignaturePrefix...), data...), key.Bytes())
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal upload progress: %w", err)
+	}
+	return append(line, '\n'), nil
+}
+
+// verifyProgressRecord 验证由 marshalProgressRecord 签名的记录。
+func verifyProgressRecord(record any, signature *[]byte, key *memguard.LockedBuffer) bool {
+	expected := *signature
+	*signature = nil
+	data, err := json.Marshal(record)
+	*signature = expected
+	if err != nil {
+		return false
+	}
+	return verify(append(append([]byte{}, progressSignaturePrefix...), data...), expected, key.Bytes())
+}
@@ -0,0 +1,166 @@
+package secstorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// failingPutBackend 包装 Backend，让 key 包含 failKey 的 Put 调用失败，并记录所有成功写入的 key。
+type failingPutBackend struct {
+	Backend
+	failKey string
+
+	mu   sync.Mutex
+	puts []string
+}
+
+func (b *failingPutBackend) Put(ctx context.Context, key string, data []byte) error {
+	if b.failKey != "" && strings.Contains(key, b.failKey) {
+		return errors.New("injected put failure")
+	}
+	b.mu.Lock()
+	b.puts = append(b.puts, key)
+	b.mu.Unlock()
+	return b.Backend.Put(ctx, key, data)
+}
+
+// interruptedUpload 加密一个在写入第 2 个块时失败的文件，返回源文件路径、内容、manifestID 和分片后端。
+func interruptedUpload(t *testing.T, s *Syncer) (string, []byte, string, *failingPutBackend) {
+	t.Helper()
+	path, data := writeTestFile(t, t.TempDir(), "input.bin", 8000)
+	backend := &failingPutBackend{Backend: NewLocalBackend(t.TempDir()), failKey: "/chunk_2_"}
+	s.Backend = backend
+	manifestID, err := s.EncryptFile(path, testOptions())
+	if err == nil {
+		t.Fatal("EncryptFile ignored the injected failure")
+	}
+	if manifestID == "" {
+		t.Fatalf("failed upload returned no manifest ID: %v", err)
+	}
+	return path, data, manifestID, backend
+}
+
+func TestResumeUploadAfterPutFailure(t *testing.T) {
+	s := newTestSyncer(t)
+	path, data, manifestID, backend := interruptedUpload(t, s)
+	if _, err := s.ReadManifest(manifestID); err == nil {
+		t.Fatal("failed upload wrote a manifest")
+	}
+
+	backend.failKey = ""
+	backend.puts = nil
+	opts := testOptions()
+	opts.ResumeManifestID = manifestID
+	resumedID, err := s.EncryptFile(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumedID != manifestID {
+		t.Fatalf("resume returned %s, want %s", resumedID, manifestID)
+	}
+	for _, key := range backend.puts {
+		if strings.Contains(key, "/chunk_0_") || strings.Contains(key, "/chunk_1_") {
+			t.Fatalf("completed chunk was uploaded again: %s", key)
+		}
+	}
+	if _, err := os.Stat(s.progressPath(manifestID)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("progress file left behind: %v", err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	if _, err := s.EncryptFile(path, opts); err == nil {
+		t.Fatal("resumed an upload that has already completed")
+	}
+}
+
+func TestResumeUploadReplacesPartialChunk(t *testing.T) {
+	s := newTestSyncer(t)
+	path, data := writeTestFile(t, t.TempDir(), "input.bin", 8000)
+	// The first shards of chunk 2 are written before the failure
+	backend := &failingPutBackend{Backend: NewLocalBackend(t.TempDir()), failKey: "/chunk_2_shard_3"}
+	s.Backend = backend
+	opts := testOptions()
+	manifestID, err := s.EncryptFile(path, opts)
+	if err == nil {
+		t.Fatal("EncryptFile ignored the injected failure")
+	}
+	if _, err := backend.Get(context.Background(), shardKey(manifestID, "chunk_2_shard_0.dat")); err != nil {
+		t.Fatalf("expected a partial chunk: %v", err)
+	}
+
+	backend.failKey = ""
+	opts.ResumeManifestID = manifestID
+	if _, err := s.EncryptFile(path, opts); err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
+
+func TestResumeUploadRejectsChangedSource(t *testing.T) {
+	s := newTestSyncer(t)
+	path, data, manifestID, backend := interruptedUpload(t, s)
+	backend.failKey = ""
+
+	data[0] ^= 1
+	if err := os.WriteFile(path, data, defaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions()
+	opts.ResumeManifestID = manifestID
+	if _, err := s.EncryptFile(path, opts); err == nil {
+		t.Fatal("resumed with a modified source file")
+	}
+
+	opts = testOptions()
+	opts.ResumeManifestID = manifestID
+	opts.ParityShards = 1
+	if _, err := s.EncryptFile(path, opts); err == nil {
+		t.Fatal("resumed with different shard counts")
+	}
+}
+
+func TestDeleteManifestAbortsInterruptedUpload(t *testing.T) {
+	s := newTestSyncer(t)
+	_, _, manifestID, backend := interruptedUpload(t, s)
+
+	if err := s.DeleteManifest(manifestID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(s.StorageDir, manifestID)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("upload directory left behind: %v", err)
+	}
+	remaining, err := filepath.Glob(filepath.Join(backend.Backend.(*LocalBackend).Root, manifestID, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) > 0 {
+		t.Fatalf("shards left behind: %v", remaining)
+	}
+}
+
+func TestEncryptFileValidatesOptionsFirst(t *testing.T) {
+	path, _ := writeTestFile(t, t.TempDir(), "input.bin", 100)
+	for name, edit := range map[string]func(opts *EncryptionOptions){
+		"unknown key wrap cipher": func(opts *EncryptionOptions) { opts.KeyWrapCipher = "rot13" },
+		"zero chunk size":         func(opts *EncryptionOptions) { opts.ChunkSizeKB = 0 },
+		"huge chunk size":         func(opts *EncryptionOptions) { opts.ChunkSizeKB = 5 << 20 },
+		"negative parity":         func(opts *EncryptionOptions) { opts.ParityShards = -1 },
+		"no data shards":          func(opts *EncryptionOptions) { opts.DataShards = 0 },
+		"too many shards":         func(opts *EncryptionOptions) { opts.DataShards = 250; opts.ParityShards = 10 },
+	} {
+		s := newTestSyncer(t)
+		opts := testOptions()
+		edit(&opts)
+		if _, err := s.EncryptFile(path, opts); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+		if entries, _ := os.ReadDir(s.StorageDir); len(entries) > 0 {
+			t.Errorf("%s: created %s before rejecting the options", name, entries[0].Name())
+		}
+	}
+}
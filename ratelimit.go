@@ -0,0 +1,50 @@
+package secstorage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter 是按字节计数的令牌桶限速器，桶容量为一秒的配额。
+// 单次请求可以超过桶容量，此时令牌数变为负值，之后的请求需要等待补足。nil 表示不限速。
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter 创建每秒最多放行 bytesPerSec 字节的限速器；bytesPerSec 不大于 0 时返回 nil，即不限速。
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(bytesPerSec), tokens: float64(bytesPerSec), last: time.Now()}
+}
+
+// wait 取走 n 个字节的令牌，令牌不足时阻塞到补足为止。ctx 被取消时立即返回其错误。
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,50 @@
+package secstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if err := (*rateLimiter)(nil).wait(context.Background(), 1<<30); err != nil {
+		t.Fatalf("nil limiter: %v", err)
+	}
+
+	// The first second's worth is available immediately, the rest is paced
+	l := newRateLimiter(1000)
+	start := time.Now()
+	for range 3 {
+		if err := l.wait(context.Background(), 500); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("1500 bytes at 1000 B/s took %v, want about 500ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx, 1<<20); err != context.Canceled {
+		t.Fatalf("wait on a cancelled context: %v", err)
+	}
+}
+
+func TestEncryptFileMaxBytesPerSec(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	// About 3000 bytes of data plus half again of parity; the first 2000 bytes pass immediately
+	opts.MaxBytesPerSec = 2000
+	start := time.Now()
+	manifestID, data := encryptTestFile(t, s, opts, 3000)
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Fatalf("throttled encryption took only %v", elapsed)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	opts.MaxBytesPerSec = -1
+	path, _ := writeTestFile(t, t.TempDir(), "input.bin", 10)
+	if _, err := s.EncryptFile(path, opts); err == nil {
+		t.Fatal("negative rate limit accepted")
+	}
+}
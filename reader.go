@@ -0,0 +1,237 @@
+package secstorage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/awnumar/memguard"
+	"github.com/klauspost/reedsolomon"
+)
+
+// plaintextChunkSizes 返回清单中每个块的明文字节数。
+// 旧清单没有记录明文大小，此时由加密块大小减去 nonce 和认证标签的长度推算。
+func plaintextChunkSizes(manifest *Manifest) ([]int, error) {
+	if len(manifest.PlaintextChunkSizes) > 0 {
+		return manifest.PlaintextChunkSizes, nil
+	}
+	overhead, err := cipherOverhead(manifest.ChunkCipher)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.NonceSize != 0 && manifest.ChunkCipher != CipherXChaCha20Poly1305 {
+		overhead += manifest.NonceSize - 12
+	}
+	sizes := make([]int, len(manifest.EncryptedChunkSizes))
+	for i, size := range manifest.EncryptedChunkSizes {
+		if size < overhead {
+			return nil, fmt.Errorf("chunk %d is too small to be encrypted: %d bytes", i, size)
+		}
+		sizes[i] = size - overhead
+	}
+	return sizes, nil
+}
+
+// defaultReadCacheBytes 是 Syncer.ReadCacheBytes 为 0 时解密句柄缓存的明文字节数上限。
+const defaultReadCacheBytes = 8 << 20
+
+// chunkCache 是按总字节数限制大小的 LRU 块缓存，保存最近解密的块明文。
+// 最近使用的一个块总会被保留，即使它本身超过了上限，否则在同一块内的连续读取将不断重复解密。
+// 被淘汰的明文会立即被擦除。
+type chunkCache struct {
+	limit   int
+	size    int
+	order   *list.List // of *cachedChunk, most recently used first
+	entries map[int]*list.Element
+}
+
+// cachedChunk 是 chunkCache 中的一项。
+type cachedChunk struct {
+	index     int
+	plaintext []byte
+}
+
+// newChunkCache 创建一个最多保存 limit 字节明文的缓存。
+func newChunkCache(limit int) *chunkCache {
+	return &chunkCache{limit: limit, order: list.New(), entries: make(map[int]*list.Element)}
+}
+
+// get 返回第 i 个块的缓存明文，并将其标记为最近使用。
+func (c *chunkCache) get(i int) ([]byte, bool) {
+	elem, ok := c.entries[i]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cachedChunk).plaintext, true
+}
+
+// put 缓存第 i 个块的明文，并淘汰最久未使用的块直到总大小不超过上限。
+func (c *chunkCache) put(i int, plaintext []byte) {
+	c.entries[i] = c.order.PushFront(&cachedChunk{index: i, plaintext: plaintext})
+	c.size += len(plaintext)
+	for c.size > c.limit && c.order.Len() > 1 {
+		c.evict(c.order.Back())
+	}
+}
+
+// evict 移除并擦除一项缓存。
+func (c *chunkCache) evict(elem *list.Element) {
+	entry := c.order.Remove(elem).(*cachedChunk)
+	delete(c.entries, entry.index)
+	c.size -= len(entry.plaintext)
+	memguard.WipeBytes(entry.plaintext)
+}
+
+// clear 擦除并移除所有缓存的明文。
+func (c *chunkCache) clear() {
+	for c.order.Len() > 0 {
+		c.evict(c.order.Back())
+	}
+}
+
+// decryptedFile 是 OpenDecrypted 返回的只读文件句柄，按需读取并解密所需的块。
+// 它不能被多个 goroutine 并发使用。
+type decryptedFile struct {
+	s          *Syncer
+	manifestID string
+	manifest   *Manifest
+	key        *memguard.LockedBuffer
+	enc        reedsolomon.Encoder
+	// offsets[i] 是第 i 个块在明文中的起始偏移，最后一项是文件总大小。
+	offsets []int64
+	pos     int64
+	cache   *chunkCache
+	closed  bool
+}
+
+// OpenDecrypted 打开 manifestID 对应的文件，返回一个可随机访问的只读句柄。
+// 句柄只在 Read 需要时读取、重建并解密相应的块，因此应用可以像普通文件一样读取加密文件，而无需先把它完整解密到磁盘。
+// 最近解密的块保存在大小由 Syncer.ReadCacheBytes 限制的 LRU 缓存中，在已缓存的块内读取或回退不会再次读取分片。
+// Syncer.StrictIntegrity 同样适用于句柄的读取。
+// 调用方必须调用 Close，它会擦除缓存的明文并销毁文件密钥。句柄不能被多个 goroutine 并发使用。
+func (s *Syncer) OpenDecrypted(manifestID, password string) (io.ReadSeekCloser, error) {
+	manifest, key, err := s.openManifest(manifestID, password)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes, err := plaintextChunkSizes(manifest)
+	if err != nil {
+		key.Destroy()
+		return nil, err
+	}
+	offsets := make([]int64, len(sizes)+1)
+	for i, size := range sizes {
+		offsets[i+1] = offsets[i] + int64(size)
+	}
+
+	var enc reedsolomon.Encoder
+	if manifest.ParityShards > 0 {
+		enc, err = s.newErasureCoder(manifest.DataShards, manifest.ParityShards)
+		if err != nil {
+			key.Destroy()
+			return nil, fmt.Errorf("failed to create erasure code decoder: %w", err)
+		}
+	}
+
+	cacheBytes := s.ReadCacheBytes
+	if cacheBytes == 0 {
+		cacheBytes = defaultReadCacheBytes
+	}
+	return &decryptedFile{
+		s:          s,
+		manifestID: manifestID,
+		manifest:   manifest,
+		key:        key,
+		enc:        enc,
+		offsets:    offsets,
+		cache:      newChunkCache(cacheBytes),
+	}, nil
+}
+
+// size 返回文件的明文总大小。
+func (f *decryptedFile) size() int64 {
+	return f.offsets[len(f.offsets)-1]
+}
+
+// chunk 返回第 i 个块的明文，必要时读取并解密它。
+func (f *decryptedFile) chunk(i int) ([]byte, error) {
+	if plaintext, ok := f.cache.get(i); ok {
+		return plaintext, nil
+	}
+	plaintext, damage, err := f.s.readChunk(context.Background(), f.manifestID, f.manifest, f.enc, f.key, i, f.s.StrictIntegrity)
+	if err != nil {
+		return nil, err
+	}
+	if damage.degraded && f.s.StrictIntegrity {
+		memguard.WipeBytes(plaintext)
+		return nil, fmt.Errorf("chunk %d of manifest %s: %w", i, f.manifestID, ErrShardIntegrity)
+	}
+	if int64(len(plaintext)) != f.offsets[i+1]-f.offsets[i] {
+		memguard.WipeBytes(plaintext)
+		return nil, fmt.Errorf("chunk %d decrypted to %d bytes, expected %d", i, len(plaintext), f.offsets[i+1]-f.offsets[i])
+	}
+	f.s.metrics().IncChunksDecrypted()
+
+	f.cache.put(i, plaintext)
+	return plaintext, nil
+}
+
+// Read 实现了 io.Reader 接口。
+func (f *decryptedFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.pos >= f.size() {
+		return 0, io.EOF
+	}
+
+	// The chunk containing pos is the last one starting at or before it
+	i := sort.Search(len(f.offsets)-1, func(i int) bool { return f.offsets[i+1] > f.pos })
+	plaintext, err := f.chunk(i)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, plaintext[f.pos-f.offsets[i]:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+// Seek 实现了 io.Seeker 接口。允许定位到文件末尾之后，此时 Read 返回 io.EOF。
+func (f *decryptedFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = f.pos + offset
+	case io.SeekEnd:
+		pos = f.size() + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if pos < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.pos = pos
+	return pos, nil
+}
+
+// Close 擦除缓存的明文并销毁文件密钥。重复调用返回 os.ErrClosed。
+func (f *decryptedFile) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	f.cache.clear()
+	f.key.Destroy()
+	return nil
+}
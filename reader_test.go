@@ -0,0 +1,179 @@
+package secstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+// assertRandomReads 以随机的偏移和长度读取 f，并与 want 对比。
+func assertRandomReads(t *testing.T, f io.ReadSeeker, want []byte) {
+	t.Helper()
+	for range 50 {
+		offset := rand.IntN(len(want))
+		buf := make([]byte, rand.IntN(3000)+1)
+		if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		n, err := io.ReadFull(f, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], want[offset:offset+n]) || (n < len(buf) && offset+n != len(want)) {
+			t.Fatalf("read %d bytes at %d differs", len(buf), offset)
+		}
+	}
+}
+
+func TestOpenDecrypted(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 8000)
+
+	f, err := s.OpenDecrypted(manifestID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("sequential read differs")
+	}
+	assertRandomReads(t, f, data)
+
+	if pos, err := f.Seek(-10, io.SeekEnd); err != nil || pos != int64(len(data)-10) {
+		t.Fatalf("Seek from end = %d, %v", pos, err)
+	}
+	if _, err := f.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("seeked to a negative position")
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if f.(*decryptedFile).key.IsAlive() {
+		t.Fatal("Close did not destroy the file key")
+	}
+	if _, err := f.Read(make([]byte, 1)); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("Read after Close returned %v", err)
+	}
+}
+
+func TestOpenDecryptedWithoutStoredPlaintextSizes(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 5000)
+
+	// Manifests written before plaintext sizes were stored fall back to the cipher overhead
+	manifest, key, err := s.OpenManifest(manifestID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.PlaintextChunkSizes = nil
+	if err := s.WriteManifest(manifestID, manifest, key); err != nil {
+		t.Fatal(err)
+	}
+	key.Destroy()
+
+	f, err := s.OpenDecrypted(manifestID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	assertRandomReads(t, f, data)
+}
+
+func TestPlaintextChunkSizesOverhead(t *testing.T) {
+	for _, cipher := range []CipherAlgorithm{"", CipherAESGCM, CipherXChaCha20Poly1305} {
+		key, err := generateDataKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ciphertext, err := encryptWith(cipher, make([]byte, 100), key, nil)
+		key.Destroy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes, err := plaintextChunkSizes(&Manifest{ChunkCipher: cipher, EncryptedChunkSizes: []int{len(ciphertext)}})
+		if err != nil || sizes[0] != 100 {
+			t.Fatalf("%q: sizes = %v, %v", cipher, sizes, err)
+		}
+	}
+}
+
+// countingBackend 包装 Backend 并统计 Get 调用次数。
+type countingBackend struct {
+	Backend
+	gets atomic.Int64
+}
+
+func (b *countingBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	b.gets.Add(1)
+	return b.Backend.Get(ctx, key)
+}
+
+func TestOpenDecryptedCachedSeekReadsNoShards(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 8000)
+	backend := &countingBackend{Backend: NewLocalBackend(s.StorageDir)}
+	s.Backend = backend
+
+	f, err := s.OpenDecrypted(manifestID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 100)
+	if _, err := f.Seek(300, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(f, buf); err != nil {
+		t.Fatal(err)
+	}
+	reads := backend.gets.Load()
+	if reads == 0 {
+		t.Fatal("first read did not touch the backend")
+	}
+
+	// A backward seek within the cached first chunk
+	if _, err := f.Seek(10, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(f, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data[10:110]) {
+		t.Fatal("cached read differs")
+	}
+	if extra := backend.gets.Load() - reads; extra != 0 {
+		t.Fatalf("backward seek within a cached chunk read %d shards", extra)
+	}
+}
+
+func TestChunkCacheEvictsAndWipes(t *testing.T) {
+	cache := newChunkCache(10)
+	first := []byte("123456")
+	cache.put(0, first)
+	cache.put(1, []byte("abcdef"))
+	if _, ok := cache.get(0); ok {
+		t.Fatal("least recently used chunk was not evicted")
+	}
+	if !bytes.Equal(first, make([]byte, len(first))) {
+		t.Fatal("evicted plaintext was not wiped")
+	}
+
+	// The most recent chunk stays even when it alone exceeds the limit
+	cache.put(2, make([]byte, 20))
+	if _, ok := cache.get(2); !ok || cache.order.Len() != 1 {
+		t.Fatal("oversized chunk was not kept on its own")
+	}
+	cache.clear()
+	if cache.size != 0 || len(cache.entries) != 0 {
+		t.Fatal("clear left entries behind")
+	}
+}
@@ -0,0 +1,295 @@
+package secstorage
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/awnumar/memguard"
+)
+
+// Recipient 描述一个可以解密文件的密码。
+// 它保存了从该密码派生密钥所需的盐值和 KDF 参数，以及用派生密钥包装（加密）后的文件密钥。
+// 密码本身从不存储；多个接收者共享同一个文件密钥，因此增删接收者不需要重新加密任何分片。
+type Recipient struct {
+	Salt []byte `json:"salt"`
+	// KDF 是派生密钥所用的算法，为空时为 Argon2id。
+	KDF           KDFAlgorithm `json:"kdf,omitempty"`
+	Argon2Time    uint32       `json:"argon2_time"`
+	Argon2Memory  uint32       `json:"argon2_memory"`
+	Argon2Threads uint8        `json:"argon2_threads"`
+	// ScryptN、ScryptR 和 ScryptP 是 KDF 为 KDFScrypt 时的参数。
+	ScryptN    int    `json:"scrypt_n,omitempty"`
+	ScryptR    int    `json:"scrypt_r,omitempty"`
+	ScryptP    int    `json:"scrypt_p,omitempty"`
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+// argon2Params 返回该接收者的 Argon2id 参数。
+func (r Recipient) argon2Params() Argon2Config {
+	return Argon2Config{Time: r.Argon2Time, MemoryKB: r.Argon2Memory, Threads: r.Argon2Threads}
+}
+
+// validate 检查接收者记录的 KDF 及其参数。清单在验证签名之前就会用这些参数派生密钥，因此不能信任它们。
+func (r Recipient) validate() error {
+	switch r.KDF {
+	case "", KDFArgon2id:
+		return validateArgon2Params(r.argon2Params())
+	case KDFScrypt:
+		return validateScryptParams(r.ScryptN, r.ScryptR, r.ScryptP)
+	default:
+		return fmt.Errorf("unsupported key derivation function %q", r.KDF)
+	}
+}
+
+// deriveKey 按接收者记录的 KDF 从 password 派生包装密钥。Argon2id 通过 kd 计算。
+func (r Recipient) deriveKey(kd KeyDeriver, password []byte) (*memguard.LockedBuffer, error) {
+	switch r.KDF {
+	case "", KDFArgon2id:
+		return kd.Derive(password, r.Salt, r.argon2Params()), nil
+	case KDFScrypt:
+		return deriveScryptKey(password, r.Salt, r.ScryptN, r.ScryptR, r.ScryptP)
+	default:
+		return nil, fmt.Errorf("unsupported key derivation function %q", r.KDF)
+	}
+}
+
+// newRecipient 为 password 生成新的盐值，按 params 中的 KDF 参数派生密钥并用它包装 fileKey。
+// params 的盐值和包装密钥会被忽略。
+func newRecipient(kd KeyDeriver, password []byte, fileKey *memguard.LockedBuffer, params Recipient) (Recipient, error) {
+	salt, err := generateSalt()
+	if err != nil {
+		return Recipient{}, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return wrapFileKey(kd, password, fileKey, params, salt)
+}
+
+// wrapFileKey 以 salt 和 params 中的 KDF 参数从 password 派生密钥，并用它包装 fileKey。
+func wrapFileKey(kd KeyDeriver, password []byte, fileKey *memguard.LockedBuffer, params Recipient, salt []byte) (Recipient, error) {
+	recipient := params
+	recipient.Salt = salt
+
+	key, err := recipient.deriveKey(kd, password)
+	if err != nil {
+		return Recipient{}, err
+	}
+	defer key.Destroy()
+
+	recipient.WrappedKey, err = encrypt(fileKey.Bytes(), key, nil)
+	if err != nil {
+		return Recipient{}, fmt.Errorf("failed to wrap file key: %w", err)
+	}
+	return recipient, nil
+}
+
+// newFileKey 为新文件生成文件密钥，并为 opts 中的每个密码创建一个接收者。
+// 确定性模式下密钥和盐值由 manifestID 和 opts.DeterministicSalt 派生。返回的密钥必须由调用方销毁。
+func newFileKey(kd KeyDeriver, manifestID string, opts EncryptionOptions) (*memguard.LockedBuffer, []Recipient, error) {
+	params, err := opts.recipientParams()
+	if err != nil {
+		return nil, nil, err
+	}
+	passwords := append([]string{opts.Password}, opts.AdditionalPasswords...)
+	if opts.Password == "" && opts.KeyWrapper != nil {
+		passwords = passwords[1:]
+	}
+	if opts.Deterministic {
+		return deterministicRecipients(kd, manifestID, passwords, params, opts.DeterministicSalt)
+	}
+
+	key, err := generateDataKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate file key: %w", err)
+	}
+	var recipients []Recipient
+	for _, password := range passwords {
+		var recipient Recipient
+		if len(opts.SharedSalt) > 0 {
+			recipient, err = wrapFileKey(kd, []byte(password), key, params, bytes.Clone(opts.SharedSalt))
+		} else {
+			recipient, err = newRecipient(kd, []byte(password), key, params)
+		}
+		if err != nil {
+			key.Destroy()
+			return nil, nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	return key, recipients, nil
+}
+
+// unwrap 尝试用 password 解开该接收者包装的文件密钥。
+// GCM 认证保证了密码错误时一定返回错误，而不会得到错误的密钥。
+func (r Recipient) unwrap(kd KeyDeriver, password []byte) (*memguard.LockedBuffer, error) {
+	key, err := r.deriveKey(kd, password)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Destroy()
+
+	fileKey, err := decrypt(r.WrappedKey, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return memguard.NewBufferFromBytes(fileKey), nil
+}
+
+// findRecipient 返回 password 能够解开的接收者的索引及其文件密钥。
+func findRecipient(kd KeyDeriver, recipients []Recipient, password []byte) (int, *memguard.LockedBuffer, error) {
+	for i, recipient := range recipients {
+		fileKey, err := recipient.unwrap(kd, password)
+		if err == nil {
+			return i, fileKey, nil
+		}
+	}
+	return -1, nil, fmt.Errorf("password does not match any recipient of the manifest")
+}
+
+// unlockManifest 使用 password 获取清单的文件密钥，该密钥用于验证签名以及解密文件名和数据密钥。
+// 旧版清单没有接收者列表，其文件密钥就是从密码和清单盐值直接派生出的密钥。
+// 返回的密钥在使用完毕后必须由调用方销毁。
+func unlockManifest(kd KeyDeriver, manifest *Manifest, password string) (*memguard.LockedBuffer, error) {
+	pass := memguard.NewBufferFromBytes([]byte(password))
+	defer pass.Destroy()
+
+	if manifest.Version < manifestVersionRecipients {
+		params := Argon2Config{Time: manifest.Argon2Time, MemoryKB: manifest.Argon2Memory, Threads: manifest.Argon2Threads}
+		if err := validateArgon2Params(params); err != nil {
+			return nil, err
+		}
+		return kd.Derive(pass.Bytes(), manifest.Salt, params), nil
+	}
+
+	_, fileKey, err := findRecipient(kd, manifest.Recipients, pass.Bytes())
+	return fileKey, err
+}
+
+// AddRecipient 为已有的清单添加一个新密码。
+// 它用 existingPassword 解开文件密钥，再为 newPassword 重新包装并追加到接收者列表，最后重新签名清单。
+// 新接收者沿用 existingPassword 所在接收者的 KDF 及其参数。该操作不会读取或改写任何分片。
+func (s *Syncer) AddRecipient(manifestID, existingPassword, newPassword string) error {
+	defer s.lockManifest(manifestID)()
+
+	manifest, err := s.loadManifest(manifestID)
+	if err != nil {
+		return err
+	}
+	if manifest.Version < manifestVersionRecipients {
+		return fmt.Errorf("manifest %s predates recipient support and cannot be shared", manifestID)
+	}
+
+	pass := memguard.NewBufferFromBytes([]byte(existingPassword))
+	defer pass.Destroy()
+	index, key, err := findRecipient(s.keyDeriver(), manifest.Recipients, pass.Bytes())
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+
+	if err := verifyManifestSignature(manifest, key); err != nil {
+		return err
+	}
+
+	existing := manifest.Recipients[index]
+	recipient, err := newRecipient(s.keyDeriver(), []byte(newPassword), key, existing)
+	if err != nil {
+		return err
+	}
+	manifest.Recipients = append(manifest.Recipients, recipient)
+
+	return s.updateManifest(manifestID, manifest, key)
+}
+
+// RemoveRecipient 从清单中移除 password 对应的接收者并重新签名清单。
+// 为避免文件变得无法解密，不允许移除最后一个接收者。
+// 注意：文件密钥本身不会轮换，已经获取过文件密钥的一方在技术上仍可能解密现有分片。
+func (s *Syncer) RemoveRecipient(manifestID, password string) error {
+	defer s.lockManifest(manifestID)()
+
+	manifest, err := s.loadManifest(manifestID)
+	if err != nil {
+		return err
+	}
+	if manifest.Version < manifestVersionRecipients {
+		return fmt.Errorf("manifest %s predates recipient support", manifestID)
+	}
+
+	pass := memguard.NewBufferFromBytes([]byte(password))
+	defer pass.Destroy()
+	index, key, err := findRecipient(s.keyDeriver(), manifest.Recipients, pass.Bytes())
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+
+	if err := verifyManifestSignature(manifest, key); err != nil {
+		return err
+	}
+
+	if len(manifest.Recipients) == 1 {
+		return fmt.Errorf("cannot remove the last recipient of manifest %s", manifestID)
+	}
+	manifest.Recipients = append(manifest.Recipients[:index], manifest.Recipients[index+1:]...)
+
+	return s.updateManifest(manifestID, manifest, key)
+}
+
+// RotateSalt 为 password 对应的接收者生成新的盐值，以同样的 KDF 参数重新派生密钥、包装文件密钥并重新签名清单，
+// 密码本身不变。数据密钥和文件名由文件密钥加密，不受盐值影响，因此该操作与 AddRecipient 一样不读取或改写任何分片，
+// 可以定期对所有清单执行。其他接收者保持不变。旧版清单的文件密钥直接由密码和盐值派生，不支持轮换盐值。
+func (s *Syncer) RotateSalt(manifestID, password string) error {
+	defer s.lockManifest(manifestID)()
+
+	manifest, err := s.loadManifest(manifestID)
+	if err != nil {
+		return err
+	}
+	if manifest.Version < manifestVersionRecipients {
+		return fmt.Errorf("manifest %s predates recipient support and its salt cannot be rotated", manifestID)
+	}
+
+	pass := memguard.NewBufferFromBytes([]byte(password))
+	defer pass.Destroy()
+	index, key, err := findRecipient(s.keyDeriver(), manifest.Recipients, pass.Bytes())
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+
+	if err := verifyManifestSignature(manifest, key); err != nil {
+		return err
+	}
+
+	recipient, err := newRecipient(s.keyDeriver(), pass.Bytes(), key, manifest.Recipients[index])
+	if err != nil {
+		return err
+	}
+	manifest.Recipients[index] = recipient
+
+	return s.updateManifest(manifestID, manifest, key)
+}
+
+// CheckPassword 快速检查 password 能否解密 manifestID 对应的文件，而不读取或解密任何分片。
+// 它只需要一次密钥派生（每个接收者一次）和一次签名验证，适合在耗时的解密前为 CLI 或 UI 提供即时反馈。
+// 密码错误时返回 false 和 nil；清单无法读取，或密码正确但签名验证失败（清单被篡改）时返回错误。
+func (s *Syncer) CheckPassword(manifestID, password string) (bool, error) {
+	manifest, err := s.loadManifest(manifestID)
+	if err != nil {
+		return false, err
+	}
+
+	key, err := s.unlockFileKey(s.keyDeriver(), manifest, password)
+	if err != nil {
+		return false, nil
+	}
+	defer key.Destroy()
+
+	if err := verifyManifestSignature(manifest, key); err != nil {
+		// Legacy keys are derived directly from the password, so a wrong password
+		// only shows up as a signature mismatch.
+		if manifest.Version < manifestVersionRecipients {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
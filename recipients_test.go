@@ -0,0 +1,150 @@
+package secstorage
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestMultipleRecipients(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.AdditionalPasswords = []string{"second password"}
+	manifestID, data := encryptTestFile(t, s, opts, 3000)
+
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	assertDecrypts(t, s, manifestID, "second password", data)
+
+	if err := s.AddRecipient(manifestID, "second password", "third password"); err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, "third password", data)
+	if err := s.AddRecipient(manifestID, "wrong password", "fourth password"); err == nil {
+		t.Fatal("AddRecipient accepted a wrong existing password")
+	}
+
+	if err := s.RemoveRecipient(manifestID, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DecryptFile(manifestID, t.TempDir(), testPassword); err == nil {
+		t.Fatal("removed recipient can still decrypt")
+	}
+	if err := s.RemoveRecipient(manifestID, "second password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveRecipient(manifestID, "third password"); err == nil {
+		t.Fatal("removed the last recipient")
+	}
+	assertDecrypts(t, s, manifestID, "third password", data)
+}
+
+func TestRotateSalt(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.AdditionalPasswords = []string{"second password"}
+	opts.RecoveryRecords = true
+	manifestID, data := encryptTestFile(t, s, opts, 3000)
+	before, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RotateSalt(manifestID, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	after, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(after.Recipients[0].Salt, before.Recipients[0].Salt) ||
+		bytes.Equal(after.Recipients[0].WrappedKey, before.Recipients[0].WrappedKey) {
+		t.Fatal("salt and wrapped key were not rotated")
+	}
+	if !bytes.Equal(after.Recipients[1].Salt, before.Recipients[1].Salt) {
+		t.Fatal("another recipient's salt changed")
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	assertDecrypts(t, s, manifestID, "second password", data)
+
+	if err := s.RotateSalt(manifestID, "wrong password"); err == nil {
+		t.Fatal("RotateSalt accepted a wrong password")
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, s, testOptions(), 100)
+
+	if ok, err := s.CheckPassword(manifestID, testPassword); !ok || err != nil {
+		t.Fatalf("CheckPassword(correct) = %v, %v", ok, err)
+	}
+	if ok, err := s.CheckPassword(manifestID, "wrong password"); ok || err != nil {
+		t.Fatalf("CheckPassword(wrong) = %v, %v", ok, err)
+	}
+
+	// Change a signed field without re-signing
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.CreatorVersion = "tampered"
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.getManifestPath(manifestID), data, defaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CheckPassword(manifestID, testPassword); err == nil {
+		t.Fatal("CheckPassword accepted a tampered manifest")
+	}
+}
+
+func TestScryptRecipients(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.KDF = KDFScrypt
+	opts.ScryptN = 1 << 10
+	manifestID, data := encryptTestFile(t, s, opts, 3000)
+
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient := manifest.Recipients[0]
+	if recipient.KDF != KDFScrypt || recipient.ScryptN != 1<<10 || recipient.ScryptR != defaultScryptR || recipient.ScryptP != defaultScryptP {
+		t.Fatalf("recipient records %+v", recipient)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	if err := s.DecryptFile(manifestID, t.TempDir(), "wrong password"); err == nil {
+		t.Fatal("wrong password accepted")
+	}
+
+	// New recipients inherit the KDF of the unlocking one
+	if err := s.AddRecipient(manifestID, testPassword, "second password"); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err = s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Recipients[1].KDF != KDFScrypt {
+		t.Fatalf("added recipient uses %q", manifest.Recipients[1].KDF)
+	}
+	assertDecrypts(t, s, manifestID, "second password", data)
+}
+
+func TestKDFOptionsValidation(t *testing.T) {
+	for name, edit := range map[string]func(opts *EncryptionOptions){
+		"unknown KDF":          func(opts *EncryptionOptions) { opts.KDF = "pbkdf2" },
+		"N not a power of two": func(opts *EncryptionOptions) { opts.KDF = KDFScrypt; opts.ScryptN = 1000 },
+		"negative r":           func(opts *EncryptionOptions) { opts.KDF = KDFScrypt; opts.ScryptR = -1 },
+	} {
+		opts := testOptions()
+		edit(&opts)
+		if err := opts.validate(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
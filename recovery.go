@@ -0,0 +1,262 @@
+package secstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/awnumar/memguard"
+)
+
+// recoveryRecordSuffix 是块恢复记录文件的后缀，完整文件名形如 chunk_3.rec。
+const recoveryRecordSuffix = ".rec"
+
+// recoveryRecord 是与每个块一起存储的恢复记录。
+// 每条记录都自带解开文件密钥所需的接收者列表，因此无需清单即可验证记录；
+// 但每条记录只描述一个块，重建清单要求所有块的记录都完好，缺少任何一条都会导致重建失败。
+// 记录本身不包含任何明文秘密：数据密钥和文件名都是加密后的形式，并且整条记录由 HMAC 签名。
+//
+// 存储开销：每个块额外一个小文件，JSON 编码后通常为 400-600 字节（取决于文件名长度和分片数）。
+// 自定义元数据和扩展属性可能较大，与完整内容的哈希一起只保存在第 0 块的记录中。
+type recoveryRecord struct {
+	Version               int             `json:"version,omitempty"`
+	Recipients            []Recipient     `json:"recipients"`
+	KMSWrappedKey         []byte          `json:"kms_wrapped_key,omitempty"`
+	KeyWrapCipher         CipherAlgorithm `json:"key_wrap_cipher,omitempty"`
+	ChunkCipher           CipherAlgorithm `json:"chunk_cipher,omitempty"`
+	DataShards            int             `json:"data_shards"`
+	ParityShards          int             `json:"parity_shards"`
+	EncryptedOrigFilename []byte          `json:"encrypted_orig_filename"`
+	ChunkCount            int             `json:"chunk_count"`
+	ChunkIndex            int             `json:"chunk_index"`
+	ChunkPath             string          `json:"chunk_path"`
+	EncryptedDataKey      []byte          `json:"encrypted_data_key"`
+	EncryptedChunkSize    int             `json:"encrypted_chunk_size"`
+	PlaintextChunkSize    int             `json:"plaintext_chunk_size,omitempty"`
+	ChunkSuffixes         []string        `json:"chunk_suffixes"`
+	ChunkerPolynomial     uint64          `json:"chunker_polynomial,omitempty"`
+	MaxShardBytes         int             `json:"max_shard_bytes,omitempty"`
+	CreatedAt             time.Time       `json:"created_at,omitzero"`
+	CreatorVersion        string          `json:"creator_version,omitempty"`
+	EncryptedMetadata     []byte          `json:"encrypted_metadata,omitempty"`
+	EncryptedXattrs       []byte          `json:"encrypted_xattrs,omitempty"`
+	EncryptedOwner        []byte          `json:"encrypted_owner,omitempty"`
+	EncryptedSourceSizes  []byte          `json:"encrypted_source_sizes,omitempty"`
+	EncryptedContentHash  []byte          `json:"encrypted_content_hash,omitempty"`
+	ContentHashAlgorithm  HashAlgorithm   `json:"content_hash_algorithm,omitempty"`
+	AADHash               []byte          `json:"aad_hash,omitempty"`
+	ShardTransform        string          `json:"shard_transform,omitempty"`
+	Signature             []byte          `json:"signature,omitempty"`
+}
+
+// writeRecoveryRecords 为清单中的每个块写入一条签名的恢复记录。
+func (s *Syncer) writeRecoveryRecords(outputDir string, manifest *Manifest, key *memguard.LockedBuffer) error {
+	for i, chunkPath := range manifest.ChunkPaths {
+		record := recoveryRecord{
+			Version:               manifest.Version,
+			Recipients:            manifest.Recipients,
+			KMSWrappedKey:         manifest.KMSWrappedKey,
+			KeyWrapCipher:         manifest.KeyWrapCipher,
+			ChunkCipher:           manifest.ChunkCipher,
+			ChunkerPolynomial:     manifest.ChunkerPolynomial,
+			MaxShardBytes:         manifest.MaxShardBytes,
+			CreatedAt:             manifest.CreatedAt,
+			CreatorVersion:        manifest.CreatorVersion,
+			DataShards:            manifest.DataShards,
+			ParityShards:          manifest.ParityShards,
+			EncryptedOrigFilename: manifest.EncryptedOrigFilename,
+			ChunkCount:            len(manifest.ChunkPaths),
+			ChunkIndex:            i,
+			ChunkPath:             chunkPath,
+			EncryptedDataKey:      manifest.EncryptedDataKeys[i],
+			EncryptedChunkSize:    manifest.EncryptedChunkSizes[i],
+			ChunkSuffixes:         manifest.chunkSuffixes(i),
+			AADHash:               manifest.AADHash,
+			ShardTransform:        manifest.ShardTransform,
+		}
+
+		if len(manifest.PlaintextChunkSizes) > 0 {
+			record.PlaintextChunkSize = manifest.PlaintextChunkSizes[i]
+		}
+
+		// Metadata can be large, so only the first record carries it
+		if i == 0 {
+			record.EncryptedMetadata = manifest.EncryptedMetadata
+			record.EncryptedXattrs = manifest.EncryptedXattrs
+			record.EncryptedOwner = manifest.EncryptedOwner
+			record.EncryptedSourceSizes = manifest.EncryptedSourceSizes
+			record.EncryptedContentHash = manifest.EncryptedContentHash
+			record.ContentHashAlgorithm = manifest.ContentHashAlgorithm
+		}
+
+		recordData, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to marshal recovery record for chunk %d: %w", i, err)
+		}
+		record.Signature = sign(recordData, key.Bytes())
+
+		signedData, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to marshal signed recovery record for chunk %d: %w", i, err)
+		}
+
+		recordPath := filepath.Join(outputDir, chunkPath+recoveryRecordSuffix)
+		if err := s.writeFile(recordPath, signedData); err != nil {
+			return fmt.Errorf("failed to write recovery record for chunk %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// updateManifest 重新签名并保存被修改过的清单。
+// 如果该清单带有恢复记录，它们也会被重写，以免恢复记录与清单内容不一致。
+// 新记录通过临时文件原子地覆盖旧记录，之后才删除不再属于任何块的旧记录，
+// 因此任何时刻崩溃，目录中都保留着一套完整的记录。
+func (s *Syncer) updateManifest(manifestID string, manifest *Manifest, key *memguard.LockedBuffer) error {
+	outputDir := s.manifestDir(manifestID)
+	recordPaths, err := filepath.Glob(filepath.Join(outputDir, "chunk_*"+recoveryRecordSuffix))
+	if err != nil {
+		return fmt.Errorf("failed to list recovery records: %w", err)
+	}
+	if len(recordPaths) > 0 {
+		if err := s.writeRecoveryRecords(outputDir, manifest, key); err != nil {
+			return err
+		}
+		current := make(map[string]bool, len(manifest.ChunkPaths))
+		for _, chunkPath := range manifest.ChunkPaths {
+			current[filepath.Join(outputDir, chunkPath+recoveryRecordSuffix)] = true
+		}
+		for _, recordPath := range recordPaths {
+			if current[recordPath] {
+				continue
+			}
+			if err := os.Remove(recordPath); err != nil {
+				return fmt.Errorf("failed to remove stale recovery record: %w", err)
+			}
+		}
+	}
+
+	return s.saveManifest(manifestID, manifest, key)
+}
+
+// verifyRecoveryRecord 使用 key 验证恢复记录的签名。
+func verifyRecoveryRecord(record recoveryRecord, key *memguard.LockedBuffer) (bool, error) {
+	signature := record.Signature
+	record.Signature = nil
+	recordData, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+	return verify(recordData, signature, key.Bytes()), nil
+}
+
+// RebuildManifest 在 manifest.json 丢失但分片文件和恢复记录仍然存在时重建清单。
+// 只有以 EncryptionOptions.RecoveryRecords 加密的文件才能被重建。
+// 它会扫描 manifestID 目录下的所有恢复记录，用 password 解开文件密钥并验证每条记录的签名，
+// 检查块序号连续且完整后重新生成并签名清单。如果清单仍然存在，则返回错误而不会覆盖它。
+func (s *Syncer) RebuildManifest(manifestID, password string) error {
+	if err := s.validateManifestID(manifestID); err != nil {
+		return err
+	}
+	defer s.lockManifest(manifestID)()
+	if err := s.migrateManifestDir(manifestID); err != nil {
+		return err
+	}
+
+	manifestPath := s.getManifestPath(manifestID)
+	if _, err := os.Stat(manifestPath); err == nil {
+		return fmt.Errorf("manifest %s already exists, refusing to overwrite it", manifestID)
+	}
+
+	recordPaths, err := filepath.Glob(filepath.Join(s.manifestDir(manifestID), "chunk_*"+recoveryRecordSuffix))
+	if err != nil {
+		return fmt.Errorf("failed to list recovery records: %w", err)
+	}
+	if len(recordPaths) == 0 {
+		return fmt.Errorf("no recovery records found for manifest %s", manifestID)
+	}
+
+	records := make([]recoveryRecord, 0, len(recordPaths))
+	for _, recordPath := range recordPaths {
+		data, err := os.ReadFile(recordPath)
+		if err != nil {
+			return fmt.Errorf("failed to read recovery record %s: %w", recordPath, err)
+		}
+		var record recoveryRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("failed to unmarshal recovery record %s: %w", recordPath, err)
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ChunkIndex < records[j].ChunkIndex })
+
+	// All records carry the same recipients; unlock the file key once from the first.
+	first := records[0]
+	manifest := Manifest{
+		Version:               first.Version,
+		Recipients:            first.Recipients,
+		KMSWrappedKey:         first.KMSWrappedKey,
+		KeyWrapCipher:         first.KeyWrapCipher,
+		ChunkCipher:           first.ChunkCipher,
+		ChunkerPolynomial:     first.ChunkerPolynomial,
+		MaxShardBytes:         first.MaxShardBytes,
+		CreatedAt:             first.CreatedAt,
+		CreatorVersion:        first.CreatorVersion,
+		EncryptedMetadata:     first.EncryptedMetadata,
+		EncryptedXattrs:       first.EncryptedXattrs,
+		EncryptedOwner:        first.EncryptedOwner,
+		EncryptedSourceSizes:  first.EncryptedSourceSizes,
+		EncryptedContentHash:  first.EncryptedContentHash,
+		ContentHashAlgorithm:  first.ContentHashAlgorithm,
+		AADHash:               first.AADHash,
+		ShardTransform:        first.ShardTransform,
+		EncryptedOrigFilename: first.EncryptedOrigFilename,
+		DataShards:            first.DataShards,
+		ParityShards:          first.ParityShards,
+	}
+
+	key, err := s.unlockFileKey(s.keyDeriver(), &manifest, password)
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+
+	for i, record := range records {
+		ok, err := verifyRecoveryRecord(record, key)
+		if err != nil {
+			return fmt.Errorf("failed to marshal recovery record for chunk %d: %w", record.ChunkIndex, err)
+		}
+		if !ok {
+			return fmt.Errorf("recovery record signature verification failed for chunk %d", record.ChunkIndex)
+		}
+		if record.ChunkIndex != i || record.ChunkCount != first.ChunkCount {
+			return fmt.Errorf("recovery record for chunk %d is missing or inconsistent", i)
+		}
+
+		manifest.ChunkPaths = append(manifest.ChunkPaths, record.ChunkPath)
+		manifest.EncryptedDataKeys = append(manifest.EncryptedDataKeys, record.EncryptedDataKey)
+		manifest.EncryptedChunkSizes = append(manifest.EncryptedChunkSizes, record.EncryptedChunkSize)
+		if manifest.Version >= manifestVersionShardSuffixes {
+			if !slices.Equal(record.ChunkSuffixes, first.ChunkSuffixes) {
+				return fmt.Errorf("recovery record for chunk %d lists different shards", i)
+			}
+			manifest.ShardSuffixes = first.ChunkSuffixes
+		} else {
+			manifest.ErasureCodeChunkSuffixes = append(manifest.ErasureCodeChunkSuffixes, record.ChunkSuffixes)
+		}
+		// Records written before plaintext sizes were stored leave the list empty
+		if first.PlaintextChunkSize > 0 {
+			manifest.PlaintextChunkSizes = append(manifest.PlaintextChunkSizes, record.PlaintextChunkSize)
+		}
+	}
+
+	if len(records) != first.ChunkCount {
+		return fmt.Errorf("found %d recovery records, expected %d", len(records), first.ChunkCount)
+	}
+
+	return s.saveManifest(manifestID, &manifest, key)
+}
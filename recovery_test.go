@@ -0,0 +1,107 @@
+package secstorage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// assertRebuildsIdentically 删除 manifestID 的清单，从恢复记录重建后检查它与原清单完全相同。
+func assertRebuildsIdentically(t *testing.T, s *Syncer, manifestID string) {
+	t.Helper()
+	original, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(s.getManifestPath(manifestID)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RebuildManifest(manifestID, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(original, rebuilt) {
+		t.Fatal("rebuilt manifest differs from the original")
+	}
+}
+
+func TestRebuildManifest(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.RecoveryRecords = true
+	opts.Metadata = map[string]string{"set": "daily"}
+	manifestID, data := encryptTestFile(t, s, opts, 5000)
+
+	original, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RebuildManifest(manifestID, testPassword); err == nil {
+		t.Fatal("RebuildManifest overwrote an existing manifest")
+	}
+
+	if err := os.Remove(s.getManifestPath(manifestID)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RebuildManifest(manifestID, "wrong password"); err == nil {
+		t.Fatal("RebuildManifest accepted a wrong password")
+	}
+	if err := s.RebuildManifest(manifestID, testPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	rebuilt, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(original, rebuilt) {
+		t.Fatal("rebuilt manifest differs from the original")
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
+
+func TestRebuildManifestRequiresEveryRecord(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.RecoveryRecords = true
+	manifestID, _ := encryptTestFile(t, s, opts, 5000)
+
+	outputDir := filepath.Join(s.StorageDir, manifestID)
+	if err := os.Remove(s.getManifestPath(manifestID)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(outputDir, "chunk_1"+recoveryRecordSuffix)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RebuildManifest(manifestID, testPassword); err == nil {
+		t.Fatal("RebuildManifest succeeded with a missing recovery record")
+	}
+}
+
+func TestUpdateManifestRewritesRecoveryRecords(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.RecoveryRecords = true
+	manifestID, data := encryptTestFile(t, s, opts, 5000)
+
+	if err := s.AddRecipient(manifestID, testPassword, "second password"); err != nil {
+		t.Fatal(err)
+	}
+	outputDir := filepath.Join(s.StorageDir, manifestID)
+	if leftovers, _ := filepath.Glob(filepath.Join(outputDir, "*.tmp")); len(leftovers) > 0 {
+		t.Fatalf("temporary files left behind: %v", leftovers)
+	}
+
+	// The rewritten records must carry the new recipient
+	if err := os.Remove(s.getManifestPath(manifestID)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RebuildManifest(manifestID, "second password"); err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
@@ -0,0 +1,44 @@
+package secstorage
+
+import "context"
+
+// DecryptReport 是 DecryptFileWithReport 的结果，用于判断文件是直接读出的，还是在存储退化的情况下通过纠删码恢复的。
+// 分片以其在后端中的键表示（清单记录了 ShardLocations 时即其中的位置），可以用 ChunkHealth 进一步检查所在的块。
+// 数据分片完好的块不读取奇偶校验分片，因此其奇偶校验分片的丢失或损坏不会出现在报告中，需要用 VerifyManifest 检查。
+type DecryptReport struct {
+	// Metadata 是文件的自定义元数据，没有时为 nil。
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// ReconstructedChunks 是有分片丢失、读取失败或内容损坏，需要通过纠删码重建的块数。
+	ReconstructedChunks int `json:"reconstructed_chunks"`
+	// MissingShards 是不存在、读取失败或长度不对的分片，按块和分片序号排列。
+	MissingShards []string `json:"missing_shards,omitempty"`
+	// CorruptedShards 是存在但内容损坏、通过逐个排除并重新认证定位到的分片。
+	// 只损坏了奇偶校验分片时块仍能解密但无法定位，这样的块只计入 ReconstructedChunks。
+	CorruptedShards []string `json:"corrupted_shards,omitempty"`
+}
+
+// Degraded 报告解密时是否有任何块需要重建，即存储已经退化、应当安排修复。
+func (r DecryptReport) Degraded() bool {
+	return r.ReconstructedChunks > 0
+}
+
+// record 把 readChunk 读取第 i 个块时发现的问题加入报告。
+func (r *DecryptReport) record(manifestID string, manifest *Manifest, i int, damage chunkDamage) {
+	if !damage.degraded {
+		return
+	}
+	r.ReconstructedChunks++
+	for _, j := range damage.missing {
+		r.MissingShards = append(r.MissingShards, manifest.shardLocation(manifestID, i, j))
+	}
+	for _, j := range damage.corrupted {
+		r.CorruptedShards = append(r.CorruptedShards, manifest.shardLocation(manifestID, i, j))
+	}
+}
+
+// DecryptFileWithReport 与 DecryptFileWithMetadata 相同，但返回的报告还说明了有多少块需要通过纠删码重建，
+// 以及哪些分片丢失或损坏，便于恢复任务在成功后记录存储的退化情况并安排修复。
+// 设置了 Syncer.StrictIntegrity 时，遇到第一个需要重建的块即返回 ErrShardIntegrity，报告不会被返回。
+func (s *Syncer) DecryptFileWithReport(manifestID, outputPath, password string) (DecryptReport, error) {
+	return s.decryptFileContext(context.Background(), manifestID, outputPath, password)
+}
@@ -0,0 +1,83 @@
+package secstorage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecryptFileWithReportClean(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.Metadata = map[string]string{"source": "test"}
+	manifestID, _ := encryptTestFile(t, s, opts, 5000)
+
+	report, err := s.DecryptFileWithReport(manifestID, t.TempDir(), testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Degraded() || report.MissingShards != nil || report.CorruptedShards != nil {
+		t.Fatalf("clean file reported damage: %+v", report)
+	}
+	if report.Metadata["source"] != "test" {
+		t.Fatalf("got metadata %v", report.Metadata)
+	}
+}
+
+func TestDecryptFileWithReportDamage(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 5000)
+	if err := os.Remove(shardPath(s, manifestID, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(shardPath(s, manifestID, 0, 5)); err != nil {
+		t.Fatal(err)
+	}
+	flipBit(t, shardPath(s, manifestID, 1, 2))
+
+	outputDir := t.TempDir()
+	report, err := s.DecryptFileWithReport(manifestID, outputDir, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(outputDir, "input.bin")); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("restored content differs: %v", err)
+	}
+	if !report.Degraded() || report.ReconstructedChunks != 2 {
+		t.Fatalf("got %d reconstructed chunks, want 2", report.ReconstructedChunks)
+	}
+	if len(report.MissingShards) != 2 || !strings.HasSuffix(report.MissingShards[0], "chunk_0_shard_1.dat") ||
+		!strings.HasSuffix(report.MissingShards[1], "chunk_0_shard_5.dat") {
+		t.Fatalf("got missing shards %v", report.MissingShards)
+	}
+	if len(report.CorruptedShards) != 1 || !strings.HasSuffix(report.CorruptedShards[0], "chunk_1_shard_2.dat") {
+		t.Fatalf("got corrupted shards %v", report.CorruptedShards)
+	}
+}
+
+func TestDecryptFileWithReportToStdout(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, s, testOptions(), 3000)
+	if err := os.Remove(shardPath(s, manifestID, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	orig := os.Stdout
+	os.Stdout = stdout
+	defer func() { os.Stdout = orig }()
+
+	report, err := s.DecryptFileWithReport(manifestID, StdoutPath, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ReconstructedChunks != 1 || len(report.MissingShards) != 1 {
+		t.Fatalf("got %+v", report)
+	}
+}
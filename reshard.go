@@ -0,0 +1,133 @@
+package secstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/awnumar/memguard"
+	"github.com/klauspost/reedsolomon"
+)
+
+// reshardSuffixes 返回 Reshard 为 dataShards+parityShards 写入的分片后缀。后缀中带有分片参数，
+// 因此与原有的分片（包括加密时写入的标准后缀）不会重名，新分片可以在旧分片仍然存在时写入。
+func reshardSuffixes(dataShards, parityShards int) []string {
+	suffixes := standardShardSuffixes(dataShards, parityShards)
+	tag := fmt.Sprintf("_rs%dx%d", dataShards, parityShards)
+	for j, suffix := range suffixes {
+		suffixes[j] = tag + suffix
+	}
+	return suffixes
+}
+
+// Reshard 把 manifestID 的每个块改用 newData+newParity 的纠删码参数存储（newParity 为 0 时每个块只存一个文件），
+// 例如把 4+2 的对象转换为 6+3，而不需要重新派生数据密钥或重新加密内容。
+//
+// 每个块的加密数据从现有分片中读取，必要时通过纠删码重建，并经解密认证（明文随即在内存中擦除，不会写出），
+// 然后按新参数拆分、编码并写入新的分片。所有块都写完后才重新签名并保存清单（以及恢复记录），最后删除旧分片，
+// 因此任何时刻崩溃，清单都指向一套完整的分片；写入新分片的过程中失败时，已写入的新分片会被删除，清单保持不变。
+// 参数与当前相同时直接返回。记录了 ShardLocations 的清单不受支持。
+func (s *Syncer) Reshard(manifestID, password string, newData, newParity int) (err error) {
+	if newData <= 0 || newParity < 0 {
+		return fmt.Errorf("invalid shard counts %d+%d", newData, newParity)
+	}
+	if newData+newParity > maxTotalShards {
+		return fmt.Errorf("total shards %d exceeds the maximum of %d", newData+newParity, maxTotalShards)
+	}
+	if newParity == 0 {
+		newData = 1
+	}
+
+	defer s.lockManifest(manifestID)()
+	manifest, key, err := s.openManifest(manifestID, password)
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+	if len(manifest.ShardLocations) > 0 {
+		return errors.New("manifests with external shard locations cannot be resharded")
+	}
+	if newData == manifest.DataShards && newParity == manifest.ParityShards {
+		return nil
+	}
+	transform, err := s.shardTransform(manifest)
+	if err != nil {
+		return err
+	}
+	for i, size := range manifest.EncryptedChunkSizes {
+		if err := validateShardParts(shardSizeFor(size, newData, newParity), manifest.MaxShardBytes); err != nil {
+			return fmt.Errorf("chunk %d: %w", i, err)
+		}
+	}
+
+	var oldEnc, newEnc reedsolomon.Encoder
+	if manifest.ParityShards > 0 {
+		if oldEnc, err = s.newErasureCoder(manifest.DataShards, manifest.ParityShards); err != nil {
+			return fmt.Errorf("failed to create erasure code decoder: %w", err)
+		}
+	}
+	if newParity > 0 {
+		if newEnc, err = s.newErasureCoder(newData, newParity); err != nil {
+			return fmt.Errorf("failed to create erasure code encoder: %w", err)
+		}
+	}
+
+	// 1. Write every chunk under the new layout next to the old shards
+	ctx := context.Background()
+	suffixes := reshardSuffixes(newData, newParity)
+	var oldNames, newNames []string
+	defer func() {
+		if err != nil {
+			for _, name := range newNames {
+				s.backend().Delete(ctx, shardKey(manifestID, name))
+			}
+		}
+	}()
+	for i, chunkPath := range manifest.ChunkPaths {
+		oldNames = append(oldNames, manifest.storageNames(i)...)
+		ciphertext, _, err := s.recoverChunk(ctx, manifestID, manifest, oldEnc, i, false, func(ciphertext []byte) ([]byte, error) {
+			plaintext, err := decryptChunk(manifestID, manifest, key, i, nil, ciphertext)
+			if err != nil {
+				return nil, err
+			}
+			memguard.WipeBytes(plaintext)
+			return bytes.Clone(ciphertext), nil
+		})
+		if err != nil {
+			return err
+		}
+		names, err := s.putChunkShards(ctx, manifestID, i, chunkPath, suffixes, ciphertext, newEnc, manifest.MaxShardBytes, transform)
+		newNames = append(newNames, names...)
+		if err != nil {
+			return fmt.Errorf("chunk %d: %w", i, err)
+		}
+	}
+
+	// 2. Switch the manifest over to the new shards; from here on they must be kept even if saving fails,
+	// as the recovery records may already refer to them
+	newNames = nil
+	manifest.DataShards = newData
+	manifest.ParityShards = newParity
+	if manifest.Version >= manifestVersionShardSuffixes {
+		manifest.ShardSuffixes = suffixes
+	} else {
+		for i := range manifest.ErasureCodeChunkSuffixes {
+			manifest.ErasureCodeChunkSuffixes[i] = suffixes
+		}
+	}
+	if err := validateManifest(manifest); err != nil {
+		return fmt.Errorf("invalid resharded manifest: %w", err)
+	}
+	if err := s.updateManifest(manifestID, manifest, key); err != nil {
+		return err
+	}
+
+	// 3. The old shards are no longer referenced
+	for _, name := range oldNames {
+		if err := s.backend().Delete(ctx, shardKey(manifestID, name)); err != nil {
+			return fmt.Errorf("failed to delete old shard %s: %w", name, err)
+		}
+	}
+	return nil
+}
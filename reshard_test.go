@@ -0,0 +1,129 @@
+package secstorage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// storedShardNames 返回清单目录中的所有分片文件名。
+func storedShardNames(t *testing.T, s *Syncer, manifestID string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(s.manifestDir(manifestID), "chunk_*.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range names {
+		names[i] = filepath.Base(name)
+	}
+	return names
+}
+
+func TestReshard(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 5000)
+	// The source may already be degraded
+	if err := os.Remove(shardPath(s, manifestID, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Reshard(manifestID, testPassword, 6, 3); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.DataShards != 6 || manifest.ParityShards != 3 || len(manifest.ShardSuffixes) != 9 {
+		t.Fatalf("got %d+%d with %d suffixes", manifest.DataShards, manifest.ParityShards, len(manifest.ShardSuffixes))
+	}
+	if names := storedShardNames(t, s, manifestID); len(names) != 9*len(manifest.ChunkPaths) {
+		t.Fatalf("got %d shard files, want only the %d new ones: %v", len(names), 9*len(manifest.ChunkPaths), names)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	// The new layout tolerates three missing shards per chunk
+	for j := range 3 {
+		if err := os.Remove(filepath.Join(s.manifestDir(manifestID), "chunk_0"+manifest.ShardSuffixes[j])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
+
+func TestReshardToAndFromSealed(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 5000)
+
+	if err := s.Reshard(manifestID, testPassword, 4, 0); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.DataShards != 1 || manifest.ParityShards != 0 {
+		t.Fatalf("got %d+%d, want 1+0", manifest.DataShards, manifest.ParityShards)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	if err := s.Reshard(manifestID, testPassword, 4, 2); err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	if names := storedShardNames(t, s, manifestID); len(names) != 6*len(manifest.ChunkPaths) {
+		t.Fatalf("got %d shard files: %v", len(names), names)
+	}
+}
+
+func TestReshardKeepsRecoveryRecordsUsable(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.RecoveryRecords = true
+	manifestID, data := encryptTestFile(t, s, opts, 5000)
+	if err := s.Reshard(manifestID, testPassword, 3, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(s.getManifestPath(manifestID)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RebuildManifest(manifestID, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
+
+func TestReshardRejectsBadInput(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, s, testOptions(), 3000)
+	before := storedShardNames(t, s, manifestID)
+
+	for _, counts := range [][2]int{{0, 2}, {4, -1}, {200, 100}} {
+		if err := s.Reshard(manifestID, testPassword, counts[0], counts[1]); err == nil {
+			t.Errorf("%d+%d: accepted", counts[0], counts[1])
+		}
+	}
+	if err := s.Reshard(manifestID, "wrong password", 6, 3); err == nil {
+		t.Fatal("expected an error for a wrong password")
+	}
+
+	// Too many missing shards: nothing is written and the manifest is unchanged
+	for j := range 3 {
+		if err := os.Remove(shardPath(s, manifestID, 1, j)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Reshard(manifestID, testPassword, 6, 3); err == nil {
+		t.Fatal("expected an error for an unrecoverable chunk")
+	}
+	if after := storedShardNames(t, s, manifestID); len(after) != len(before)-3 {
+		t.Fatalf("failed reshard left %d shard files, want %d", len(after), len(before)-3)
+	}
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.DataShards != 4 || manifest.ParityShards != 2 {
+		t.Fatalf("failed reshard changed the manifest to %d+%d", manifest.DataShards, manifest.ParityShards)
+	}
+}
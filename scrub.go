@@ -0,0 +1,151 @@
+package secstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ObjectStatus 是 Scrub 对单个对象的检查结论。
+type ObjectStatus string
+
+const (
+	// ObjectHealthy 表示对象的所有块都完好。
+	ObjectHealthy ObjectStatus = "healthy"
+	// ObjectDegraded 表示对象有损坏或丢失的分片，但所有块仍可恢复，应尽快修复。
+	ObjectDegraded ObjectStatus = "degraded"
+	// ObjectUnrecoverable 表示对象至少有一个块无法恢复。
+	ObjectUnrecoverable ObjectStatus = "unrecoverable"
+	// ObjectError 表示对象无法检查，例如清单损坏、签名无效或没有可用的密码。
+	ObjectError ObjectStatus = "error"
+)
+
+// ObjectScrubResult 是 Scrub 对单个对象的检查结果。
+type ObjectScrubResult struct {
+	ManifestID string       `json:"manifest_id"`
+	Status     ObjectStatus `json:"status"`
+	// Chunks 是 VerifyManifest 的逐块报告，Status 为 ObjectError 时为空。
+	Chunks VerifyReport `json:"chunks"`
+	// Error 是 Status 为 ObjectError 时的错误信息。
+	Error string `json:"error,omitempty"`
+}
+
+// ScrubReport 汇总了 Scrub 对存储目录中所有对象的检查结果，可直接编码为 JSON 供调度修复任务使用。
+type ScrubReport struct {
+	// Objects 按 manifestID 排序。
+	Objects []ObjectScrubResult `json:"objects"`
+}
+
+// OK 报告是否所有对象都完好无损。
+func (r ScrubReport) OK() bool {
+	for _, object := range r.Objects {
+		if object.Status != ObjectHealthy {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter 返回状态为 status 的对象的 manifestID。
+func (r ScrubReport) Filter(status ObjectStatus) []string {
+	var ids []string
+	for _, object := range r.Objects {
+		if object.Status == status {
+			ids = append(ids, object.ManifestID)
+		}
+	}
+	return ids
+}
+
+// Scrub 用同一个密码检查存储目录中的每个对象，详见 ScrubFunc。
+func (s *Syncer) Scrub(password string, concurrency int) (ScrubReport, error) {
+	return s.ScrubFunc(func(string) (string, error) { return password, nil }, concurrency)
+}
+
+// ScrubFunc 遍历存储目录中的所有清单（不使用 Index，以免遗漏索引之外的对象），
+// 对每个对象调用 VerifyManifest，并汇总为健康、降级、不可恢复或无法检查。
+// 不同对象可能使用不同的密码，password 为每个 manifestID 返回其密码，返回错误的对象被记为 ObjectError。
+// 对象按顺序逐个检查，concurrency 控制每个对象内部并行检查的块数。
+// 单个对象的失败不会中止检查，只有存储目录无法读取时才返回错误。
+func (s *Syncer) ScrubFunc(password func(manifestID string) (string, error), concurrency int) (ScrubReport, error) {
+	return s.scrub(context.Background(), password, concurrency)
+}
+
+// scrub 是 ScrubFunc 的实现。ctx 被取消时在检查下一个对象之前停止并返回 ctx 的错误。
+func (s *Syncer) scrub(ctx context.Context, password func(manifestID string) (string, error), concurrency int) (ScrubReport, error) {
+	ids, err := s.storedManifestIDs()
+	if err != nil {
+		return ScrubReport{}, err
+	}
+
+	report := ScrubReport{Objects: make([]ObjectScrubResult, 0, len(ids))}
+	for _, manifestID := range ids {
+		if err := ctx.Err(); err != nil {
+			return ScrubReport{}, err
+		}
+		result := ObjectScrubResult{ManifestID: manifestID}
+		pw, err := password(manifestID)
+		if err == nil {
+			result.Chunks, err = s.VerifyManifest(manifestID, pw, concurrency)
+		} else {
+			err = fmt.Errorf("no password for manifest %s: %w", manifestID, err)
+		}
+		switch {
+		case err != nil:
+			result.Status = ObjectError
+			result.Error = err.Error()
+		case !result.Chunks.OK():
+			result.Status = ObjectUnrecoverable
+		case len(result.Chunks.Degraded) > 0:
+			result.Status = ObjectDegraded
+		default:
+			result.Status = ObjectHealthy
+		}
+		report.Objects = append(report.Objects, result)
+	}
+	return report, nil
+}
+
+// StartScrubber 在后台定期检查存储目录中的所有对象，使位衰减的发现无需人工介入。
+// 第一轮检查立即开始，之后每隔 interval 开始新的一轮（上一轮未结束时顺延），每轮的结果从返回的通道发出。
+// passwordFn 为每个 manifestID 提供密码，返回 false 的对象被记为 ObjectError。
+// 为了不与前台操作争抢资源，对象内的块逐个检查。存储目录无法读取的那一轮只通过 Syncer.Logger 记录警告，不发出报告。
+// ctx 被取消后正在进行的一轮在下一个对象之前停止并被丢弃，随后通道关闭。interval 必须大于 0。
+// 调用方应持续读取通道，否则下一轮检查会等到报告被取走才开始。
+func (s *Syncer) StartScrubber(ctx context.Context, interval time.Duration, passwordFn func(manifestID string) (string, bool)) <-chan ScrubReport {
+	ticker := time.NewTicker(interval)
+	reports := make(chan ScrubReport)
+	password := func(manifestID string) (string, error) {
+		if pw, ok := passwordFn(manifestID); ok {
+			return pw, nil
+		}
+		return "", errors.New("password not available")
+	}
+
+	go func() {
+		defer close(reports)
+		defer ticker.Stop()
+		for {
+			report, err := s.scrub(ctx, password, 1)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				s.logger().Warn("background scrub failed", "error", err)
+			} else {
+				select {
+				case reports <- report:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return reports
+}
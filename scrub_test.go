@@ -0,0 +1,120 @@
+package secstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestScrub(t *testing.T) {
+	s := newTestSyncer(t)
+	healthy, _ := encryptTestFile(t, s, testOptions(), 3000)
+	degraded, _ := encryptTestFile(t, s, testOptions(), 3000)
+	if err := os.Remove(shardPath(s, degraded, 1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	unrecoverable, _ := encryptTestFile(t, s, testOptions(), 3000)
+	for shard := 0; shard < 3; shard++ {
+		if err := os.Remove(shardPath(s, unrecoverable, 0, shard)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := testOptions()
+	opts.Password = "another password"
+	other, _ := encryptTestFile(t, s, opts, 100)
+
+	report, err := s.Scrub(testPassword, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]ObjectStatus{
+		healthy:       ObjectHealthy,
+		degraded:      ObjectDegraded,
+		unrecoverable: ObjectUnrecoverable,
+		other:         ObjectError,
+	}
+	if len(report.Objects) != len(want) {
+		t.Fatalf("scrubbed %d objects, want %d", len(report.Objects), len(want))
+	}
+	for _, object := range report.Objects {
+		if object.Status != want[object.ManifestID] {
+			t.Errorf("%s: status %s, want %s", object.ManifestID, object.Status, want[object.ManifestID])
+		}
+	}
+	if report.OK() {
+		t.Fatal("report with damaged objects is OK")
+	}
+	if ids := report.Filter(ObjectDegraded); len(ids) != 1 || ids[0] != degraded {
+		t.Fatalf("Filter(degraded) = %v", ids)
+	}
+	if _, err := json.Marshal(report); err != nil {
+		t.Fatal(err)
+	}
+
+	// A per-object password callback unlocks the object with a different password
+	report, err = s.ScrubFunc(func(manifestID string) (string, error) {
+		if manifestID == other {
+			return opts.Password, nil
+		}
+		return "", errors.New("unknown object")
+	}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, object := range report.Objects {
+		wantStatus := ObjectError
+		if object.ManifestID == other {
+			wantStatus = ObjectHealthy
+		}
+		if object.Status != wantStatus {
+			t.Errorf("%s: status %s, want %s", object.ManifestID, object.Status, wantStatus)
+		}
+	}
+}
+
+func TestStartScrubber(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, s, testOptions(), 3000)
+	opts := testOptions()
+	opts.Password = "another password"
+	other, _ := encryptTestFile(t, s, opts, 100)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reports := s.StartScrubber(ctx, 10*time.Millisecond, func(id string) (string, bool) {
+		return testPassword, id == manifestID
+	})
+	status := func(report ScrubReport, id string) ObjectStatus {
+		for _, object := range report.Objects {
+			if object.ManifestID == id {
+				return object.Status
+			}
+		}
+		return ""
+	}
+
+	first := <-reports
+	if status(first, manifestID) != ObjectHealthy || status(first, other) != ObjectError {
+		t.Fatalf("first report: %+v", first)
+	}
+	// A later pass notices the damage
+	if err := os.Remove(shardPath(s, manifestID, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(10 * time.Second)
+	for degraded := false; !degraded; {
+		select {
+		case report := <-reports:
+			degraded = status(report, manifestID) == ObjectDegraded
+		case <-deadline:
+			t.Fatal("no report shows the damaged object")
+		}
+	}
+
+	cancel()
+	for range reports {
+	}
+}
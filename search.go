@@ -0,0 +1,66 @@
+package secstorage
+
+import (
+	"crypto/hmac"
+	"strings"
+)
+
+// nameTag 计算文件名的盲索引标签：以 searchKey 为密钥，对小写文件名计算 HMAC-SHA256。
+// 没有 searchKey 的人无法从标签反推出文件名，也无法对文件名做字典攻击。
+func nameTag(searchKey []byte, name string) []byte {
+	return sign([]byte(strings.ToLower(name)), searchKey)
+}
+
+// FindByName 返回原始文件名与 name 相同（不区分大小写）且能用 password 解密的所有清单 ID。
+//
+// 未配置 SearchKey 时，它需要为每个清单派生密钥并解密文件名，代价与清单数量成正比。
+// 配置了 SearchKey 后，加密时会在清单和索引中记录文件名的盲索引标签，
+// 查找时只需对标签匹配的候选清单派生密钥确认，从而避免解密每一个清单。
+func (s *Syncer) FindByName(name, password string) ([]string, error) {
+	var tag []byte
+	if len(s.SearchKey) > 0 {
+		tag = nameTag(s.SearchKey, name)
+	}
+
+	var entries []IndexEntry
+	var err error
+	if s.Index != nil {
+		entries, err = s.Index.List()
+	} else {
+		// Manifests that cannot be parsed cannot be opened either, so they never match
+		entries, _, err = s.scanManifests()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for _, entry := range entries {
+		if tag != nil && entry.NameTag != nil && !hmac.Equal(tag, entry.NameTag) {
+			continue
+		}
+		if s.manifestHasName(entry.ManifestID, name, password) {
+			matches = append(matches, entry.ManifestID)
+		}
+	}
+	return matches, nil
+}
+
+// manifestHasName 判断清单的原始文件名是否为 name。
+// 无法用 password 打开的清单（例如属于其他用户的文件）视为不匹配。
+func (s *Syncer) manifestHasName(manifestID, name, password string) bool {
+	manifest, key, err := s.openManifest(manifestID, password)
+	if err != nil {
+		return false
+	}
+	defer key.Destroy()
+
+	if len(manifest.EncryptedOrigFilename) == 0 {
+		return false
+	}
+	origFilename, err := decryptFilename(manifest, key)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(origFilename, name)
+}
@@ -0,0 +1,102 @@
+package secstorage
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// encryptNamedFile 加密一个名为 name 的小文件并返回 manifestID。
+func encryptNamedFile(t *testing.T, s *Syncer, name string, opts EncryptionOptions) string {
+	t.Helper()
+	path, _ := writeTestFile(t, t.TempDir(), name, 100)
+	manifestID, err := s.EncryptFile(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifestID
+}
+
+// assertFound 检查 FindByName 返回的 ID 与 want 相同（不计顺序）。
+func assertFound(t *testing.T, s *Syncer, name string, want ...string) {
+	t.Helper()
+	got, err := s.FindByName(name, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Fatalf("FindByName(%q) = %v, want %v", name, got, want)
+	}
+}
+
+func TestFindByName(t *testing.T) {
+	s := newTestSyncer(t)
+	match := encryptNamedFile(t, s, "Report.PDF", testOptions())
+	encryptNamedFile(t, s, "other.txt", testOptions())
+
+	// A manifest that the password cannot open is not a match, even with the same name
+	otherUser := testOptions()
+	otherUser.Password = "someone else"
+	encryptNamedFile(t, s, "report.pdf", otherUser)
+
+	assertFound(t, s, "report.pdf", match)
+	assertFound(t, s, "REPORT.pdf", match)
+	assertFound(t, s, "missing.txt")
+}
+
+func TestFindByNameSkipsMismatchedTags(t *testing.T) {
+	s := newTestSyncer(t)
+	untagged := encryptNamedFile(t, s, "a.txt", testOptions())
+	s.SearchKey = []byte("old search key")
+	oldTag := encryptNamedFile(t, s, "a.txt", testOptions())
+	s.SearchKey = []byte("new search key")
+	tagged := encryptNamedFile(t, s, "A.TXT", testOptions())
+	encryptNamedFile(t, s, "b.txt", testOptions())
+
+	manifest, err := s.ReadManifest(tagged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.NameTag) == 0 {
+		t.Fatal("no name tag recorded with a SearchKey set")
+	}
+
+	// The manifest tagged under the old key would decrypt to a matching name, but its tag rules it out
+	// before any key is derived; untagged manifests are still checked by decrypting their names.
+	assertFound(t, s, "a.txt", untagged, tagged)
+	s.SearchKey = []byte("old search key")
+	assertFound(t, s, "a.txt", untagged, oldTag)
+}
+
+func TestFindByNameUsesIndex(t *testing.T) {
+	s := newTestSyncer(t)
+	s.SearchKey = []byte("search key")
+	unindexed := encryptNamedFile(t, s, "a.txt", testOptions())
+
+	index, err := NewFileIndex(filepath.Join(t.TempDir(), "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Index = index
+	indexed := encryptNamedFile(t, s, "a.txt", testOptions())
+	encryptNamedFile(t, s, "b.txt", testOptions())
+
+	entries, err := index.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if len(entry.NameTag) == 0 {
+			t.Fatalf("index entry %s has no name tag", entry.ManifestID)
+		}
+	}
+
+	// Only the index is consulted, so the object written before it existed is not found
+	assertFound(t, s, "a.txt", indexed)
+	if err := s.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	assertFound(t, s, "a.txt", indexed, unindexed)
+}
@@ -0,0 +1,143 @@
+package secstorage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+const (
+	// minShardPartBytes 是 MaxShardBytes 允许的最小值。
+	minShardPartBytes = 64
+	// maxShardParts 是一个分片最多可以拆分成的部分数，避免过小的上限产生海量对象。
+	maxShardParts = 1024
+	// maxCipherOverhead 是所有受支持算法中最大的加密开销，用于估算块的最大密文大小。
+	maxCipherOverhead = XNonceSize + TagOverhead
+)
+
+// shardPartSuffix 返回分片被拆分后第 k 部分追加在分片文件名后的后缀，完整文件名形如 chunk_0_shard_1.dat.part2。
+func shardPartSuffix(k int) string {
+	return fmt.Sprintf(".part%d", k)
+}
+
+// shardPartCount 返回大小为 size 的分片在上限 maxBytes 下被拆分成的部分数；maxBytes 为 0 表示不拆分。
+func shardPartCount(size, maxBytes int) int {
+	if maxBytes <= 0 || size <= maxBytes {
+		return 1
+	}
+	return (size + maxBytes - 1) / maxBytes
+}
+
+// shardPartNames 返回大小为 size 的分片 name 实际存储时使用的文件名：不需要拆分时就是 name 本身。
+func shardPartNames(name string, size, maxBytes int) []string {
+	count := shardPartCount(size, maxBytes)
+	if count == 1 {
+		return []string{name}
+	}
+	names := make([]string, count)
+	for k := range names {
+		names[k] = name + shardPartSuffix(k)
+	}
+	return names
+}
+
+// shardSizeFor 返回密文大小为 encryptedSize 的块的每个分片的大小，与 reedsolomon 的 Split 一致。
+func shardSizeFor(encryptedSize, dataShards, parityShards int) int {
+	if parityShards == 0 {
+		return encryptedSize
+	}
+	return (encryptedSize + dataShards - 1) / dataShards
+}
+
+// validateShardParts 检查分片在上限 maxBytes 下的部分数不超过 maxShardParts。
+func validateShardParts(shardSize, maxBytes int) error {
+	if count := shardPartCount(shardSize, maxBytes); count > maxShardParts {
+		return fmt.Errorf("a %d-byte shard would be split into %d parts, at most %d are supported", shardSize, count, maxShardParts)
+	}
+	return nil
+}
+
+// defaultMaxEncryptedChunkBytes 是 Syncer.MaxEncryptedChunkBytes 为 0 时单个加密块的大小上限。
+const defaultMaxEncryptedChunkBytes = 1 << 30
+
+// checkChunkSize 检查清单记录的第 i 个块的大小不超过 Syncer.MaxEncryptedChunkBytes，应在按该大小读取或分配之前调用。
+func (s *Syncer) checkChunkSize(m *Manifest, i int) error {
+	limit := s.MaxEncryptedChunkBytes
+	if limit <= 0 {
+		limit = defaultMaxEncryptedChunkBytes
+	}
+	if size := m.EncryptedChunkSizes[i]; size > limit {
+		return fmt.Errorf("chunk %d records %d bytes, more than the limit of %d", i, size, limit)
+	}
+	return nil
+}
+
+// shardSize 返回第 i 个块每个分片的大小。
+func (m *Manifest) shardSize(i int) int {
+	return shardSizeFor(m.EncryptedChunkSizes[i], m.DataShards, m.ParityShards)
+}
+
+// storageNames 返回第 i 个块在后端中的所有文件名（相对于清单目录），包括拆分后的各个部分。
+func (m *Manifest) storageNames(i int) []string {
+	var names []string
+	for _, suffix := range m.chunkSuffixes(i) {
+		names = append(names, shardPartNames(m.ChunkPaths[i]+suffix, m.shardSize(i), m.MaxShardBytes)...)
+	}
+	return names
+}
+
+// putShard 将分片 name 写入后端，超过 maxBytes 时拆分为多个部分分别写入。transform 不为 nil 时每个部分写入前先经过它的变换。
+func (s *Syncer) putShard(ctx context.Context, manifestID, name string, data []byte, maxBytes int, transform ShardTransform) error {
+	put := func(key string, part []byte) error {
+		if transform != nil {
+			var err error
+			if part, err = transform.Apply(part); err != nil {
+				return fmt.Errorf("failed to transform shard: %w", err)
+			}
+		}
+		return s.backend().Put(ctx, key, part)
+	}
+	if shardPartCount(len(data), maxBytes) == 1 {
+		return put(shardKey(manifestID, name), data)
+	}
+	for k, partName := range shardPartNames(name, len(data), maxBytes) {
+		part := data
+		if maxBytes > 0 && len(data) > maxBytes {
+			part = data[k*maxBytes : min((k+1)*maxBytes, len(data))]
+		}
+		if err := put(shardKey(manifestID, partName), part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getShard 读取大小为 size 的分片 name，必要时读取各个部分并拼接。任何一个部分读取失败都视为整个分片读取失败。
+// transform 不为 nil 时每个部分读取后先还原它的变换。
+func (s *Syncer) getShard(ctx context.Context, manifestID, name string, size, maxBytes int, transform ShardTransform) ([]byte, error) {
+	if shardPartCount(size, maxBytes) == 1 {
+		return s.getTransformed(ctx, shardKey(manifestID, name), transform)
+	}
+	var data bytes.Buffer
+	data.Grow(size)
+	for _, partName := range shardPartNames(name, size, maxBytes) {
+		part, err := s.getTransformed(ctx, shardKey(manifestID, partName), transform)
+		if err != nil {
+			return nil, err
+		}
+		data.Write(part)
+	}
+	return data.Bytes(), nil
+}
+
+// getTransformed 从后端读取 key，并在 transform 不为 nil 时还原它的变换。
+func (s *Syncer) getTransformed(ctx context.Context, key string, transform ShardTransform) ([]byte, error) {
+	data, err := s.backend().Get(ctx, key)
+	if err != nil || transform == nil {
+		return data, err
+	}
+	if data, err = transform.Invert(data); err != nil {
+		return nil, fmt.Errorf("failed to invert shard transform: %w", err)
+	}
+	return data, nil
+}
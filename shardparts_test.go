@@ -0,0 +1,148 @@
+package secstorage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMaxShardBytesSplitsShards(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.MaxShardBytes = minShardPartBytes
+	manifestID, data := encryptTestFile(t, s, opts, 3000)
+
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.MaxShardBytes != opts.MaxShardBytes {
+		t.Fatalf("manifest records max shard size %d, want %d", manifest.MaxShardBytes, opts.MaxShardBytes)
+	}
+	parts, err := filepath.Glob(shardPath(s, manifestID, 0, 0) + ".part*")
+	if err != nil || len(parts) < 2 {
+		t.Fatalf("shard was split into %d parts, want several", len(parts))
+	}
+	for _, part := range parts {
+		info, err := os.Stat(part)
+		if err != nil || info.Size() > int64(opts.MaxShardBytes) {
+			t.Fatalf("%s exceeds the cap: %v", part, err)
+		}
+	}
+	if _, err := os.Stat(shardPath(s, manifestID, 0, 0)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("unsplit shard was written too: %v", err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	// Losing one part loses its shard, which the erasure code reconstructs
+	if err := os.Remove(parts[1]); err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	var archive bytes.Buffer
+	if err := s.ExportArchive(manifestID, &archive); err != nil {
+		t.Fatal(err)
+	}
+	dst := newTestSyncer(t)
+	if _, err := dst.ImportArchive(&archive); err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, dst, manifestID, testPassword, data)
+
+	if err := s.DeleteManifest(manifestID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(s.StorageDir, manifestID)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("DeleteManifest left files behind: %v", err)
+	}
+}
+
+func TestMaxShardBytesWithoutParity(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.ParityShards = 0
+	opts.MaxShardBytes = 100
+	manifestID, data := encryptTestFile(t, s, opts, 3000)
+	if _, err := os.Stat(filepath.Join(s.StorageDir, manifestID, "chunk_0"+plainChunkSuffix+shardPartSuffix(0))); err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
+
+func TestMaxShardBytesValidation(t *testing.T) {
+	s := newTestSyncer(t)
+	path, _ := writeTestFile(t, t.TempDir(), "input.bin", 100)
+	for _, maxBytes := range []int{-1, minShardPartBytes - 1} {
+		opts := testOptions()
+		opts.MaxShardBytes = maxBytes
+		if _, err := s.EncryptFile(path, opts); err == nil {
+			t.Errorf("MaxShardBytes %d accepted", maxBytes)
+		}
+	}
+	// 64-byte parts of 16MB chunks would need far too many objects
+	opts := testOptions()
+	opts.ChunkSizeKB = maxChunkSizeKB
+	opts.MaxShardBytes = minShardPartBytes
+	if _, err := s.EncryptFile(path, opts); err == nil {
+		t.Error("MaxShardBytes producing too many parts accepted")
+	}
+}
+
+// rewriteChunkSize 把 manifestID 第 i 个块记录的加密大小改为 size 并重新签名清单。
+func rewriteChunkSize(t *testing.T, s *Syncer, manifestID string, i, size int) {
+	t.Helper()
+	manifest, key, err := s.OpenManifest(manifestID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+	manifest.EncryptedChunkSizes[i] = size
+	if err := s.WriteManifest(manifestID, manifest, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHugeEncryptedChunkSizeRejected(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, s, testOptions(), 3000)
+	rewriteChunkSize(t, s, manifestID, 0, 1<<40)
+
+	err := s.DecryptFile(manifestID, t.TempDir(), testPassword)
+	if err == nil || !strings.Contains(err.Error(), "more than the limit") {
+		t.Fatalf("got %v, want the chunk size limit error", err)
+	}
+	if report, err := s.VerifyManifest(manifestID, testPassword, 1); err != nil || len(report.Unrecoverable) != 1 {
+		t.Fatalf("got %+v, %v; want chunk 0 unrecoverable", report, err)
+	}
+	if _, err := s.ChunkHealth(manifestID, 0); err == nil {
+		t.Fatal("ChunkHealth accepted a huge chunk size")
+	}
+
+	// The limit is configurable
+	s.MaxEncryptedChunkBytes = 1024
+	manifestID, _ = encryptTestFile(t, s, testOptions(), 3000)
+	if err := s.DecryptFile(manifestID, t.TempDir(), testPassword); err == nil {
+		t.Fatal("expected chunks above MaxEncryptedChunkBytes to be rejected")
+	}
+}
+
+func TestEncryptedChunkSizeMustMatchShards(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.ParityShards = 0
+	manifestID, _ := encryptTestFile(t, s, opts, 3000)
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewriteChunkSize(t, s, manifestID, 0, manifest.EncryptedChunkSizes[0]+4096)
+
+	err = s.DecryptFile(manifestID, t.TempDir(), testPassword)
+	if err == nil || !strings.Contains(err.Error(), "but the manifest records") {
+		t.Fatalf("got %v, want a chunk size mismatch", err)
+	}
+}
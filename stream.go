@@ -0,0 +1,114 @@
+package secstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// StdoutPath 作为 DecryptFile 的 outputPath 时表示把解密内容写入标准输出，而不是创建文件。
+const StdoutPath = "-"
+
+// EncryptReader 加密 r 的全部内容（例如 os.Stdin），与 EncryptFile 一样把分片和清单写入存储，
+// 但不需要源文件，也不会在本地创建任何临时文件，适合在管道中使用。
+// origName 作为原始文件名保存在清单中；它为空时必须设置 opts.OmitFilename。
+// 续传（opts.ResumeManifestID）要求 r 重新提供与中断前完全相同的内容，否则返回错误。
+func (s *Syncer) EncryptReader(ctx context.Context, r io.Reader, origName string, opts EncryptionOptions) (string, error) {
+	return s.EncryptReaderN(ctx, r, -1, origName, opts)
+}
+
+// EncryptReaderN 与 EncryptReader 相同，但调用方提供 r 的总字节数 size（未知时为 -1），
+// 使 opts.Progress 能够报告真实的百分比。实际读取的字节数与 size 不同时只通过 Syncer.Logger 记录一条警告，加密仍然成功。
+func (s *Syncer) EncryptReaderN(ctx context.Context, r io.Reader, size int64, origName string, opts EncryptionOptions) (string, error) {
+	if origName == "" && !opts.OmitFilename {
+		return "", errors.New("an original filename is required unless OmitFilename is set")
+	}
+	return s.encryptReader(ctx, r, size, origName, nil, nil, opts)
+}
+
+// CreateEncrypted 返回一个写入端，写入的内容按块边界缓冲、加密、纠删编码后立即写入存储，
+// 适合逐步产生数据、事先没有文件也没有完整 Reader 的调用方。password 覆盖 opts.Password。
+// manifestID 在写入任何数据之前就已分配；Close 写入最后一个块并保存清单，返回加密的结果，
+// 分块器中尚未到达块边界的明文缓冲区此时被擦除。
+//
+// 写入失败后，之后的 Write 和 Close 都返回同一个错误；与 EncryptFile 一样，
+// 可以把 manifestID 设置为 opts.ResumeManifestID 并重新写入完全相同的内容以续传。
+// 调用方必须调用 Close，否则加密所用的 goroutine 和文件密钥不会被释放。
+func (s *Syncer) CreateEncrypted(origName, password string, opts EncryptionOptions) (w io.WriteCloser, manifestID string, err error) {
+	if origName == "" && !opts.OmitFilename {
+		return nil, "", errors.New("an original filename is required unless OmitFilename is set")
+	}
+	opts.Password = password
+	upload, err := s.startUpload(opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pr, pw := io.Pipe()
+	ew := &encryptWriter{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(ew.done)
+		_, ew.err = s.encryptUpload(context.Background(), upload, pr, -1, origName, nil, nil, opts)
+		// Unblock a pending Write when the upload stops early
+		pr.CloseWithError(ew.err)
+	}()
+	return ew, upload.manifestID, nil
+}
+
+// encryptWriter 是 CreateEncrypted 返回的写入端，写入的数据经管道交给后台的 encryptUpload。
+type encryptWriter struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+	// err 是 encryptUpload 的结果，done 关闭后才能读取。
+	err error
+}
+
+// Write 在后台读完 p 之前阻塞；加密失败后返回其错误，Close 之后返回 io.ErrClosedPipe。
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close 结束写入，等待最后一个块和清单写完并返回加密的结果。重复调用返回相同的结果。
+func (w *encryptWriter) Close() error {
+	w.pw.Close()
+	<-w.done
+	return w.err
+}
+
+// DecryptToWriter 解密 manifestID 对应的文件并按顺序写入 w，返回文件的自定义元数据（没有时为 nil）。
+// 它不创建任何文件，因此不会恢复文件名、权限或扩展属性；符号链接清单没有内容可写，会返回错误。
+//
+// 写出的同时计算完整内容的 SHA-256，结束时与加密时记录的哈希比较，不一致则返回 ErrContentHash（旧清单没有记录，不做检查）。
+// 与 DecryptFile 不同，内容是边解密边写出的：任何块解密失败时，w 中可能已经写入了前面的块；
+// 返回 ErrContentHash 时 w 甚至已经收到了全部（可能已损坏的）内容。调用方应在收到任何错误后丢弃已写出的内容。
+func (s *Syncer) DecryptToWriter(ctx context.Context, manifestID, password string, w io.Writer) (map[string]string, error) {
+	report, err := s.decryptToWriter(ctx, manifestID, password, w)
+	return report.Metadata, err
+}
+
+// decryptToWriter 是 DecryptToWriter 的实现，返回包括元数据在内的解密报告。
+func (s *Syncer) decryptToWriter(ctx context.Context, manifestID, password string, w io.Writer) (DecryptReport, error) {
+	defer func(start time.Time) { s.metrics().ObserveDecryptDuration(time.Since(start)) }(time.Now())
+
+	manifest, key, err := s.openManifest(manifestID, password)
+	if err != nil {
+		return DecryptReport{}, err
+	}
+	defer key.Destroy()
+	if _, err := s.limitConcurrency(manifest, 1); err != nil {
+		return DecryptReport{}, err
+	}
+	if len(manifest.EncryptedLinkTarget) > 0 {
+		return DecryptReport{}, fmt.Errorf("manifest %s is a symbolic link and has no content to write", manifestID)
+	}
+	var report DecryptReport
+	if report.Metadata, err = decryptMetadata(manifest, key); err != nil {
+		return DecryptReport{}, err
+	}
+	if err := s.decryptChunks(ctx, manifestID, manifest, key, w, &report); err != nil {
+		return DecryptReport{}, err
+	}
+	return report, nil
+}
@@ -0,0 +1,225 @@
+package secstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptReaderDecryptToWriter(t *testing.T) {
+	s := newTestSyncer(t)
+	data := make([]byte, 5000)
+	rand.Read(data)
+	opts := testOptions()
+	opts.Metadata = map[string]string{"source": "pipe"}
+
+	manifestID, err := s.EncryptReader(context.Background(), bytes.NewReader(data), "piped.bin", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	metadata, err := s.DecryptToWriter(context.Background(), manifestID, testPassword, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("decrypted content differs")
+	}
+	if metadata["source"] != "pipe" {
+		t.Fatalf("got metadata %v", metadata)
+	}
+
+	// The caller-supplied name is what DecryptFile restores
+	outputDir := t.TempDir()
+	if err := s.DecryptFile(manifestID, outputDir, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(outputDir, "piped.bin")); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("restored piped.bin differs: %v", err)
+	}
+}
+
+func TestEncryptReaderRequiresName(t *testing.T) {
+	s := newTestSyncer(t)
+	if _, err := s.EncryptReader(context.Background(), bytes.NewReader([]byte("hello")), "", testOptions()); err == nil {
+		t.Fatal("expected an error without an original filename")
+	}
+	opts := testOptions()
+	opts.OmitFilename = true
+	if _, err := s.EncryptReader(context.Background(), bytes.NewReader([]byte("hello")), "", opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecryptFileToStdout(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 3000)
+
+	dir := t.TempDir()
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	orig := os.Stdout
+	os.Stdout = stdout
+	defer func() { os.Stdout = orig }()
+
+	wd, _ := os.Getwd()
+	if err := s.DecryptFile(manifestID, StdoutPath, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = orig
+	got, err := os.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("stdout content differs")
+	}
+	if _, err := os.Stat(filepath.Join(wd, StdoutPath)); !os.IsNotExist(err) {
+		t.Fatalf("DecryptFile created a file named %q: %v", StdoutPath, err)
+	}
+}
+
+func TestEncryptReaderNProgress(t *testing.T) {
+	s := newTestSyncer(t)
+	data := make([]byte, 8000)
+	rand.Read(data)
+	opts := testOptions()
+	var calls [][2]int64
+	opts.Progress = func(done, total int64) { calls = append(calls, [2]int64{done, total}) }
+
+	if _, err := s.EncryptReaderN(context.Background(), bytes.NewReader(data), int64(len(data)), "input.bin", opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) < 2 {
+		t.Fatalf("got %d progress calls, want one per chunk", len(calls))
+	}
+	for i, call := range calls {
+		if call[1] != int64(len(data)) || (i > 0 && call[0] <= calls[i-1][0]) {
+			t.Fatalf("progress call %d = %v", i, call)
+		}
+	}
+	if last := calls[len(calls)-1]; last[0] != int64(len(data)) {
+		t.Fatalf("final progress %v, want %d done", last, len(data))
+	}
+}
+
+func TestEncryptReaderNSizeMismatch(t *testing.T) {
+	s := newTestSyncer(t)
+	var logs bytes.Buffer
+	s.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+	opts := testOptions()
+	var last [2]int64
+	opts.Progress = func(done, total int64) { last = [2]int64{done, total} }
+
+	// Declaring too little never reports more than 100%
+	data := make([]byte, 3000)
+	manifestID, err := s.EncryptReaderN(context.Background(), bytes.NewReader(data), 1000, "input.bin", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != [2]int64{3000, 3000} {
+		t.Fatalf("final progress %v", last)
+	}
+	if !strings.Contains(logs.String(), "level=WARN") || !strings.Contains(logs.String(), manifestID) {
+		t.Fatalf("expected a warning naming the manifest, got %q", logs.String())
+	}
+
+	// An unknown size is reported as -1 and is not a mismatch
+	logs.Reset()
+	if _, err := s.EncryptReader(context.Background(), bytes.NewReader(data), "input.bin", opts); err != nil {
+		t.Fatal(err)
+	}
+	if last[1] != -1 || logs.Len() != 0 {
+		t.Fatalf("final progress %v, logs %q", last, logs.String())
+	}
+}
+
+func TestCreateEncrypted(t *testing.T) {
+	s := newTestSyncer(t)
+	data := make([]byte, 7000)
+	rand.Read(data)
+	opts := testOptions()
+	opts.Password = "ignored"
+
+	w, manifestID, err := s.CreateEncrypted("generated.bin", testPassword, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Write in pieces that do not line up with chunk boundaries
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 333)
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if _, err := s.ReadManifest(manifestID); err == nil {
+		t.Fatal("manifest saved before Close")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	if _, err := w.Write([]byte("late")); err == nil {
+		t.Fatal("expected an error writing after Close")
+	}
+
+	outputDir := t.TempDir()
+	if err := s.DecryptFile(manifestID, outputDir, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(outputDir, "generated.bin")); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("restored generated.bin differs: %v", err)
+	}
+}
+
+func TestCreateEncryptedFailure(t *testing.T) {
+	s := newTestSyncer(t)
+	s.Backend = &failingPutBackend{Backend: s.backend(), failKey: "/chunk_1_"}
+	w, manifestID, err := s.CreateEncrypted("generated.bin", testPassword, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 8000)
+	rand.Read(data)
+	_, writeErr := w.Write(data)
+	closeErr := w.Close()
+	if writeErr == nil && closeErr == nil {
+		t.Fatal("expected the failed upload to be reported")
+	}
+	if closeErr == nil {
+		t.Fatal("Close succeeded after a failed upload")
+	}
+	if _, err := s.ReadManifest(manifestID); err == nil {
+		t.Fatal("manifest saved for a failed upload")
+	}
+
+	if _, _, err := s.CreateEncrypted("", testPassword, testOptions()); err == nil {
+		t.Fatal("expected an error without an original filename")
+	}
+}
+
+func TestWipingReader(t *testing.T) {
+	r := &wipingReader{r: strings.NewReader("secret plaintext")}
+	buf := make([]byte, 32)
+	for range 2 {
+		r.Read(buf[:8])
+	}
+	if len(r.bufs) != 1 {
+		t.Fatalf("recorded %d buffers, want 1", len(r.bufs))
+	}
+	r.wipe()
+	if !bytes.Equal(buf, make([]byte, 32)) {
+		t.Fatalf("buffer not wiped: %q", buf)
+	}
+}
@@ -2,17 +2,31 @@ package secstorage
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/awnumar/memguard"
 	"github.com/klauspost/reedsolomon"
+	"github.com/restic/chunker"
 )
 
 // EncryptionOptions 封装了加密操作所需的所有参数。
+// ParityShards 为 0 时将跳过纠删码，每个加密块作为单个文件存储，DataShards 会被忽略。
+// 零值的分块大小和 Argon2 参数都不可用，调用方应从 DefaultEncryptionOptions 开始再按需修改。
 type EncryptionOptions struct {
 	Password      string
 	DataShards    int
@@ -21,6 +35,212 @@ type EncryptionOptions struct {
 	Argon2Time    uint32
 	Argon2Memory  uint32
 	Argon2Threads uint8
+	// OmitFilename 为 true 时不在清单中保存原始文件名（即使是加密形式），以减少元数据泄露。
+	// 此类文件解密时，DecryptFile 的 outputPath 必须是完整的目标文件路径。
+	OmitFilename bool
+	// KeyWrapCipher 是包装数据密钥和加密文件名所用的算法，为空时使用 AES-256-GCM。
+	// 对于块数量极多的文件，可选用 CipherXChaCha20Poly1305 以消除随机 nonce 碰撞的风险。
+	KeyWrapCipher CipherAlgorithm
+	// AdditionalPasswords 列出除 Password 之外同样可以解密该文件的密码。
+	// 每个密码都会使用独立的盐值（设置 SharedSalt 时除外）包装同一个文件密钥，之后也可以通过接收者管理方法增删。
+	AdditionalPasswords []string
+	// PasswordPolicy 不为 nil 时，加密在派生密钥和写入任何数据之前用它逐个检查 Password 和 AdditionalPasswords，
+	// 返回错误即拒绝加密。默认不检查。MinPasswordEntropy 提供了基于长度和字符类别的内置策略。
+	PasswordPolicy func(password string) error
+	// KeyWrapper 不为 nil 时，文件密钥还会由它包装（例如交给云 KMS）并保存在清单中，
+	// 之后配置了相应 Syncer.KeyWrapper 的一方可以用空密码解密，无需知道任何密码。
+	// Password 为空时不创建密码接收者，文件只能通过 KeyWrapper 解密（AdditionalPasswords 仍然有效）。
+	// 不能与 Deterministic 同时使用，EncryptToShards 也不支持。
+	KeyWrapper KeyWrapper
+	// AAD 不为空时，文件名和每个块的数据密钥以它为关联数据加密，把密文绑定到调用方的上下文（例如租户 ID 或文档 ID）。
+	// 清单只记录它以文件密钥计算的 HMAC 而不保存其本身，解密时 Syncer.AAD 必须与之相同，否则返回 ErrAADMismatch。
+	// 续传时必须与中断前相同，EncryptToShards 不支持。
+	AAD []byte
+	// RecoveryRecords 为 true 时，每个块旁会额外写入一个签名的恢复记录文件，
+	// 以便在 manifest.json 丢失后通过 RebuildManifest 重建清单。详见 RebuildManifest。
+	RecoveryRecords bool
+	// ResumeManifestID 不为空时，EncryptFile 将继续该清单中断的上传：已记录为上传完成的块会被跳过，
+	// 只上传剩余的块。源文件和影响分片布局的参数必须与中断前相同，接收者沿用中断前的设置。
+	ResumeManifestID string
+	// ManifestID 不为空时，新文件使用该 ID 而不是随机生成的 ID，便于调用方以自己的方案（例如内容哈希）命名对象，
+	// 使重复执行得到同一个逻辑对象。它必须符合 Syncer 配置的 ID 编码和长度，且不能与 ResumeManifestID 同时设置。
+	ManifestID string
+	// Overwrite 控制 ManifestID 已存在时的行为：为 false 时返回满足 errors.Is(err, os.ErrExist) 的错误；
+	// 为 true 时先通过 DeleteManifest 删除旧对象（包括未完成的上传）再加密，因此加密失败时旧对象也已不存在。
+	Overwrite bool
+	// MaxShardBytes 是单个分片文件的大小上限，为 0 时不限制。超过上限的分片会被拆分为编号的多个部分
+	// （chunk_0_shard_1.dat.part0、.part1……）分别写入后端，读取时再拼接，纠删码的计算不受影响。
+	// 适用于限制单个对象大小的对象存储。上限记录在清单中，不能小于 64 字节，每个分片最多拆分为 1024 个部分。
+	MaxShardBytes int
+	// MaxBytesPerSec 限制加密时写入分片的速率（字节/秒，包括纠删码冗余），为 0 时不限速。
+	// 限速作用于每一次分片写入，对网络后端即限制上传带宽，使后台备份不会挤占前台负载的磁盘或网络。
+	MaxBytesPerSec int64
+	// Deterministic 为 true 时启用确定性加密，仅用于测试和内容寻址缓存等高级场景：
+	// 以相同的 ManifestID、密码、DeterministicSalt 和参数加密相同的文件，得到逐字节相同的分片和清单。
+	// 此时接收者盐值、文件密钥、数据密钥和所有 nonce 都由 HKDF 派生而不是随机生成，清单也不记录创建时间。
+	//
+	// 安全性代价：nonce 失去了随机性。数据密钥和 nonce 的派生输入包含明文，因此内容不同的块不会重用 nonce，
+	// 但相同的内容总是产生相同的密文，存储方可以据此判断两个对象（或两次备份中的同一个块）内容是否相同；
+	// 密码相同时，不同盐值的对象之间不共享密钥。除非确实需要可复现的输出，否则不要启用。
+	// 必须同时设置 ManifestID（或 ResumeManifestID）和 DeterministicSalt。
+	Deterministic bool
+	// DeterministicSalt 是确定性模式下派生接收者盐值所用的盐值，至少 16 字节，应当对每个用途随机生成一次后固定使用。
+	DeterministicSalt []byte
+	// SharedSalt 不为空时，所有接收者都使用这个盐值而不是为每个文件随机生成，至少 16 字节。
+	// 以同一 SharedSalt、同一密码和相同 Argon2id 参数加密的一组文件只需派生一次密钥，
+	// DecryptBatch 和 DecryptDirStream 会在整批文件之间复用它，省去每个文件一次的 Argon2 计算。
+	//
+	// 安全性代价：盐值不再唯一，攻击者对整组文件的每次密码猜测只需计算一次，整组的抗暴力破解能力等同于单个文件；
+	// 组内相同的密码还会得到相同的包装密钥，一个包装密钥泄露即危及整组文件。只应在同一批、同一密码的文件之间共享，
+	// 并为每一组随机生成新的盐值。不能与 Deterministic 同时使用。
+	SharedSalt []byte
+	// KDF 是从密码派生密钥的算法，为空时使用 Argon2id（由 Argon2Time 等参数控制）。
+	// 选择 KDFScrypt 时使用 ScryptN、ScryptR 和 ScryptP，为 0 的参数取默认值 N=32768、r=8、p=1。
+	// 所选算法及其参数记录在每个接收者中，解密时据此派生密钥。
+	KDF     KDFAlgorithm
+	ScryptN int
+	ScryptR int
+	ScryptP int
+	// PreserveXattrs 为 true 时，EncryptFile 读取文件的扩展属性（例如 macOS 的隔离标记、SELinux 标签），
+	// 加密后保存在清单中；解密时只有设置了 Syncer.RestoreMetadata 才会还原。仅支持 Linux 和 macOS，
+	// 其他平台或不支持扩展属性的文件系统上不保存任何属性。编码为 JSON 后不能超过 256KB。
+	PreserveXattrs bool
+	// PreserveOwner 为 true 时，EncryptFile 记录文件的 uid 和 gid，加密后保存在清单中；
+	// 解密时只有设置了 Syncer.RestoreMetadata 才会还原。仅支持 Unix 平台，其他平台上不保存。
+	PreserveOwner bool
+	// MemoryMap 为 true 时，EncryptFile、EncryptOpenFile 和 PlanEncryption 把普通文件以只读方式映射到内存后读取，
+	// 省去每次读取的系统调用和内核到用户空间的复制，对多次处理同一个大文件（例如先规划再加密）时页面缓存的复用也更直接。
+	// 平台或文件系统不支持映射时自动改用普通读取。映射期间文件被其他进程截短时加密返回错误。
+	MemoryMap bool
+	// MaxChunks 限制单个文件的块数，为 0 时不限制。块大小很小而文件很大时，分片文件的数量会急剧膨胀，
+	// 严重拖慢文件系统。块数超过上限时返回 ErrTooManyChunks，错误中给出保证满足上限的 ChunkSizeKB。
+	// EncryptFile 在写入任何数据之前根据文件大小检查块数的下限；实际块数取决于内容，
+	// 因此加密过程中达到上限时同样会中止，此前已上传的分片可以通过 DeleteManifest 清理。
+	// 可以先用 PlanEncryption 得到准确的块数。
+	MaxChunks int
+	// ContentHash 是计算完整内容哈希（解密时用于检查完整性）的算法，为空时使用 SHA-256。
+	// 对数 GB 的大文件，HashBLAKE2b 和 HashBLAKE3 在多数没有 SHA 指令扩展的 CPU 上快得多。所选算法记录在清单中。
+	ContentHash HashAlgorithm
+	// Progress 不为 nil 时，每个块写入存储（或续传时被跳过）后以已读取的明文字节数 done 和总字节数 total 调用它。
+	// EncryptFile 的 total 是文件大小；EncryptReaderN 使用调用方声明的大小，但不小于 done；EncryptReader 的 total 为 -1。
+	// 它在加密所在的 goroutine 中同步调用，应当尽快返回。
+	Progress func(done, total int64)
+	// Metadata 是附加到文件上的自定义键值对（例如标签、来源主机名），以加密形式保存在清单中，
+	// 可通过 GetMetadata 读取。编码为 JSON 后不能超过 64KB。
+	Metadata map[string]string
+}
+
+// maxTotalShards 是 Reed-Solomon 编码允许的最大分片总数。
+const maxTotalShards = 256
+
+// validate 检查与具体文件无关的加密参数，使无效的参数在创建清单目录之前就被拒绝。
+func (opts EncryptionOptions) validate() error {
+	if err := validateChunkSizeKB(opts.ChunkSizeKB); err != nil {
+		return err
+	}
+	if opts.ParityShards < 0 {
+		return fmt.Errorf("parity shards must not be negative, got %d", opts.ParityShards)
+	}
+	// Data shards are ignored without parity
+	if opts.ParityShards > 0 {
+		if opts.DataShards <= 0 {
+			return fmt.Errorf("data shards must be positive, got %d", opts.DataShards)
+		}
+		if total := opts.DataShards + opts.ParityShards; total > maxTotalShards {
+			return fmt.Errorf("at most %d shards are supported, got %d", maxTotalShards, total)
+		}
+	}
+	if opts.ManifestID != "" && opts.ResumeManifestID != "" {
+		return errors.New("ManifestID and ResumeManifestID cannot both be set")
+	}
+	if err := opts.validateDeterministic(); err != nil {
+		return err
+	}
+	if err := opts.validateSharedSalt(); err != nil {
+		return err
+	}
+	if err := opts.validateKeyWrapper(); err != nil {
+		return err
+	}
+	if err := opts.checkPasswordPolicy(); err != nil {
+		return err
+	}
+	if _, err := newContentHash(opts.ContentHash); err != nil {
+		return err
+	}
+	if opts.MaxChunks < 0 {
+		return fmt.Errorf("max chunks must not be negative, got %d", opts.MaxChunks)
+	}
+	if opts.MaxBytesPerSec < 0 {
+		return fmt.Errorf("rate limit must not be negative, got %d", opts.MaxBytesPerSec)
+	}
+	if opts.MaxShardBytes != 0 {
+		if opts.MaxShardBytes < minShardPartBytes {
+			return fmt.Errorf("max shard size must be at least %d bytes, got %d", minShardPartBytes, opts.MaxShardBytes)
+		}
+		// The chunker never produces chunks larger than twice the average size
+		maxShard := shardSizeFor(opts.ChunkSizeKB*2048+maxCipherOverhead, opts.DataShards, opts.ParityShards)
+		if err := validateShardParts(maxShard, opts.MaxShardBytes); err != nil {
+			return err
+		}
+	}
+	if _, err := opts.recipientParams(); err != nil {
+		return err
+	}
+	return validateCipher(opts.KeyWrapCipher)
+}
+
+// recipientParams 返回新接收者使用的 KDF 参数，未指定的 scrypt 参数取默认值。
+func (opts EncryptionOptions) recipientParams() (Recipient, error) {
+	switch opts.KDF {
+	case "", KDFArgon2id:
+		params := Recipient{Argon2Time: opts.Argon2Time, Argon2Memory: opts.Argon2Memory, Argon2Threads: opts.Argon2Threads}
+		return params, params.validate()
+	case KDFScrypt:
+		params := Recipient{KDF: KDFScrypt, ScryptN: opts.ScryptN, ScryptR: opts.ScryptR, ScryptP: opts.ScryptP}
+		if params.ScryptN == 0 {
+			params.ScryptN = defaultScryptN
+		}
+		if params.ScryptR == 0 {
+			params.ScryptR = defaultScryptR
+		}
+		if params.ScryptP == 0 {
+			params.ScryptP = defaultScryptP
+		}
+		return params, validateScryptParams(params.ScryptN, params.ScryptR, params.ScryptP)
+	default:
+		return Recipient{}, fmt.Errorf("unsupported key derivation function %q", opts.KDF)
+	}
+}
+
+// ErrShardIntegrity 表示某个块的分片丢失或校验失败。只有启用 Syncer.StrictIntegrity 时才会返回，
+// 否则这类块会通过纠删码自动重建。
+var ErrShardIntegrity = errors.New("shard missing or failed verification")
+
+// ErrManifestTampered 表示清单中按块索引的各个切片长度不一致，或某个块的分片数与纠删码参数不符，
+// 通常说明清单被手工修改或已损坏。这种清单在解析后立即被拒绝，不会等到验证签名或按块索引时才出错。
+var ErrManifestTampered = errors.New("manifest chunk lists are inconsistent")
+
+// ErrStorageReadOnly 表示 StorageDir 不可写，例如位于只读文件系统上或没有写权限。
+// EncryptFile 在派生密钥之前就会发现这种情况，不会白白进行耗时的 Argon2 计算。
+var ErrStorageReadOnly = errors.New("storage directory is not writable")
+
+// storageWriteErr 在 err 表示只读文件系统或没有写权限时为其附加 ErrStorageReadOnly。
+func storageWriteErr(err error) error {
+	if errors.Is(err, syscall.EROFS) || errors.Is(err, fs.ErrPermission) {
+		return fmt.Errorf("%w: %w", ErrStorageReadOnly, err)
+	}
+	return err
+}
+
+// checkWritable 通过创建并删除一个临时文件检查目录 dir 是否可写。
+func checkWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".probe-*")
+	if err != nil {
+		return storageWriteErr(fmt.Errorf("failed to write to %s: %w", dir, err))
+	}
+	file.Close()
+	return os.Remove(file.Name())
 }
 
 // SecureSyncer 定义了安全文件同步器的接口，提供了加密和解密文件的核心功能。
@@ -34,11 +254,135 @@ const (
 	defaultDirPerm = 0755
 	// defaultFilePerm 定义了创建文件时使用的默认权限。
 	defaultFilePerm = 0644
+	// plainChunkSuffix 是无奇偶校验模式下单文件加密块使用的后缀。
+	plainChunkSuffix = ".dat"
+	// maxManifestIDAttempts 是 manifestID 发生冲突时重新生成的最大尝试次数。
+	maxManifestIDAttempts = 5
+)
+
+const (
+	// manifestVersionLegacy 表示未记录版本号的旧清单，其数据块加密不带关联数据。
+	manifestVersionLegacy = 0
+	// manifestVersionChunkAAD 表示数据块加密以 manifestID 和块序号作为关联数据。
+	manifestVersionChunkAAD = 1
+	// manifestVersionRecipients 表示文件由随机文件密钥保护，该密钥为每个接收者分别包装。
+	manifestVersionRecipients = 2
+	// manifestVersionPaddedFilename 表示原始文件名在加密前被填充到固定的块大小。
+	manifestVersionPaddedFilename = 3
+	// manifestVersionShardSuffixes 表示所有块共用 ShardSuffixes 中的分片后缀，不再为每个块单独记录。
+	manifestVersionShardSuffixes = 4
+	// currentManifestVersion 是新建清单时写入的版本号。
+	currentManifestVersion = manifestVersionShardSuffixes
 )
 
 // Syncer 是 SecureSyncer 接口的具体实现。
+//
+// Syncer 可以被多个 goroutine 并发使用，前提是配置字段在开始使用后不再修改。
+// 并发的 EncryptFile 通过原子地创建目录来分配 manifestID，不会相互覆盖；
+// 修改同一清单的操作（AddRecipient、RemoveRecipient、WriteManifest 等）在同一个 Syncer 内相互排斥。
+// 多个进程或多个 Syncer 共享同一存储目录时，新建文件同样安全，但同时修改同一清单可能丢失其中一次修改。
 type Syncer struct {
 	StorageDir string
+	// Metrics 是可选的指标接收器，为 nil 时不上报任何指标。
+	Metrics Metrics
+	// Index 是可选的清单索引，设置后 EncryptFile 和 DeleteManifest 会同步更新它，
+	// ListManifests 也将直接读取索引而不再遍历存储目录。
+	Index Index
+	// Backend 是分片的存储后端，为 nil 时分片与清单一起保存在 StorageDir 中。
+	Backend Backend
+	// ManifestIDBytes 是新 manifestID 的随机字节数，为 0 时使用默认的 16 字节，不允许小于 16。
+	ManifestIDBytes int
+	// ManifestIDEncoding 是 manifestID 的文本编码，为空时使用十六进制。
+	// 读取清单时只接受符合当前配置的 ID。
+	ManifestIDEncoding ManifestIDEncoding
+	// SearchKey 是可选的盲索引密钥。设置后，加密时会记录文件名的 HMAC 标签，
+	// 使 FindByName 无需解密每个清单即可按文件名查找。它应当是一个独立保管的随机秘密。
+	SearchKey []byte
+	// AutoCipher 为 true 时，数据块的加密算法根据当前 CPU 自动选择：支持 AES 硬件加速时使用 AES-256-GCM，
+	// 否则使用 XChaCha20-Poly1305。所选算法记录在清单中，因此解密不受运行机器的影响。
+	AutoCipher bool
+	// Durable 为 true 时，EncryptFile 等写操作会在返回前对清单、恢复记录及其所在目录执行 fsync，
+	// 默认的本地后端也会对每个分片执行 fsync，因此成功返回即意味着数据在断电后不会丢失。
+	// 代价是每个分片至少多一次 fsync，在机械硬盘或网络文件系统上可能使加密速度下降一个数量级。
+	// 自定义 Backend 需要自行保证持久性，例如设置 LocalBackend 的 Durable 字段。
+	// 为 false 时，EncryptDir 和 Backup 等批量操作仍会在结束前对新建的清单目录及其父目录各执行一次 fsync，
+	// 使操作完成后崩溃不会丢失整个清单目录，但清单和分片的内容本身不保证落盘。
+	Durable bool
+	// StrictIntegrity 为 true 时，DecryptFile 遇到分片丢失或校验失败的块会返回 ErrShardIntegrity，
+	// 而不是通过纠删码透明地重建后继续，以便定期巡检能够及时发现存储退化。
+	// 默认情况下数据分片完好的块不会读取奇偶校验分片；启用后每个块的所有分片都会被读取并校验。
+	StrictIntegrity bool
+	// ReadCacheBytes 是 OpenDecrypted 返回的每个句柄最多缓存的明文字节数，为 0 时使用 8MB。
+	// 缓存至少保留最近读取的一个块；设为负数即只保留这一个块。
+	ReadCacheBytes int
+	// BufferPool 是可选的缓冲区池。设置后，解密时每个块的缓冲区取自池中并在用完后清零放回，
+	// 多次 EncryptFile 和 DecryptFile 之间也会复用块缓冲区，以降低大量小块时的分配和 GC 压力。
+	// 无论是否设置，同一次加密中的所有块都复用同一组缓冲区，因此自定义 Backend 的 Put 不能在返回后保留 data。
+	BufferPool *BufferPool
+	// RestoreMetadata 为 true 时，DecryptFile 把加密时以 PreserveXattrs 保存的扩展属性还原到输出文件上。
+	// 输出位置的文件系统不支持扩展属性时静默跳过；其他失败（例如没有权限设置 security.* 属性）会使解密失败。
+	// 以 PreserveOwner 保存的所有者在文件移动到位后还原；没有权限（通常需要 root）或平台不支持时
+	// 只通过 Logger 记录警告，文件保留为当前用户所有。
+	RestoreMetadata bool
+	// MemoryBudget 是解密和校验时在途数据允许占用的内存上限（字节），为 0 时不限制。
+	// 设置后，VerifyManifest 和 Scrub 会把并行的块数降低到估计内存不超过预算的水平；
+	// 连一个块都超出预算时，DecryptFile 和 VerifyManifest 直接返回 ErrMemoryBudget，而不是冒着内存耗尽的风险开始解密。
+	// 估计方法见 EstimateDecryptMemory。
+	MemoryBudget int64
+	// ShardTransform 是可选的分片变换，新文件的每个分片写入后端前都经过它的 Apply，读取后经过 Invert，
+	// 名称记录在清单中。为 nil 时分片原样写入；解密以变换写入的文件时必须配置同名的变换。
+	ShardTransform ShardTransform
+	// MaxEncryptedChunkBytes 是读取时接受的单个加密块的最大字节数，为 0 时使用 1GB。
+	// 清单中的块大小决定了分片大小和重建时的缓冲区大小，超过上限的块在读取任何分片之前即被拒绝，
+	// 以免被篡改或损坏的清单使进程分配巨大的内存。只有以极大的 ChunkSizeKB 加密的文件才需要调高它。
+	MaxEncryptedChunkBytes int
+	// KeyDeriver 是从密码派生密钥的 Argon2id 实现，为 nil 时使用 golang.org/x/crypto/argon2。
+	KeyDeriver KeyDeriver
+	// ManifestDirDepth 是清单目录按 manifestID 前缀分层的层数，为 0 时所有清单目录都直接位于 StorageDir 下。
+	// 每层取 ID 的 2 个字符，例如为 1 时清单保存在 StorageDir/ab/abcdef…/ 中，为 2 时保存在 StorageDir/ab/cd/abcdef…/ 中，
+	// 以免单个目录包含数万个条目而拖慢文件系统，最多 3 层。默认后端的分片随清单目录一起分层，自定义 Backend 的 key 不受影响。
+	// 从 0 改为非零值后，旧的扁平目录在首次被访问（读取、删除、续传等）时自动移动到分层位置，
+	// ListManifests 和 Reindex 也能找到尚未移动的目录；已经分层的存储不支持再修改层数。
+	ManifestDirDepth int
+	// TempDir 是 DecryptFile 存放解密中间文件的目录，为空时使用目标文件所在的目录。
+	// 目标位于较慢的网络挂载点时，可以把它设为本地快速磁盘上的目录：解密完成后，同一文件系统上直接重命名，
+	// 否则把文件复制到目标目录中的临时文件后再重命名，目标文件同样不会处于半写入状态。
+	// 该目录必须已存在且可写，DecryptFile 在派生密钥之前就会检查。
+	TempDir string
+	// BatchConcurrency 是 DecryptBatch 同时解密的文件数，为 0 时使用 runtime.GOMAXPROCS(0)。
+	// 每个需要派生密钥的文件都会占用 Argon2Memory 大小的内存，内存紧张时应调低。
+	BatchConcurrency int
+	// RSGoroutines 是一次纠删码编码或重建最多使用的 goroutine 数，为 0 时使用 reedsolomon 库的默认值（最多 384 个）。
+	// 库会按分片大小把计算拆分到多个 goroutine 上，因此默认情况下大分片已经能利用多个核心；
+	// 同时加密或解密很多文件时，可以设为 1 等较小的值，以免各文件的编码相互争抢 CPU。
+	RSGoroutines int
+	// KeyWrapper 用于解开以 EncryptionOptions.KeyWrapper 加密的文件的密钥。设置后，以空密码调用 DecryptFile 等方法时
+	// 通过它而不是密码获取文件密钥；密码不为空时仍使用密码。
+	KeyWrapper KeyWrapper
+	// AAD 是解密以 EncryptionOptions.AAD 加密的文件时提供的关联数据，必须与加密时相同；
+	// 解密没有绑定关联数据的文件时必须为空。不同上下文的文件可以各用一个 Syncer 访问同一存储目录。
+	AAD []byte
+	// Logger 接收加密和解密过程中不影响结果的警告，例如 EncryptReaderN 实际读取的字节数与声明的不同，
+	// 为 nil 时丢弃所有日志。
+	Logger *slog.Logger
+	// CompressManifest 为 true 时，写入的 manifest.json 使用 gzip 压缩，这可以显著缩小包含大量块的清单。
+	// 读取时根据 gzip 的魔数自动识别，因此压缩与未压缩的清单可以混合存放，该选项不影响读取。
+	CompressManifest bool
+
+	// manifestLocks 串行化对同一清单的读-改-写操作，清单按 ID 的哈希分配到固定数量的锁上。
+	manifestLocks [manifestLockStripes]sync.Mutex
+}
+
+// manifestLockStripes 是 Syncer.manifestLocks 中锁的数量。
+const manifestLockStripes = 64
+
+// lockManifest 锁定 manifestID 对应的清单，返回解锁函数。
+func (s *Syncer) lockManifest(manifestID string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(manifestID))
+	mu := &s.manifestLocks[h.Sum32()%manifestLockStripes]
+	mu.Lock()
+	return mu.Unlock
 }
 
 // NewSyncer 创建一个新的 Syncer 实例。
@@ -46,280 +390,1338 @@ func NewSyncer(storageDir string) *Syncer {
 	return &Syncer{StorageDir: storageDir}
 }
 
+// keyDeriver 返回 Syncer 配置的 KeyDeriver；未配置时返回默认的 Argon2id 实现。
+func (s *Syncer) keyDeriver() KeyDeriver {
+	if s.KeyDeriver == nil {
+		return argon2Deriver{}
+	}
+	return s.KeyDeriver
+}
+
+// chunkCipher 返回新文件的数据块加密算法。未启用 AutoCipher 时返回空值，即 AES-256-GCM。
+func (s *Syncer) chunkCipher() CipherAlgorithm {
+	if s.AutoCipher {
+		return preferredCipher()
+	}
+	return ""
+}
+
+// manifestFileName 是清单目录中清单文件的名称。
+const manifestFileName = "manifest.json"
+
 // getManifestPath 根据 manifestID 生成并返回 manifest.json 文件的完整路径。
 func (s *Syncer) getManifestPath(manifestID string) string {
-	return filepath.Join(s.StorageDir, manifestID, "manifest.json")
+	return filepath.Join(s.manifestDir(manifestID), manifestFileName)
+}
+
+// createManifestDir 生成一个新的 manifestID 并创建其目录。
+// 目录通过 os.Mkdir 原子地创建，如果目录已存在（即发生了 ID 冲突），会重新生成 ID，
+// 最多尝试 maxManifestIDAttempts 次，从而保证不会覆盖其他文件的分片。
+func (s *Syncer) createManifestDir() (string, error) {
+	if err := s.validateManifestDirDepth(); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(s.StorageDir, defaultDirPerm); err != nil {
+		return "", storageWriteErr(fmt.Errorf("failed to create storage directory: %w", err))
+	}
+
+	for attempt := 0; attempt < maxManifestIDAttempts; attempt++ {
+		manifestID, err := s.generateManifestID()
+		if err != nil {
+			return "", fmt.Errorf("failed to generate manifest ID: %w", err)
+		}
+
+		// A flat directory left over from before sharding was enabled still owns its ID
+		if s.ManifestDirDepth > 0 {
+			if _, err := os.Lstat(filepath.Join(s.StorageDir, manifestID)); err == nil {
+				continue
+			}
+		}
+		dir := s.manifestDir(manifestID)
+		if err := os.MkdirAll(filepath.Dir(dir), defaultDirPerm); err != nil {
+			return "", storageWriteErr(fmt.Errorf("failed to create manifest prefix directory: %w", err))
+		}
+		err = os.Mkdir(dir, defaultDirPerm)
+		if err == nil {
+			return manifestID, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", storageWriteErr(fmt.Errorf("failed to create output directory: %w", err))
+		}
+	}
+	return "", fmt.Errorf("failed to allocate a unique manifest ID after %d attempts", maxManifestIDAttempts)
+}
+
+// claimManifestDir 为调用方指定的 manifestID 创建目录。目录已存在时，overwrite 为 false 则返回
+// 满足 errors.Is(err, os.ErrExist) 的错误，否则先删除已有的对象再重新创建。
+// 目录中既没有清单也没有进度文件时（例如上传在写入进度之前就中断了），无从得知分片，直接删除整个目录。
+func (s *Syncer) claimManifestDir(manifestID string, overwrite bool) error {
+	if err := s.validateManifestID(manifestID); err != nil {
+		return err
+	}
+	if err := s.migrateManifestDir(manifestID); err != nil {
+		return err
+	}
+
+	dir := s.manifestDir(manifestID)
+	if err := os.MkdirAll(filepath.Dir(dir), defaultDirPerm); err != nil {
+		return storageWriteErr(fmt.Errorf("failed to create storage directory: %w", err))
+	}
+	err := os.Mkdir(dir, defaultDirPerm)
+	if err == nil || !errors.Is(err, os.ErrExist) {
+		return storageWriteErr(err)
+	}
+	if !overwrite {
+		return fmt.Errorf("manifest %s already exists: %w", manifestID, os.ErrExist)
+	}
+	if s.hasManifestOrProgress(manifestID) {
+		if err := s.DeleteManifest(manifestID); err != nil {
+			return fmt.Errorf("failed to replace manifest %s: %w", manifestID, err)
+		}
+	} else if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to replace manifest %s: %w", manifestID, err)
+	}
+	return os.Mkdir(dir, defaultDirPerm)
+}
+
+// hasManifestOrProgress 判断 manifestID 的目录中是否存在清单或上传进度文件。
+// 无法确定时返回 true，交给 DeleteManifest 报告具体错误。
+func (s *Syncer) hasManifestOrProgress(manifestID string) bool {
+	for _, path := range []string{s.getManifestPath(manifestID), s.progressPath(manifestID)} {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadManifest 读取并解析 manifestID 对应的清单，但不验证其签名。
+func (s *Syncer) loadManifest(manifestID string) (*Manifest, error) {
+	if err := s.validateManifestID(manifestID); err != nil {
+		return nil, err
+	}
+	if err := s.migrateManifestDir(manifestID); err != nil {
+		return nil, err
+	}
+
+	manifestPath := s.getManifestPath(manifestID)
+	manifestData, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest from %s: %w", manifestPath, err)
+	}
+
+	manifest, err := decodeManifest(manifestData)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateManifest(manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", manifestID, err)
+	}
+	return manifest, nil
+}
+
+// validateManifest 检查清单的结构是否自洽：各个按块索引的切片长度一致、分片数量与纠删码参数相符、
+// 块名和分片后缀都是不含路径分隔符的普通文件名。长度或分片数不一致时返回的错误包装了 ErrManifestTampered。
+// 清单在验证签名之前就会被用来定位和删除分片，因此这些检查不能依赖签名。
+func validateManifest(m *Manifest) error {
+	chunks := len(m.ChunkPaths)
+	suffixLists := chunks
+	if m.Version >= manifestVersionShardSuffixes {
+		suffixLists = 0
+	}
+	if len(m.ErasureCodeChunkSuffixes) != suffixLists || len(m.EncryptedDataKeys) != chunks || len(m.EncryptedChunkSizes) != chunks {
+		return fmt.Errorf("%w: %d paths, %d suffix lists, %d data keys, %d sizes", ErrManifestTampered,
+			chunks, len(m.ErasureCodeChunkSuffixes), len(m.EncryptedDataKeys), len(m.EncryptedChunkSizes))
+	}
+	if m.DataShards < 0 || m.ParityShards < 0 || (m.ParityShards > 0 && m.DataShards == 0) {
+		return fmt.Errorf("invalid shard counts %d+%d", m.DataShards, m.ParityShards)
+	}
+	shards := 1
+	if m.ParityShards > 0 {
+		shards = m.DataShards + m.ParityShards
+	}
+	if len(m.EncryptedLinkTarget) > 0 && chunks > 0 {
+		return fmt.Errorf("symbolic link manifest has %d chunks", chunks)
+	}
+	if _, err := newContentHash(m.ContentHashAlgorithm); err != nil {
+		return err
+	}
+	if err := validateManifestKDF(m); err != nil {
+		return err
+	}
+	if m.NonceSize != 0 && (m.NonceSize < minGCMNonceSize || m.NonceSize > maxGCMNonceSize) {
+		return fmt.Errorf("invalid nonce size %d", m.NonceSize)
+	}
+	if m.MaxShardBytes != 0 && m.MaxShardBytes < minShardPartBytes {
+		return fmt.Errorf("invalid max shard size %d", m.MaxShardBytes)
+	}
+	if err := validateShardLocations(m, shards); err != nil {
+		return err
+	}
+
+	for i, chunkPath := range m.ChunkPaths {
+		if err := validateStorageName(chunkPath); err != nil {
+			return fmt.Errorf("chunk %d: %w", i, err)
+		}
+		suffixes := m.chunkSuffixes(i)
+		if len(suffixes) != shards {
+			return fmt.Errorf("%w: chunk %d has %d shards, expected %d", ErrManifestTampered, i, len(suffixes), shards)
+		}
+		for _, suffix := range suffixes {
+			if err := validateStorageName(chunkPath + suffix); err != nil {
+				return fmt.Errorf("chunk %d: %w", i, err)
+			}
+		}
+		if m.EncryptedChunkSizes[i] < 0 {
+			return fmt.Errorf("chunk %d has negative size %d", i, m.EncryptedChunkSizes[i])
+		}
+		if err := validateShardParts(m.shardSize(i), m.MaxShardBytes); err != nil {
+			return fmt.Errorf("chunk %d: %w", i, err)
+		}
+	}
+
+	if len(m.PlaintextChunkSizes) > 0 {
+		if len(m.PlaintextChunkSizes) != chunks {
+			return fmt.Errorf("%w: %d plaintext sizes for %d chunks", ErrManifestTampered, len(m.PlaintextChunkSizes), chunks)
+		}
+		for i, size := range m.PlaintextChunkSizes {
+			if size < 0 {
+				return fmt.Errorf("chunk %d has negative plaintext size %d", i, size)
+			}
+		}
+	}
+	return nil
+}
+
+// validateManifestKDF 检查每个接收者的 KDF 参数。旧版清单的 Argon2 参数在 unlockManifest 中检查。
+func validateManifestKDF(m *Manifest) error {
+	for i, recipient := range m.Recipients {
+		if err := recipient.validate(); err != nil {
+			return fmt.Errorf("recipient %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// validateStorageName 检查 name 可以作为清单目录中的文件名：非空、不含路径分隔符和 ".."。
+func validateStorageName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	return nil
+}
+
+// verifyManifestSignature 使用 key 验证清单的 HMAC 签名。
+func verifyManifestSignature(manifest *Manifest, key *memguard.LockedBuffer) error {
+	// Temporarily remove signature for verification
+	signature := manifest.Signature
+	manifest.Signature = nil
+	unsignedManifestData, err := json.Marshal(manifest)
+	manifest.Signature = signature
+	if err != nil {
+		return fmt.Errorf("failed to marshal unsigned manifest for verification: %w", err)
+	}
+
+	if !verify(unsignedManifestData, signature, key.Bytes()) {
+		return fmt.Errorf("manifest signature verification failed")
+	}
+	return nil
+}
+
+// openManifest 读取清单，使用 password 解开文件密钥并验证清单签名。
+// 返回的密钥在使用完毕后必须由调用方销毁。
+func (s *Syncer) openManifest(manifestID, password string) (*Manifest, *memguard.LockedBuffer, error) {
+	return s.openManifestWith(s.keyDeriver(), manifestID, password)
+}
+
+// openManifestWith 与 openManifest 相同，但通过 kd 派生密钥。
+func (s *Syncer) openManifestWith(kd KeyDeriver, manifestID, password string) (*Manifest, *memguard.LockedBuffer, error) {
+	manifest, err := s.loadManifest(manifestID)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := s.unlockManifestWith(kd, manifestID, manifest, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	return manifest, key, nil
+}
+
+// unlockManifestWith 通过 kd 用 password 解开已读取的 manifest 的文件密钥，验证签名并核对关联数据。
+func (s *Syncer) unlockManifestWith(kd KeyDeriver, manifestID string, manifest *Manifest, password string) (*memguard.LockedBuffer, error) {
+	key, err := s.unlockFileKey(kd, manifest, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyManifestSignature(manifest, key); err != nil {
+		key.Destroy()
+		return nil, err
+	}
+	if err := bindAAD(manifest, key, s.AAD); err != nil {
+		key.Destroy()
+		return nil, fmt.Errorf("manifest %s: %w", manifestID, err)
+	}
+	return key, nil
+}
+
+// ReadManifest 读取并解析 manifestID 对应的清单，供需要自行检查或修改清单的高级用户使用。
+// 它不验证签名；需要可信内容时请使用 OpenManifest。
+func (s *Syncer) ReadManifest(manifestID string) (*Manifest, error) {
+	return s.loadManifest(manifestID)
+}
+
+// OpenManifest 读取清单，用 password 解开文件密钥并验证签名，返回清单和文件密钥。
+// 返回的密钥可用于 WriteManifest，使用完毕后必须由调用方调用 Destroy 销毁。
+func (s *Syncer) OpenManifest(manifestID, password string) (*Manifest, *memguard.LockedBuffer, error) {
+	return s.openManifest(manifestID, password)
+}
+
+// WriteManifest 使用文件密钥 key 重新签名 m，并将其写入 manifestID 对应的位置，覆盖已有清单。
+// 如果该清单带有恢复记录，它们也会被同步重写。清单和记录都先写入临时文件再重命名，
+// 写入中途失败或崩溃时原有清单保持完好。
+//
+// 警告：这是一个底层接口，库不会检查修改后的清单是否仍与分片一致。
+// 错误的块列表、大小或数据密钥会导致文件无法解密，使用前请务必备份原清单。
+func (s *Syncer) WriteManifest(manifestID string, m *Manifest, key *memguard.LockedBuffer) error {
+	if err := s.validateManifestID(manifestID); err != nil {
+		return err
+	}
+	if err := validateManifest(m); err != nil {
+		return fmt.Errorf("invalid manifest %s: %w", manifestID, err)
+	}
+	defer s.lockManifest(manifestID)()
+	if err := s.migrateManifestDir(manifestID); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.manifestDir(manifestID), defaultDirPerm); err != nil {
+		return fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+	return s.updateManifest(manifestID, m, key)
 }
 
 // Manifest 结构体定义了加密文件的元数据，这些元数据以 JSON 格式存储在 manifest.json 文件中。
 // 它包含了重建和解密文件所需的所有信息。
+// 自版本 2 起，Salt 和 Argon2 参数不再使用，每个接收者在 Recipients 中记录各自的密钥派生参数。
 type Manifest struct {
-	Salt                     []byte     `json:"salt"`
-	ChunkPaths               []string   `json:"chunk_paths"`
-	EncryptedOrigFilename    []byte     `json:"encrypted_orig_filename"`
-	EncryptedDataKeys        [][]byte   `json:"encrypted_data_keys"`
-	Argon2Time               uint32     `json:"argon2_time"`
-	Argon2Memory             uint32     `json:"argon2_memory"`
-	Argon2Threads            uint8      `json:"argon2_threads"`
-	Signature                []byte     `json:"signature,omitempty"`
-	DataShards               int        `json:"data_shards"`
-	ParityShards             int        `json:"parity_shards"`
-	ErasureCodeChunkSuffixes [][]string `json:"erasure_code_chunk_suffixes"`
-	EncryptedChunkSizes      []int      `json:"encrypted_chunk_sizes"`
+	Version                  int             `json:"version,omitempty"`
+	Recipients               []Recipient     `json:"recipients,omitempty"`
+	Salt                     []byte          `json:"salt"`
+	ChunkPaths               []string        `json:"chunk_paths"`
+	EncryptedOrigFilename    []byte          `json:"encrypted_orig_filename"`
+	EncryptedDataKeys        [][]byte        `json:"encrypted_data_keys"`
+	Argon2Time               uint32          `json:"argon2_time"`
+	Argon2Memory             uint32          `json:"argon2_memory"`
+	Argon2Threads            uint8           `json:"argon2_threads"`
+	Signature                []byte          `json:"signature,omitempty"`
+	DataShards               int             `json:"data_shards"`
+	ParityShards             int             `json:"parity_shards"`
+	ErasureCodeChunkSuffixes [][]string      `json:"erasure_code_chunk_suffixes"`
+	EncryptedChunkSizes      []int           `json:"encrypted_chunk_sizes"`
+	NameTag                  []byte          `json:"name_tag,omitempty"`
+	KeyWrapCipher            CipherAlgorithm `json:"key_wrap_cipher,omitempty"`
+	// ChunkCipher 是加密数据块所用的算法，为空时为 AES-256-GCM。
+	ChunkCipher CipherAlgorithm `json:"chunk_cipher,omitempty"`
+	// ChunkerPolynomial 是分块时使用的 Rabin 多项式，用同一多项式重新分块可以得到完全相同的块边界。
+	ChunkerPolynomial uint64 `json:"chunker_polynomial,omitempty"`
+	// CreatedAt 和 CreatorVersion 记录清单的创建时间和创建它的库版本，仅用于审计和排查问题。
+	// 确定性模式下不记录 CreatedAt。
+	// 它们不是机密，以明文保存，但和其他字段一样受签名保护。
+	CreatedAt      time.Time `json:"created_at,omitzero"`
+	CreatorVersion string    `json:"creator_version,omitempty"`
+	// EncryptedMetadata 是加密后的自定义元数据（JSON 编码），没有元数据时为空。
+	EncryptedMetadata []byte `json:"encrypted_metadata,omitempty"`
+	// PlaintextChunkSizes 是每个块的明文字节数，用于随机访问时把文件偏移映射到块。
+	// 旧清单没有该字段，此时由加密块大小减去算法开销推算。
+	PlaintextChunkSizes []int `json:"plaintext_chunk_sizes,omitempty"`
+	// MaxShardBytes 是加密时的分片大小上限，为 0 时分片没有被拆分。详见 EncryptionOptions.MaxShardBytes。
+	MaxShardBytes int `json:"max_shard_bytes,omitempty"`
+	// ShardSuffixes 是版本 4 起所有块共用的分片后缀，第 j 个分片的文件名为块名加上第 j 个后缀。
+	// 更早的清单在 ErasureCodeChunkSuffixes 中为每个块分别记录后缀，新清单中该字段为空。
+	ShardSuffixes []string `json:"shard_suffixes,omitempty"`
+	// EncryptedLinkTarget 不为空时，该清单表示一个符号链接：这是用文件密钥加密的（填充后的）链接目标，清单没有任何块。
+	EncryptedLinkTarget []byte `json:"encrypted_link_target,omitempty"`
+	// EncryptedXattrs 是加密后的扩展属性（属性名到值的 JSON 映射），加密时未设置 PreserveXattrs 或文件没有扩展属性时为空。
+	EncryptedXattrs []byte `json:"encrypted_xattrs,omitempty"`
+	// EncryptedOwner 是加密后的文件所有者（uid 和 gid 的 JSON），加密时未设置 PreserveOwner 时为空。
+	EncryptedOwner []byte `json:"encrypted_owner,omitempty"`
+	// KMSWrappedKey 是由 EncryptionOptions.KeyWrapper 包装的文件密钥，未使用 KeyWrapper 时为空。
+	KMSWrappedKey []byte `json:"kms_wrapped_key,omitempty"`
+	// ShardLocations 不为空时，ShardLocations[i][j] 是第 i 个块第 j 个分片在 Backend 中的完整 key（例如 CDN 上的 URL），
+	// 读取分片时直接使用它，而不是按约定由 manifestID、块名和分片后缀拼出 key。这样清单可以留在本地，
+	// 分片则放在任意位置，由能够识别这些 key 的自定义 Backend 读取。库本身不会写入该字段，
+	// 通常在把分片上传到别处之后通过 WriteManifest 填写。DeleteManifest 和 WipeManifest 不会删除外部位置上的分片，
+	// ExportArchive 也不支持这样的清单。
+	ShardLocations [][]string `json:"shard_locations,omitempty"`
+	// NonceSize 是用文件密钥或数据密钥加密的 AES-GCM 密文（块、数据密钥、文件名、元数据等）所用的 nonce 字节数，
+	// 为 0 时为标准的 12 字节。本库加密时总是使用标准长度；该字段用于解密从使用其他 nonce 长度的实现迁移来的数据。
+	// 它对 XChaCha20-Poly1305 密文和接收者包装的文件密钥没有影响。
+	NonceSize int `json:"nonce_size,omitempty"`
+	// EncryptedContentHash 是用文件密钥加密的完整明文哈希，解密时据此检查拼接出的内容，旧清单没有该字段。
+	// 它被加密保存，以免存储方通过比对哈希确认文件内容。
+	EncryptedContentHash []byte `json:"encrypted_content_hash,omitempty"`
+	// ContentHashAlgorithm 是 EncryptedContentHash 所用的哈希算法，为空时为 SHA-256。
+	ContentHashAlgorithm HashAlgorithm `json:"content_hash_algorithm,omitempty"`
+	// AADHash 是加密时提供的关联数据（EncryptionOptions.AAD）以文件密钥计算的 HMAC，没有关联数据时为空。
+	AADHash []byte `json:"aad_hash,omitempty"`
+	// ShardTransform 是写入分片时所用的 Syncer.ShardTransform 的名称，为空时分片未经变换。
+	ShardTransform string `json:"shard_transform,omitempty"`
+	// EncryptedSourceSizes 是用文件密钥加密的各个源文件的明文字节数（JSON 数组），只有 EncryptConcat 创建的清单才有。
+	EncryptedSourceSizes []byte `json:"encrypted_source_sizes,omitempty"`
+
+	// aad 是打开清单时经 AADHash 核对过的关联数据，只存在于内存中，用于解密文件名和数据密钥。
+	aad []byte
 }
 
 // EncryptFile 负责加密单个文件，并将其安全地存储到指定的目录中。
-func (s *Syncer) EncryptFile(localPath string, opts EncryptionOptions) (manifestID string, err error) {
-	// 1. Generate a unique manifest ID
-	manifestID, err = generateManifestID()
+// 如果文件已写入但更新索引失败，会同时返回 manifestID 和错误。
+// 上传过程中失败时同样会返回 manifestID，之后可以将其设置为 opts.ResumeManifestID 重新调用以跳过已上传的块。
+func (s *Syncer) EncryptFile(localPath string, opts EncryptionOptions) (string, error) {
+	return s.EncryptFileContext(context.Background(), localPath, opts)
+}
+
+// EncryptFileContext 与 EncryptFile 相同，但 ctx 会传递给每一次 Backend 调用，
+// 因此其截止时间和取消同样约束 RetryBackend 的重试。ctx 被取消时上传中止，返回的 manifestID 可用于续传。
+func (s *Syncer) EncryptFileContext(ctx context.Context, localPath string, opts EncryptionOptions) (string, error) {
+	file, err := os.Open(localPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate manifest ID: %w", err)
+		return "", err
 	}
+	defer file.Close()
+	return s.encryptOpenFile(ctx, file, localPath, opts)
+}
 
-	outputDir := filepath.Join(s.StorageDir, manifestID)
-	if err := os.MkdirAll(outputDir, defaultDirPerm); err != nil {
-		return "", fmt.Errorf("failed to create output directory: %w", err)
+// EncryptOpenFile 与 EncryptFile 相同，但直接从调用方已经打开的 f 的当前位置读到末尾，而不是按路径重新打开，
+// 因此调用方检查文件（或持有文件锁）之后文件不会被替换，f 也可以是管道或设备。f 由调用方负责关闭。
+// origName 的最后一个元素作为原始文件名保存在清单中，为空时使用 f.Name()。
+// f 是普通文件时其剩余字节数用于 MaxChunks 检查和进度报告，否则大小按未知处理。
+func (s *Syncer) EncryptOpenFile(f *os.File, origName string, opts EncryptionOptions) (string, error) {
+	if origName == "" {
+		origName = f.Name()
 	}
+	return s.encryptOpenFile(context.Background(), f, origName, opts)
+}
 
-	// 2. Generate salt and derive key
-	salt, err := generateSalt()
+// encryptOpenFile 是 EncryptFileContext 和 EncryptOpenFile 的实现，按 opts 读取 file 的扩展属性和所有者后加密其剩余内容。
+func (s *Syncer) encryptOpenFile(ctx context.Context, file *os.File, localPath string, opts EncryptionOptions) (string, error) {
+	size, xattrs, owner, err := readSourceFile(file, opts)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate salt: %w", err)
+		return "", err
 	}
+	source, release := s.sourceReader(file, size, opts)
+	defer release()
+	return s.encryptReader(ctx, source, size, localPath, xattrs, owner, opts)
+}
 
-	key := deriveKey([]byte(opts.Password), salt, opts.Argon2Time, opts.Argon2Memory, opts.Argon2Threads)
-	defer key.Destroy()
+// readSourceFile 返回 file 从当前位置起剩余的字节数（不是普通文件时为 -1），
+// 以及按 opts 读取并编码的扩展属性和所有者，并检查文件大小不会超出 MaxChunks。
+func readSourceFile(file *os.File, opts EncryptionOptions) (size int64, xattrs, owner []byte, err error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	size = -1
+	if info.Mode().IsRegular() {
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		size = max(info.Size()-offset, 0)
+	}
+	if err := checkProjectedChunks(size, opts); err != nil {
+		return 0, nil, nil, err
+	}
 
-	// 3. Handle file chunking and encryption
-	file, err := os.Open(localPath)
+	if opts.PreserveXattrs {
+		attrs, err := readXattrs(file)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if xattrs, err = marshalXattrs(attrs); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+	if opts.PreserveOwner {
+		if owner, err = marshalOwner(info); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+	return size, xattrs, owner, nil
+}
+
+// pendingUpload 是已经分配了清单目录和文件密钥、尚未写入任何块的一次加密，由 startUpload 创建，交给 encryptUpload 完成。
+type pendingUpload struct {
+	manifestID string
+	progress   *uploadProgress
+	key        *memguard.LockedBuffer
+	metadata   []byte
+	start      time.Time
+	// detached 不为 nil 时，签名后的清单保存到这里而不是写入存储目录，也不记录到索引中。
+	detached *Manifest
+	// sources 不为 nil 时就是被加密的 Reader，清单中记录它从各个源文件读取的字节数。
+	sources *concatReader
+}
+
+// startUpload 检查元数据并为 opts 分配清单目录和文件密钥（或打开中断的上传），使调用方在写入数据之前就能得到 manifestID。
+func (s *Syncer) startUpload(opts EncryptionOptions) (*pendingUpload, error) {
+	start := time.Now()
+
+	// Reject oversized metadata before anything is uploaded
+	metadata, err := marshalMetadata(opts.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	manifestID, progress, key, err := s.beginUpload(opts)
+	if err != nil {
+		memguard.WipeBytes(metadata)
+		return nil, err
+	}
+	return &pendingUpload{manifestID: manifestID, progress: progress, key: key, metadata: metadata, start: start}, nil
+}
+
+// encryptReader 加密 r 的全部内容，是 EncryptFileContext 的实现。
+// size 是 r 预计的总字节数，用于报告进度，未知时为 -1。
+// localPath 用于错误信息，其最后一个元素作为原始文件名保存在清单中。
+// xattrs 是 marshalXattrs 编码的扩展属性，owner 是 marshalOwner 编码的所有者，为 nil 时清单不保存对应字段。
+func (s *Syncer) encryptReader(ctx context.Context, r io.Reader, size int64, localPath string, xattrs, owner []byte, opts EncryptionOptions) (string, error) {
+	upload, err := s.startUpload(opts)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
+	return s.encryptUpload(ctx, upload, r, size, localPath, xattrs, owner, opts)
+}
+
+// encryptUpload 把 r 的全部内容加密写入 upload 分配的清单目录并保存清单，参数与 encryptReader 相同。
+// 无论成功与否，upload 持有的文件密钥和元数据都会被擦除。
+func (s *Syncer) encryptUpload(ctx context.Context, upload *pendingUpload, r io.Reader, size int64, localPath string, xattrs, owner []byte, opts EncryptionOptions) (manifestID string, err error) {
+	defer func() { s.metrics().ObserveEncryptDuration(time.Since(upload.start)) }()
+
+	// 1. Take over the manifest directory and file key claimed by startUpload
+	manifestID, progress, key, metadata := upload.manifestID, upload.progress, upload.key, upload.metadata
+	defer memguard.WipeBytes(metadata)
+	defer key.Destroy()
+	defer progress.Close()
+	outputDir := s.manifestDir(manifestID)
 
+	// 2. Handle file chunking and encryption
+	limiter := newRateLimiter(opts.MaxBytesPerSec)
+	seal := sealFunc(encryptAppend)
+	if opts.Deterministic {
+		seal = encryptSynthetic
+	}
+
+	// beginUpload has checked that the configured transform is the one recorded for the upload
+	var transform ShardTransform
+	if progress.header.ShardTransform != "" {
+		transform = s.ShardTransform
+	}
+
+	// Erasure code; with no parity requested Reed-Solomon is skipped entirely and
+	// each encrypted chunk is stored as a single file.
+	dataShards := opts.DataShards
+	var enc reedsolomon.Encoder
+	if opts.ParityShards == 0 {
+		dataShards = 1
+	} else {
+		enc, err = s.newErasureCoder(opts.DataShards, opts.ParityShards)
+		if err != nil {
+			return manifestID, fmt.Errorf("failed to create erasure code encoder: %w", err)
+		}
+	}
+
+	shardSuffixes := standardShardSuffixes(dataShards, opts.ParityShards)
 	var encryptedChunkPaths []string
-	var erasureCodeChunkSuffixes [][]string
 	var encryptedChunkSizes []int
+	var plaintextChunkSizes []int
 	var encryptedDataKeys [][]byte
 
-	chunker := newCDCChunker(file, opts.ChunkSizeKB)
+	// The plaintext and ciphertext buffers are reused for every chunk
+	plainBuf, encodedBuf := chunkBuffers(s.BufferPool, opts)
+	defer s.BufferPool.put(plainBuf)
+	defer s.BufferPool.put(encodedBuf)
+
+	// The chunker keeps its own buffer of plaintext read ahead of the next chunk boundary
+	source := &wipingReader{r: r}
+	defer source.wipe()
+	chunker := newCDCChunker(source, opts.ChunkSizeKB, chunker.Pol(progress.header.ChunkerPolynomial))
+	contentHash, err := newContentHash(opts.ContentHash)
+	if err != nil {
+		return manifestID, err
+	}
+	var bytesRead int64
 	var chunkNumber int
 	for {
-		chunk, err := chunker.Next(nil)
+		if err := ctx.Err(); err != nil {
+			return manifestID, err
+		}
+		chunk, err := chunker.Next(plainBuf)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return "", fmt.Errorf("failed to read chunk: %w", err)
+			return manifestID, fmt.Errorf("failed to read chunk: %w", err)
+		}
+		if opts.MaxChunks > 0 && chunkNumber >= opts.MaxChunks {
+			return manifestID, fmt.Errorf("%w: the limit is %d", ErrTooManyChunks, opts.MaxChunks)
 		}
+		contentHash.Write(chunk.Data)
+		bytesRead += int64(len(chunk.Data))
 
-		dataKey, err := generateDataKey()
-		if err != nil {
-			return "", fmt.Errorf("failed to generate data key for chunk %d: %w", chunkNumber, err)
+		// Chunks uploaded before an interruption are reused as long as the source still matches
+		chunkBaseName := fmt.Sprintf("chunk_%d", chunkNumber)
+		tag := progressChunkTag(key, manifestID, chunkNumber, chunk.Data)
+		if done, ok := progress.completed(chunkNumber); ok {
+			if done.PlainSize != len(chunk.Data) || !hmac.Equal(done.PlainTag, tag) {
+				return manifestID, fmt.Errorf("source file '%s' changed since the interrupted upload at chunk %d", localPath, chunkNumber)
+			}
+			if !slices.Equal(done.ChunkSuffixes, shardSuffixes) {
+				return manifestID, fmt.Errorf("interrupted upload stored chunk %d under unexpected shard names", chunkNumber)
+			}
+			encryptedDataKeys = append(encryptedDataKeys, done.EncryptedDataKey)
+			encryptedChunkSizes = append(encryptedChunkSizes, done.EncryptedChunkSize)
+			plaintextChunkSizes = append(plaintextChunkSizes, done.PlainSize)
+			encryptedChunkPaths = append(encryptedChunkPaths, chunkBaseName)
+			reportProgress(opts.Progress, bytesRead, size)
+			chunkNumber++
+			continue
 		}
 
-		encryptedData, err := encrypt(chunk.Data, dataKey)
+		encryptedData, encryptedKey, err := sealChunk(seal, progress.header.ChunkCipher, opts, key, chunkAAD(manifestID, chunkNumber), encodedBuf, chunk.Data)
 		if err != nil {
-			dataKey.Destroy()
-			return "", fmt.Errorf("failed to encrypt chunk %d for file '%s': %w", chunkNumber, localPath, err)
+			return manifestID, fmt.Errorf("failed to encrypt chunk %d for file '%s': %w", chunkNumber, localPath, err)
 		}
 
-		encryptedKey, err := encrypt(dataKey.Bytes(), key)
-		dataKey.Destroy() // Destroy key immediately after use
+		currentChunkSuffixes, err := s.writeChunkShards(ctx, manifestID, chunkNumber, encryptedData, enc, progress.header.MaxShardBytes, transform, limiter)
 		if err != nil {
-			return "", fmt.Errorf("failed to encrypt data key for chunk %d: %w", chunkNumber, err)
+			return manifestID, err
 		}
+		s.metrics().IncChunksEncrypted()
+
+		if err := progress.record(uploadProgressChunk{
+			Index:              chunkNumber,
+			PlainSize:          len(chunk.Data),
+			PlainTag:           tag,
+			EncryptedDataKey:   encryptedKey,
+			EncryptedChunkSize: len(encryptedData),
+			ChunkSuffixes:      currentChunkSuffixes,
+		}); err != nil {
+			return manifestID, err
+		}
+
 		encryptedDataKeys = append(encryptedDataKeys, encryptedKey)
 		encryptedChunkSizes = append(encryptedChunkSizes, len(encryptedData))
+		plaintextChunkSizes = append(plaintextChunkSizes, len(chunk.Data))
+		encryptedChunkPaths = append(encryptedChunkPaths, chunkBaseName)
+		reportProgress(opts.Progress, bytesRead, size)
+		chunkNumber++
+	}
+	if size >= 0 && bytesRead != size {
+		s.logger().Warn("source size differs from the declared size",
+			"manifest_id", manifestID, "path", localPath, "declared", size, "read", bytesRead)
+	}
+	if chunkNumber < len(progress.chunks) {
+		return manifestID, fmt.Errorf("source file '%s' changed since the interrupted upload: it now has fewer chunks", localPath)
+	}
 
-		// Erasure code
-		enc, err := reedsolomon.New(opts.DataShards, opts.ParityShards)
+	// 3. Encrypt original filename, unless the caller asked not to store it, and the metadata
+	origFilename := filepath.Base(localPath)
+	var encryptedOrigFilename []byte
+	if !opts.OmitFilename {
+		encryptedOrigFilename, err = encryptFilenameWith(seal, opts.KeyWrapCipher, origFilename, key, opts.AAD)
 		if err != nil {
-			return "", fmt.Errorf("failed to create erasure code encoder: %w", err)
+			return manifestID, fmt.Errorf("failed to encrypt original filename for file '%s': %w", localPath, err)
 		}
+	}
 
-		shards, err := enc.Split(encryptedData)
+	var encryptedMetadata []byte
+	if metadata != nil {
+		encryptedMetadata, err = seal(opts.KeyWrapCipher, nil, metadata, key, nil)
 		if err != nil {
-			return "", fmt.Errorf("failed to split data into shards: %w", err)
+			return manifestID, fmt.Errorf("failed to encrypt metadata: %w", err)
 		}
+	}
+	encryptedContentHash, err := seal(opts.KeyWrapCipher, nil, contentHash.Sum(nil), key, nil)
+	if err != nil {
+		return manifestID, fmt.Errorf("failed to encrypt content hash: %w", err)
+	}
+	var encryptedXattrs []byte
+	if xattrs != nil {
+		encryptedXattrs, err = seal(opts.KeyWrapCipher, nil, xattrs, key, nil)
+		if err != nil {
+			return manifestID, fmt.Errorf("failed to encrypt extended attributes: %w", err)
+		}
+	}
+	var encryptedOwner []byte
+	if owner != nil {
+		encryptedOwner, err = seal(opts.KeyWrapCipher, nil, owner, key, nil)
+		if err != nil {
+			return manifestID, fmt.Errorf("failed to encrypt file owner: %w", err)
+		}
+	}
 
-		if err := enc.Encode(shards); err != nil {
-			return "", fmt.Errorf("failed to encode data shards: %w", err)
+	var encryptedSourceSizes []byte
+	if upload.sources != nil {
+		sizes, err := json.Marshal(upload.sources.sizes)
+		if err != nil {
+			return manifestID, fmt.Errorf("failed to marshal source sizes: %w", err)
 		}
+		if encryptedSourceSizes, err = seal(opts.KeyWrapCipher, nil, sizes, key, nil); err != nil {
+			return manifestID, fmt.Errorf("failed to encrypt source sizes: %w", err)
+		}
+	}
 
-		var currentChunkSuffixes []string
-		for i, shard := range shards {
-			suffix := fmt.Sprintf("_shard_%d.dat", i)
-			shardPath := filepath.Join(outputDir, fmt.Sprintf("chunk_%d%s", chunkNumber, suffix))
-			if err := os.WriteFile(shardPath, shard, defaultFilePerm); err != nil {
-				return "", fmt.Errorf("failed to write shard %d of chunk %d: %w", i, chunkNumber, err)
-			}
-			currentChunkSuffixes = append(currentChunkSuffixes, suffix)
+	// 4. Create the manifest
+	manifest := Manifest{
+		Version:               currentManifestVersion,
+		Recipients:            progress.header.Recipients,
+		KMSWrappedKey:         progress.header.KMSWrappedKey,
+		KeyWrapCipher:         opts.KeyWrapCipher,
+		ChunkCipher:           progress.header.ChunkCipher,
+		ChunkPaths:            encryptedChunkPaths,
+		EncryptedOrigFilename: encryptedOrigFilename,
+		EncryptedDataKeys:     encryptedDataKeys,
+		DataShards:            dataShards,
+		ParityShards:          opts.ParityShards,
+		ShardSuffixes:         shardSuffixes,
+		EncryptedChunkSizes:   encryptedChunkSizes,
+		PlaintextChunkSizes:   plaintextChunkSizes,
+		MaxShardBytes:         progress.header.MaxShardBytes,
+		ChunkerPolynomial:     progress.header.ChunkerPolynomial,
+		CreatorVersion:        creatorVersion(),
+		EncryptedMetadata:     encryptedMetadata,
+		EncryptedXattrs:       encryptedXattrs,
+		EncryptedOwner:        encryptedOwner,
+		EncryptedContentHash:  encryptedContentHash,
+		ContentHashAlgorithm:  opts.ContentHash,
+		AADHash:               progress.header.AADHash,
+		ShardTransform:        progress.header.ShardTransform,
+		EncryptedSourceSizes:  encryptedSourceSizes,
+	}
+
+	if !opts.Deterministic {
+		manifest.CreatedAt = time.Now().UTC()
+	}
+	if len(s.SearchKey) > 0 && !opts.OmitFilename {
+		manifest.NameTag = nameTag(s.SearchKey, origFilename)
+	}
+
+	// 5. Optionally write per-chunk recovery records so a lost manifest can be rebuilt
+	if opts.RecoveryRecords {
+		if err := s.writeRecoveryRecords(outputDir, &manifest, key); err != nil {
+			return manifestID, err
 		}
+	}
 
-		chunkBaseName := fmt.Sprintf("chunk_%d", chunkNumber)
-		encryptedChunkPaths = append(encryptedChunkPaths, chunkBaseName)
-		erasureCodeChunkSuffixes = append(erasureCodeChunkSuffixes, currentChunkSuffixes)
-		chunkNumber++
+	// 6. Sign and save the manifest; the upload is complete and its progress is no longer needed
+	if upload.detached != nil {
+		if err := signManifest(&manifest, key); err != nil {
+			return manifestID, err
+		}
+		*upload.detached = manifest
+	} else if err := s.saveManifest(manifestID, &manifest, key); err != nil {
+		return manifestID, err
+	}
+	if err := progress.remove(); err != nil {
+		return manifestID, err
+	}
+	if upload.detached != nil {
+		// Nothing but shards may remain; the directory is still in use when the backend stores them there
+		os.Remove(outputDir)
+		return manifestID, nil
 	}
 
-	// 4. Encrypt original filename
-	origFilename := filepath.Base(localPath)
-	encryptedOrigFilename, err := encrypt([]byte(origFilename), key)
+	// 7. Record the new manifest in the index; the data itself is already stored,
+	// so the ID is returned alongside the error and Reindex can pick it up later.
+	if s.Index != nil {
+		if err := s.Index.Put(newIndexEntry(manifestID, &manifest, manifest.CreatedAt)); err != nil {
+			return manifestID, fmt.Errorf("failed to update manifest index: %w", err)
+		}
+	}
+
+	return manifestID, nil
+}
+
+// sealChunk 为一个块生成数据密钥（确定性模式下由明文派生），用它加密 plaintext，
+// 并用文件密钥包装数据密钥。返回加密后的块（追加在 dst 之后）和包装后的数据密钥。
+func sealChunk(seal sealFunc, chunkCipher CipherAlgorithm, opts EncryptionOptions, key *memguard.LockedBuffer, aad, dst, plaintext []byte) ([]byte, []byte, error) {
+	var dataKey *memguard.LockedBuffer
+	var err error
+	if opts.Deterministic {
+		dataKey, err = syntheticDataKey(key, aad, plaintext)
+	} else {
+		dataKey, err = generateDataKey()
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to encrypt original filename for file '%s': %w", localPath, err)
+		return nil, nil, fmt.Errorf("failed to generate data key: %w", err)
 	}
+	defer dataKey.Destroy() // Destroy key immediately after use
 
-	// 5. Create and sign the manifest
-	manifest := Manifest{
-		Salt:                     salt,
-		ChunkPaths:               encryptedChunkPaths,
-		EncryptedOrigFilename:    encryptedOrigFilename,
-		EncryptedDataKeys:        encryptedDataKeys,
-		Argon2Time:               opts.Argon2Time,
-		Argon2Memory:             opts.Argon2Memory,
-		Argon2Threads:            opts.Argon2Threads,
-		DataShards:               opts.DataShards,
-		ParityShards:             opts.ParityShards,
-		ErasureCodeChunkSuffixes: erasureCodeChunkSuffixes,
-		EncryptedChunkSizes:      encryptedChunkSizes,
+	encryptedData, err := seal(chunkCipher, dst, plaintext, dataKey, aad)
+	if err != nil {
+		return nil, nil, err
+	}
+	encryptedKey, err := seal(opts.KeyWrapCipher, nil, dataKey.Bytes(), key, opts.AAD)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encrypt data key: %w", err)
 	}
+	return encryptedData, encryptedKey, nil
+}
 
+// signManifest 使用 key 计算清单的签名并写入 Signature 字段。
+func signManifest(manifest *Manifest, key *memguard.LockedBuffer) error {
+	manifest.Signature = nil
 	manifestData, err := json.Marshal(manifest)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal manifest for signing: %w", err)
+		return fmt.Errorf("failed to marshal manifest for signing: %w", err)
 	}
-
 	manifest.Signature = sign(manifestData, key.Bytes())
+	return nil
+}
 
-	finalManifestData, err := json.MarshalIndent(manifest, "", "  ")
+// saveManifest 使用 key 为清单签名，并将其写入 manifestID 对应的 manifest.json。
+func (s *Syncer) saveManifest(manifestID string, manifest *Manifest, key *memguard.LockedBuffer) error {
+	if err := signManifest(manifest, key); err != nil {
+		return err
+	}
+
+	finalManifestData, err := s.encodeManifest(manifest)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal final manifest: %w", err)
+		return err
 	}
 
-	// 7. Save the manifest
-	if err := os.WriteFile(s.getManifestPath(manifestID), finalManifestData, defaultFilePerm); err != nil {
-		return "", fmt.Errorf("failed to write manifest: %w", err)
+	if err := s.writeFile(s.getManifestPath(manifestID), finalManifestData); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
 	}
 
-	return manifestID, nil
+	// Persist the directory entries of the manifest, its recovery records and local shards
+	if s.Durable {
+		if err := syncDir(s.manifestDir(manifestID)); err != nil {
+			return err
+		}
+		if err := s.syncManifestParents(manifestID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncryptFileSealed 以“封存”模式加密文件：只加密和签名，不做纠删编码。
+// 它等价于以 DataShards=1、ParityShards=0 调用 EncryptFile，每个块只存储一个加密文件，
+// 适合只需要防篡改而不需要冗余的小文件（例如配置文件）。解密仍使用 DecryptFile。
+func (s *Syncer) EncryptFileSealed(localPath string, opts EncryptionOptions) (string, error) {
+	opts.DataShards = 1
+	opts.ParityShards = 0
+	return s.EncryptFile(localPath, opts)
 }
 
 // DecryptFile 负责从存储中解密文件。
-func (s *Syncer) DecryptFile(manifestID, outputPath, password string) (err error) {
+// outputPath 是输出目录，文件以其原始文件名还原；若加密时未保存文件名，outputPath 则是完整的目标文件路径。
+// outputPath 为 StdoutPath（"-"）时，内容直接写入标准输出而不创建任何文件，详见 DecryptToWriter。
+func (s *Syncer) DecryptFile(manifestID, outputPath, password string) error {
+	_, err := s.DecryptFileWithMetadata(manifestID, outputPath, password)
+	return err
+}
+
+// DecryptFileWithMetadata 与 DecryptFile 相同，并在成功时返回文件的自定义元数据（没有时为 nil）。
+func (s *Syncer) DecryptFileWithMetadata(manifestID, outputPath, password string) (map[string]string, error) {
+	return s.DecryptFileContext(context.Background(), manifestID, outputPath, password)
+}
+
+// DecryptFileContext 与 DecryptFileWithMetadata 相同，但 ctx 会传递给每一次 Backend 调用，
+// 因此其截止时间和取消同样约束 RetryBackend 的重试。
+func (s *Syncer) DecryptFileContext(ctx context.Context, manifestID, outputPath, password string) (map[string]string, error) {
+	report, err := s.decryptFileContext(ctx, manifestID, outputPath, password)
+	return report.Metadata, err
+}
+
+// decryptFileContext 是 DecryptFileContext 的实现，返回包括元数据在内的解密报告。
+func (s *Syncer) decryptFileContext(ctx context.Context, manifestID, outputPath, password string) (DecryptReport, error) {
+	if outputPath == StdoutPath {
+		return s.decryptToWriter(ctx, manifestID, password, os.Stdout)
+	}
+	return s.decryptFile(ctx, s.keyDeriver(), manifestID, password, outputTarget(manifestID, outputPath))
+}
+
+// outputTarget 返回 DecryptFile 的 outputPath 对应的 target：文件以原始文件名还原到 outputPath 目录中，
+// 未保存文件名时 outputPath 就是目标文件。
+func outputTarget(manifestID, outputPath string) func(name string) (string, error) {
+	return func(name string) (string, error) {
+		// Without a stored name outputPath is the target file itself
+		if name == "" {
+			if outputPath == "" {
+				return "", fmt.Errorf("manifest %s does not store the original filename, an output file path is required", manifestID)
+			}
+			return outputPath, nil
+		}
+		return filepath.Join(outputPath, name), nil
+	}
+}
+
+// decryptFile 解密 manifestID 对应的文件，返回的报告包含其自定义元数据和重建情况。
+// target 根据解密出的原始文件名（未保存时为空）决定输出文件的完整路径。kd 用于从 password 派生密钥。
+func (s *Syncer) decryptFile(ctx context.Context, kd KeyDeriver, manifestID, password string, target func(name string) (string, error)) (DecryptReport, error) {
+	return s.decryptManifest(ctx, kd, manifestID, nil, password, target)
+}
+
+// decryptManifest 是 decryptFile 的实现。manifest 为 nil 时从存储目录读取 manifestID 的清单，否则使用调用方提供的清单。
+func (s *Syncer) decryptManifest(ctx context.Context, kd KeyDeriver, manifestID string, manifest *Manifest, password string, target func(name string) (string, error)) (report DecryptReport, err error) {
+	defer func(start time.Time) { s.metrics().ObserveDecryptDuration(time.Since(start)) }(time.Now())
+	if s.TempDir != "" {
+		if err := checkTempDir(s.TempDir); err != nil {
+			return DecryptReport{}, err
+		}
+	}
+
+	// 1. Read the manifest, unlock its file key and verify the signature
+	if manifest == nil {
+		if manifest, err = s.loadManifest(manifestID); err != nil {
+			return DecryptReport{}, err
+		}
+	}
+	key, err := s.unlockManifestWith(kd, manifestID, manifest, password)
+	if err != nil {
+		return DecryptReport{}, err
+	}
+	defer key.Destroy()
+	if _, err := s.limitConcurrency(manifest, 1); err != nil {
+		return DecryptReport{}, err
+	}
+
+	report.Metadata, err = decryptMetadata(manifest, key)
+	if err != nil {
+		return DecryptReport{}, err
+	}
+
+	// 4. Decrypt original filename and resolve the output path
+	var origFilename string
+	if len(manifest.EncryptedOrigFilename) > 0 {
+		origFilename, err = decryptFilename(manifest, key)
+		if err != nil {
+			return DecryptReport{}, fmt.Errorf("failed to decrypt original filename: %w", err)
+		}
+	}
+	finalOutputPath, err := target(origFilename)
+	if err != nil {
+		return DecryptReport{}, err
+	}
+
 	// Ensure the output directory exists
-	if err := os.MkdirAll(filepath.Dir(outputPath), defaultDirPerm); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+	if err := os.MkdirAll(filepath.Dir(finalOutputPath), defaultDirPerm); err != nil {
+		return DecryptReport{}, fmt.Errorf("failed to create output directory: %w", err)
 	}
-	// 1. Read and validate the manifest
-	manifestPath := s.getManifestPath(manifestID)
-	manifestData, err := os.ReadFile(manifestPath)
+	if len(manifest.EncryptedLinkTarget) > 0 {
+		return report, restoreSymlink(manifest, key, finalOutputPath)
+	}
+
+	// Decrypt into a temp file in the target or configured temp directory and move it into place
+	// only once every chunk has been written, so a failure never leaves a partial file.
+	outputFile, err := os.CreateTemp(s.outputTempDir(finalOutputPath), "."+filepath.Base(finalOutputPath)+".tmp-*")
 	if err != nil {
-		return fmt.Errorf("failed to read manifest from %s: %w", manifestPath, err)
+		return DecryptReport{}, fmt.Errorf("failed to create temp output file: %w", err)
 	}
+	tempPath := outputFile.Name()
+	defer func() {
+		if err != nil {
+			outputFile.Close()
+			os.Remove(tempPath)
+		}
+	}()
 
-	var manifest Manifest
-	if err := json.Unmarshal(manifestData, &manifest); err != nil {
-		return fmt.Errorf("failed to unmarshal manifest: %w", err)
+	// 5. Reconstruct and decrypt chunks
+	if err := s.decryptChunks(ctx, manifestID, manifest, key, outputFile, &report); err != nil {
+		return DecryptReport{}, err
 	}
 
-	// Temporarily remove signature for verification
-	signature := manifest.Signature
-	manifest.Signature = nil
-	unsignedManifestData, err := json.Marshal(manifest)
+	// 6. Restore extended attributes and atomically move the fully decrypted file into place
+	var attrs map[string][]byte
+	var owner *fileOwner
+	if s.RestoreMetadata {
+		attrs, err = decryptXattrs(manifest, key)
+		if err != nil {
+			return DecryptReport{}, err
+		}
+		if owner, err = decryptOwner(manifest, key); err != nil {
+			return DecryptReport{}, err
+		}
+		if err := writeXattrs(outputFile, attrs); err != nil {
+			return DecryptReport{}, err
+		}
+	}
+	if err := outputFile.Chmod(defaultFilePerm); err != nil {
+		return DecryptReport{}, fmt.Errorf("failed to set output file permissions: %w", err)
+	}
+	if err := outputFile.Close(); err != nil {
+		return DecryptReport{}, fmt.Errorf("failed to close temp output file: %w", err)
+	}
+	if err := moveFile(tempPath, finalOutputPath, attrs); err != nil {
+		return DecryptReport{}, fmt.Errorf("failed to move decrypted file into place: %w", err)
+	}
+	if owner != nil {
+		s.restoreOwner(manifestID, finalOutputPath, owner)
+	}
+
+	return report, nil
+}
+
+// decryptChunks 按顺序重建并解密清单的每个块，写入 w，最后检查完整内容的 SHA-256 与清单记录的一致，
+// 不一致时返回 ErrContentHash。出错时 w 中可能已经写入了前面的块，甚至是全部内容。
+// 需要重建的块及其丢失或损坏的分片记录在 report 中。
+func (s *Syncer) decryptChunks(ctx context.Context, manifestID string, manifest *Manifest, key *memguard.LockedBuffer, w io.Writer, report *DecryptReport) error {
+	var enc reedsolomon.Encoder
+	var err error
+	if manifest.ParityShards > 0 {
+		enc, err = s.newErasureCoder(manifest.DataShards, manifest.ParityShards)
+		if err != nil {
+			return fmt.Errorf("failed to create erasure code decoder: %w", err)
+		}
+	}
+
+	contentHash, err := newContentHash(manifest.ContentHashAlgorithm)
 	if err != nil {
-		return fmt.Errorf("failed to marshal unsigned manifest for verification: %w", err)
+		return err
 	}
+	for i := range manifest.ChunkPaths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		decryptedData, damage, err := s.readChunk(ctx, manifestID, manifest, enc, key, i, s.StrictIntegrity)
+		if err != nil {
+			return err
+		}
+		report.record(manifestID, manifest, i, damage)
+		if damage.degraded && s.StrictIntegrity {
+			s.BufferPool.put(decryptedData)
+			return fmt.Errorf("chunk %d of manifest %s: %w", i, manifestID, ErrShardIntegrity)
+		}
 
-	// 2. Derive key from password
-	pass := memguard.NewBufferFromBytes([]byte(password))
-	defer pass.Destroy()
-	key := deriveKey(pass.Bytes(), manifest.Salt, manifest.Argon2Time, manifest.Argon2Memory, manifest.Argon2Threads)
-	defer key.Destroy()
+		contentHash.Write(decryptedData)
+		_, err = w.Write(decryptedData)
+		s.BufferPool.put(decryptedData)
+		if err != nil {
+			return fmt.Errorf("failed to write decrypted chunk %d: %w", i, err)
+		}
+		s.metrics().IncChunksDecrypted()
+		s.metrics().AddBytesWritten(len(decryptedData))
+	}
+	if err := verifyContentHash(manifest, key, contentHash.Sum(nil)); err != nil {
+		return fmt.Errorf("manifest %s: %w", manifestID, err)
+	}
+	return nil
+}
 
-	if !verify(unsignedManifestData, signature, key.Bytes()) {
-		return fmt.Errorf("manifest signature verification failed")
+// writeChunkShards 将一个加密块的分片写入存储后端，并返回其各分片文件的后缀。
+// enc 为 nil 表示无奇偶校验模式，此时整个加密块作为单个文件写入。
+// 每个分片写入前都会经过 limiter 限速，limiter 为 nil 时不限速。
+func (s *Syncer) writeChunkShards(ctx context.Context, manifestID string, chunkNumber int, encryptedData []byte, enc reedsolomon.Encoder, maxShardBytes int, transform ShardTransform, limiter *rateLimiter) ([]string, error) {
+	if enc == nil {
+		if err := limiter.wait(ctx, len(encryptedData)); err != nil {
+			return nil, err
+		}
+		if err := s.putShard(ctx, manifestID, fmt.Sprintf("chunk_%d%s", chunkNumber, plainChunkSuffix), encryptedData, maxShardBytes, transform); err != nil {
+			return nil, fmt.Errorf("failed to write chunk %d: %w", chunkNumber, err)
+		}
+		s.metrics().AddBytesWritten(len(encryptedData))
+		return []string{plainChunkSuffix}, nil
 	}
 
+	shards, err := enc.Split(encryptedData)
+	if err != nil {
+		return nil, &ErasureError{Op: ErasureSplit, Chunk: chunkNumber, Err: err}
+	}
 
+	if err := enc.Encode(shards); err != nil {
+		return nil, &ErasureError{Op: ErasureEncode, Chunk: chunkNumber, Err: err}
+	}
 
-	// 4. Decrypt original filename
-	decryptedOrigFilename, err := decrypt(manifest.EncryptedOrigFilename, key)
+	var suffixes []string
+	for i, shard := range shards {
+		suffix := shardSuffix(i)
+		if err := limiter.wait(ctx, len(shard)); err != nil {
+			return nil, err
+		}
+		if err := s.putShard(ctx, manifestID, fmt.Sprintf("chunk_%d%s", chunkNumber, suffix), shard, maxShardBytes, transform); err != nil {
+			return nil, fmt.Errorf("failed to write shard %d of chunk %d: %w", i, chunkNumber, err)
+		}
+		s.metrics().AddBytesWritten(len(shard))
+		suffixes = append(suffixes, suffix)
+	}
+	return suffixes, nil
+}
+
+// putChunkShards 把加密块 ciphertext 用 enc 拆分编码后写入以 chunkPath 加 suffixes 命名的分片（enc 为 nil 时整块写入唯一的分片），
+// 返回写入的所有文件名（包括拆分后的各个部分）。出错时同样返回已经写入的文件名，以便调用方清理。chunk 是块序号，只用于错误信息。
+func (s *Syncer) putChunkShards(ctx context.Context, manifestID string, chunk int, chunkPath string, suffixes []string, ciphertext []byte, enc reedsolomon.Encoder, maxShardBytes int, transform ShardTransform) ([]string, error) {
+	shards := [][]byte{ciphertext}
+	if enc != nil {
+		var err error
+		if shards, err = enc.Split(ciphertext); err != nil {
+			return nil, &ErasureError{Op: ErasureSplit, Chunk: chunk, Err: err}
+		}
+		if err := enc.Encode(shards); err != nil {
+			return nil, &ErasureError{Op: ErasureEncode, Chunk: chunk, Err: err}
+		}
+	}
+	var names []string
+	for j, shard := range shards {
+		name := chunkPath + suffixes[j]
+		names = append(names, shardPartNames(name, len(shard), maxShardBytes)...)
+		if err := s.putShard(ctx, manifestID, name, shard, maxShardBytes, transform); err != nil {
+			return names, fmt.Errorf("failed to write shard %s: %w", name, err)
+		}
+		s.metrics().AddBytesWritten(len(shard))
+	}
+	return names, nil
+}
+
+// newErasureCoder 按 Syncer.RSGoroutines 创建 dataShards+parityShards 的纠删码编码器。
+func (s *Syncer) newErasureCoder(dataShards, parityShards int) (reedsolomon.Encoder, error) {
+	var opts []reedsolomon.Option
+	if s.RSGoroutines > 0 {
+		opts = append(opts, reedsolomon.WithMaxGoroutines(s.RSGoroutines))
+	}
+	enc, err := reedsolomon.New(dataShards, parityShards, opts...)
 	if err != nil {
-		return fmt.Errorf("failed to decrypt original filename: %w", err)
+		return nil, &ErasureError{Op: ErasureNew, Chunk: -1, Err: err}
 	}
+	return enc, nil
+}
 
+// shardSuffix 返回纠删码模式下第 i 个分片文件名的后缀。
+func shardSuffix(i int) string {
+	return fmt.Sprintf("_shard_%d.dat", i)
+}
 
+// standardShardSuffixes 返回 writeChunkShards 为每个块写入的分片后缀：无奇偶校验时只有 plainChunkSuffix。
+func standardShardSuffixes(dataShards, parityShards int) []string {
+	if parityShards == 0 {
+		return []string{plainChunkSuffix}
+	}
+	suffixes := make([]string, dataShards+parityShards)
+	for i := range suffixes {
+		suffixes[i] = shardSuffix(i)
+	}
+	return suffixes
+}
+
+// chunkSuffixes 返回第 i 个块的分片后缀，兼容为每个块分别记录后缀的旧清单。
+func (m *Manifest) chunkSuffixes(i int) []string {
+	if m.Version >= manifestVersionShardSuffixes {
+		return m.ShardSuffixes
+	}
+	return m.ErasureCodeChunkSuffixes[i]
+}
+
+// open 解密清单中用文件密钥或数据密钥加密的密文，AES-GCM 密文使用清单记录的 nonce 长度。
+func (m *Manifest) open(algorithm CipherAlgorithm, ciphertext []byte, key *memguard.LockedBuffer, aad []byte) ([]byte, error) {
+	return decryptAppend(algorithm, m.NonceSize, nil, ciphertext, key, aad)
+}
 
-	finalOutputPath := filepath.Join(outputPath, string(decryptedOrigFilename))
-	outputFile, err := os.Create(finalOutputPath)
+// encryptFilename 填充并以 aad 为关联数据加密原始文件名。
+func encryptFilename(algorithm CipherAlgorithm, name string, key *memguard.LockedBuffer, aad []byte) ([]byte, error) {
+	return encryptFilenameWith(encryptAppend, algorithm, name, key, aad)
+}
+
+// encryptFilenameWith 与 encryptFilename 相同，但使用 seal 加密。
+func encryptFilenameWith(seal sealFunc, algorithm CipherAlgorithm, name string, key *memguard.LockedBuffer, aad []byte) ([]byte, error) {
+	padded, err := padFilename(name)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return nil, err
 	}
-	defer outputFile.Close()
+	return seal(algorithm, nil, padded, key, aad)
+}
 
-	// 5. Reconstruct and decrypt chunks
-	enc, err := reedsolomon.New(manifest.DataShards, manifest.ParityShards)
+// decryptFilename 解密清单中的原始文件名；旧版清单中的文件名没有填充。
+func decryptFilename(manifest *Manifest, key *memguard.LockedBuffer) (string, error) {
+	plaintext, err := manifest.open(manifest.KeyWrapCipher, manifest.EncryptedOrigFilename, key, manifest.aad)
 	if err != nil {
-		return fmt.Errorf("failed to create erasure code decoder: %w", err)
+		return "", err
+	}
+	if manifest.Version < manifestVersionPaddedFilename {
+		return string(plaintext), nil
+	}
+	return unpadFilename(plaintext)
+}
+
+// decryptChunk 用文件密钥解开第 i 个块的数据密钥，并把该块的加密数据解密后追加到 dst 之后。
+func decryptChunk(manifestID string, manifest *Manifest, key *memguard.LockedBuffer, i int, dst, encryptedData []byte) ([]byte, error) {
+	// Decrypt data key
+	dataKeyBytes, err := manifest.open(manifest.KeyWrapCipher, manifest.EncryptedDataKeys[i], key, manifest.aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key for chunk %d: %w", i, err)
+	}
+	dataKey := memguard.NewBufferFromBytes(dataKeyBytes)
+	defer dataKey.Destroy() // Destroy key immediately after use
+
+	// Decrypt chunk data; legacy manifests were encrypted without associated data
+	var aad []byte
+	if manifest.Version >= manifestVersionChunkAAD {
+		aad = chunkAAD(manifestID, i)
+	}
+	decryptedData, err := decryptAppend(manifest.ChunkCipher, manifest.NonceSize, dst, encryptedData, dataKey, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt chunk %d: %w", i, err)
+	}
+	return decryptedData, nil
+}
+
+// chunkDamage 描述 readChunk 读取一个块时发现的问题，零值表示该块完好。
+type chunkDamage struct {
+	// degraded 表示该块处于降级状态，即有分片丢失、读取失败或内容损坏，需要通过纠删码重建。
+	degraded bool
+	// missing 是丢失、读取失败或长度不对的分片序号。
+	missing []int
+	// corrupted 是通过逐个排除定位到的内容损坏的分片序号，最多一个。
+	// 奇偶校验分片损坏而数据分片完好时块仍能解密，但无法定位，此时 degraded 为 true 而 corrupted 为空。
+	corrupted []int
+}
+
+// readChunk 读取第 i 个块的分片，必要时通过纠删码重建，解密并返回该块的明文。
+// 返回的 chunkDamage 描述该块是否处于降级状态，以及哪些分片丢失或损坏。
+// 读取失败（包括 RetryBackend 重试耗尽）的分片与丢失的分片同样处理，只要剩余分片足以重建该块。
+//
+// 纠删码只能填补已知缺失的分片，无法定位内容被篡改或翻转的分片。因此当分片校验失败、
+// 重建后的块又无法通过 AEAD 认证时，会依次假设每个现存分片已损坏，将其丢弃后重建并重新认证，
+// 直到找到能通过认证的组合为止。
+// enc 为 nil 表示该清单处于无奇偶校验模式，块文件将被直接读取并解密。
+// checkParity 为 false 时先只读取数据分片并直接拼接解密，认证通过即返回，奇偶校验分片只在数据分片缺失或损坏时才读取，
+// 因此健康的块只需读取一半左右的数据，但奇偶校验分片的丢失或损坏不会被发现；VerifyManifest 等检查操作应传 true。
+// 明文所在的缓冲区取自 s.BufferPool，调用方用完后可以通过 put 放回。
+func (s *Syncer) readChunk(ctx context.Context, manifestID string, manifest *Manifest, enc reedsolomon.Encoder, key *memguard.LockedBuffer, i int, checkParity bool) ([]byte, chunkDamage, error) {
+	return s.recoverChunk(ctx, manifestID, manifest, enc, i, checkParity, func(ciphertext []byte) ([]byte, error) {
+		return decryptChunk(manifestID, manifest, key, i, s.BufferPool.get(len(ciphertext)), ciphertext)
+	})
+}
+
+// recoverChunk 是 readChunk 的实现：它读取并在必要时重建第 i 个块的加密数据，把每个候选密文交给 open，
+// 直到 open 成功为止，并返回 open 的结果。open 负责认证密文；密文所在的缓冲区在 open 返回后被放回 s.BufferPool，
+// open 不能保留它。
+func (s *Syncer) recoverChunk(ctx context.Context, manifestID string, manifest *Manifest, enc reedsolomon.Encoder, i int, checkParity bool, open func(ciphertext []byte) ([]byte, error)) ([]byte, chunkDamage, error) {
+	if err := s.checkChunkSize(manifest, i); err != nil {
+		return nil, chunkDamage{}, err
+	}
+	if _, err := s.shardTransform(manifest); err != nil {
+		return nil, chunkDamage{}, err
+	}
+	if enc == nil {
+		data, err := s.readShard(ctx, manifestID, manifest, i, 0)
+		if err != nil {
+			return nil, chunkDamage{}, fmt.Errorf("failed to read chunk %d (no parity shards to reconstruct from): %w", i, err)
+		}
+		if len(data) != manifest.EncryptedChunkSizes[i] {
+			return nil, chunkDamage{}, fmt.Errorf("chunk %d is %d bytes, but the manifest records %d", i, len(data), manifest.EncryptedChunkSizes[i])
+		}
+		plaintext, err := open(data)
+		return plaintext, chunkDamage{}, err
 	}
 
-	for i, chunkBaseName := range manifest.ChunkPaths {
-		shards := make([][]byte, manifest.DataShards+manifest.ParityShards)
-		shardPresentCount := 0
+	// Every shard of a chunk has the same size; anything else is treated as missing
+	shardSize := manifest.shardSize(i)
+	shards := make([][]byte, manifest.DataShards+manifest.ParityShards)
+	shardPresentCount := 0
+	var readErr error
+	var damage chunkDamage
 
-		for j, suffix := range manifest.ErasureCodeChunkSuffixes[i] {
-			shardPath := filepath.Join(s.StorageDir, manifestID, fmt.Sprintf("%s%s", chunkBaseName, suffix))
-			data, err := os.ReadFile(shardPath)
+	// readShards reads shards [from, to) into shards, leaving missing or unreadable ones nil
+	readShards := func(from, to int) error {
+		for j := from; j < to; j++ {
+			location := manifest.shardLocation(manifestID, i, j)
+			data, err := s.readShard(ctx, manifestID, manifest, i, j)
 			if err != nil {
-				if !os.IsNotExist(err) {
-					return fmt.Errorf("failed to read shard %s: %w", shardPath, err)
+				// A cancelled context fails every remaining read, so there is no point going on
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return ctxErr
+				}
+				if !errors.Is(err, os.ErrNotExist) {
+					readErr = fmt.Errorf("failed to read shard %s: %w", location, err)
 				}
-				shards[j] = nil // Mark missing shard as nil
-			} else {
-				shards[j] = data
-				shardPresentCount++
+				damage.missing = append(damage.missing, j)
+				continue
 			}
+			if len(data) != shardSize {
+				damage.missing = append(damage.missing, j)
+				continue
+			}
+			shards[j] = data
+			shardPresentCount++
 		}
+		return nil
+	}
 
-		if shardPresentCount < manifest.DataShards {
-			return fmt.Errorf("not enough shards to reconstruct chunk %d: have %d, need %d", i, shardPresentCount, manifest.DataShards)
+	// reconstructAndDecrypt rebuilds the nil data shards of a copy of candidate and authenticates the result
+	reconstructAndDecrypt := func(candidate [][]byte) ([]byte, error) {
+		// Reconstruction allocates the recorded size, which the shards actually read must cover
+		readBytes := 0
+		for _, shard := range candidate {
+			readBytes += len(shard)
 		}
-
-		// Verify the shards, and reconstruct if necessary.
-		ok, err := enc.Verify(shards)
-		if !ok {
-			if err != nil { // Log the verification error
-				fmt.Printf("Shard verification failed for chunk %d: %v. Attempting reconstruction.\n", i, err)
-			}
-			if err := enc.Reconstruct(shards); err != nil {
-				return fmt.Errorf("failed to reconstruct chunk %d after verification failure: %w", i, err)
-			}
+		if manifest.EncryptedChunkSizes[i] > readBytes {
+			return nil, fmt.Errorf("chunk %d records %d bytes, but only %d bytes of shards were read", i, manifest.EncryptedChunkSizes[i], readBytes)
+		}
+		candidate = slices.Clone(candidate)
+		if err := enc.ReconstructData(candidate); err != nil {
+			return nil, &ErasureError{Op: ErasureReconstruct, Chunk: i, Err: err}
+		}
+		encryptedData := bytes.NewBuffer(s.BufferPool.get(manifest.EncryptedChunkSizes[i]))
+		defer func() { s.BufferPool.put(encryptedData.Bytes()) }()
+		if err := enc.Join(encryptedData, candidate, manifest.EncryptedChunkSizes[i]); err != nil {
+			return nil, &ErasureError{Op: ErasureJoin, Chunk: i, Err: err}
 		}
+		return open(encryptedData.Bytes())
+	}
 
-		var encryptedData bytes.Buffer
-		if err := enc.Join(&encryptedData, shards, manifest.EncryptedChunkSizes[i]); err != nil {
-			return fmt.Errorf("failed to join shards for chunk %d: %w", i, err)
+	// 1. Fast path: join the data shards directly; the parity shards are only read when that fails
+	if err := readShards(0, manifest.DataShards); err != nil {
+		return nil, chunkDamage{}, err
+	}
+	if !checkParity && shardPresentCount == manifest.DataShards {
+		if plaintext, err := reconstructAndDecrypt(shards); err == nil {
+			return plaintext, chunkDamage{}, nil
 		}
+	}
+	if err := readShards(manifest.DataShards, len(shards)); err != nil {
+		return nil, chunkDamage{}, err
+	}
 
-		// Decrypt data key
-		dataKeyBytes, err := decrypt(manifest.EncryptedDataKeys[i], key)
-		if err != nil {
-			return fmt.Errorf("failed to decrypt data key for chunk %d: %w", i, err)
+	if shardPresentCount < manifest.DataShards {
+		if readErr != nil {
+			return nil, chunkDamage{}, fmt.Errorf("not enough shards to reconstruct chunk %d: have %d, need %d: %w", i, shardPresentCount, manifest.DataShards, readErr)
 		}
-		dataKey := memguard.NewBufferFromBytes(dataKeyBytes)
+		return nil, chunkDamage{}, fmt.Errorf("not enough shards to reconstruct chunk %d: have %d, need %d", i, shardPresentCount, manifest.DataShards)
+	}
+	missing := len(shards) - shardPresentCount
 
-		// Decrypt chunk data
-		decryptedData, err := decrypt(encryptedData.Bytes(), dataKey)
-		dataKey.Destroy() // Destroy key immediately after use
-		if err != nil {
-			return fmt.Errorf("failed to decrypt chunk %d: %w", i, err)
+	// 2. All shards present and consistent with their parity
+	if missing == 0 {
+		if ok, _ := enc.Verify(shards); ok {
+			plaintext, err := reconstructAndDecrypt(shards)
+			return plaintext, chunkDamage{}, err
 		}
+	}
+	damage.degraded = true
 
-		if _, err := outputFile.Write(decryptedData); err != nil {
-			return fmt.Errorf("failed to write decrypted chunk %d to file: %w", i, err)
+	// 3. Fill in the missing shards; this is enough unless a present shard is corrupted
+	plaintext, err := reconstructAndDecrypt(shards)
+	if err == nil {
+		if missing > 0 {
+			s.metrics().IncShardsReconstructed(missing)
 		}
+		return plaintext, damage, nil
 	}
 
-	return nil
+	// 4. Locate a corrupted shard by dropping each present shard in turn
+	if shardPresentCount > manifest.DataShards {
+		for j := range shards {
+			if shards[j] == nil {
+				continue
+			}
+			candidate := slices.Clone(shards)
+			candidate[j] = nil
+			if plaintext, err := reconstructAndDecrypt(candidate); err == nil {
+				s.metrics().IncShardsReconstructed(missing + 1)
+				damage.corrupted = []int{j}
+				return plaintext, damage, nil
+			}
+		}
+	}
+	return nil, chunkDamage{}, fmt.Errorf("failed to reconstruct chunk %d: %w", i, err)
 }
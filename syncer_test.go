@@ -0,0 +1,339 @@
+package secstorage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestWriteManifestKeepsOriginalOnFailure(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 3000)
+
+	manifest, key, err := s.OpenManifest(manifestID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+
+	// A directory in place of the temp file makes the write fail halfway
+	tempPath := s.getManifestPath(manifestID) + ".tmp"
+	if err := os.Mkdir(tempPath, defaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	manifest.CreatorVersion = "edited"
+	if err := s.WriteManifest(manifestID, manifest, key); err == nil {
+		t.Fatal("WriteManifest succeeded despite the blocked temp file")
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	os.RemoveAll(tempPath)
+	manifest, key2, err := s.OpenManifest(manifestID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key2.Destroy()
+	if err := s.WriteManifest(manifestID, manifest, key2); err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
+
+func TestOmitFilename(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.OmitFilename = true
+	manifestID, data := encryptTestFile(t, s, opts, 3000)
+
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.EncryptedOrigFilename) != 0 {
+		t.Fatal("manifest stores a filename")
+	}
+	if err := s.DecryptFile(manifestID, "", testPassword); err == nil {
+		t.Fatal("DecryptFile accepted an empty output path")
+	}
+	outputPath := filepath.Join(t.TempDir(), "restored.bin")
+	if err := s.DecryptFile(manifestID, outputPath, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(outputPath); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("restored file differs: %v", err)
+	}
+}
+
+func TestEncryptWithoutParity(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.ParityShards = 0
+	manifestID, data := encryptTestFile(t, s, opts, 5000)
+
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.DataShards != 1 || manifest.ParityShards != 0 {
+		t.Fatalf("manifest records %d+%d shards, want 1+0", manifest.DataShards, manifest.ParityShards)
+	}
+	if len(manifest.ShardSuffixes) != 1 || manifest.ShardSuffixes[0] != plainChunkSuffix {
+		t.Fatalf("chunks stored as %v", manifest.ShardSuffixes)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	// Without parity a lost chunk file cannot be recovered
+	if err := os.Remove(filepath.Join(s.StorageDir, manifestID, "chunk_0"+plainChunkSuffix)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DecryptFile(manifestID, t.TempDir(), testPassword); err == nil {
+		t.Fatal("decrypted a file with a missing chunk")
+	}
+}
+
+func TestEncryptFileSealed(t *testing.T) {
+	s := newTestSyncer(t)
+	path, data := writeTestFile(t, t.TempDir(), "input.bin", 3000)
+	manifestID, err := s.EncryptFileSealed(path, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	chunkPath := filepath.Join(s.StorageDir, manifestID, "chunk_0"+plainChunkSuffix)
+	sealed, err := os.ReadFile(chunkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed[0] ^= 1
+	if err := os.WriteFile(chunkPath, sealed, defaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DecryptFile(manifestID, t.TempDir(), testPassword); err == nil {
+		t.Fatal("decrypted a tampered sealed chunk")
+	}
+}
+
+func TestEncryptOpenFile(t *testing.T) {
+	s := newTestSyncer(t)
+	path, data := writeTestFile(t, t.TempDir(), "locked.bin", 3000)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(100, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading starts at the current offset and the handle is left open
+	manifestID, err := s.EncryptOpenFile(f, "input.bin", testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Stat(); err != nil {
+		t.Fatalf("handle was closed: %v", err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data[100:])
+
+	// Without a name the handle's own name is stored
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if manifestID, err = s.EncryptOpenFile(f, "", testOptions()); err != nil {
+		t.Fatal(err)
+	}
+	outputDir := t.TempDir()
+	if err := s.DecryptFile(manifestID, outputDir, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(outputDir, "locked.bin")); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("restored locked.bin differs: %v", err)
+	}
+}
+
+func TestEncryptOpenFilePipe(t *testing.T) {
+	s := newTestSyncer(t)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data := make([]byte, 5000)
+	rand.Read(data)
+	go func() {
+		w.Write(data)
+		w.Close()
+	}()
+
+	manifestID, err := s.EncryptOpenFile(r, "input.bin", testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
+
+func TestEncryptFileWithManifestID(t *testing.T) {
+	s := newTestSyncer(t)
+	index, err := NewFileIndex(filepath.Join(t.TempDir(), "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Index = index
+	opts := testOptions()
+	opts.ManifestID = strings.Repeat("ab", defaultManifestIDBytes)
+
+	manifestID, first := encryptTestFile(t, s, opts, 3000)
+	if manifestID != opts.ManifestID {
+		t.Fatalf("got manifest ID %s, want %s", manifestID, opts.ManifestID)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, first)
+
+	path, _ := writeTestFile(t, t.TempDir(), "input.bin", 100)
+	if _, err := s.EncryptFile(path, opts); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("encrypting to an existing ID: got %v, want os.ErrExist", err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, first)
+
+	opts.Overwrite = true
+	_, second := encryptTestFile(t, s, opts, 100)
+	assertDecrypts(t, s, manifestID, testPassword, second)
+	ids, err := s.ListManifests()
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("ListManifests = %v, %v; want only the overwritten manifest", ids, err)
+	}
+	// The smaller replacement must not leave the old object's later chunks behind
+	if _, err := os.Stat(shardPath(s, manifestID, 2, 0)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("shard of the overwritten object remains: %v", err)
+	}
+}
+
+func TestEncryptFileOverwritesEmptyManifestDir(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.ManifestID = strings.Repeat("cd", defaultManifestIDBytes)
+	opts.Overwrite = true
+
+	// An upload that died before writing its progress file leaves only the directory and stray files
+	dir := s.manifestDir(opts.ManifestID)
+	if err := os.MkdirAll(dir, defaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "leftover.tmp"), []byte("x"), defaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	manifestID, data := encryptTestFile(t, s, opts, 3000)
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	if _, err := os.Stat(filepath.Join(dir, "leftover.tmp")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("leftover file survived the overwrite: %v", err)
+	}
+}
+
+func TestEncryptFileRejectsBadManifestID(t *testing.T) {
+	s := newTestSyncer(t)
+	path, _ := writeTestFile(t, t.TempDir(), "input.bin", 100)
+	for _, id := range []string{"../escape", "ABAB", strings.Repeat("ab", 4)} {
+		opts := testOptions()
+		opts.ManifestID = id
+		if _, err := s.EncryptFile(path, opts); err == nil {
+			t.Errorf("manifest ID %q accepted", id)
+		}
+	}
+	opts := testOptions()
+	opts.ManifestID = strings.Repeat("ab", defaultManifestIDBytes)
+	opts.ResumeManifestID = opts.ManifestID
+	if _, err := s.EncryptFile(path, opts); err == nil {
+		t.Error("ManifestID together with ResumeManifestID accepted")
+	}
+	if entries, _ := os.ReadDir(s.StorageDir); len(entries) > 0 {
+		t.Fatalf("rejected IDs created %d entries", len(entries))
+	}
+}
+
+func TestStorageWriteErr(t *testing.T) {
+	for _, errno := range []syscall.Errno{syscall.EROFS, syscall.EACCES} {
+		err := storageWriteErr(&fs.PathError{Op: "mkdir", Path: "x", Err: errno})
+		if !errors.Is(err, ErrStorageReadOnly) || !errors.Is(err, errno) {
+			t.Fatalf("%v: got %v", errno, err)
+		}
+	}
+	if err := storageWriteErr(&fs.PathError{Op: "mkdir", Path: "x", Err: syscall.ENOSPC}); errors.Is(err, ErrStorageReadOnly) {
+		t.Fatalf("a full disk was reported as read-only: %v", err)
+	}
+}
+
+func TestEncryptFileReadOnlyStorage(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to directories regardless of their permissions")
+	}
+	s := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, s, testOptions(), 100)
+	if err := os.Chmod(s.StorageDir, 0o500); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(s.StorageDir, defaultDirPerm)
+	deriver := &countingDeriver{}
+	s.KeyDeriver = deriver
+
+	path, _ := writeTestFile(t, t.TempDir(), "input.bin", 100)
+	if _, err := s.EncryptFile(path, testOptions()); !errors.Is(err, ErrStorageReadOnly) {
+		t.Fatalf("got %v, want ErrStorageReadOnly", err)
+	}
+	if deriver.calls.Load() != 0 {
+		t.Fatal("keys were derived for an encryption that could not be stored")
+	}
+	// Reading is unaffected
+	if _, err := s.ReadManifest(manifestID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRSGoroutines(t *testing.T) {
+	s := newTestSyncer(t)
+	s.RSGoroutines = 1
+	manifestID, data := encryptTestFile(t, s, testOptions(), 5000)
+	if err := os.Remove(shardPath(s, manifestID, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	s.RSGoroutines = 4
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
+
+// BenchmarkErasureEncode 比较 RSGoroutines 对 10+4 分片编码一个 16MB 块的吞吐量的影响。
+func BenchmarkErasureEncode(b *testing.B) {
+	data := make([]byte, 16<<20)
+	rand.Read(data)
+	for _, tc := range []struct {
+		name       string
+		goroutines int
+	}{
+		{"default", 0},
+		{"single", 1},
+		{"four", 4},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			s := &Syncer{RSGoroutines: tc.goroutines}
+			enc, err := s.newErasureCoder(10, 4)
+			if err != nil {
+				b.Fatal(err)
+			}
+			shards, err := enc.Split(bytes.Clone(data))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				if err := enc.Encode(shards); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package secstorage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// checkTempDir 确认 dir 存在且可以在其中创建文件，使 TempDir 配置错误在派生密钥和解密之前就被发现。
+func checkTempDir(dir string) error {
+	file, err := os.CreateTemp(dir, ".probe-*")
+	if err != nil {
+		return fmt.Errorf("temp directory %s is not writable: %w", dir, err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// outputTempDir 返回解密 path 时存放临时文件的目录：配置了 TempDir 时使用它，否则使用 path 所在的目录。
+func (s *Syncer) outputTempDir(path string) string {
+	if s.TempDir != "" {
+		return s.TempDir
+	}
+	return filepath.Dir(path)
+}
+
+// moveFile 把临时文件 src 移动到 dst。两者位于不同文件系统而无法重命名时，先把内容和 attrs 中的扩展属性
+// 复制到 dst 所在目录的另一个临时文件，再将其重命名到 dst 并删除 src，因此 dst 同样不会处于半写入状态。
+func moveFile(src, dst string, attrs map[string][]byte) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	copyErr := func() error {
+		if _, err := io.Copy(out, in); err != nil {
+			return err
+		}
+		if err := writeXattrs(out, attrs); err != nil {
+			return err
+		}
+		if err := out.Chmod(defaultFilePerm); err != nil {
+			return err
+		}
+		return out.Close()
+	}()
+	if copyErr != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return fmt.Errorf("failed to copy %s across file systems: %w", src, copyErr)
+	}
+	if err := os.Rename(out.Name(), dst); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Remove(src)
+}
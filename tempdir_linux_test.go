@@ -0,0 +1,42 @@
+//go:build linux
+
+package secstorage
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestMoveFileAcrossFileSystems(t *testing.T) {
+	// /dev/shm is a separate tmpfs on most Linux systems
+	shm, err := os.MkdirTemp("/dev/shm", "secstorage-test-*")
+	if err != nil {
+		t.Skip("no /dev/shm:", err)
+	}
+	defer os.RemoveAll(shm)
+	dstDir := t.TempDir()
+	var shmStat, dstStat syscall.Stat_t
+	if syscall.Stat(shm, &shmStat) != nil || syscall.Stat(dstDir, &dstStat) != nil || shmStat.Dev == dstStat.Dev {
+		t.Skip("/dev/shm is on the same file system as the test directory")
+	}
+
+	src := filepath.Join(shm, "decrypted")
+	if err := os.WriteFile(src, []byte("moved across devices"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dstDir, "output.txt")
+	if err := moveFile(src, dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(dst); err != nil || string(got) != "moved across devices" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Fatalf("source still exists: %v", err)
+	}
+	if entries, _ := os.ReadDir(dstDir); len(entries) != 1 {
+		t.Fatalf("target directory has %d entries, want 1", len(entries))
+	}
+}
@@ -0,0 +1,22 @@
+package secstorage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecryptFileTempDir(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 3000)
+	s.TempDir = t.TempDir()
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	if entries, _ := os.ReadDir(s.TempDir); len(entries) != 0 {
+		t.Fatalf("temp directory has %d entries left", len(entries))
+	}
+
+	s.TempDir = filepath.Join(t.TempDir(), "missing")
+	if err := s.DecryptFile(manifestID, t.TempDir(), testPassword); err == nil {
+		t.Fatal("expected an error for a missing temp directory")
+	}
+}
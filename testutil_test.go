@@ -0,0 +1,84 @@
+package secstorage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// testPassword 是测试中默认使用的密码。
+const testPassword = "correct horse battery staple"
+
+// testOptions 返回一组参数较小、运行较快的加密选项：1KB 的块使得几 KB 的文件就会被分成多个块。
+func testOptions() EncryptionOptions {
+	return EncryptionOptions{
+		Password:      testPassword,
+		DataShards:    4,
+		ParityShards:  2,
+		ChunkSizeKB:   1,
+		Argon2Time:    1,
+		Argon2Memory:  64,
+		Argon2Threads: 1,
+	}
+}
+
+// newTestSyncer 创建一个以临时目录为存储目录的 Syncer。
+func newTestSyncer(t *testing.T) *Syncer {
+	t.Helper()
+	return NewSyncer(t.TempDir())
+}
+
+// writeTestFile 在 dir 下写入一个名为 name、内容随机的文件，返回其路径和内容。
+func writeTestFile(t testing.TB, dir, name string, size int) (string, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), defaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, defaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+// encryptTestFile 加密一个大小为 size 的随机文件 "input.bin"，返回 manifestID 和原始内容。
+func encryptTestFile(t *testing.T, s *Syncer, opts EncryptionOptions, size int) (string, []byte) {
+	t.Helper()
+	path, data := writeTestFile(t, t.TempDir(), "input.bin", size)
+	manifestID, err := s.EncryptFile(path, opts)
+	if err != nil {
+		t.Fatalf("EncryptFile: %v", err)
+	}
+	return manifestID, data
+}
+
+// assertDecrypts 解密 manifestID 并检查还原的 "input.bin" 与 want 一致。
+func assertDecrypts(t *testing.T, s *Syncer, manifestID, password string, want []byte) {
+	t.Helper()
+	outputDir := t.TempDir()
+	if err := s.DecryptFile(manifestID, outputDir, password); err != nil {
+		t.Fatalf("DecryptFile: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(outputDir, "input.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("decrypted content differs: got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+// countingMetrics 是记录被重建分片数量的 Metrics 实现。
+type countingMetrics struct {
+	noopMetrics
+	reconstructed atomic.Int64
+}
+
+func (m *countingMetrics) IncShardsReconstructed(n int) { m.reconstructed.Add(int64(n)) }
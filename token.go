@@ -0,0 +1,208 @@
+package secstorage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// manifestTokenSeparator 分隔清单令牌中的 manifestID 和编码后的清单。
+// 所有受支持的 manifestID 编码都不会产生该字符。
+const manifestTokenSeparator = "."
+
+// ExportManifestToken 将 manifestID 对应的清单编码为一个可复制的字符串，形如 "<manifestID>.<base64url 编码的清单 JSON>"。
+// 令牌只包含清单本身（其中的秘密都是加密的，整体由签名保护），不包含任何分片，
+// 适合在分片另行同步的情况下单独传递元数据。接收方用 ImportManifestToken 导入。
+func (s *Syncer) ExportManifestToken(manifestID string) (string, error) {
+	manifest, err := s.loadManifest(manifestID)
+	if err != nil {
+		return "", err
+	}
+	return encodeManifestToken(manifestID, manifest)
+}
+
+// encodeManifestToken 把 manifestID 和清单编码为 ExportManifestToken 格式的令牌。
+func encodeManifestToken(manifestID string, manifest *Manifest) (string, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	return manifestID + manifestTokenSeparator + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// EncryptFileDetachedManifest 与 EncryptFile 相同，但签名后的清单不写入存储目录，也不记录到索引中，
+// 而是以 ExportManifestToken 的令牌格式返回，由调用方另行保管（例如放进单独的密钥库），存储中只留下分片。
+// 解密时把返回的字节交给 DecryptFileWithManifest，也可以之后用 ImportManifestToken 把它转为普通的清单。
+// 恢复记录可以用来重建清单，因此不能与 opts.RecoveryRecords 同时使用。
+// 上传中断时同样返回 manifestID，可以设置 opts.ResumeManifestID 再次调用以续传。
+func (s *Syncer) EncryptFileDetachedManifest(localPath string, opts EncryptionOptions) (manifestBytes []byte, manifestID string, err error) {
+	if opts.RecoveryRecords {
+		return nil, "", errors.New("recovery records cannot be written for a detached manifest")
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+	size, xattrs, owner, err := readSourceFile(file, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	upload, err := s.startUpload(opts)
+	if err != nil {
+		return nil, "", err
+	}
+	var manifest Manifest
+	upload.detached = &manifest
+	if manifestID, err = s.encryptUpload(context.Background(), upload, file, size, localPath, xattrs, owner, opts); err != nil {
+		return nil, manifestID, err
+	}
+	token, err := encodeManifestToken(manifestID, &manifest)
+	if err != nil {
+		return nil, manifestID, err
+	}
+	return []byte(token), manifestID, nil
+}
+
+// DecryptFileWithManifest 与 DecryptFile 相同，但使用 EncryptFileDetachedManifest 返回的清单，而不是从存储目录读取。
+// 清单的签名同样用 password 验证，分片仍从 Syncer 的后端读取。outputPath 的含义与 DecryptFile 相同，但不支持 StdoutPath。
+func (s *Syncer) DecryptFileWithManifest(manifestBytes []byte, outputPath, password string) error {
+	manifestID, manifest, err := decodeManifestToken(string(manifestBytes))
+	if err != nil {
+		return err
+	}
+	if err := s.validateManifestID(manifestID); err != nil {
+		return err
+	}
+	if outputPath == StdoutPath {
+		return errors.New("decrypting a detached manifest to standard output is not supported")
+	}
+	_, err = s.decryptManifest(context.Background(), s.keyDeriver(), manifestID, manifest, password, outputTarget(manifestID, outputPath))
+	return err
+}
+
+// ImportManifestToken 解码 ExportManifestToken 生成的令牌，检查其 manifestID 和清单结构，
+// 然后将清单写入存储目录并返回 manifestID。同名清单已存在时返回错误而不会覆盖它。
+// 导入不需要密码，因此无法验证签名；签名会在之后用密码打开清单时验证，也可以事先用 VerifyManifestToken 验证。
+func (s *Syncer) ImportManifestToken(token string) (string, error) {
+	manifestID, manifest, err := decodeManifestToken(token)
+	if err != nil {
+		return "", err
+	}
+	if err := s.validateManifestID(manifestID); err != nil {
+		return "", err
+	}
+
+	defer s.lockManifest(manifestID)()
+	if err := s.checkImportTarget(manifestID); err != nil {
+		return "", err
+	}
+	if err := s.importManifest(manifestID, manifest); err != nil {
+		return "", err
+	}
+	return manifestID, nil
+}
+
+// checkImportTarget 在 manifestID 已有清单或未完成的上传时返回错误，以免导入覆盖它们。调用方必须持有清单锁。
+func (s *Syncer) checkImportTarget(manifestID string) error {
+	if err := s.migrateManifestDir(manifestID); err != nil {
+		return err
+	}
+	for _, path := range []string{s.getManifestPath(manifestID), s.progressPath(manifestID)} {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("manifest %s already exists, refusing to overwrite it", manifestID)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// importManifest 将从外部获得的已签名清单写入 manifestID 对应的位置，并在配置了索引时记录它。
+// 调用方必须持有清单锁并已通过 checkImportTarget 检查。
+func (s *Syncer) importManifest(manifestID string, manifest *Manifest) error {
+	manifestPath := s.getManifestPath(manifestID)
+	if err := os.MkdirAll(filepath.Dir(manifestPath), defaultDirPerm); err != nil {
+		return fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+
+	data, err := s.encodeManifest(manifest)
+	if err != nil {
+		return err
+	}
+	if err := s.writeFile(manifestPath, data); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if s.Durable {
+		if err := syncDir(filepath.Dir(manifestPath)); err != nil {
+			return err
+		}
+		if err := s.syncManifestParents(manifestID); err != nil {
+			return err
+		}
+	}
+
+	if s.Index != nil {
+		createdAt := manifest.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now().UTC()
+		}
+		if err := s.Index.Put(newIndexEntry(manifestID, manifest, createdAt)); err != nil {
+			return fmt.Errorf("failed to update manifest index: %w", err)
+		}
+	}
+	return nil
+}
+
+// VerifyManifestToken 解码 ExportManifestToken 生成的令牌，用 password 解开文件密钥并验证清单签名，
+// 返回清单的非机密元数据。它不需要 Syncer，也不读写任何存储，适合在同步分片或导入之前确认令牌描述的文件是真实的。
+// 由于没有 Syncer 的配置，令牌中的 manifestID 不按 ID 编码检查，以 KeyWrapper 解密的文件也必须提供密码。
+func VerifyManifestToken(token, password string) (ManifestInfo, error) {
+	manifestID, manifest, err := decodeManifestToken(token)
+	if err != nil {
+		return ManifestInfo{}, err
+	}
+	key, err := unlockManifest(argon2Deriver{}, manifest, password)
+	if err != nil {
+		return ManifestInfo{}, err
+	}
+	defer key.Destroy()
+	if err := verifyManifestSignature(manifest, key); err != nil {
+		return ManifestInfo{}, err
+	}
+	return newManifestInfo(manifestID, manifest)
+}
+
+// decodeManifestToken 把令牌拆分为 manifestID 和清单，并检查清单带有签名且结构有效。它不验证签名本身。
+func decodeManifestToken(token string) (string, *Manifest, error) {
+	manifestID, encoded, ok := strings.Cut(token, manifestTokenSeparator)
+	if !ok {
+		return "", nil, errors.New("malformed manifest token: missing separator")
+	}
+	if manifestID == "" {
+		return "", nil, errors.New("malformed manifest token: empty manifest ID")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("malformed manifest token: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", nil, fmt.Errorf("failed to unmarshal manifest token: %w", err)
+	}
+	if len(manifest.Signature) == 0 {
+		return "", nil, fmt.Errorf("invalid manifest %s: missing signature", manifestID)
+	}
+	if err := validateManifest(&manifest); err != nil {
+		return "", nil, fmt.Errorf("invalid manifest %s: %w", manifestID, err)
+	}
+	return manifestID, &manifest, nil
+}
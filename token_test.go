@@ -0,0 +1,145 @@
+package secstorage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManifestTokenRoundTrip(t *testing.T) {
+	src := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, src, testOptions(), 3000)
+	token, err := src.ExportManifestToken(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The destination receives the shards separately and the manifest through the token
+	dst := newTestSyncer(t)
+	if err := os.CopyFS(dst.StorageDir, os.DirFS(src.StorageDir)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(dst.getManifestPath(manifestID)); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := dst.ImportManifestToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != manifestID {
+		t.Fatalf("imported %s, want %s", imported, manifestID)
+	}
+	assertDecrypts(t, dst, manifestID, testPassword, data)
+
+	if _, err := dst.ImportManifestToken(token); err == nil {
+		t.Fatal("import overwrote an existing manifest")
+	}
+}
+
+func TestImportManifestTokenRejectsBadInput(t *testing.T) {
+	src := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, src, testOptions(), 100)
+	token, err := src.ExportManifestToken(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, encoded, _ := strings.Cut(token, manifestTokenSeparator)
+
+	dst := newTestSyncer(t)
+	for name, bad := range map[string]string{
+		"no separator":   encoded,
+		"bad ID":         "../x." + encoded,
+		"bad base64":     manifestID + ".!!!",
+		"not a manifest": manifestID + ".bnVsbA",
+	} {
+		if _, err := dst.ImportManifestToken(bad); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	if entries, _ := os.ReadDir(dst.StorageDir); len(entries) > 0 {
+		t.Fatalf("rejected tokens left %s behind", filepath.Join(dst.StorageDir, entries[0].Name()))
+	}
+}
+
+func TestVerifyManifestToken(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, s, testOptions(), 3000)
+	token, err := s.ExportManifestToken(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is read from storage
+	if err := os.RemoveAll(s.StorageDir); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := VerifyManifestToken(token, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ManifestID != manifestID || info.DataShards != 4 || info.ParityShards != 2 || info.ChunkCount == 0 || !info.HasFilename {
+		t.Fatalf("got %+v", info)
+	}
+	if _, err := VerifyManifestToken(token, "wrong password"); err == nil {
+		t.Fatal("expected an error for a wrong password")
+	}
+
+	// A token whose manifest was altered after signing is rejected
+	_, encoded, _ := strings.Cut(token, manifestTokenSeparator)
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	manifest.CreatorVersion = "forged"
+	if data, err = json.Marshal(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	forged := manifestID + manifestTokenSeparator + base64.RawURLEncoding.EncodeToString(data)
+	if _, err := VerifyManifestToken(forged, testPassword); err == nil {
+		t.Fatal("expected a tampered token to fail verification")
+	}
+	if _, err := VerifyManifestToken(encoded, testPassword); err == nil {
+		t.Fatal("expected a malformed token to be rejected")
+	}
+}
+
+func TestDetachedManifest(t *testing.T) {
+	s := newTestSyncer(t)
+	s.Backend = NewLocalBackend(t.TempDir())
+	path, data := writeTestFile(t, t.TempDir(), "input.bin", 3000)
+	manifestBytes, manifestID, err := s.EncryptFileDetachedManifest(path, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := os.ReadDir(s.StorageDir); err != nil || len(entries) > 0 {
+		t.Fatalf("storage directory holds %v, %v; want nothing", entries, err)
+	}
+	if _, err := s.ReadManifest(manifestID); err == nil {
+		t.Fatal("detached manifest was written to storage")
+	}
+
+	outputDir := t.TempDir()
+	if err := s.DecryptFileWithManifest(manifestBytes, outputDir, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(outputDir, "input.bin")); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("decrypted content differs: %v", err)
+	}
+	if err := s.DecryptFileWithManifest(manifestBytes, t.TempDir(), "wrong password"); err == nil {
+		t.Fatal("decrypted with a wrong password")
+	}
+
+	opts := testOptions()
+	opts.RecoveryRecords = true
+	if _, _, err := s.EncryptFileDetachedManifest(path, opts); err == nil {
+		t.Fatal("wrote recovery records for a detached manifest")
+	}
+}
@@ -0,0 +1,43 @@
+package secstorage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ShardTransform 在分片写入后端之前对其进行额外的变换，并在读取后还原，例如把分片填充到固定大小，
+// 使后端无法根据大小关联分片，或者再套一层外部加密。设置 Syncer.ShardTransform 后，新加密的文件在清单中记录 Name，
+// 解密时必须配置同名的变换；没有记录变换的旧文件不受影响。
+// 分片按 MaxShardBytes 拆分时每个部分单独变换。实现可以被多个 goroutine 并发调用。
+type ShardTransform interface {
+	// Name 标识变换及其参数，记录在清单中，不能为空。
+	Name() string
+	// Apply 返回写入后端的数据，不能修改或保留 data。
+	Apply(data []byte) ([]byte, error)
+	// Invert 还原 Apply 的输出。
+	Invert(data []byte) ([]byte, error)
+}
+
+// shardTransformName 返回 Syncer.ShardTransform 的名称，没有配置时为空。
+func (s *Syncer) shardTransformName() (string, error) {
+	if s.ShardTransform == nil {
+		return "", nil
+	}
+	name := s.ShardTransform.Name()
+	if name == "" {
+		return "", errors.New("shard transform has an empty name")
+	}
+	return name, nil
+}
+
+// shardTransform 返回读写 manifest 的分片所用的变换，清单没有记录变换时为 nil。
+// 清单记录的变换与 Syncer.ShardTransform 不同名时返回错误。
+func (s *Syncer) shardTransform(manifest *Manifest) (ShardTransform, error) {
+	if manifest.ShardTransform == "" {
+		return nil, nil
+	}
+	if s.ShardTransform == nil || s.ShardTransform.Name() != manifest.ShardTransform {
+		return nil, fmt.Errorf("shards were stored with transform %q, which is not configured", manifest.ShardTransform)
+	}
+	return s.ShardTransform, nil
+}
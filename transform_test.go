@@ -0,0 +1,98 @@
+package secstorage
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// padTransform 把每个分片加上长度前缀并填充到 size 字节，使所有分片大小相同。
+type padTransform struct {
+	size int
+}
+
+func (p padTransform) Name() string {
+	return "pad"
+}
+
+func (p padTransform) Apply(data []byte) ([]byte, error) {
+	if len(data)+4 > p.size {
+		return nil, errors.New("shard too large to pad")
+	}
+	out := make([]byte, p.size)
+	binary.BigEndian.PutUint32(out, uint32(len(data)))
+	copy(out[4:], data)
+	return out, nil
+}
+
+func (p padTransform) Invert(data []byte) ([]byte, error) {
+	if len(data) < 4 || int(binary.BigEndian.Uint32(data)) > len(data)-4 {
+		return nil, errors.New("malformed padded shard")
+	}
+	return data[4 : 4+binary.BigEndian.Uint32(data)], nil
+}
+
+func TestShardTransform(t *testing.T) {
+	s := newTestSyncer(t)
+	s.ShardTransform = padTransform{size: 4096}
+	opts := testOptions()
+	opts.RecoveryRecords = true
+	manifestID, data := encryptTestFile(t, s, opts, 5000)
+
+	// Every stored shard has the padded size
+	names, err := filepath.Glob(filepath.Join(s.manifestDir(manifestID), "chunk_*.dat"))
+	if err != nil || len(names) == 0 {
+		t.Fatalf("no shards found: %v", err)
+	}
+	for _, name := range names {
+		if info, err := os.Stat(name); err != nil || info.Size() != 4096 {
+			t.Fatalf("%s: got %v, %v", name, info.Size(), err)
+		}
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	// The recorded name survives rebuilding the manifest from the recovery records
+	if err := os.Remove(s.getManifestPath(manifestID)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RebuildManifest(manifestID, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil || manifest.ShardTransform != "pad" {
+		t.Fatalf("got transform %q, %v", manifest.ShardTransform, err)
+	}
+
+	// Reconstruction and resharding go through the transform as well
+	if err := os.Remove(shardPath(s, manifestID, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reshard(manifestID, testPassword, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	s.ShardTransform = nil
+	if err := s.DecryptFile(manifestID, t.TempDir(), testPassword); err == nil || !strings.Contains(err.Error(), `transform "pad"`) {
+		t.Fatalf("got %v, want a missing transform error", err)
+	}
+}
+
+func TestShardTransformWithSplitShards(t *testing.T) {
+	s := newTestSyncer(t)
+	s.ShardTransform = padTransform{size: 512}
+	opts := testOptions()
+	opts.MaxShardBytes = 256
+	manifestID, data := encryptTestFile(t, s, opts, 5000)
+	assertDecrypts(t, s, manifestID, testPassword, data)
+
+	// Files written without a transform are still read as they are
+	opts.MaxShardBytes = 0
+	s.ShardTransform = nil
+	plainID, plainData := encryptTestFile(t, s, opts, 3000)
+	s.ShardTransform = padTransform{size: 512}
+	assertDecrypts(t, s, plainID, testPassword, plainData)
+}
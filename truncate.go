@@ -0,0 +1,170 @@
+package secstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// Truncate 把 manifestID 对应的文件截短为前 newLength 个明文字节，例如在日志轮转时丢弃文件的尾部。
+// 截断点之后的块的分片被删除；截断点落在某个块中间时，只有这一个块被解密，其前半部分用新的数据密钥重新加密，
+// 以新的块路径写入后替换原来的块。清单记录了完整内容的哈希时，哈希需要按截断后的内容重新计算，
+// 因此之前的块会被逐个读取和认证（每次只有一个块的明文在内存中）；旧清单没有记录哈希，不需要读取这些块。
+// 所有新分片写完后才重新签名并保存清单，最后删除不再引用的分片。newLength 等于当前长度时直接返回，大于当前长度时返回错误。
+// 符号链接清单、记录了 ShardLocations 的清单，以及截断点落在块中间且 NonceSize 不是标准长度的清单不受支持。
+func (s *Syncer) Truncate(manifestID, password string, newLength int64) (err error) {
+	if newLength < 0 {
+		return fmt.Errorf("invalid length %d", newLength)
+	}
+	defer s.lockManifest(manifestID)()
+	manifest, key, err := s.openManifest(manifestID, password)
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+	if len(manifest.EncryptedLinkTarget) > 0 {
+		return fmt.Errorf("manifest %s is a symbolic link and has no content to truncate", manifestID)
+	}
+	if len(manifest.ShardLocations) > 0 {
+		return errors.New("manifests with external shard locations cannot be truncated")
+	}
+
+	// 1. Find the chunk containing the cut: chunks before keep stay as they are, the first cut bytes of chunk keep are kept
+	sizes, err := plaintextChunkSizes(manifest)
+	if err != nil {
+		return err
+	}
+	var length int64
+	for _, size := range sizes {
+		length += int64(size)
+	}
+	if newLength > length {
+		return fmt.Errorf("cannot truncate a %d-byte file to %d bytes", length, newLength)
+	}
+	if newLength == length {
+		return nil
+	}
+	keep, cut := 0, newLength
+	for cut >= int64(sizes[keep]) {
+		cut -= int64(sizes[keep])
+		keep++
+	}
+	if cut > 0 && manifest.NonceSize != 0 {
+		return fmt.Errorf("manifest %s uses %d-byte nonces and its chunks cannot be re-encrypted", manifestID, manifest.NonceSize)
+	}
+
+	var enc reedsolomon.Encoder
+	if manifest.ParityShards > 0 {
+		if enc, err = s.newErasureCoder(manifest.DataShards, manifest.ParityShards); err != nil {
+			return fmt.Errorf("failed to create erasure code decoder: %w", err)
+		}
+	}
+	transform, err := s.shardTransform(manifest)
+	if err != nil {
+		return err
+	}
+
+	// 2. Hash the chunks that are kept whole
+	ctx := context.Background()
+	var contentHash hash.Hash
+	if len(manifest.EncryptedContentHash) > 0 {
+		if contentHash, err = newContentHash(manifest.ContentHashAlgorithm); err != nil {
+			return err
+		}
+		for i := range keep {
+			plaintext, _, err := s.readChunk(ctx, manifestID, manifest, enc, key, i, false)
+			if err != nil {
+				return err
+			}
+			contentHash.Write(plaintext)
+			s.BufferPool.put(plaintext)
+		}
+	}
+
+	// 3. Re-encrypt the kept part of the chunk containing the cut under a new chunk path
+	var oldNames, newNames []string
+	for i := keep; i < len(manifest.ChunkPaths); i++ {
+		oldNames = append(oldNames, manifest.storageNames(i)...)
+	}
+	defer func() {
+		if err != nil {
+			for _, name := range newNames {
+				s.backend().Delete(ctx, shardKey(manifestID, name))
+			}
+		}
+	}()
+	if cut > 0 {
+		plaintext, _, err := s.readChunk(ctx, manifestID, manifest, enc, key, keep, false)
+		if err != nil {
+			return err
+		}
+		if contentHash != nil {
+			contentHash.Write(plaintext[:cut])
+		}
+		var aad []byte
+		if manifest.Version >= manifestVersionChunkAAD {
+			aad = chunkAAD(manifestID, keep)
+		}
+		opts := EncryptionOptions{KeyWrapCipher: manifest.KeyWrapCipher, AAD: manifest.aad}
+		encryptedData, encryptedKey, err := sealChunk(encryptAppend, manifest.ChunkCipher, opts, key, aad, nil, plaintext[:cut])
+		s.BufferPool.put(plaintext)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt chunk %d: %w", keep, err)
+		}
+
+		chunkPath := fmt.Sprintf("chunk_%d_t%d", keep, cut)
+		names, err := s.putChunkShards(ctx, manifestID, keep, chunkPath, manifest.chunkSuffixes(keep), encryptedData, enc, manifest.MaxShardBytes, transform)
+		newNames = names
+		if err != nil {
+			return fmt.Errorf("chunk %d: %w", keep, err)
+		}
+		manifest.ChunkPaths[keep] = chunkPath
+		manifest.EncryptedDataKeys[keep] = encryptedKey
+		manifest.EncryptedChunkSizes[keep] = len(encryptedData)
+		if len(manifest.PlaintextChunkSizes) > 0 {
+			manifest.PlaintextChunkSizes[keep] = int(cut)
+		}
+		keep++
+	}
+
+	// 4. Drop the remaining chunks and save the manifest; from here on the new shards are referenced
+	newNames = nil
+	manifest.ChunkPaths = manifest.ChunkPaths[:keep]
+	manifest.EncryptedDataKeys = manifest.EncryptedDataKeys[:keep]
+	manifest.EncryptedChunkSizes = manifest.EncryptedChunkSizes[:keep]
+	if len(manifest.PlaintextChunkSizes) > 0 {
+		manifest.PlaintextChunkSizes = manifest.PlaintextChunkSizes[:keep]
+	}
+	if len(manifest.ErasureCodeChunkSuffixes) > 0 {
+		manifest.ErasureCodeChunkSuffixes = manifest.ErasureCodeChunkSuffixes[:keep]
+	}
+	if contentHash != nil {
+		if manifest.EncryptedContentHash, err = encryptWith(manifest.KeyWrapCipher, contentHash.Sum(nil), key, nil); err != nil {
+			return fmt.Errorf("failed to encrypt content hash: %w", err)
+		}
+	}
+	if err := validateManifest(manifest); err != nil {
+		return fmt.Errorf("invalid truncated manifest: %w", err)
+	}
+	if err := s.updateManifest(manifestID, manifest, key); err != nil {
+		return err
+	}
+
+	// 5. The dropped chunks and the original of the cut chunk are no longer referenced
+	for _, name := range oldNames {
+		if err := s.backend().Delete(ctx, shardKey(manifestID, name)); err != nil {
+			return fmt.Errorf("failed to delete old shard %s: %w", name, err)
+		}
+	}
+
+	// 6. The index records the stored size
+	if s.Index != nil {
+		if err := s.Index.Put(newIndexEntry(manifestID, manifest, manifest.CreatedAt)); err != nil {
+			return fmt.Errorf("failed to update manifest index: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package secstorage
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+)
+
+// decryptedContent 用 DecryptToWriter 返回 manifestID 的全部明文。
+func decryptedContent(t *testing.T, s *Syncer, manifestID string) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	if _, err := s.DecryptToWriter(context.Background(), manifestID, testPassword, &out); err != nil {
+		t.Fatalf("DecryptToWriter: %v", err)
+	}
+	return out.Bytes()
+}
+
+func TestTruncate(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.RecoveryRecords = true
+	manifestID, data := encryptTestFile(t, s, opts, 8000)
+	before, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cutting in the middle of the second chunk re-encrypts only that chunk
+	newLength := before.PlaintextChunkSizes[0] + 100
+	if err := s.Truncate(manifestID, testPassword, int64(newLength)); err != nil {
+		t.Fatal(err)
+	}
+	if got := decryptedContent(t, s, manifestID); !bytes.Equal(got, data[:newLength]) {
+		t.Fatalf("got %d bytes, want the first %d", len(got), newLength)
+	}
+	after, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after.ChunkPaths) != 2 || after.ChunkPaths[0] != before.ChunkPaths[0] || after.PlaintextChunkSizes[1] != 100 {
+		t.Fatalf("got chunks %v with sizes %v", after.ChunkPaths, after.PlaintextChunkSizes)
+	}
+	if names := storedShardNames(t, s, manifestID); len(names) != 2*6 {
+		t.Fatalf("got %d shard files: %v", len(names), names)
+	}
+
+	// The truncated file is still repairable and rebuildable
+	if err := os.Remove(shardPath(s, manifestID, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(s.getManifestPath(manifestID)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RebuildManifest(manifestID, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if got := decryptedContent(t, s, manifestID); !bytes.Equal(got, data[:newLength]) {
+		t.Fatal("rebuilt manifest decrypts to different content")
+	}
+
+	// Cutting at a chunk boundary only drops chunks
+	if err := s.Truncate(manifestID, testPassword, int64(before.PlaintextChunkSizes[0])); err != nil {
+		t.Fatal(err)
+	}
+	if got := decryptedContent(t, s, manifestID); !bytes.Equal(got, data[:before.PlaintextChunkSizes[0]]) {
+		t.Fatal("content differs after cutting at a chunk boundary")
+	}
+
+	if err := s.Truncate(manifestID, testPassword, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := decryptedContent(t, s, manifestID); len(got) != 0 {
+		t.Fatalf("got %d bytes after truncating to zero", len(got))
+	}
+	if names := storedShardNames(t, s, manifestID); len(names) != 0 {
+		t.Fatalf("shards left after truncating to zero: %v", names)
+	}
+}
+
+func TestTruncateWithoutParity(t *testing.T) {
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.ParityShards = 0
+	manifestID, data := encryptTestFile(t, s, opts, 5000)
+	if err := s.Truncate(manifestID, testPassword, 1234); err != nil {
+		t.Fatal(err)
+	}
+	if got := decryptedContent(t, s, manifestID); !bytes.Equal(got, data[:1234]) {
+		t.Fatalf("got %d bytes, want 1234", len(got))
+	}
+}
+
+func TestTruncateRejectsBadInput(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 3000)
+	for _, length := range []int64{-1, 3001} {
+		if err := s.Truncate(manifestID, testPassword, length); err == nil {
+			t.Errorf("truncating to %d succeeded", length)
+		}
+	}
+	if err := s.Truncate(manifestID, "wrong password", 10); err == nil {
+		t.Fatal("expected an error for a wrong password")
+	}
+	if err := s.Truncate(manifestID, testPassword, 3000); err != nil {
+		t.Fatal(err)
+	}
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
@@ -0,0 +1,153 @@
+package secstorage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// shardPath 返回 manifestID 第 chunk 个块的第 shard 个分片在默认本地后端中的路径。
+func shardPath(s *Syncer, manifestID string, chunk, shard int) string {
+	return filepath.Join(s.StorageDir, manifestID, fmt.Sprintf("chunk_%d_shard_%d.dat", chunk, shard))
+}
+
+// flipBit 翻转 path 文件中第一个字节的最低位。
+func flipBit(t *testing.T, path string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[0] ^= 1
+	if err := os.WriteFile(path, data, defaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyManifest(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, s, testOptions(), 8000)
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.ChunkPaths) < 4 {
+		t.Fatalf("test file produced only %d chunks", len(manifest.ChunkPaths))
+	}
+
+	report, err := s.VerifyManifest(manifestID, testPassword, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || len(report.Healthy) != len(manifest.ChunkPaths) {
+		t.Fatalf("fresh file reported as %+v", report)
+	}
+
+	// Chunk 0 loses a shard, chunk 1 has a bit flip in a data shard, chunk 2 in a parity shard,
+	// and chunk 3 has two corrupted shards, which cannot be told apart from each other.
+	if err := os.Remove(shardPath(s, manifestID, 0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	flipBit(t, shardPath(s, manifestID, 1, 0))
+	flipBit(t, shardPath(s, manifestID, 2, 5))
+	flipBit(t, shardPath(s, manifestID, 3, 0))
+	flipBit(t, shardPath(s, manifestID, 3, 1))
+
+	report, err = s.VerifyManifest(manifestID, testPassword, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 1, 2}; !reflect.DeepEqual(report.Degraded, want) {
+		t.Fatalf("Degraded = %v, want %v", report.Degraded, want)
+	}
+	if want := []int{3}; !reflect.DeepEqual(report.Unrecoverable, want) {
+		t.Fatalf("Unrecoverable = %v, want %v", report.Unrecoverable, want)
+	}
+
+	if _, err := s.VerifyManifest(manifestID, "wrong password", 1); err == nil {
+		t.Fatal("VerifyManifest accepted a wrong password")
+	}
+}
+
+func TestDecryptRepairsCorruptedShard(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 5000)
+	flipBit(t, shardPath(s, manifestID, 1, 0))
+
+	// A truncated shard is treated like a missing one
+	path := shardPath(s, manifestID, 2, 3)
+	shard, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, shard[:len(shard)-1], defaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := &countingMetrics{}
+	s.Metrics = metrics
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	if metrics.reconstructed.Load() != 2 {
+		t.Fatalf("reported %d reconstructed shards, want 2", metrics.reconstructed.Load())
+	}
+
+	s.StrictIntegrity = true
+	if err := s.DecryptFile(manifestID, t.TempDir(), testPassword); !errors.Is(err, ErrShardIntegrity) {
+		t.Fatalf("expected ErrShardIntegrity, got %v", err)
+	}
+}
+
+func TestStrictIntegrityAcceptsHealthyFile(t *testing.T) {
+	s := newTestSyncer(t)
+	s.StrictIntegrity = true
+	manifestID, data := encryptTestFile(t, s, testOptions(), 5000)
+	assertDecrypts(t, s, manifestID, testPassword, data)
+}
+
+func TestDecryptReadsOnlyDataShards(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, data := encryptTestFile(t, s, testOptions(), 5000)
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := int64(len(manifest.ChunkPaths))
+	backend := &countingBackend{Backend: NewLocalBackend(s.StorageDir)}
+	s.Backend = backend
+
+	// A healthy object never touches its parity shards
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	if got := backend.gets.Load(); got != 4*chunks {
+		t.Fatalf("read %d shards, want %d", got, 4*chunks)
+	}
+
+	// Damaged parity goes unnoticed by decryption but not by verification or strict mode
+	if err := os.Remove(shardPath(s, manifestID, 0, 5)); err != nil {
+		t.Fatal(err)
+	}
+	report, err := s.DecryptFileWithReport(manifestID, t.TempDir(), testPassword)
+	if err != nil || report.Degraded() {
+		t.Fatalf("got %+v, %v", report, err)
+	}
+	if verify, err := s.VerifyManifest(manifestID, testPassword, 1); err != nil || !reflect.DeepEqual(verify.Degraded, []int{0}) {
+		t.Fatalf("got %+v, %v", verify, err)
+	}
+	s.StrictIntegrity = true
+	if err := s.DecryptFile(manifestID, t.TempDir(), testPassword); !errors.Is(err, ErrShardIntegrity) {
+		t.Fatalf("expected ErrShardIntegrity, got %v", err)
+	}
+	s.StrictIntegrity = false
+
+	// A missing data shard falls back to the parity shards
+	if err := os.Remove(shardPath(s, manifestID, 1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	backend.gets.Store(0)
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	if got := backend.gets.Load(); got != 4*chunks+2 {
+		t.Fatalf("read %d shards, want %d", got, 4*chunks+2)
+	}
+}
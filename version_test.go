@@ -0,0 +1,146 @@
+package secstorage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/awnumar/memguard"
+	"github.com/klauspost/reedsolomon"
+)
+
+// writeVersionedManifest 按 version 对应的旧格式手工写出一个包含 data 的清单（文件名为 "input.bin"），
+// 用于验证当前代码仍能解密历史版本写出的文件：
+// 版本 0 的数据块没有关联数据，版本 2 之前的文件密钥直接由密码和清单盐值派生，版本 3 之前的文件名没有填充，
+// 版本 4 之前每个块分别记录分片后缀。
+func writeVersionedManifest(t *testing.T, s *Syncer, version int, data []byte) string {
+	t.Helper()
+	manifestID, err := s.createManifestDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := Manifest{Version: version, DataShards: 4, ParityShards: 2}
+	var key *memguard.LockedBuffer
+	if version < manifestVersionRecipients {
+		salt, err := generateSalt()
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest.Salt, manifest.Argon2Time, manifest.Argon2Memory, manifest.Argon2Threads = salt, 1, 64, 1
+		key = deriveKey([]byte(testPassword), salt, 1, 64, 1)
+	} else {
+		key, err = generateDataKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		recipient, err := newRecipient(argon2Deriver{}, []byte(testPassword), key, Recipient{Argon2Time: 1, Argon2Memory: 64, Argon2Threads: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest.Recipients = []Recipient{recipient}
+	}
+	defer key.Destroy()
+
+	enc, err := reedsolomon.New(manifest.DataShards, manifest.ParityShards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, chunk := range [][]byte{data[:len(data)/2], data[len(data)/2:]} {
+		dataKey, err := generateDataKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var aad []byte
+		if version >= manifestVersionChunkAAD {
+			aad = chunkAAD(manifestID, i)
+		}
+		encryptedData, err := encrypt(chunk, dataKey, aad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encryptedKey, err := encrypt(dataKey.Bytes(), key, nil)
+		dataKey.Destroy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		suffixes, err := s.writeChunkShards(context.Background(), manifestID, i, encryptedData, enc, 0, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest.ChunkPaths = append(manifest.ChunkPaths, fmt.Sprintf("chunk_%d", i))
+		manifest.EncryptedDataKeys = append(manifest.EncryptedDataKeys, encryptedKey)
+		manifest.EncryptedChunkSizes = append(manifest.EncryptedChunkSizes, len(encryptedData))
+		if version >= manifestVersionShardSuffixes {
+			manifest.ShardSuffixes = suffixes
+		} else {
+			manifest.ErasureCodeChunkSuffixes = append(manifest.ErasureCodeChunkSuffixes, suffixes)
+		}
+	}
+
+	if version >= manifestVersionPaddedFilename {
+		manifest.EncryptedOrigFilename, err = encryptFilename("", "input.bin", key, nil)
+	} else {
+		manifest.EncryptedOrigFilename, err = encrypt([]byte("input.bin"), key, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.saveManifest(manifestID, &manifest, key); err != nil {
+		t.Fatal(err)
+	}
+	return manifestID
+}
+
+func TestDecryptEveryManifestVersion(t *testing.T) {
+	for version := manifestVersionLegacy; version <= currentManifestVersion; version++ {
+		s := newTestSyncer(t)
+		_, data := writeTestFile(t, t.TempDir(), "input.bin", 3000)
+		manifestID := writeVersionedManifest(t, s, version, data)
+
+		assertDecrypts(t, s, manifestID, testPassword, data)
+		report, err := s.VerifyManifest(manifestID, testPassword, 1)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if len(report.Healthy) != 2 {
+			t.Fatalf("version %d: report %+v", version, report)
+		}
+		if err := s.DecryptFile(manifestID, t.TempDir(), "wrong password"); err == nil {
+			t.Fatalf("version %d: wrong password accepted", version)
+		}
+	}
+}
+
+func TestChunkAADRejectsSwappedChunks(t *testing.T) {
+	s := newTestSyncer(t)
+	_, data := writeTestFile(t, t.TempDir(), "input.bin", 3000)
+	manifestID := writeVersionedManifest(t, s, currentManifestVersion, data)
+
+	manifest, key, err := s.OpenManifest(manifestID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer key.Destroy()
+	manifest.ChunkPaths[0], manifest.ChunkPaths[1] = manifest.ChunkPaths[1], manifest.ChunkPaths[0]
+	manifest.EncryptedDataKeys[0], manifest.EncryptedDataKeys[1] = manifest.EncryptedDataKeys[1], manifest.EncryptedDataKeys[0]
+	manifest.EncryptedChunkSizes[0], manifest.EncryptedChunkSizes[1] = manifest.EncryptedChunkSizes[1], manifest.EncryptedChunkSizes[0]
+	if err := s.WriteManifest(manifestID, manifest, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DecryptFile(manifestID, t.TempDir(), testPassword); err == nil {
+		t.Fatal("chunks decrypted at swapped positions")
+	}
+}
+
+func TestEncryptFileWritesCurrentVersion(t *testing.T) {
+	s := newTestSyncer(t)
+	manifestID, _ := encryptTestFile(t, s, testOptions(), 100)
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Version != currentManifestVersion {
+		t.Fatalf("new manifest has version %d, want %d", manifest.Version, currentManifestVersion)
+	}
+}
@@ -0,0 +1,135 @@
+package secstorage
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Wiper 是 Backend 可选实现的接口，用于 WipeManifest 在删除分片之前原地覆写其内容。
+// 未实现 Wiper 的后端会先用 Put 写入同样长度的随机数据，再调用 Delete，因此这类后端的 Put 必须能覆盖已存在的 key。
+type Wiper interface {
+	Wipe(ctx context.Context, key string) error
+}
+
+// Wipe 实现了 Wiper 接口：用随机字节原地覆写 key 对应的分片文件并 fsync，然后删除它。
+// key 不存在时返回 nil。
+func (b *LocalBackend) Wipe(ctx context.Context, key string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := overwriteFile(p); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return os.Remove(p)
+}
+
+// WipeManifest 与 DeleteManifest 一样删除 manifestID 对应的清单、分片和索引记录，
+// 但在解除链接之前先用随机字节覆写每个分片文件以及清单目录中的所有文件（清单、进度文件和恢复记录）。
+//
+// 这只是尽力而为：在 SSD（存在损耗均衡）、写时复制或日志型文件系统（如 btrfs、ZFS）以及带快照的存储上，
+// 覆写不保证落在原来的物理位置，旧数据仍可能被恢复。它对传统磁盘上的普通文件系统仍然有帮助。
+// 需要可靠的销毁时，应在加密前规划好全盘加密或销毁整个介质。
+func (s *Syncer) WipeManifest(manifestID string) error {
+	if err := s.validateManifestID(manifestID); err != nil {
+		return err
+	}
+	defer s.lockManifest(manifestID)()
+
+	names, err := s.manifestShardNames(manifestID)
+	if err != nil {
+		return err
+	}
+
+	// 1. Overwrite and remove every shard through the backend
+	ctx := context.Background()
+	backend := s.backend()
+	for _, name := range names {
+		if err := wipeShard(ctx, backend, shardKey(manifestID, name)); err != nil {
+			return fmt.Errorf("failed to wipe shard %s: %w", name, err)
+		}
+	}
+
+	// 2. Overwrite the manifest, progress and recovery files left in the manifest directory
+	dir := s.manifestDir(manifestID)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return overwriteFile(path)
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to wipe manifest %s: %w", manifestID, err)
+	}
+
+	// 3. Remove the directory and the index entry
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to delete manifest %s: %w", manifestID, err)
+	}
+	if s.Index != nil {
+		if err := s.Index.Delete(manifestID); err != nil {
+			return fmt.Errorf("failed to remove manifest %s from index: %w", manifestID, err)
+		}
+	}
+	return nil
+}
+
+// wipeShard 覆写并删除后端中的一个分片。后端实现了 Wiper 时交给它处理，否则使用 overwriteShard。
+func wipeShard(ctx context.Context, backend Backend, key string) error {
+	if w, ok := backend.(Wiper); ok {
+		return w.Wipe(ctx, key)
+	}
+	return overwriteShard(ctx, backend, key)
+}
+
+// overwriteShard 读取分片得到其长度，用 Put 写入同样长度的随机数据后再删除。分片不存在时直接跳过。
+func overwriteShard(ctx context.Context, backend Backend, key string) error {
+	data, err := backend.Get(ctx, key)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	noise := make([]byte, len(data))
+	if _, err := rand.Read(noise); err != nil {
+		return err
+	}
+	if err := backend.Put(ctx, key, noise); err != nil {
+		return err
+	}
+	return backend.Delete(ctx, key)
+}
+
+// overwriteFile 在不截断文件的情况下用随机字节覆写 path 的全部内容，并在返回前 fsync。
+// 截断会先释放原有的数据块，之后的写入可能落到别处，因此这里以只写方式打开并从头覆写。
+func overwriteFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, rand.Reader, info.Size()); err != nil {
+		return fmt.Errorf("failed to overwrite %s: %w", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	return f.Close()
+}
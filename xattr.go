@@ -0,0 +1,44 @@
+package secstorage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/awnumar/memguard"
+)
+
+// maxXattrsSize 是扩展属性编码为 JSON 后允许的最大字节数。
+const maxXattrsSize = 256 * 1024
+
+// marshalXattrs 将扩展属性编码为 JSON 并检查大小；attrs 为空时返回 nil。
+func marshalXattrs(attrs map[string][]byte) ([]byte, error) {
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(attrs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal extended attributes: %w", err)
+	}
+	if len(data) > maxXattrsSize {
+		return nil, fmt.Errorf("extended attributes are %d bytes when encoded, the limit is %d", len(data), maxXattrsSize)
+	}
+	return data, nil
+}
+
+// decryptXattrs 解密清单中的扩展属性；清单未保存扩展属性时返回 nil。
+func decryptXattrs(manifest *Manifest, key *memguard.LockedBuffer) (map[string][]byte, error) {
+	if len(manifest.EncryptedXattrs) == 0 {
+		return nil, nil
+	}
+	data, err := manifest.open(manifest.KeyWrapCipher, manifest.EncryptedXattrs, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt extended attributes: %w", err)
+	}
+	defer memguard.WipeBytes(data)
+
+	var attrs map[string][]byte
+	if err := json.Unmarshal(data, &attrs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal extended attributes: %w", err)
+	}
+	return attrs, nil
+}
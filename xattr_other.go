@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package secstorage
+
+import "os"
+
+// readXattrs 在不支持扩展属性的平台上总是返回 nil。
+func readXattrs(f *os.File) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// writeXattrs 在不支持扩展属性的平台上什么也不做。
+func writeXattrs(f *os.File, attrs map[string][]byte) error {
+	return nil
+}
@@ -0,0 +1,65 @@
+//go:build linux || darwin
+
+package secstorage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs 返回打开的文件 f 的所有扩展属性。文件系统不支持扩展属性时返回 nil。
+func readXattrs(f *os.File) (map[string][]byte, error) {
+	fd := int(f.Fd())
+	size, err := unix.Flistxattr(fd, nil)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extended attributes: %w", err)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	list := make([]byte, size)
+	size, err = unix.Flistxattr(fd, list)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extended attributes: %w", err)
+	}
+
+	attrs := make(map[string][]byte)
+	for _, name := range bytes.Split(list[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		size, err := unix.Fgetxattr(fd, string(name), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read extended attribute %q: %w", name, err)
+		}
+		value := make([]byte, size)
+		size, err = unix.Fgetxattr(fd, string(name), value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read extended attribute %q: %w", name, err)
+		}
+		attrs[string(name)] = value[:size]
+	}
+	return attrs, nil
+}
+
+// writeXattrs 为打开的文件 f 设置 attrs 中的扩展属性。文件系统不支持扩展属性时什么也不做。
+func writeXattrs(f *os.File, attrs map[string][]byte) error {
+	fd := int(f.Fd())
+	for name, value := range attrs {
+		err := unix.Fsetxattr(fd, name, value, 0)
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore extended attribute %q: %w", name, err)
+		}
+	}
+	return nil
+}
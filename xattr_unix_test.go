@@ -0,0 +1,108 @@
+//go:build linux || darwin
+
+package secstorage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestPreserveXattrs(t *testing.T) {
+	path, data := writeTestFile(t, t.TempDir(), "input.bin", 3000)
+	err := unix.Setxattr(path, "user.secstorage.test", []byte("label"), 0)
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) {
+		t.Skipf("file system does not support user extended attributes: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.PreserveXattrs = true
+	manifestID, err := s.EncryptFile(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.EncryptedXattrs) == 0 {
+		t.Fatal("extended attributes were not stored")
+	}
+
+	// Without RestoreMetadata the attributes are left out
+	outputDir := t.TempDir()
+	assertDecrypts(t, s, manifestID, testPassword, data)
+	if err := s.DecryptFile(manifestID, outputDir, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unix.Getxattr(filepath.Join(outputDir, "input.bin"), "user.secstorage.test", nil); err == nil {
+		t.Fatal("extended attribute restored without RestoreMetadata")
+	}
+
+	s.RestoreMetadata = true
+	if err := s.DecryptFile(manifestID, outputDir, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	value := make([]byte, 64)
+	n, err := unix.Getxattr(filepath.Join(outputDir, "input.bin"), "user.secstorage.test", value)
+	if err != nil || string(value[:n]) != "label" {
+		t.Fatalf("extended attribute = %q, %v; want \"label\"", value[:n], err)
+	}
+}
+
+func TestRebuildManifestKeepsXattrs(t *testing.T) {
+	path, _ := writeTestFile(t, t.TempDir(), "input.bin", 3000)
+	err := unix.Setxattr(path, "user.secstorage.test", []byte("label"), 0)
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) {
+		t.Skipf("file system does not support user extended attributes: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestSyncer(t)
+	opts := testOptions()
+	opts.PreserveXattrs = true
+	opts.RecoveryRecords = true
+	manifestID, err := s.EncryptFile(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRebuildsIdentically(t, s, manifestID)
+
+	outputDir := t.TempDir()
+	s.RestoreMetadata = true
+	if err := s.DecryptFile(manifestID, outputDir, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	value := make([]byte, 64)
+	n, err := unix.Getxattr(filepath.Join(outputDir, "input.bin"), "user.secstorage.test", value)
+	if err != nil || string(value[:n]) != "label" {
+		t.Fatalf("extended attribute after rebuild = %q, %v; want \"label\"", value[:n], err)
+	}
+}
+
+func TestXattrsNotStoredByDefault(t *testing.T) {
+	path, _ := writeTestFile(t, t.TempDir(), "input.bin", 100)
+	if err := unix.Setxattr(path, "user.secstorage.test", []byte("label"), 0); err != nil {
+		t.Skipf("file system does not support user extended attributes: %v", err)
+	}
+	s := newTestSyncer(t)
+	manifestID, err := s.EncryptFile(path, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := s.ReadManifest(manifestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.EncryptedXattrs) != 0 {
+		t.Fatal("extended attributes were stored without PreserveXattrs")
+	}
+}